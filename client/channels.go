@@ -0,0 +1,124 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// maxChannels is the most 9P connections a Cmd serves.
+	maxChannels = 16
+	// minMsize is the msize for a link with a round trip of
+	// under a millisecond: measurement has shown 64K is good.
+	minMsize = 64 * 1024
+	// maxMsize is the most msize the kernel takes for a 9P
+	// mount on a file descriptor.
+	maxMsize = 1024 * 1024
+)
+
+// channels returns how many 9P connections are served.
+func (c *Cmd) channels() int {
+	switch {
+	case c.Channels < 1:
+		return 1
+	case c.Channels > maxChannels:
+		return maxChannels
+	}
+	return c.Channels
+}
+
+// channelsRequest is the global request with which cpu asks cpud how
+// many of the 9P connections it would serve sessions mount.
+const channelsRequest = "channels@u-root.org"
+
+// negotiateChannels asks cpud how many of Channels 9P connections its
+// sessions mount, and serves that many. A server that does not know
+// the request, e.g. an older cpud, mounts only one, so only one is
+// served.
+func (c *Cmd) negotiateChannels() error {
+	n := c.channels()
+	if n < 2 {
+		return nil
+	}
+	ok, reply, err := c.client.SendRequest(channelsRequest, true, ssh.Marshal(&struct{ Channels uint32 }{uint32(n)}))
+	if err != nil {
+		return fmt.Errorf("%s:%w", channelsRequest, err)
+	}
+	var mounts struct{ Channels uint32 }
+	if !ok || ssh.Unmarshal(reply, &mounts) != nil || mounts.Channels < 1 {
+		verbose("server does not mount %d 9P connections; serving 1", n)
+		c.Channels = 1
+		return nil
+	}
+	if int(mounts.Channels) < n {
+		n = int(mounts.Channels)
+	}
+	c.Channels = n
+	verbose("serving %d 9P connections", n)
+	return nil
+}
+
+// channelMount returns where cpud mounts 9P connection n: the first in
+// /tmp/cpu, as ever, and the others in /tmp/cpu.n.
+func channelMount(n int) string {
+	if n == 0 {
+		return "/tmp/cpu"
+	}
+	return fmt.Sprintf("/tmp/cpu.%d", n)
+}
+
+// stripeBinds returns fstab, as parseBinds makes it, with its binds
+// striped over n 9P connections: bind i is from the mount of
+// connection i%n, so that reads of files in different binds, e.g.
+// /usr and /lib, go over different connections.
+func stripeBinds(fstab string, n int) string {
+	if n < 2 {
+		return fstab
+	}
+	lines := strings.SplitAfter(fstab, "\n")
+	var b int
+	for i, l := range lines {
+		if !strings.HasPrefix(l, channelMount(0)+"/") && !strings.HasPrefix(l, channelMount(0)+" ") {
+			continue
+		}
+		lines[i] = channelMount(b%n) + l[len(channelMount(0)):]
+		b++
+	}
+	return strings.Join(lines, "")
+}
+
+// adaptiveMsize returns an msize for a link with round trip rtt: the
+// longer it is, the more each message should carry, so that the reads
+// of a large file, each a round trip, are not slowed by it. It is 64K
+// for a round trip under a millisecond, doubling as the round trip
+// does, to at most 1M.
+func adaptiveMsize(rtt time.Duration) int {
+	m := minMsize
+	for d := time.Millisecond; d <= rtt && m < maxMsize; d *= 2 {
+		m *= 2
+	}
+	return m
+}
+
+// rtt returns the shortest of a few round trips of the SSH connection.
+func (c *Cmd) rtt() (time.Duration, error) {
+	var rtt time.Duration
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		// The server need not know the request, only answer.
+		if _, _, err := c.client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			return 0, err
+		}
+		if d := time.Since(start); i == 0 || d < rtt {
+			rtt = d
+		}
+	}
+	return rtt, nil
+}
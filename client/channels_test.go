@@ -0,0 +1,53 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStripeBinds(t *testing.T) {
+	fstab, err := ParseBinds("/lib:/usr:/bin=/x/bin:/home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		n    int
+		want string
+	}{
+		{n: 1, want: fstab},
+		{n: 2, want: "/tmp/cpu/lib /lib none defaults,bind 0 0\n" +
+			"/tmp/cpu.1/usr /usr none defaults,bind 0 0\n" +
+			"/tmp/cpu/x/bin /bin none defaults,bind 0 0\n" +
+			"/tmp/cpu.1/home /home none defaults,bind 0 0\n"},
+		{n: 3, want: "/tmp/cpu/lib /lib none defaults,bind 0 0\n" +
+			"/tmp/cpu.1/usr /usr none defaults,bind 0 0\n" +
+			"/tmp/cpu.2/x/bin /bin none defaults,bind 0 0\n" +
+			"/tmp/cpu/home /home none defaults,bind 0 0\n"},
+	} {
+		if got := stripeBinds(fstab, tt.n); got != tt.want {
+			t.Errorf("stripeBinds(%q, %d): %q, want %q", fstab, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestAdaptiveMsize(t *testing.T) {
+	for _, tt := range []struct {
+		rtt  time.Duration
+		want int
+	}{
+		{rtt: 0, want: 64 * 1024},
+		{rtt: 500 * time.Microsecond, want: 64 * 1024},
+		{rtt: time.Millisecond, want: 128 * 1024},
+		{rtt: 5 * time.Millisecond, want: 512 * 1024},
+		{rtt: 8 * time.Millisecond, want: 1024 * 1024},
+		{rtt: time.Second, want: 1024 * 1024},
+	} {
+		if got := adaptiveMsize(tt.rtt); got != tt.want {
+			t.Errorf("adaptiveMsize(%v): %d, want %d", tt.rtt, got, tt.want)
+		}
+	}
+}
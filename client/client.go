@@ -17,6 +17,7 @@ import (
 
 	"github.com/hugelgupf/p9/p9"
 	"github.com/mdlayher/vsock"
+	"github.com/u-root/cpu/compress"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/term"
 )
@@ -68,10 +69,46 @@ type Cmd struct {
 	hasTTY            bool // Set if we have a TTY
 	// NameSpace is a string as defined in the cpu documentation.
 	NameSpace string
+	// Xattrs is the namespaces of the extended attributes the 9P
+	// server serves, comma-separated, e.g. user,security; none
+	// serves none. If it is empty, user attributes are served.
+	Xattrs string
+	// IDMap maps the user and group ids of the files the 9P server
+	// serves, in the format described in ParseIDMap, e.g. squash.
+	// If it is empty, they are not mapped.
+	IDMap string
+	// Cache is how long the 9P server caches the attributes of
+	// files and the contents of directories, and cpud mounts it
+	// with cache=loose; if it is 0, nothing is cached.
+	Cache time.Duration
+	// Hide is a :-separated list of globs of names that are not
+	// served to the remote, e.g. ~/.ssh:~/.gnupg. A ~ is the home
+	// directory, which must then be in Root. See Policy.
+	Hide string
 	// FSTab is an fstab(5)-format string
 	FSTab string
 	// Ninep determines if client will run a 9P server
 	Ninep bool
+	// Channels is how many 9P connections are served, each over
+	// its own SSH channel, with the binds of NameSpace striped over
+	// them. If it is 0, there is one. Dial sets it to how many
+	// the server mounts, which, for an older cpud, is one.
+	Channels int
+	// Msize is the largest 9P message. If it is 0, it is chosen
+	// from the round trip time of the SSH connection.
+	Msize int
+	// Compress is the algorithms offered to cpud to compress the 9P
+	// and NFS channels with, comma-separated, in the order they are
+	// preferred, e.g. zstd,zlib. If it is empty, or none, or cpud
+	// takes none of them, they are not compressed.
+	Compress string
+	// Limits are resource limits requested for the session,
+	// in the format described in server.Limits. cpud caps them
+	// with its own policy.
+	Limits string
+	// Namespaces are the namespaces requested for the session,
+	// in the format described in session.Namespaces, e.g. pid,net.
+	Namespaces string
 
 	nonce      nonce
 	network    string // This is a variable but we expect it will always be tcp
@@ -79,6 +116,15 @@ type Cmd struct {
 	cmd        string // The command is built up, bit by bit, as we configure the client
 	closers    []func() error
 	fileServer p9.Attacher
+	// policy is kept by the file servers, if not empty. It is
+	// set from NameSpace and Hide by Dial.
+	policy *Policy
+	// ids is parsed from IDMap by Dial.
+	ids *IDMap
+	// compress is the algorithm cpud chose from Compress, if any.
+	compress string
+	// prompt asks the user for passwords and verification codes.
+	prompt Prompt
 }
 
 // SetOptions sets various options into the Command.
@@ -97,9 +143,14 @@ func SetVerbose(f func(string, ...interface{})) {
 	v = f
 }
 
-// Listen implements net.Listen on the ssh socket.
+// Listen implements net.Listen on the ssh socket. The connections are
+// compressed, if Dial arranged it.
 func (c *Cmd) Listen(n, addr string) (net.Listener, error) {
-	return c.client.Listen(n, addr)
+	l, err := c.client.Listen(n, addr)
+	if err != nil {
+		return nil, err
+	}
+	return compress.Listener(l, c.compress), nil
 }
 
 func sameFD(w io.WriteCloser, std *os.File) bool {
@@ -175,6 +226,35 @@ func With9P(p9 bool) Set {
 	}
 }
 
+// WithChannels sets how many 9P connections are served.
+func WithChannels(n int) Set {
+	return func(c *Cmd) error {
+		c.Channels = n
+		return nil
+	}
+}
+
+// WithMsize sets the largest 9P message; 0 chooses it from the round
+// trip time of the SSH connection.
+func WithMsize(m int) Set {
+	return func(c *Cmd) error {
+		c.Msize = m
+		return nil
+	}
+}
+
+// WithCompress sets the compression algorithms offered for the 9P and
+// NFS channels, e.g. zstd,zlib, or none.
+func WithCompress(algs string) Set {
+	return func(c *Cmd) error {
+		if _, err := compress.Parse(algs); err != nil {
+			return err
+		}
+		c.Compress = algs
+		return nil
+	}
+}
+
 // WithNameSpace sets the namespace to Cmd.There is no default: having some default
 // violates the principle of least surprise for package users.
 func WithNameSpace(ns string) Set {
@@ -184,6 +264,55 @@ func WithNameSpace(ns string) Set {
 	}
 }
 
+// WithXattrs sets the namespaces of the extended attributes served.
+func WithXattrs(xattrs string) Set {
+	return func(c *Cmd) error {
+		c.Xattrs = xattrs
+		return nil
+	}
+}
+
+// xattrs returns the namespaces of the extended attributes served.
+func (c *Cmd) xattrs() []string {
+	switch c.Xattrs {
+	case "":
+		return []string{"user"}
+	case "none":
+		return nil
+	}
+	return strings.Split(c.Xattrs, ",")
+}
+
+// WithIDMap sets the map of the user and group ids of the files
+// served.
+func WithIDMap(m string) Set {
+	return func(c *Cmd) error {
+		c.IDMap = m
+		return nil
+	}
+}
+
+// WithCache sets how long the attributes of files and the contents of
+// directories are cached, e.g. 5s; 0 caches nothing.
+func WithCache(ttl string) Set {
+	return func(c *Cmd) error {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return err
+		}
+		c.Cache = d
+		return nil
+	}
+}
+
+// WithHide sets the globs of names that are not served to the remote.
+func WithHide(hide string) Set {
+	return func(c *Cmd) error {
+		c.Hide = hide
+		return nil
+	}
+}
+
 // WithFSTab reads a file for the FSTab member.
 func WithFSTab(fstab string) Set {
 	return func(c *Cmd) error {
@@ -199,6 +328,22 @@ func WithFSTab(fstab string) Set {
 	}
 }
 
+// WithLimits requests resource limits for the remote session.
+func WithLimits(limits string) Set {
+	return func(c *Cmd) error {
+		c.Limits = limits
+		return nil
+	}
+}
+
+// WithNamespaces requests namespaces for the remote session.
+func WithNamespaces(namespaces string) Set {
+	return func(c *Cmd) error {
+		c.Namespaces = namespaces
+		return nil
+	}
+}
+
 // WithTimeout sets the 9p timeout.
 func WithTimeout(timeout string) Set {
 	return func(c *Cmd) error {
@@ -229,6 +374,19 @@ func WithDisablePrivateKey(disable bool) Set {
 	}
 }
 
+// Prompt asks the user a question, e.g. for a password, and returns
+// the answer. If echo is false, the answer is not shown as it is typed.
+type Prompt func(question string, echo bool) (string, error)
+
+// WithPrompt sets a prompt, with which password and keyboard-interactive
+// authentication are tried, after the key, if any.
+func WithPrompt(p Prompt) Set {
+	return func(c *Cmd) error {
+		c.prompt = p
+		return nil
+	}
+}
+
 // WithHostKeyFile adds a host key to a Cmd
 func WithHostKeyFile(key string) Set {
 	return func(c *Cmd) error {
@@ -322,11 +480,19 @@ func unixVsockDial(path, port string) (net.Conn, error) {
 // to avoid callers getting ordering of setting variables
 // in the Cmd wrong.
 func (c *Cmd) Dial() error {
-	fstab, err := ParseBinds(c.NameSpace)
+	fstab, policy, err := parseBinds(c.NameSpace)
 	if err != nil {
 		return err
 	}
-	c.FSTab = JoinFSTab(c.FSTab, fstab)
+	if policy.Hide, err = parseHide(c.Hide, c.Root); err != nil {
+		return err
+	}
+	if !policy.empty() {
+		c.policy = policy
+	}
+	if c.ids, err = ParseIDMap(c.IDMap); err != nil {
+		return err
+	}
 
 	if err := c.UserKeyConfig(); err != nil {
 		return err
@@ -366,23 +532,43 @@ func (c *Cmd) Dial() error {
 	}
 
 	c.client = cl
+	// Compression is negotiated before the forwards it applies to.
+	if err := c.negotiateCompress(); err != nil {
+		return err
+	}
+	// The limits and namespaces are for the session, whether or
+	// not it has a namespace from the client.
+	if len(c.Limits) > 0 {
+		c.Env = append(c.Env, "CPU_LIMITS="+c.Limits)
+	}
+	if len(c.Namespaces) > 0 {
+		c.Env = append(c.Env, "CPU_NAMESPACES="+c.Namespaces)
+	}
 	// Specifying a root is required for a remote namespace.
 	if len(c.Root) == 0 {
 		return nil
 	}
+	// The binds are striped over as many 9P connections as cpud
+	// mounts.
+	if c.Ninep {
+		if err := c.negotiateChannels(); err != nil {
+			return err
+		}
+	}
+	c.FSTab = JoinFSTab(c.FSTab, stripeBinds(fstab, c.channels()))
 
 	// Arrange port forwarding from remote ssh to our server.
-	// Note: cl.Listen returns a TCP listener with network "tcp"
+	// Note: c.Listen returns a TCP listener with network "tcp"
 	// or variants. This lets us use a listen deadline.
 	if c.Ninep {
-		l, err := cl.Listen("tcp", "127.0.0.1:0")
+		l, err := c.Listen("tcp", "127.0.0.1:0")
 		if err != nil {
 			// If ipv4 isn't available, try ipv6.  It's not enough
 			// to use Listen("tcp", "localhost:0a)", since we (the
 			// cpu client) might have v4 (which the runtime will
 			// use if we say "localhost"), but the server (cpud)
 			// might not.
-			l, err = cl.Listen("tcp", "[::1]:0")
+			l, err = c.Listen("tcp", "[::1]:0")
 			if err != nil {
 				return fmt.Errorf("cpu client listen for forwarded 9p port %v", err)
 			}
@@ -407,6 +593,19 @@ func (c *Cmd) Dial() error {
 		c.nonce = nonce
 		c.Env = append(c.Env, "CPUNONCE="+nonce.String())
 		verbose("Set NONCE to %q", nonce.String())
+		// cpud mounts each 9P connection with this msize.
+		if c.Msize == 0 {
+			rtt, err := c.rtt()
+			if err != nil {
+				return fmt.Errorf("measuring round trip time for msize:%w", err)
+			}
+			c.Msize = adaptiveMsize(rtt)
+			verbose("round trip %v: msize %d", rtt, c.Msize)
+		}
+		c.Env = append(c.Env, fmt.Sprintf("CPU_MSIZE=%d", c.Msize))
+		if n := c.channels(); n > 1 {
+			c.Env = append(c.Env, fmt.Sprintf("CPU_CHANNELS=%d", n))
+		}
 		go func(l net.Listener) {
 			if err := c.srv(l); err != nil {
 				log.Printf("9p server error: %v", err)
@@ -417,10 +616,45 @@ func (c *Cmd) Dial() error {
 		c.Env = append(c.Env, "CPU_FSTAB="+c.FSTab)
 		c.Env = append(c.Env, "LC_GLENDA_CPU_FSTAB="+c.FSTab)
 	}
+	// cpud mounts the 9P server with extended attributes only if
+	// they are served.
+	if x := c.xattrs(); len(x) > 0 {
+		c.Env = append(c.Env, "CPU_XATTRS="+strings.Join(x, ","))
+	}
+	// cpud mounts the 9P server with a cache only if it caches,
+	// too, and sees changes to the files it serves.
+	if c.Cache > 0 {
+		c.Env = append(c.Env, "CPU_CACHE=loose")
+	}
+	// cpud mounts the 9P server with the ids the remote sees the
+	// client's user and group as, for files that have none.
+	c.Env = append(c.Env, fmt.Sprintf("CPU_DFLTID=%d:%d", c.ids.remoteUID(uint32(os.Getuid())), c.ids.remoteGID(uint32(os.Getgid()))))
 
 	return nil
 }
 
+// negotiateCompress offers cpud the algorithms of Compress, and sets
+// the one it chooses. A server that takes none of them, e.g. sshd,
+// is not an error: the channels are not compressed.
+func (c *Cmd) negotiateCompress() error {
+	algs, err := compress.Parse(c.Compress)
+	if err != nil || len(algs) == 0 {
+		return err
+	}
+	ok, reply, err := c.client.SendRequest(compress.Request, true, ssh.Marshal(&struct{ Algorithms string }{strings.Join(algs, ",")}))
+	if err != nil {
+		return fmt.Errorf("%s:%w", compress.Request, err)
+	}
+	var chosen struct{ Algorithm string }
+	if !ok || ssh.Unmarshal(reply, &chosen) != nil || compress.Choose([]string{chosen.Algorithm}) == "" {
+		verbose("server does not compress with any of %q; not compressing", algs)
+		return nil
+	}
+	c.compress = chosen.Algorithm
+	verbose("compressing with %s", c.compress)
+	return nil
+}
+
 func quoteArg(arg string) string {
 	return "'" + strings.ReplaceAll(arg, "'", "'\"'\"'") + "'"
 }
@@ -468,7 +702,10 @@ func (c *Cmd) Start() error {
 
 	// if they did not set an attacher, provide a default one
 	if c.fileServer == nil {
-		c.fileServer = &CPU9P{path: c.Root}
+		c.fileServer = NewCPU9P(c.Root, CPU9PXattrs(c.xattrs()...), CPU9PIDMap(c.ids), CPU9PCache(c.Cache))
+	}
+	if c.policy != nil {
+		c.fileServer = &policy9P{a: c.fileServer, p: c.policy}
 	}
 
 	if c.SessionIn, err = c.session.StdinPipe(); err != nil {
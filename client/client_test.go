@@ -5,9 +5,15 @@
 package client
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
 	"errors"
+	"net"
+	"slices"
 	"strconv"
 	"testing"
+
+	"golang.org/x/crypto/ssh"
 )
 
 func TestBadVsockHost(t *testing.T) {
@@ -53,3 +59,69 @@ func TestCmdWithDisablePrivateKey(t *testing.T) {
 		t.Fatal("WithDisablePrivateKey(true) should set DisablePrivateKey to true, got false")
 	}
 }
+
+// sshServer serves SSH connections, with no authentication, that
+// refuse all channels and requests, and returns its address.
+func sshServer(t *testing.T) string {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hk, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hk)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					ch.Reject(ssh.Prohibited, "no channels") //nolint
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestDialEnv(t *testing.T) {
+	host, port, err := net.SplitHostPort(sshServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// What the session is asked for is asked for even if it has
+	// no namespace.
+	for _, root := range []string{"", "/"} {
+		c := Command(host, "true")
+		if err := c.SetOptions(WithPort(port), WithDisablePrivateKey(true), WithRoot(root), WithLimits("mem=1G"), WithNamespaces("pid")); err != nil {
+			t.Fatal(err)
+		}
+		c.Env, c.Ninep = []string{}, false
+		if err := c.Dial(); err != nil {
+			t.Fatalf("Dial with root %q: %v", root, err)
+		}
+		defer c.client.Close()
+		if !slices.Contains(c.Env, "CPU_LIMITS=mem=1G") {
+			t.Errorf("Dial with root %q: environment %q has no CPU_LIMITS", root, c.Env)
+		}
+		if !slices.Contains(c.Env, "CPU_NAMESPACES=pid") {
+			t.Errorf("Dial with root %q: environment %q has no CPU_NAMESPACES", root, c.Env)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/u-root/cpu/sftp"
+)
+
+// SFTP starts an sftp subsystem session on the host, and returns a
+// client for it. The session is closed by Close, or with the Cmd.
+// No namespace is set up: paths are those of the host, and relative
+// paths relative to the home directory.
+func (c *Cmd) SFTP() (*sftp.Client, error) {
+	if c.client == nil {
+		return nil, fmt.Errorf("Cmd has no client")
+	}
+	s, err := c.client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	w, err := s.StdinPipe()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	r, err := s.StdoutPipe()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.RequestSubsystem("sftp"); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("sftp subsystem: %w", err)
+	}
+	cl, err := sftp.NewClient(r, w)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	c.closers = append(c.closers, func() error {
+		if err := s.Close(); err != nil && err != io.EOF {
+			return fmt.Errorf("closing sftp session: %v", err)
+		}
+		return nil
+	})
+	return cl, nil
+}
+
+// SplitRemote splits a name of a file on a host, as in scp, host:path,
+// into the host and path. The host can be user@host, and an IPv6
+// address is in brackets, e.g. [::1]:/tmp. A name with a / before the
+// first :, e.g. ./a:b, is a local name. An empty path is ".".
+func SplitRemote(name string) (host, file string, ok bool) {
+	i := strings.Index(name, ":")
+	if strings.HasPrefix(name, "[") {
+		if j := strings.Index(name, "]:"); j > 0 {
+			i = j + 1
+		}
+	}
+	if i < 1 || strings.Contains(name[:i], "/") {
+		return "", "", false
+	}
+	host, file = name[:i], name[i+1:]
+	if strings.HasPrefix(host, "[") {
+		host = strings.Trim(host, "[]")
+	}
+	if len(file) == 0 {
+		file = "."
+	}
+	return host, file, true
+}
+
+// CopyOption is an option of Copy.
+type CopyOption func(*copier)
+
+// CopyRecursive copies directories, and all in them.
+func CopyRecursive(r bool) CopyOption {
+	return func(c *copier) {
+		c.recursive = r
+	}
+}
+
+// CopyProgress shows the progress of each file copied on w, usually a
+// terminal.
+func CopyProgress(w io.Writer) CopyOption {
+	return func(c *copier) {
+		c.progress = w
+	}
+}
+
+// Copy copies files between this machine and the host c is dialed to,
+// as cp -p does: modes and modification times are kept. Either the
+// files in src or dst are on the host, named as SplitRemote describes,
+// with the host of c. If dst is a directory, or there are several
+// files in src, they are copied into it. Directories are copied only
+// with CopyRecursive; symbolic links in them are copied as links.
+//
+// The files are copied over the sftp subsystem, which cpud and sshd
+// both serve.
+func Copy(c *Cmd, src []string, dst string, opts ...CopyOption) error {
+	if len(src) == 0 {
+		return fmt.Errorf("no files to copy:%w", os.ErrInvalid)
+	}
+	var (
+		from   []string
+		remote int
+	)
+	for _, s := range append(append([]string{}, src...), dst) {
+		h, f, ok := SplitRemote(s)
+		if ok && h != c.Host && h != c.HostName {
+			return fmt.Errorf("%q is not on %q:%w", s, c.Host, os.ErrInvalid)
+		}
+		if ok {
+			remote++
+			s = f
+		}
+		from = append(from, s)
+	}
+	_, _, upload := SplitRemote(dst)
+	if want := len(src); (upload && remote != 1) || (!upload && remote != want) {
+		return fmt.Errorf("copy from %q to %q: either the files to copy or the destination must be on %q:%w", src, dst, c.Host, os.ErrInvalid)
+	}
+	cl, err := c.SFTP()
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	cp := &copier{src: localFS{}, dst: remoteFS{cl}}
+	if !upload {
+		cp.src, cp.dst = cp.dst, cp.src
+	}
+	for _, o := range opts {
+		o(cp)
+	}
+	return cp.copy(from[:len(src)], from[len(src)])
+}
+
+// fileSystem is the files on one side of a copy.
+type fileSystem interface {
+	Stat(string) (os.FileInfo, error)
+	ReadDir(string) ([]os.FileInfo, error)
+	Open(string) (io.ReadCloser, error)
+	Create(string, os.FileMode) (io.WriteCloser, error)
+	Mkdir(string, os.FileMode) error
+	Readlink(string) (string, error)
+	Symlink(string, string) error
+	Chmod(string, os.FileMode) error
+	Chtimes(string, time.Time, time.Time) error
+	Join(...string) string
+	Base(string) string
+}
+
+// localFS is the files of this machine.
+type localFS struct{}
+
+func (localFS) Stat(n string) (os.FileInfo, error) { return os.Stat(n) }
+func (localFS) Open(n string) (io.ReadCloser, error) {
+	return os.Open(n)
+}
+func (localFS) Create(n string, m os.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(n, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, m)
+}
+func (localFS) Mkdir(n string, m os.FileMode) error    { return os.Mkdir(n, m) }
+func (localFS) Readlink(n string) (string, error)      { return os.Readlink(n) }
+func (localFS) Symlink(t, n string) error              { return os.Symlink(t, n) }
+func (localFS) Chmod(n string, m os.FileMode) error    { return os.Chmod(n, m) }
+func (localFS) Chtimes(n string, a, m time.Time) error { return os.Chtimes(n, a, m) }
+func (localFS) Join(e ...string) string                { return filepath.Join(e...) }
+func (localFS) Base(n string) string                   { return filepath.Base(n) }
+func (localFS) ReadDir(n string) ([]os.FileInfo, error) {
+	ents, err := os.ReadDir(n)
+	if err != nil {
+		return nil, err
+	}
+	var fis []os.FileInfo
+	for _, e := range ents {
+		fi, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		fis = append(fis, fi)
+	}
+	return fis, nil
+}
+
+// remoteFS is the files of the host, over SFTP.
+type remoteFS struct {
+	*sftp.Client
+}
+
+func (r remoteFS) Open(n string) (io.ReadCloser, error) {
+	return r.Client.Open(n)
+}
+func (r remoteFS) Create(n string, m os.FileMode) (io.WriteCloser, error) {
+	return r.Client.Create(n, m)
+}
+func (remoteFS) Join(e ...string) string { return path.Join(e...) }
+func (remoteFS) Base(n string) string    { return path.Base(n) }
+
+// copier copies files from one fileSystem to another.
+type copier struct {
+	src, dst  fileSystem
+	recursive bool
+	progress  io.Writer
+}
+
+// copy copies the files src into dst, if it is a directory, or to it.
+func (c *copier) copy(src []string, dst string) error {
+	dfi, err := c.dst.Stat(dst)
+	into := err == nil && dfi.IsDir()
+	if len(src) > 1 && !into {
+		return fmt.Errorf("copying %d files to %q: not a directory:%w", len(src), dst, os.ErrInvalid)
+	}
+	var errs error
+	for _, s := range src {
+		fi, err := c.src.Stat(s)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		d := dst
+		if into {
+			d = c.dst.Join(dst, c.src.Base(s))
+		}
+		errs = errors.Join(errs, c.copyFile(s, d, fi))
+	}
+	return errs
+}
+
+// copyFile copies src, whose attributes are fi, to dst, and, for a
+// directory, all in it.
+func (c *copier) copyFile(src, dst string, fi os.FileInfo) error {
+	m := fi.Mode()
+	switch {
+	case m.IsDir():
+		if !c.recursive {
+			return fmt.Errorf("%q is a directory, and not copied without recursion:%w", src, os.ErrInvalid)
+		}
+		// Make it writable, until all in it is copied.
+		if err := c.dst.Mkdir(dst, m.Perm()|0o700); err != nil {
+			if dfi, serr := c.dst.Stat(dst); serr != nil || !dfi.IsDir() {
+				return err
+			}
+		}
+		fis, err := c.src.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		var errs error
+		for _, e := range fis {
+			errs = errors.Join(errs, c.copyFile(c.src.Join(src, e.Name()), c.dst.Join(dst, e.Name()), e))
+		}
+		return errors.Join(errs, c.attrs(dst, fi))
+	case m&os.ModeSymlink != 0:
+		t, err := c.src.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return c.dst.Symlink(t, dst)
+	case !m.IsRegular():
+		return fmt.Errorf("%q is not a regular file, and not copied:%w", src, os.ErrInvalid)
+	}
+
+	in, err := c.src.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := c.dst.Create(dst, m.Perm())
+	if err != nil {
+		return err
+	}
+	r, w := io.Reader(in), io.Writer(out)
+	if c.progress != nil {
+		p := &progress{w: c.progress, name: dst, size: fi.Size(), start: time.Now()}
+		defer p.finish()
+		// Count on this machine's side, so that io.Copy can use
+		// the WriteTo or ReadFrom of the sftp.File.
+		if _, ok := in.(*sftp.File); ok {
+			w = io.MultiWriter(out, p)
+		} else {
+			r = io.TeeReader(in, p)
+		}
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		out.Close()
+		return fmt.Errorf("copying %q to %q: %w", src, dst, err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return c.attrs(dst, fi)
+}
+
+// attrs sets the mode and times of dst to those of fi. The mode is set
+// after the file is made, since it was masked then.
+func (c *copier) attrs(dst string, fi os.FileInfo) error {
+	if err := c.dst.Chmod(dst, fi.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	return c.dst.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
+
+// progress shows how much of a file is copied.
+type progress struct {
+	w          io.Writer
+	name       string
+	size, done int64
+	start      time.Time
+	shown      time.Time
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if time.Since(p.shown) > 200*time.Millisecond {
+		p.show()
+	}
+	return len(b), nil
+}
+
+func (p *progress) show() {
+	p.shown = time.Now()
+	pct := int64(100)
+	if p.size > 0 {
+		pct = p.done * 100 / p.size
+	}
+	rate := int64(float64(p.done) / p.shown.Sub(p.start).Seconds())
+	fmt.Fprintf(p.w, "\r%-40s %3d%% %9s %9s/s", p.name, pct, humanBytes(p.done), humanBytes(rate))
+}
+
+// finish shows the progress once the file is copied.
+func (p *progress) finish() {
+	p.show()
+	fmt.Fprintln(p.w)
+}
+
+// humanBytes returns n, in bytes, in the largest unit that is at least
+// 1, e.g. 1.5MiB.
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	d, i := int64(unit), 0
+	for ; n/d >= unit && i < 5; i++ {
+		d *= unit
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(d), "KMGTPE"[i])
+}
@@ -0,0 +1,139 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/u-root/cpu/sftp"
+)
+
+func TestSplitRemote(t *testing.T) {
+	for _, tt := range []struct {
+		in         string
+		host, file string
+		ok         bool
+	}{
+		{in: "/etc/hosts"},
+		{in: "./a:b"},
+		{in: ":b"},
+		{in: "host:/etc/hosts", host: "host", file: "/etc/hosts", ok: true},
+		{in: "glenda@host:lib", host: "glenda@host", file: "lib", ok: true},
+		{in: "host:", host: "host", file: ".", ok: true},
+		{in: "[::1]:/tmp", host: "::1", file: "/tmp", ok: true},
+	} {
+		host, file, ok := SplitRemote(tt.in)
+		if host != tt.host || file != tt.file || ok != tt.ok {
+			t.Errorf("SplitRemote(%q): %q, %q, %v, want %q, %q, %v", tt.in, host, file, ok, tt.host, tt.file, tt.ok)
+		}
+	}
+}
+
+// sftpPipe returns a client of an SFTP server on pipes.
+func sftpPipe(t *testing.T) *sftp.Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	go func() {
+		sftp.Serve(sr, sw) //nolint
+		sw.Close()
+	}()
+	c, err := sftp.NewClient(cr, cw)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestCopy(t *testing.T) {
+	d := t.TempDir()
+	src := filepath.Join(d, "src")
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, f := range []struct {
+		name string
+		mode os.FileMode
+		data string
+	}{
+		{name: "a", mode: 0o640, data: "a"},
+		{name: "sub/b", mode: 0o755, data: strings.Repeat("b", 100000)},
+	} {
+		n := filepath.Join(src, f.name)
+		if err := os.MkdirAll(filepath.Dir(n), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(n, []byte(f.data), f.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(n, when, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a", filepath.Join(src, "l")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "sub"), 0o710); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(src, "sub"), when, when); err != nil {
+		t.Fatal(err)
+	}
+	c := sftpPipe(t)
+
+	up := filepath.Join(d, "up")
+	if err := (&copier{src: localFS{}, dst: remoteFS{c}}).copy([]string{src}, up); err == nil {
+		t.Errorf("copying a directory without recursion: nil, want an error")
+	}
+	var progress bytes.Buffer
+	if err := (&copier{src: localFS{}, dst: remoteFS{c}, recursive: true, progress: &progress}).copy([]string{src}, up); err != nil {
+		t.Fatalf("copying to the server: %v", err)
+	}
+	if !strings.Contains(progress.String(), "100%") {
+		t.Errorf("progress: %q, want 100%%", progress.String())
+	}
+	// Copying to a directory copies into it.
+	down := filepath.Join(d, "down")
+	if err := os.Mkdir(down, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&copier{src: remoteFS{c}, dst: localFS{}, recursive: true}).copy([]string{up}, down); err != nil {
+		t.Fatalf("copying from the server: %v", err)
+	}
+	for _, dir := range []string{up, filepath.Join(down, "up")} {
+		for _, f := range []struct {
+			name string
+			mode os.FileMode
+		}{
+			{name: "a", mode: 0o640},
+			{name: "sub", mode: os.ModeDir | 0o710},
+			{name: "sub/b", mode: 0o755},
+		} {
+			n := filepath.Join(dir, f.name)
+			fi, err := os.Stat(n)
+			if err != nil {
+				t.Errorf("%s: %v", n, err)
+				continue
+			}
+			if fi.Mode() != f.mode || !fi.ModTime().Equal(when) {
+				t.Errorf("%s: mode %v, time %v, want %v, %v", n, fi.Mode(), fi.ModTime(), f.mode, when)
+			}
+		}
+		if b, err := os.ReadFile(filepath.Join(dir, "sub/b")); err != nil || len(b) != 100000 {
+			t.Errorf("%s/sub/b: %d bytes, %v, want 100000", dir, len(b), err)
+		}
+		if s, err := os.Readlink(filepath.Join(dir, "l")); err != nil || s != "a" {
+			t.Errorf("%s/l: %q, %v, want a link to a", dir, s, err)
+		}
+	}
+
+	if err := (&copier{src: localFS{}, dst: remoteFS{c}}).copy([]string{filepath.Join(src, "a"), filepath.Join(src, "l")}, filepath.Join(d, "none")); err == nil {
+		t.Errorf("copying two files to a directory that does not exist: nil, want an error")
+	}
+}
@@ -15,9 +15,9 @@
 package client
 
 import (
-	"fmt"
 	"io"
 	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -31,9 +31,7 @@ import (
 type CPIO9P struct {
 	p9.DefaultWalkGetAttr
 
-	rr   cpio.RecordReader
-	m    map[string]uint64
-	recs []cpio.Record
+	recs cpioRecords
 }
 
 // CPIO9PFID defines a FID.
@@ -51,39 +49,45 @@ type CPIO9PFID struct {
 }
 
 // NewCPIO9P returns a CPIO9P, properly initialized, from a path.
+// The cpio may be compressed with gzip or zstd. Its records are read
+// as they are used, from an index of them made in one pass over it,
+// or from its sidecar index, as written by WriteCPIOIndex.
 func NewCPIO9P(c string) (*CPIO9P, error) {
-	f, err := os.Open(c)
+	recs, err := openCPIO(c)
 	if err != nil {
 		return nil, err
 	}
-	return NewCPIO9PReaderAt(f)
+	return newCPIO9P(recs), nil
 }
 
 // NewCPIO9PReaderAt returns a CPIO9P, properly initialized, from an io.ReaderAt.
+// It is as NewCPIO9P, save that there is no sidecar index.
 func NewCPIO9PReaderAt(r io.ReaderAt) (*CPIO9P, error) {
-	archive, err := cpio.Format("newc")
+	u, _, _, err := uncompressed(r, math.MaxInt64)
 	if err != nil {
 		return nil, err
 	}
-
-	rr := archive.Reader(r)
-
-	recs, err := cpio.ReadAllRecords(rr)
-	if len(recs) == 0 {
-		return nil, fmt.Errorf("cpio:No records: %w", os.ErrInvalid)
-	}
-
+	recs, err := indexCPIO(u)
 	if err != nil {
 		return nil, err
 	}
+	return newCPIO9P(recs), nil
+}
 
-	m := map[string]uint64{}
-	for i, r := range recs {
-		v("put %s in %d", r.Info.Name, i)
-		m[r.Info.Name] = uint64(i)
+// NewImage9P returns a CPIO9P of the image ref, which may be empty if
+// there is only one, in the docker save tarball or OCI image layout at
+// path, as described in ImageRecords.
+func NewImage9P(path, ref string) (*CPIO9P, error) {
+	recs, err := ImageRecords(path, ref)
+	if err != nil {
+		return nil, err
 	}
+	return newCPIO9P(newMemRecords(recs)), nil
+}
 
-	return &CPIO9P{rr: rr, recs: recs, m: m}, nil
+// newCPIO9P returns a CPIO9P of recs.
+func newCPIO9P(recs cpioRecords) *CPIO9P {
+	return &CPIO9P{recs: recs}
 }
 
 // Attach implements p9.Attacher.Attach.
@@ -98,11 +102,12 @@ var (
 )
 
 func (l *CPIO9PFID) rec() (*cpio.Record, error) {
-	if int(l.path) > len(l.fs.recs) {
-		return nil, os.ErrNotExist
+	r, err := l.fs.recs.record(l.path)
+	if err != nil {
+		return nil, err
 	}
-	v("cpio:rec for %v is %v", l, l.fs.recs[l.path])
-	return &l.fs.recs[l.path], nil
+	v("cpio:rec for %v is %v", l, r)
+	return r, nil
 }
 
 // info constructs a QID for this file.
@@ -167,7 +172,7 @@ func (l *CPIO9PFID) Walk(names []string) ([]p9.QID, p9.File, error) {
 	}
 	for _, name := range names {
 		fullpath = filepath.Join(fullpath, name)
-		ix, ok := l.fs.m[fullpath]
+		ix, ok := l.fs.recs.lookup(fullpath)
 		verbose("cpio:Walk %q get %v, %v", fullpath, ix, ok)
 		if !ok {
 			return nil, nil, os.ErrNotExist
@@ -250,27 +255,9 @@ func (l *CPIO9PFID) readdir() ([]uint64, error) {
 	if err != nil {
 		return nil, err
 	}
-	dn := r.Info.Name
 	verbose("cpio:readdir starts from %v %v", l, r)
-	// while the name is a prefix of the records we are scanning,
-	// append the record.
-	// This can not be returned as a range as we do not want
-	// contents of all subdirs.
-	var list []uint64
-	for i, r := range l.fs.recs[l.path+1:] {
-		// filepath.Rel fails, we're done here.
-		b, err := filepath.Rel(dn, r.Name)
-		if err != nil {
-			verbose("cpio:r.Name %q: DONE", r.Name)
-			break
-		}
-		dir, _ := filepath.Split(b)
-		if len(dir) > 0 {
-			continue
-		}
-		verbose("cpio:readdir: %v", i)
-		list = append(list, uint64(i)+l.path+1)
-	}
+	list := children(l.fs.recs, l.path)
+	verbose("cpio:readdir: %v", list)
 	return list, nil
 }
 
@@ -286,20 +273,22 @@ func (l *CPIO9PFID) Readdir(offset uint64, count uint32) (p9.Dirents, error) {
 	if err != nil {
 		return nil, err
 	}
-	if offset > uint64(len(list)) {
-		return nil, io.EOF
-	}
 	verbose("cpio:readdir list %v", list)
+	// The offset is where in the list, with '.' first, the
+	// reader left off, as in CPU9P.Readdir.
 	var dirents p9.Dirents
-	dirents = append(dirents, p9.Dirent{
-		QID:    qid,
-		Type:   qid.Type,
-		Name:   ".",
-		Offset: l.path,
-	})
-	verbose("cpio:add path %d '.'", l.path)
-	//log.Printf("cpio:readdir %q returns %d entries start at offset %d", l.path, len(fi), offset)
-	for _, i := range list[offset:] {
+	if offset == 0 {
+		dirents = append(dirents, p9.Dirent{
+			QID:    qid,
+			Type:   qid.Type,
+			Name:   ".",
+			Offset: 1,
+		})
+		verbose("cpio:add path %d '.'", l.path)
+		offset++
+	}
+	for o := offset; o <= uint64(len(list)); o++ {
+		i := list[o-1]
 		entry := CPIO9PFID{path: i, fs: l.fs}
 		qid, _, err := entry.info()
 		if err != nil {
@@ -314,7 +303,7 @@ func (l *CPIO9PFID) Readdir(offset uint64, count uint32) (p9.Dirents, error) {
 			QID:    qid,
 			Type:   qid.Type,
 			Name:   filepath.Base(r.Info.Name),
-			Offset: i,
+			Offset: o + 1,
 		})
 	}
 
@@ -169,6 +169,14 @@ func TestCPIO9P(t *testing.T) {
 		t.Fatalf("readdir on root: want %d entries, got %d", 3, len(dirs))
 	}
 	t.Logf("readdir / %v", dirs)
+
+	// Reading on from where the last read left off finds the end.
+	if dirs, err = root.Readdir(dirs[1].Offset, 64*1024); err != nil || len(dirs) != 1 {
+		t.Fatalf("readdir on root from the second entry: want 1 entry, nil, got %v, %v", dirs, err)
+	}
+	if dirs, err = root.Readdir(dirs[0].Offset, 64*1024); err != nil || len(dirs) != 0 {
+		t.Fatalf("readdir on root from the end: want no entries, nil, got %v, %v", dirs, err)
+	}
 }
 
 func TestCPIOReadLink(t *testing.T) {
@@ -178,9 +186,13 @@ func TestCPIOReadLink(t *testing.T) {
 		t.Fatalf("data/a.cpio: got %v, want nil", err)
 	}
 
-	t.Logf("[2] is %v #%x", fs.recs[2], fs.recs[2])
-	t.Logf("[3] is %v #%x", fs.recs[3], fs.recs[3])
-	t.Logf("mode %#x", fs.recs[3].Mode)
+	for _, i := range []uint64{2, 3} {
+		r, err := fs.recs.record(i)
+		if err != nil {
+			t.Fatalf("record %d: got %v, want nil", i, err)
+		}
+		t.Logf("[%d] is %v #%x, mode %#x", i, r, r, r.Mode)
+	}
 	// See if anything is there.
 	root, err := fs.Attach()
 	if err != nil {
@@ -0,0 +1,413 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/cpio"
+)
+
+// cpioRecords are the records of a cpio, each known by its place in
+// it. The root is the first.
+type cpioRecords interface {
+	// count returns how many records there are.
+	count() uint64
+	// name returns the name of record i.
+	name(i uint64) string
+	// record returns record i.
+	record(i uint64) (*cpio.Record, error)
+	// lookup returns the place of the record named n; if there is
+	// more than one, the last.
+	lookup(n string) (uint64, bool)
+}
+
+// children returns the places of the records in the directory at
+// place dir. Records are in some sort of order in a cpio, but what a
+// directory has in it need not be together, so they are all looked at.
+func children(recs cpioRecords, dir uint64) []uint64 {
+	dn := recs.name(dir)
+	var list []uint64
+	for i := dir + 1; i < recs.count(); i++ {
+		if n := recs.name(i); n != dn && path.Dir(n) == dn {
+			list = append(list, i)
+		}
+	}
+	return list
+}
+
+// memRecords are cpioRecords that are all in memory, as those of an
+// image are.
+type memRecords struct {
+	recs []cpio.Record
+	m    map[string]uint64
+}
+
+var (
+	_ cpioRecords = &memRecords{}
+	_ cpioRecords = &cpioIndex{}
+)
+
+// newMemRecords returns the memRecords of recs.
+func newMemRecords(recs []cpio.Record) *memRecords {
+	m := map[string]uint64{}
+	for i, r := range recs {
+		v("put %s in %d", r.Info.Name, i)
+		m[r.Info.Name] = uint64(i)
+	}
+	return &memRecords{recs: recs, m: m}
+}
+
+func (m *memRecords) count() uint64 {
+	return uint64(len(m.recs))
+}
+
+func (m *memRecords) name(i uint64) string {
+	return m.recs[i].Name
+}
+
+func (m *memRecords) record(i uint64) (*cpio.Record, error) {
+	if i >= uint64(len(m.recs)) {
+		return nil, os.ErrNotExist
+	}
+	return &m.recs[i], nil
+}
+
+func (m *memRecords) lookup(n string) (uint64, bool) {
+	i, ok := m.m[n]
+	return i, ok
+}
+
+// cpioIndex are the cpioRecords of a cpio that are read only as they
+// are used. For each record, it knows no more than its name, and where
+// its header is: even for a cpio of many GiB, and millions of files,
+// that is not much, and it is all found in one pass over the headers.
+type cpioIndex struct {
+	format cpio.RecordFormat
+	// r is the cpio, decompressed, if it is compressed.
+	r io.ReaderAt
+
+	// names are the names of the records, one after another, and
+	// ends where each of them ends.
+	names string
+	ends  []uint64
+	// offsets are where the header of each record is in r.
+	offsets []int64
+	// byName are the places of the records, sorted by name.
+	byName []uint32
+}
+
+func (x *cpioIndex) count() uint64 {
+	return uint64(len(x.ends))
+}
+
+func (x *cpioIndex) name(i uint64) string {
+	var start uint64
+	if i > 0 {
+		start = x.ends[i-1]
+	}
+	return x.names[start:x.ends[i]]
+}
+
+// record reads record i, from its header; its contents are read, in
+// turn, only as they are read.
+func (x *cpioIndex) record(i uint64) (*cpio.Record, error) {
+	if i >= x.count() {
+		return nil, os.ErrNotExist
+	}
+	off := x.offsets[i]
+	r, err := x.format.Reader(io.NewSectionReader(x.r, off, math.MaxInt64-off)).ReadRecord()
+	if err != nil {
+		return nil, fmt.Errorf("cpio:record %d at %d:%w", i, off, err)
+	}
+	r.RecPos += off
+	r.FilePos += off
+	r.ReaderAt = io.NewSectionReader(x.r, r.FilePos, int64(r.FileSize))
+	return &r, nil
+}
+
+func (x *cpioIndex) lookup(n string) (uint64, bool) {
+	// The last of those of that name is the one that counts, as it
+	// would be if the cpio were unpacked.
+	j := sort.Search(len(x.byName), func(j int) bool { return x.name(uint64(x.byName[j])) > n }) - 1
+	if j < 0 || x.name(uint64(x.byName[j])) != n {
+		return 0, false
+	}
+	return uint64(x.byName[j]), true
+}
+
+// blockReaderAt is an io.ReaderAt that reads r a block at a time, so
+// that the many small reads of the headers of a cpio, one after the
+// other, are not each a read of r. It is not safe for concurrent use.
+type blockReaderAt struct {
+	r   io.ReaderAt
+	off int64
+	b   []byte
+}
+
+// ReadAt implements io.ReaderAt.
+func (b *blockReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	const blockSize = 1 << 16
+	if off < b.off || off+int64(len(p)) > b.off+int64(len(b.b)) {
+		if len(p) > blockSize {
+			return b.r.ReadAt(p, off)
+		}
+		if b.b == nil {
+			b.b = make([]byte, blockSize)
+		}
+		n, err := b.r.ReadAt(b.b[:blockSize], off)
+		if err != nil && err != io.EOF {
+			b.b = b.b[:0]
+			return 0, err
+		}
+		b.off, b.b = off, b.b[:n]
+	}
+	n := copy(p, b.b[off-b.off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// indexCPIO returns the cpioIndex of the cpio r, which is not
+// compressed, reading each of its headers, once.
+func indexCPIO(r io.ReaderAt) (*cpioIndex, error) {
+	format, err := cpio.Format("newc")
+	if err != nil {
+		return nil, err
+	}
+	x := &cpioIndex{format: format, r: r}
+	var names strings.Builder
+	rr := format.Reader(&blockReaderAt{r: r})
+	for {
+		rec, err := rr.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		names.WriteString(rec.Name)
+		x.ends = append(x.ends, uint64(names.Len()))
+		x.offsets = append(x.offsets, rec.RecPos)
+	}
+	if len(x.ends) == 0 {
+		return nil, fmt.Errorf("cpio:No records: %w", os.ErrInvalid)
+	}
+	if len(x.ends) > math.MaxUint32 {
+		return nil, fmt.Errorf("cpio:%d records is too many:%w", len(x.ends), os.ErrInvalid)
+	}
+	x.names = names.String()
+	x.byName = make([]uint32, len(x.ends))
+	for i := range x.byName {
+		x.byName[i] = uint32(i)
+	}
+	sort.SliceStable(x.byName, func(i, j int) bool {
+		return x.name(uint64(x.byName[i])) < x.name(uint64(x.byName[j]))
+	})
+	return x, nil
+}
+
+// uncompressed returns r, of at most size bytes, or, if it is
+// compressed, what it decompresses to, and how it is compressed, and
+// its chunks. If its chunks are too big to decompress one at a time,
+// as the one chunk of a large file from plain gzip is, it is
+// decompressed, once, to a temporary file, and there are no chunks.
+func uncompressed(r io.ReaderAt, size int64) (io.ReaderAt, string, []chunk, error) {
+	format := compression(r)
+	if format == "" {
+		return r, "", nil, nil
+	}
+	cs, err := chunks(r, size, format)
+	if err == nil {
+		return newSeekable(r, format, cs), format, cs, nil
+	}
+	verbose("cpio:%v: decompressing it all", err)
+	d, _, err := decompress(r, size)
+	return d, format, nil, err
+}
+
+// cpioIndexVersion is the version of the sidecar index; one of another
+// version is not used.
+const cpioIndexVersion = 1
+
+// cpioIndexFile is what is in the sidecar index of a cpio, as written
+// by WriteCPIOIndex: the cpioIndex, and, if it is compressed, its
+// chunks. It is only used if the cpio is the size, and has the
+// modification time, it had when it was written.
+type cpioIndexFile struct {
+	Version int
+	Size    int64
+	ModTime int64
+	Format  string
+	Chunks  []chunk
+	Names   string
+	Ends    []uint64
+	Offsets []int64
+	ByName  []uint32
+}
+
+// valid returns an error if xf is not consistent, as it would not be if
+// it were corrupt, in ways that would make its use panic or decompress
+// more than maxChunk at a time.
+func (xf *cpioIndexFile) valid() error {
+	n := len(xf.Ends)
+	if n == 0 || len(xf.Offsets) != n || len(xf.ByName) != n || xf.Ends[n-1] != uint64(len(xf.Names)) {
+		return fmt.Errorf("%d names, offsets and sorted records do not match:%w", n, os.ErrInvalid)
+	}
+	for i := range xf.Ends {
+		if i > 0 && xf.Ends[i] < xf.Ends[i-1] {
+			return fmt.Errorf("name %d ends before name %d:%w", i, i-1, os.ErrInvalid)
+		}
+		if xf.Offsets[i] < 0 {
+			return fmt.Errorf("record %d is at %d:%w", i, xf.Offsets[i], os.ErrInvalid)
+		}
+		if uint64(xf.ByName[i]) >= uint64(n) {
+			return fmt.Errorf("sorted record %d is %d, of %d:%w", i, xf.ByName[i], n, os.ErrInvalid)
+		}
+	}
+	if xf.Format == "" || len(xf.Chunks) == 0 {
+		return nil
+	}
+	if len(xf.Chunks) < 2 || xf.Chunks[0].UOff != 0 {
+		return fmt.Errorf("chunks %v do not start at 0 and end:%w", xf.Chunks, os.ErrInvalid)
+	}
+	for i := 1; i < len(xf.Chunks); i++ {
+		c, p := xf.Chunks[i], xf.Chunks[i-1]
+		if c.Off < p.Off || c.UOff < p.UOff || c.UOff-p.UOff > maxChunk {
+			return fmt.Errorf("chunk %d, %v, does not follow chunk %d, %v:%w", i, c, i-1, p, os.ErrInvalid)
+		}
+	}
+	return nil
+}
+
+// CPIOIndexName returns the name of the sidecar index of the cpio c.
+func CPIOIndexName(c string) string {
+	return c + ".idx"
+}
+
+// readCPIOIndex returns the cpioIndex of f, of which fi is the
+// os.FileInfo, from the sidecar index in n.
+func readCPIOIndex(n string, f *os.File, fi os.FileInfo) (*cpioIndex, error) {
+	i, err := os.Open(n)
+	if err != nil {
+		return nil, err
+	}
+	defer i.Close()
+	var xf cpioIndexFile
+	if err := gob.NewDecoder(i).Decode(&xf); err != nil {
+		return nil, fmt.Errorf("%q:%w", n, err)
+	}
+	if xf.Version != cpioIndexVersion || xf.Size != fi.Size() || xf.ModTime != fi.ModTime().UnixNano() {
+		return nil, fmt.Errorf("%q is not the index of %q as it is now:%w", n, f.Name(), os.ErrInvalid)
+	}
+	if err := xf.valid(); err != nil {
+		return nil, fmt.Errorf("%q:%w", n, err)
+	}
+	var r io.ReaderAt = f
+	switch {
+	case xf.Format == "":
+	case len(xf.Chunks) > 0:
+		r = newSeekable(f, xf.Format, xf.Chunks)
+	default:
+		if r, _, err = decompress(f, fi.Size()); err != nil {
+			return nil, err
+		}
+	}
+	format, err := cpio.Format("newc")
+	if err != nil {
+		return nil, err
+	}
+	return &cpioIndex{format: format, r: r, names: xf.Names, ends: xf.Ends, offsets: xf.Offsets, byName: xf.ByName}, nil
+}
+
+// openCPIO returns the cpioRecords of the cpio at c, which may be
+// compressed with gzip or zstd, from its sidecar index, if it has one
+// that is up to date, or from a pass over it, if not.
+func openCPIO(c string) (cpioRecords, error) {
+	f, err := os.Open(c)
+	if err != nil {
+		return nil, err
+	}
+	x, _, err := openCPIOIndex(c, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return x, nil
+}
+
+// openCPIOIndex is openCPIO, of the cpio c, open as f, also returning
+// what is needed to write the sidecar index, if it was not read from
+// one.
+func openCPIOIndex(c string, f *os.File) (*cpioIndex, *cpioIndexFile, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	x, err := readCPIOIndex(CPIOIndexName(c), f, fi)
+	if err == nil {
+		verbose("cpio:%q: %d records from %q", c, x.count(), CPIOIndexName(c))
+		return x, nil, nil
+	}
+	verbose("cpio:%q: not using the index:%v", c, err)
+	r, format, cs, err := uncompressed(f, fi.Size())
+	if err != nil {
+		return nil, nil, err
+	}
+	if x, err = indexCPIO(r); err != nil {
+		return nil, nil, fmt.Errorf("cpio:%q:%w", c, err)
+	}
+	verbose("cpio:%q: %d records", c, x.count())
+	return x, &cpioIndexFile{
+		Version: cpioIndexVersion,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime().UnixNano(),
+		Format:  format,
+		Chunks:  cs,
+		Names:   x.names,
+		Ends:    x.ends,
+		Offsets: x.offsets,
+		ByName:  x.byName,
+	}, nil
+}
+
+// WriteCPIOIndex writes the sidecar index of the cpio at c, as named
+// by CPIOIndexName, unless there is one that is up to date. With it,
+// NewCPIO9P and NewfsCPIO need not read the headers of the cpio, nor,
+// if it is compressed, decompress it, to know what is in it.
+func WriteCPIOIndex(c string) error {
+	f, err := os.Open(c)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, xf, err := openCPIOIndex(c, f)
+	if err != nil || xf == nil {
+		return err
+	}
+	n := CPIOIndexName(c)
+	i, err := os.CreateTemp(filepath.Dir(n), filepath.Base(n))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(i.Name())
+	if err := gob.NewEncoder(i).Encode(xf); err != nil {
+		i.Close()
+		return fmt.Errorf("%q:%w", n, err)
+	}
+	if err := i.Close(); err != nil {
+		return err
+	}
+	return os.Rename(i.Name(), n)
+}
@@ -0,0 +1,314 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/u-root/u-root/pkg/cpio"
+)
+
+// mkCPIO returns a cpio with a few files, one of them twice, and a
+// big one.
+func mkCPIO(t *testing.T) []byte {
+	var b bytes.Buffer
+	archive, err := cpio.Format("newc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The newc writer writes a name only once, so the second half,
+	// which has a name the first has, is written with another.
+	halves := [][]cpio.Record{{
+		cpio.Directory(".", 0o755),
+		cpio.Directory("etc", 0o755),
+		cpio.StaticFile("etc/hosts", "old", 0o644),
+		cpio.Directory("bin", 0o755),
+		cpio.StaticFile("bin/big", string(bytes.Repeat([]byte("0123456789abcdef"), 4096)), 0o755),
+		cpio.Symlink("bin/sh", "big"),
+	}, {
+		cpio.StaticFile("etc/hosts", "new", 0o644),
+		cpio.StaticFile("zz", "", 0o644),
+	}}
+	var w cpio.RecordWriter
+	for _, recs := range halves {
+		w = archive.Writer(&b)
+		if err := cpio.WriteRecords(w, recs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cpio.WriteTrailer(w); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// pieces splits b into pieces of n bytes, each compressed alone by c.
+func pieces(t *testing.T, b []byte, n int, c func([]byte) []byte) []byte {
+	var out []byte
+	for len(b) > 0 {
+		p := b[:min(n, len(b))]
+		b = b[len(p):]
+		out = append(out, c(p)...)
+	}
+	return out
+}
+
+func gzipPiece(t *testing.T) func([]byte) []byte {
+	return func(b []byte) []byte {
+		var out bytes.Buffer
+		z := gzip.NewWriter(&out)
+		if _, err := z.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+}
+
+// zstdPiece returns a zstd frame that says how much it decompresses
+// to, or, if it is streamed, one that does not.
+func zstdPiece(t *testing.T, streamed bool) func([]byte) []byte {
+	return func(b []byte) []byte {
+		if !streamed {
+			z, err := zstd.NewWriter(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return z.EncodeAll(b, nil)
+		}
+		var out bytes.Buffer
+		z, err := zstd.NewWriter(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := z.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+}
+
+// checkRecords checks that recs are the records of the cpio plain.
+func checkRecords(t *testing.T, recs cpioRecords, plain []byte) {
+	t.Helper()
+	archive, err := cpio.Format("newc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := cpio.ReadAllRecords(archive.Reader(bytes.NewReader(plain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recs.count() != uint64(len(want)) {
+		t.Fatalf("%d records, want %d", recs.count(), len(want))
+	}
+	for i, w := range want {
+		r, err := recs.record(uint64(i))
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if r.Info != w.Info || recs.name(uint64(i)) != w.Name {
+			t.Errorf("record %d: %v, %q, want %v", i, r.Info, recs.name(uint64(i)), w.Info)
+		}
+		got, err := io.ReadAll(io.NewSectionReader(r, 0, int64(r.FileSize)))
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		wb, _ := io.ReadAll(io.NewSectionReader(w, 0, int64(w.FileSize)))
+		if !bytes.Equal(got, wb) {
+			t.Errorf("record %d: %d bytes, not the %d bytes it should have", i, len(got), len(wb))
+		}
+	}
+	if _, err := recs.record(recs.count()); err == nil {
+		t.Errorf("record %d: nil, want err", recs.count())
+	}
+	for _, tt := range []struct {
+		name string
+		want uint64
+		ok   bool
+	}{
+		{name: ".", want: 0, ok: true},
+		{name: "bin/big", want: 4, ok: true},
+		{name: "etc/hosts", want: 6, ok: true},
+		{name: "zz", want: 7, ok: true},
+		{name: "etc/passwd"},
+		{name: "a"},
+		{name: "zzz"},
+	} {
+		if i, ok := recs.lookup(tt.name); i != tt.want || ok != tt.ok {
+			t.Errorf("lookup(%q): (%d, %v), want (%d, %v)", tt.name, i, ok, tt.want, tt.ok)
+		}
+	}
+	if got := fmt.Sprint(children(recs, 1)); got != "[2 6]" {
+		t.Errorf("children of etc: %s, want [2 6]", got)
+	}
+}
+
+func TestCPIOIndex(t *testing.T) {
+	plain := mkCPIO(t)
+	for _, tt := range []struct {
+		name     string
+		b        []byte
+		maxChunk int64
+		chunks   int
+	}{
+		{name: "plain", b: plain},
+		{name: "gzip", b: pieces(t, plain, 10000, gzipPiece(t)), chunks: (len(plain) + 9999) / 10000},
+		{name: "zstd", b: pieces(t, plain, 10000, zstdPiece(t, false)), chunks: (len(plain) + 9999) / 10000},
+		{name: "zstd streamed", b: pieces(t, plain, 10000, zstdPiece(t, true)), chunks: (len(plain) + 9999) / 10000},
+		{name: "gzip one member", b: gzipPiece(t)(plain), chunks: 1},
+		// Chunks too big to keep in memory are not used, and the
+		// cpio is decompressed to a temporary file.
+		{name: "gzip big member", b: gzipPiece(t)(plain), maxChunk: 1024},
+		{name: "zstd big frames", b: pieces(t, plain, 10000, zstdPiece(t, true)), maxChunk: 1024},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.maxChunk > 0 {
+				defer func(m int64) { maxChunk = m }(maxChunk)
+				maxChunk = tt.maxChunk
+			}
+			r, _, cs, err := uncompressed(bytes.NewReader(tt.b), int64(len(tt.b)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.chunks > 0 && len(cs) != tt.chunks+1 {
+				t.Errorf("%d chunks, want %d", len(cs)-1, tt.chunks)
+			}
+			// Reads that are in more than one chunk, or go
+			// past the end, read what is there.
+			for _, off := range []int64{0, 9990, 19999, int64(len(plain)) - 5} {
+				got := make([]byte, 20)
+				n, err := r.ReadAt(got, off)
+				want := plain[off:min(off+20, int64(len(plain)))]
+				if n != len(want) || !bytes.Equal(got[:n], want) || (n < len(got)) != (err == io.EOF) {
+					t.Errorf("ReadAt(%d): (%d, %v), want %d bytes", off, n, err, len(want))
+				}
+			}
+			x, err := indexCPIO(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRecords(t, x, plain)
+		})
+	}
+	if _, err := indexCPIO(bytes.NewReader([]byte("bogus"))); err == nil {
+		t.Errorf("indexCPIO(bogus): nil, want err")
+	}
+}
+
+func TestCPIOIndexFile(t *testing.T) {
+	plain := mkCPIO(t)
+	c := filepath.Join(t.TempDir(), "root.cpio.zst")
+	if err := os.WriteFile(c, pieces(t, plain, 4096, zstdPiece(t, false)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCPIOIndex(c); err != nil {
+		t.Fatalf("WriteCPIOIndex: %v", err)
+	}
+	// open returns the records of c, and whether they were from the
+	// sidecar index.
+	open := func() (cpioRecords, bool) {
+		f, err := os.Open(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		x, xf, err := openCPIOIndex(c, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return x, xf == nil
+	}
+	x, indexed := open()
+	if !indexed {
+		t.Errorf("%q was not used", CPIOIndexName(c))
+	}
+	checkRecords(t, x, plain)
+
+	// An index is not used once the cpio changes, nor if it is
+	// not an index, and it is written again, if asked.
+	if err := os.Chtimes(c, time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, indexed := open(); indexed {
+		t.Errorf("%q was used, though it is out of date", CPIOIndexName(c))
+	}
+	if err := WriteCPIOIndex(c); err != nil {
+		t.Fatalf("WriteCPIOIndex: %v", err)
+	}
+	if _, indexed := open(); !indexed {
+		t.Errorf("%q was not used, though it was written again", CPIOIndexName(c))
+	}
+	b, err := os.ReadFile(CPIOIndexName(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(CPIOIndexName(c), []byte("bogus"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	x, indexed = open()
+	if indexed {
+		t.Errorf("%q was used, though it is bogus", CPIOIndexName(c))
+	}
+	checkRecords(t, x, plain)
+
+	// Nor is an index that is not consistent, as it would not be if
+	// it were corrupt.
+	for _, tt := range []struct {
+		name    string
+		corrupt func(xf *cpioIndexFile)
+	}{
+		{name: "names out of order", corrupt: func(xf *cpioIndexFile) { xf.Ends[0], xf.Ends[1] = xf.Ends[1], xf.Ends[0] }},
+		{name: "name past the end", corrupt: func(xf *cpioIndexFile) { xf.Ends[1] = uint64(len(xf.Names)) + 1 }},
+		{name: "sorted record out of range", corrupt: func(xf *cpioIndexFile) { xf.ByName[0] = uint32(len(xf.Ends)) }},
+		{name: "record before the start", corrupt: func(xf *cpioIndexFile) { xf.Offsets[1] = -1 }},
+		{name: "chunks out of order", corrupt: func(xf *cpioIndexFile) { xf.Chunks[1].UOff = xf.Chunks[len(xf.Chunks)-1].UOff + 1 }},
+		{name: "chunk not at 0", corrupt: func(xf *cpioIndexFile) { xf.Chunks[0].UOff = 1 }},
+	} {
+		var xf cpioIndexFile
+		if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&xf); err != nil {
+			t.Fatal(err)
+		}
+		tt.corrupt(&xf)
+		var w bytes.Buffer
+		if err := gob.NewEncoder(&w).Encode(&xf); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(CPIOIndexName(c), w.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		x, indexed = open()
+		if indexed {
+			t.Errorf("%q was used, though it has %s", CPIOIndexName(c), tt.name)
+		}
+		checkRecords(t, x, plain)
+	}
+
+	// The 9P server and the NFS file system of the cpio use it.
+	s, err := NewCPIO9P(c)
+	if err != nil {
+		t.Fatalf("NewCPIO9P: %v", err)
+	}
+	checkRecords(t, s.recs, plain)
+	fs, err := NewfsCPIO(c)
+	if err != nil {
+		t.Fatalf("NewfsCPIO: %v", err)
+	}
+	checkRecords(t, fs.recs, plain)
+}
@@ -17,32 +17,76 @@ package client
 import (
 	"log"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
-	"syscall"
+	"time"
 
-	"github.com/hugelgupf/p9/fsimpl/xattr"
+	"github.com/hugelgupf/p9/linux"
 	"github.com/hugelgupf/p9/p9"
 	"golang.org/x/sys/unix"
 )
 
-// CPU9P is a p9.Attacher.
+// CPU9P is a p9.Attacher. It serves the files beneath its root, and
+// nothing outside it: see cpuRoot.
 type CPU9P struct {
 	p9.DefaultWalkGetAttr
 
+	root *cpuRoot
+	// path is the name of the file relative to the root, "." for
+	// the root itself.
 	path string
 	file *os.File
 }
 
+// CPU9POption is an option of NewCPU9P.
+type CPU9POption func(*cpuRoot)
+
+// CPU9PXattrs sets the namespaces of the extended attributes served,
+// e.g. user and security; by default, only user attributes are. With
+// none, no attributes are served.
+func CPU9PXattrs(namespaces ...string) CPU9POption {
+	return func(r *cpuRoot) {
+		r.xattrs = namespaces
+	}
+}
+
+// CPU9PIDMap sets the map of the user and group ids of the files
+// served; by default, they are not mapped.
+func CPU9PIDMap(m *IDMap) CPU9POption {
+	return func(r *cpuRoot) {
+		r.ids = m
+	}
+}
+
+// CPU9PCache caches the attributes of files, and the contents of
+// directories, for up to ttl, so that over a slow link, walks, stats
+// and directory reads of the same names do not each go to the disk.
+// Changes made through the CPU9P are seen at once; on Linux, others
+// are seen as inotify reports them, and elsewhere, as the cache
+// expires. By default, nothing is cached.
+func CPU9PCache(ttl time.Duration) CPU9POption {
+	return func(r *cpuRoot) {
+		if ttl > 0 {
+			r.cache = newAttrCache(r, ttl)
+		}
+	}
+}
+
 // NewCPU9P returns a CPU9P, properly initialized.
-func NewCPU9P(root string) *CPU9P {
-	return &CPU9P{path: root}
+func NewCPU9P(root string, opts ...CPU9POption) *CPU9P {
+	r := &cpuRoot{path: root, xattrs: []string{"user"}}
+	for _, o := range opts {
+		o(r)
+	}
+	return &CPU9P{root: r, path: "."}
 }
 
 // Attach implements p9.Attacher.Attach.
 func (l *CPU9P) Attach() (p9.File, error) {
-	return &CPU9P{path: l.path}, nil
+	if err := l.root.open(); err != nil {
+		return nil, err
+	}
+	return &CPU9P{root: l.root, path: "."}, nil
 }
 
 var (
@@ -51,62 +95,99 @@ var (
 )
 
 // info constructs a QID for this file.
-func (l *CPU9P) info() (p9.QID, os.FileInfo, error) {
+func (l *CPU9P) info() (p9.QID, *fileInfo, error) {
 	var (
-		qid p9.QID
-		fi  os.FileInfo
+		fi  *fileInfo
 		err error
 	)
 
 	// Stat the file.
 	if l.file != nil {
-		fi, err = l.file.Stat()
+		fi, err = fileStat(l.file)
 	} else {
-		fi, err = os.Lstat(l.path)
+		fi, err = l.root.lstat(l.path)
 	}
 	if err != nil {
 		//log.Printf("error stating %#v: %v", l, err)
-		return qid, nil, err
+		return p9.QID{}, nil, err
 	}
+	return fi.qid(), fi, nil
+}
 
-	// Construct the QID type.
-	qid.Type = p9.ModeFromOS(fi.Mode()).QIDType()
-
-	// Save the path from the Ino.
-	qid.Path = fi.Sys().(*syscall.Stat_t).Ino
-	return qid, fi, nil
+// qid constructs a QID for the file of fi.
+func (fi *fileInfo) qid() p9.QID {
+	return p9.QID{
+		// Construct the QID type.
+		Type: p9.FileMode(fi.st.Mode).QIDType(),
+		// Save the path from the Ino.
+		Path: uint64(fi.st.Ino),
+	}
 }
 
-// SetXattr implements p9.File.SetXattr
+// SetXattr implements p9.File.SetXattr. Only attributes in the
+// namespaces the root serves can be set.
 func (l *CPU9P) SetXattr(attr string, data []byte, flags p9.XattrFlags) error {
-	return unix.Setxattr(l.path, attr, data, int(flags))
+	if !l.root.xattrOK(attr) {
+		return linux.EOPNOTSUPP
+	}
+	defer l.root.changed(l.path)
+	return l.root.lname("setxattr", l.path, func(p string) error {
+		return unix.Lsetxattr(p, attr, data, int(flags))
+	})
 }
 
-// ListXattrs implements p9.File.ListXattrs
+// ListXattrs implements p9.File.ListXattrs. Attributes in namespaces
+// the root does not serve are left out.
 func (l *CPU9P) ListXattrs() ([]string, error) {
-	return xattr.List(l.path)
+	var attrs []string
+	err := l.root.lname("listxattr", l.path, func(p string) error {
+		all, err := llistxattr(p)
+		for _, a := range all {
+			if l.root.xattrOK(a) {
+				attrs = append(attrs, a)
+			}
+		}
+		return err
+	})
+	return attrs, err
 }
 
-// GetXattr implements p9.File.GetXattr
+// GetXattr implements p9.File.GetXattr. Attributes in namespaces the
+// root does not serve are not there.
 func (l *CPU9P) GetXattr(attr string) ([]byte, error) {
-	return xattr.Get(l.path, attr)
+	if !l.root.xattrOK(attr) {
+		return nil, linux.ENODATA
+	}
+	var b []byte
+	err := l.root.lname("getxattr", l.path, func(p string) error {
+		var err error
+		b, err = lgetxattr(p, attr)
+		return err
+	})
+	return b, err
 }
 
-// RemoveXattr implements p9.File.RemoveXattr
+// RemoveXattr implements p9.File.RemoveXattr.
 func (l *CPU9P) RemoveXattr(attr string) error {
-	return unix.Removexattr(l.path, attr)
+	if !l.root.xattrOK(attr) {
+		return linux.EOPNOTSUPP
+	}
+	defer l.root.changed(l.path)
+	return l.root.lname("removexattr", l.path, func(p string) error {
+		return unix.Lremovexattr(p, attr)
+	})
 }
 
 // Walk implements p9.File.Walk.
 func (l *CPU9P) Walk(names []string) ([]p9.QID, p9.File, error) {
 	var qids []p9.QID
-	last := &CPU9P{path: l.path}
+	last := &CPU9P{root: l.root, path: l.path}
 	// If the names are empty we return info for l
 	// An extra stat is never hurtful; all servers
 	// are a bundle of race conditions and there's no need
 	// to make things worse.
 	if len(names) == 0 {
-		c := &CPU9P{path: last.path}
+		c := &CPU9P{root: l.root, path: last.path}
 		qid, fi, err := c.info()
 		verbose("Walk to %v: %v, %v, %v", *c, qid, fi, err)
 		if err != nil {
@@ -118,7 +199,7 @@ func (l *CPU9P) Walk(names []string) ([]p9.QID, p9.File, error) {
 	}
 	verbose("Walk: %v", names)
 	for _, name := range names {
-		c := &CPU9P{path: filepath.Join(last.path, name)}
+		c := &CPU9P{root: l.root, path: filepath.Join(last.path, name)}
 		qid, fi, err := c.info()
 		verbose("Walk to %v: %v, %v, %v", *c, qid, fi, err)
 		if err != nil {
@@ -144,6 +225,15 @@ func (l *CPU9P) Close() error {
 	return nil
 }
 
+// Tlopen flags, beyond the mode, are those of Linux, whatever the
+// OS cpu runs on. CPU9P.Open honours lopenTrunc and lopenAppend; it
+// does not create files, which is done by Create.
+const (
+	lopenCreate p9.OpenFlags = 0o100
+	lopenTrunc  p9.OpenFlags = 0o1000
+	lopenAppend p9.OpenFlags = 0o2000
+)
+
 // Open implements p9.File.Open.
 func (l *CPU9P) Open(mode p9.OpenFlags) (p9.QID, uint32, error) {
 	qid, fi, err := l.info()
@@ -153,8 +243,9 @@ func (l *CPU9P) Open(mode p9.OpenFlags) (p9.QID, uint32, error) {
 	}
 
 	flags := osflags(fi, mode)
-	// Do the actual open.
-	f, err := os.OpenFile(l.path, flags, 0)
+	// Do the actual open. A symbolic link is not followed, but read
+	// by the client.
+	f, err := l.root.openat(l.path, flags|unix.O_NOFOLLOW, 0)
 	verbose("Open(%v, %v, %v): (%v, %v", l.path, flags, 0, f, err)
 	if err != nil {
 		return qid, 0, err
@@ -180,6 +271,7 @@ func (l *CPU9P) ReadAt(p []byte, offset int64) (int, error) {
 // error, and call Write if it is the rare case of a second write
 // to an append-only file..
 func (l *CPU9P) WriteAt(p []byte, offset int64) (int, error) {
+	defer l.root.changed(l.path)
 	n, err := l.file.WriteAt(p, int64(offset))
 	if err != nil {
 		if strings.Contains(err.Error(), "os: invalid use of WriteAt on file opened with O_APPEND") {
@@ -190,13 +282,16 @@ func (l *CPU9P) WriteAt(p []byte, offset int64) (int, error) {
 }
 
 // Create implements p9.File.Create.
-func (l *CPU9P) Create(name string, mode p9.OpenFlags, permissions p9.FileMode, _ p9.UID, _ p9.GID) (p9.File, p9.QID, uint32, error) {
-	f, err := os.OpenFile(filepath.Join(l.path, name), os.O_CREATE|mode.OSFlags(), os.FileMode(permissions))
+func (l *CPU9P) Create(name string, mode p9.OpenFlags, permissions p9.FileMode, uid p9.UID, gid p9.GID) (p9.File, p9.QID, uint32, error) {
+	n := filepath.Join(l.path, name)
+	f, err := l.root.openat(n, os.O_CREATE|mode.OSFlags()|unix.O_NOFOLLOW, uint32(permissions.Permissions()))
 	if err != nil {
 		return nil, p9.QID{}, 0, err
 	}
+	l.own(n, uid, gid)
+	l.root.changed(n)
 
-	l2 := &CPU9P{path: filepath.Join(l.path, name), file: f}
+	l2 := &CPU9P{root: l.root, path: n, file: f}
 	qid, _, err := l2.info()
 	if err != nil {
 		l2.Close()
@@ -208,13 +303,32 @@ func (l *CPU9P) Create(name string, mode p9.OpenFlags, permissions p9.FileMode,
 	return l2, qid, 0, nil
 }
 
+// own gives name, which the remote has just made, the owner and
+// group it asked for, as the root's IDMap maps them. The client may
+// not be able to give files away; if it can not, name is left as it
+// was made.
+func (l *CPU9P) own(name string, uid p9.UID, gid p9.GID) {
+	u, g := l.root.ids.localUID(uid), l.root.ids.localGID(gid)
+	if u == -1 && g == -1 {
+		return
+	}
+	if err := l.root.chown(name, u, g); err != nil {
+		verbose("%q: can not own by %d:%d: %v", name, u, g, err)
+	}
+}
+
 // Mkdir implements p9.File.Mkdir.
 //
 // Not properly implemented.
-func (l *CPU9P) Mkdir(name string, permissions p9.FileMode, _ p9.UID, _ p9.GID) (p9.QID, error) {
-	if err := os.Mkdir(filepath.Join(l.path, name), os.FileMode(permissions)); err != nil {
+func (l *CPU9P) Mkdir(name string, permissions p9.FileMode, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	n := filepath.Join(l.path, name)
+	if err := l.root.at("mkdir", n, func(d int, base string) error {
+		return unix.Mkdirat(d, base, uint32(permissions.Permissions()))
+	}); err != nil {
 		return p9.QID{}, err
 	}
+	l.own(n, uid, gid)
+	l.root.changed(n)
 
 	// Blank QID.
 	return p9.QID{}, nil
@@ -223,10 +337,17 @@ func (l *CPU9P) Mkdir(name string, permissions p9.FileMode, _ p9.UID, _ p9.GID)
 // Symlink implements p9.File.Symlink.
 //
 // Not properly implemented.
-func (l *CPU9P) Symlink(oldname string, newname string, _ p9.UID, _ p9.GID) (p9.QID, error) {
-	if err := os.Symlink(oldname, filepath.Join(l.path, newname)); err != nil {
+func (l *CPU9P) Symlink(oldname string, newname string, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	// The link can name anything; it is only followed beneath the
+	// root.
+	n := filepath.Join(l.path, newname)
+	if err := l.root.at("symlink", n, func(d int, base string) error {
+		return unix.Symlinkat(oldname, d, base)
+	}); err != nil {
 		return p9.QID{}, err
 	}
+	l.own(n, uid, gid)
+	l.root.changed(n)
 
 	// Blank QID.
 	return p9.QID{}, nil
@@ -236,23 +357,29 @@ func (l *CPU9P) Symlink(oldname string, newname string, _ p9.UID, _ p9.GID) (p9.
 //
 // Not properly implemented.
 func (l *CPU9P) Link(target p9.File, newname string) error {
-	return os.Link(target.(*CPU9P).path, filepath.Join(l.path, newname))
+	t, ok := target.(*CPU9P)
+	if !ok {
+		return os.ErrInvalid
+	}
+	n := filepath.Join(l.path, newname)
+	// The link count of the target changes, too.
+	defer t.root.changed(t.path)
+	defer l.root.changed(n)
+	return t.root.link("link", t.path, l.root, n, func(od int, obase string, d int, base string) error {
+		return unix.Linkat(od, obase, d, base, 0)
+	})
 }
 
 // Readdir implements p9.File.Readdir.
 func (l *CPU9P) Readdir(offset uint64, count uint32) (p9.Dirents, error) {
-	fi, err := os.ReadDir(l.path)
+	fi, err := l.root.readDir(l.path)
 	if err != nil {
 		return nil, err
 	}
 	var dirents p9.Dirents
 	//log.Printf("readdir %q returns %d entries start at offset %d", l.path, len(fi), offset)
 	for i := int(offset); i < len(fi); i++ {
-		entry := CPU9P{path: filepath.Join(l.path, fi[i].Name())}
-		qid, _, err := entry.info()
-		if err != nil {
-			continue
-		}
+		qid := fi[i].qid()
 		dirents = append(dirents, p9.Dirent{
 			QID:    qid,
 			Type:   qid.Type,
@@ -266,7 +393,7 @@ func (l *CPU9P) Readdir(offset uint64, count uint32) (p9.Dirents, error) {
 
 // Readlink implements p9.File.Readlink.
 func (l *CPU9P) Readlink() (string, error) {
-	n, err := os.Readlink(l.path)
+	n, err := l.root.readlink(l.path)
 	if false && err != nil {
 		log.Printf("Readlink(%v): %v, %v", *l, n, err)
 	}
@@ -285,7 +412,8 @@ func (l *CPU9P) Renamed(parent p9.File, newName string) {
 
 // Remove implements p9.File.Remove
 func (l *CPU9P) Remove() error {
-	err := os.Remove(l.path)
+	defer l.root.moved(l.path)
+	err := l.root.remove(l.path)
 	verbose("Remove(%q): (%v)", l.path, err)
 	return err
 }
@@ -295,27 +423,47 @@ func (l *CPU9P) Remove() error {
 // always block on the unlink anyway.
 func (l *CPU9P) UnlinkAt(name string, flags uint32) error {
 	f := filepath.Join(l.path, name)
-	err := os.Remove(f)
+	defer l.root.moved(f)
+	err := l.root.remove(f)
 	verbose("UnlinkAt(%q=(%q, %q), %#x): (%v)", f, l.path, name, flags, err)
 	return err
 }
 
-// Mknod implements p9.File.Mknod.
-func (*CPU9P) Mknod(name string, mode p9.FileMode, major uint32, minor uint32, _ p9.UID, _ p9.GID) (p9.QID, error) {
-	verbose("Mknod: not implemented")
-	return p9.QID{}, syscall.ENOSYS
+// Mknod implements p9.File.Mknod. mode is the type, a device, FIFO or
+// socket, and permissions of the file.
+func (l *CPU9P) Mknod(name string, mode p9.FileMode, major uint32, minor uint32, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	n := filepath.Join(l.path, name)
+	if err := l.root.mknod(n, uint32(mode.FileType()|mode.Permissions()), major, minor); err != nil {
+		return p9.QID{}, err
+	}
+	l.own(n, uid, gid)
+	l.root.changed(n)
+	fi, err := l.root.lstat(n)
+	if err != nil {
+		return p9.QID{}, err
+	}
+	return fi.qid(), nil
 }
 
-// Rename implements p9.File.Rename.
-func (*CPU9P) Rename(directory p9.File, name string) error {
-	verbose("Rename: not implemented")
-	return syscall.ENOSYS
+// Rename implements p9.File.Rename. It renames l, as RenameAt; the
+// server then tells l, and any files beneath it, with Renamed.
+func (l *CPU9P) Rename(directory p9.File, name string) error {
+	nd, ok := directory.(*CPU9P)
+	if !ok {
+		log.Printf("Can not happen: cast of directory to %T failed; it is type %T", l, directory)
+		return os.ErrInvalid
+	}
+	n := filepath.Join(nd.path, name)
+	defer l.root.moved(l.path)
+	defer nd.root.moved(n)
+	return l.root.link("rename", l.path, nd.root, n, func(od int, obase string, d int, base string) error {
+		return unix.Renameat(od, obase, d, base)
+	})
 }
 
 // RenameAt implements p9.File.RenameAt.
-// There is no guarantee that there is not a zipslip issue.
+// Both names are resolved beneath the root.
 func (l *CPU9P) RenameAt(oldName string, newDir p9.File, newName string) error {
-	oldPath := path.Join(l.path, oldName)
 	nd, ok := newDir.(*CPU9P)
 	if !ok {
 		// This is extremely serious and points to an internal error.
@@ -323,15 +471,16 @@ func (l *CPU9P) RenameAt(oldName string, newDir p9.File, newName string) error {
 		log.Printf("Can not happen: cast of newDir to %T failed; it is type %T", l, newDir)
 		return os.ErrInvalid
 	}
-	newPath := path.Join(nd.path, newName)
-
-	return os.Rename(oldPath, newPath)
+	o, n := filepath.Join(l.path, oldName), filepath.Join(nd.path, newName)
+	defer l.root.moved(o)
+	defer nd.root.moved(n)
+	return l.root.link("rename", o, nd.root, n, func(od int, obase string, d int, base string) error {
+		return unix.Renameat(od, obase, d, base)
+	})
 }
 
-// StatFS implements p9.File.StatFS.
-//
-// Not implemented.
-func (*CPU9P) StatFS() (p9.FSStat, error) {
-	verbose("StatFS: not implemented")
-	return p9.FSStat{}, syscall.ENOSYS
+// StatFS implements p9.File.StatFS. It is that of the file system the
+// file is on, so that df of a served directory shows the real one.
+func (l *CPU9P) StatFS() (p9.FSStat, error) {
+	return l.root.statfs(l.path)
 }
@@ -10,7 +10,6 @@ package client
 import (
 	"errors"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/hugelgupf/p9/p9"
@@ -32,10 +31,11 @@ func (l *CPU9P) SetAttr(mask p9.SetAttrMask, attr p9.SetAttr) error {
 	// changing permissions impossible.
 	//
 	// The test actually caught this ...
+	defer l.root.changed(l.path)
 
 	if mask.Size {
-		if e := unix.Truncate(l.path, int64(attr.Size)); e != nil {
-			err = errors.Join(err, fmt.Errorf("truncate:%w", err))
+		if e := l.root.truncate(l.path, int64(attr.Size)); e != nil {
+			err = errors.Join(err, fmt.Errorf("truncate:%w", e))
 		}
 	}
 	if mask.ATime || mask.MTime {
@@ -46,7 +46,7 @@ func (l *CPU9P) SetAttr(mask p9.SetAttrMask, attr p9.SetAttr) error {
 		if mask.MTimeNotSystemTime {
 			mtime = time.Unix(int64(attr.MTimeSeconds), int64(attr.MTimeNanoSeconds))
 		}
-		if e := os.Chtimes(l.path, atime, mtime); e != nil {
+		if e := l.root.chtimes(l.path, atime, mtime); e != nil {
 			err = errors.Join(err, e)
 		}
 	}
@@ -58,18 +58,19 @@ func (l *CPU9P) SetAttr(mask p9.SetAttrMask, attr p9.SetAttr) error {
 	}
 	if mask.Permissions {
 		perm := uint32(attr.Permissions)
-		if e := unix.Chmod(l.path, perm); e != nil {
-			err = errors.Join(err, fmt.Errorf("%q:%o:%w", l.path, perm, err))
+		if e := l.root.chmod(l.path, perm); e != nil {
+			err = errors.Join(err, fmt.Errorf("%q:%o:%w", l.path, perm, e))
 		}
 	}
 
+	// The ids are the remote's, and are mapped to the client's.
 	if mask.GID {
-		if e := unix.Chown(l.path, -1, int(attr.GID)); e != nil {
+		if e := l.root.chown(l.path, -1, l.root.ids.localGID(attr.GID)); e != nil {
 			err = errors.Join(err, e)
 		}
 	}
 	if mask.UID {
-		if e := unix.Chown(l.path, int(attr.UID), -1); e != nil {
+		if e := l.root.chown(l.path, l.root.ids.localUID(attr.UID), -1); e != nil {
 			err = errors.Join(err, e)
 		}
 	}
@@ -8,6 +8,8 @@
 package client
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"reflect"
 	"runtime"
@@ -15,10 +17,25 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/hugelgupf/p9/linux"
 	"github.com/hugelgupf/p9/p9"
 	"golang.org/x/sys/unix"
 )
 
+// walk attaches to a CPU9P of root, and walks to names in it.
+func walk(t *testing.T, root string, names ...string) *CPU9P {
+	t.Helper()
+	a, err := NewCPU9P(root).Attach()
+	if err != nil {
+		t.Fatalf("Attach: %v != nil", err)
+	}
+	_, f, err := a.Walk(names)
+	if err != nil {
+		t.Fatalf("Walk(%q): %v != nil", names, err)
+	}
+	return f.(*CPU9P)
+}
+
 func Test9pUnix(t *testing.T) {
 	d := t.TempDir()
 	f := filepath.Join(d, "a")
@@ -43,9 +60,7 @@ func Test9pUnix(t *testing.T) {
 		DataVersion: true,
 	}
 
-	c := &CPU9P{
-		path: f,
-	}
+	c := walk(t, d, "a")
 
 	q, gm, ga, err := c.GetAttr(m)
 	if err != nil {
@@ -206,9 +221,7 @@ func Test9pRemove(t *testing.T) {
 	if err := os.WriteFile(f, []byte("hi"), 0666); err != nil {
 		t.Fatalf(`os.WriteFile(%q, "hi", 0666): %v != nil`, f, err)
 	}
-	c := &CPU9P{
-		path: f,
-	}
+	c := walk(t, d, "a")
 
 	err := c.Remove()
 	if err != nil {
@@ -225,9 +238,7 @@ func Test9pUnlinkat(t *testing.T) {
 	if err := os.WriteFile(f, []byte("hi"), 0666); err != nil {
 		t.Fatalf(`os.WriteFile(%q, "hi", 0666): %v != nil`, f, err)
 	}
-	c := &CPU9P{
-		path: d,
-	}
+	c := walk(t, d)
 
 	err := c.UnlinkAt("a", 0)
 	if err != nil {
@@ -244,20 +255,254 @@ func Test9pRenameAt(t *testing.T) {
 	if err := os.WriteFile(f, []byte("hi"), 0666); err != nil {
 		t.Fatalf(`os.WriteFile(%q, "hi", 0666): %v != nil`, f, err)
 	}
-	c := &CPU9P{
-		path: filepath.Join(d, "nd"),
-	}
-	if err := os.Mkdir(c.path, 0777); err != nil {
-		t.Fatalf("Mkdir(%q, 0777): %v != nil", c.path, err)
-	}
-	oldPath := &CPU9P{
-		path: d,
+	nd := filepath.Join(d, "nd")
+	if err := os.Mkdir(nd, 0777); err != nil {
+		t.Fatalf("Mkdir(%q, 0777): %v != nil", nd, err)
 	}
+	c := walk(t, d, "nd")
+	oldPath := walk(t, d)
 	if err := oldPath.RenameAt("a", c, "z"); err != nil {
-		t.Errorf("RenameAt(%q, %q, \"z\"): %v != nil", f, c.path, err)
+		t.Errorf("RenameAt(%q, %q, \"z\"): %v != nil", f, nd, err)
 	}
-	newFile := filepath.Join(c.path, "z")
+	newFile := filepath.Join(nd, "z")
 	if _, err := os.Stat(newFile); err != nil {
 		t.Errorf("os.Stat(%q): %v != nil", newFile, err)
 	}
 }
+
+func Test9pXattrs(t *testing.T) {
+	d := t.TempDir()
+	f := filepath.Join(d, "a")
+	if err := os.WriteFile(f, []byte("hi"), 0666); err != nil {
+		t.Fatalf(`os.WriteFile(%q, "hi", 0666): %v != nil`, f, err)
+	}
+	if err := unix.Setxattr(f, "user.cpu", []byte("glenda"), 0); err != nil {
+		t.Skipf("Setxattr(%q, user.cpu): %v; the file system has no user xattrs", f, err)
+	}
+	if err := os.Symlink("a", filepath.Join(d, "l")); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewCPU9P(d, CPU9PXattrs("user", "trusted")).Attach()
+	if err != nil {
+		t.Fatalf("Attach: %v != nil", err)
+	}
+	_, c, err := a.Walk([]string{"a"})
+	if err != nil {
+		t.Fatalf("Walk(a): %v != nil", err)
+	}
+	if b, err := c.GetXattr("user.cpu"); err != nil || string(b) != "glenda" {
+		t.Errorf("GetXattr(user.cpu): (%q, %v) != (%q, nil)", b, err, "glenda")
+	}
+	if err := c.SetXattr("user.ken", []byte("unix"), 0); err != nil {
+		t.Errorf("SetXattr(user.ken): %v != nil", err)
+	}
+	if b, err := lgetxattr(f, "user.ken"); err != nil || string(b) != "unix" {
+		t.Errorf("user.ken of %q: (%q, %v) != (%q, nil)", f, b, err, "unix")
+	}
+	if err := c.RemoveXattr("user.ken"); err != nil {
+		t.Errorf("RemoveXattr(user.ken): %v != nil", err)
+	}
+	l, err := c.ListXattrs()
+	if err != nil || !reflect.DeepEqual(l, []string{"user.cpu"}) {
+		t.Errorf("ListXattrs: (%q, %v) != ([user.cpu], nil)", l, err)
+	}
+
+	// Namespaces not served are not there, and can not be set.
+	if _, err := c.GetXattr("security.selinux"); !errors.Is(err, linux.ENODATA) {
+		t.Errorf("GetXattr(security.selinux): %v != %v", err, linux.ENODATA)
+	}
+	if err := c.SetXattr("security.cpu", []byte("x"), 0); !errors.Is(err, linux.EOPNOTSUPP) {
+		t.Errorf("SetXattr(security.cpu): %v != %v", err, linux.EOPNOTSUPP)
+	}
+	if err := c.RemoveXattr("system.posix_acl_access"); !errors.Is(err, linux.EOPNOTSUPP) {
+		t.Errorf("RemoveXattr(system.posix_acl_access): %v != %v", err, linux.EOPNOTSUPP)
+	}
+
+	// The attributes of a link are its own, not those of what it
+	// names.
+	_, c, err = a.Walk([]string{"l"})
+	if err != nil {
+		t.Fatalf("Walk(l): %v != nil", err)
+	}
+	if b, err := c.GetXattr("user.cpu"); err == nil {
+		t.Errorf("GetXattr(user.cpu) of a link: (%q, nil), want an error", b)
+	}
+	if l, err := c.ListXattrs(); err != nil || len(l) != 0 {
+		t.Errorf("ListXattrs of a link: (%q, %v) != ([], nil)", l, err)
+	}
+	c.SetXattr("user.ken", []byte("unix"), 0) //nolint
+	if _, err := lgetxattr(f, "user.ken"); err == nil {
+		t.Errorf("SetXattr(user.ken) of a link set it on %q", f)
+	}
+}
+
+func Test9pStatFS(t *testing.T) {
+	d := t.TempDir()
+	var want unix.Statfs_t
+	if err := unix.Statfs(d, &want); err != nil {
+		t.Fatalf("Statfs(%q): %v != nil", d, err)
+	}
+	st, err := walk(t, d).StatFS()
+	if err != nil {
+		t.Fatalf("StatFS: %v != nil", err)
+	}
+	if w := fsStat(&want); st.Type != w.Type || st.BlockSize != w.BlockSize || st.Blocks != w.Blocks || st.FSID != w.FSID || st.NameLength != w.NameLength {
+		t.Errorf("StatFS: %+v, want that of the file system of %q, %+v", st, d, w)
+	}
+	if st.Blocks == 0 || st.NameLength == 0 {
+		t.Errorf("StatFS: %+v, want blocks and a name length", st)
+	}
+}
+
+func Test9pMknod(t *testing.T) {
+	d := t.TempDir()
+	c := walk(t, d)
+	if _, err := c.Mknod("fifo", p9.ModeNamedPipe|0o640, 0, 0, 0, 0); err != nil {
+		t.Fatalf("Mknod(fifo): %v != nil", err)
+	}
+	fi, err := os.Lstat(filepath.Join(d, "fifo"))
+	if err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Fatalf("Lstat(fifo): (%v, %v), want a FIFO", fi, err)
+	}
+	if _, err := c.Mknod("fifo", p9.ModeNamedPipe|0o640, 0, 0, 0, 0); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Mknod(fifo) again: %v != %v", err, os.ErrExist)
+	}
+	if os.Getuid() != 0 {
+		t.Skipf("Skipping device test: not uid 0")
+	}
+	qid, err := c.Mknod("null", p9.ModeCharacterDevice|0o666, 1, 3, 0, 0)
+	if err != nil {
+		t.Fatalf("Mknod(null): %v != nil", err)
+	}
+	var st unix.Stat_t
+	if err := unix.Lstat(filepath.Join(d, "null"), &st); err != nil {
+		t.Fatalf("Lstat(null): %v != nil", err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFCHR || uint64(st.Rdev) != unix.Mkdev(1, 3) || qid.Path != uint64(st.Ino) {
+		t.Errorf("null: mode %#o, rdev %#x, ino %d, want a character device %#x, ino %d", st.Mode, st.Rdev, st.Ino, unix.Mkdev(1, 3), qid.Path)
+	}
+}
+
+func Test9pLink(t *testing.T) {
+	d := t.TempDir()
+	f := filepath.Join(d, "a")
+	if err := os.WriteFile(f, []byte("hi"), 0666); err != nil {
+		t.Fatalf(`os.WriteFile(%q, "hi", 0666): %v != nil`, f, err)
+	}
+	if err := os.Mkdir(filepath.Join(d, "nd"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := walk(t, d, "nd").Link(walk(t, d, "a"), "b"); err != nil {
+		t.Fatalf("Link(a, nd/b): %v != nil", err)
+	}
+	fa, err := os.Stat(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb, err := os.Stat(filepath.Join(d, "nd", "b"))
+	if err != nil || !os.SameFile(fa, fb) {
+		t.Errorf("nd/b: (%v, %v), want a link to %q", fb, err, f)
+	}
+}
+
+func Test9pRename(t *testing.T) {
+	d := t.TempDir()
+	f := filepath.Join(d, "a")
+	if err := os.WriteFile(f, []byte("hi"), 0666); err != nil {
+		t.Fatalf(`os.WriteFile(%q, "hi", 0666): %v != nil`, f, err)
+	}
+	if err := os.Mkdir(filepath.Join(d, "nd"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	c, nd := walk(t, d, "a"), walk(t, d, "nd")
+	if err := c.Rename(nd, "z"); err != nil {
+		t.Fatalf("Rename(a, nd/z): %v != nil", err)
+	}
+	c.Renamed(nd, "z")
+	if _, err := os.Stat(filepath.Join(d, "nd", "z")); err != nil {
+		t.Errorf("os.Stat(nd/z): %v != nil", err)
+	}
+	// c is now nd/z.
+	if _, _, err := c.Open(p9.ReadOnly); err != nil {
+		t.Fatalf("Open of the renamed file: %v != nil", err)
+	}
+	b := make([]byte, 2)
+	if n, err := c.ReadAt(b, 0); err != nil || string(b[:n]) != "hi" {
+		t.Errorf("ReadAt of the renamed file: (%q, %v) != (%q, nil)", b[:n], err, "hi")
+	}
+}
+
+func Test9pIDMap(t *testing.T) {
+	d := t.TempDir()
+	if err := os.WriteFile(filepath.Join(d, "a"), []byte("hi"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	attach := func(s string) p9.File {
+		t.Helper()
+		m, err := ParseIDMap(s)
+		if err != nil {
+			t.Fatalf("ParseIDMap(%q): %v != nil", s, err)
+		}
+		a, err := NewCPU9P(d, CPU9PIDMap(m)).Attach()
+		if err != nil {
+			t.Fatalf("Attach: %v != nil", err)
+		}
+		return a
+	}
+	getattr := func(f p9.File, name string) (p9.UID, p9.GID) {
+		t.Helper()
+		_, nf, err := f.Walk([]string{name})
+		if err != nil {
+			t.Fatalf("Walk(%q): %v != nil", name, err)
+		}
+		defer nf.Close()
+		_, _, attr, err := nf.GetAttr(p9.AttrMaskAll)
+		if err != nil {
+			t.Fatalf("GetAttr(%q): %v != nil", name, err)
+		}
+		return attr.UID, attr.GID
+	}
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+
+	// Squashed, everything is the client's, whatever the remote asks.
+	sq := attach("squash")
+	if u, g := getattr(sq, "a"); u != p9.UID(uid) || g != p9.GID(gid) {
+		t.Errorf("squash: a is owned by %d:%d, want %d:%d", u, g, uid, gid)
+	}
+	if _, _, _, err := sq.Create("s", p9.WriteOnly, 0o644, p9.NoUID, 4242); err != nil {
+		t.Fatalf("squash: Create(s): %v != nil", err)
+	}
+	var st unix.Stat_t
+	if err := unix.Lstat(filepath.Join(d, "s"), &st); err != nil || st.Uid != uid || st.Gid != gid {
+		t.Errorf("squash: s is owned by %d:%d (%v), want %d:%d", st.Uid, st.Gid, err, uid, gid)
+	}
+
+	// With a table, the client's ids are seen as the remote's, and
+	// the remote's are made the client's.
+	m := attach(fmt.Sprintf("u%d=1000,g%d=1000,u2000=3000", uid, gid))
+	if u, g := getattr(m, "a"); u != 1000 || g != 1000 {
+		t.Errorf("map: a is owned by %d:%d, want 1000:1000", u, g)
+	}
+	if _, err := m.Mkdir("m", 0o755, p9.NoUID, 1000); err != nil {
+		t.Fatalf("map: Mkdir(m): %v != nil", err)
+	}
+	if err := unix.Lstat(filepath.Join(d, "m"), &st); err != nil || st.Gid != gid {
+		t.Errorf("map: m has group %d (%v), want %d", st.Gid, err, gid)
+	}
+	if os.Getuid() != 0 {
+		t.Skipf("Skipping chown test: not uid 0")
+	}
+	_, f, err := m.Walk([]string{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetAttr(p9.SetAttrMask{UID: true, GID: true}, p9.SetAttr{UID: 3000, GID: 4242}); err != nil {
+		t.Fatalf("map: SetAttr(a, 3000:4242): %v != nil", err)
+	}
+	if err := unix.Lstat(filepath.Join(d, "a"), &st); err != nil || st.Uid != 2000 || st.Gid != 4242 {
+		t.Errorf("map: a is owned by %d:%d (%v), want 2000:4242", st.Uid, st.Gid, err)
+	}
+	if u, g := getattr(m, "a"); u != 3000 || g != 4242 {
+		t.Errorf("map: a is seen as owned by %d:%d, want 3000:4242", u, g)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package client
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCached is the most names an attrCache holds; when it is full, it
+// is emptied.
+const maxCached = 1 << 16
+
+// attrCache caches, for up to ttl, the attributes of the names beneath
+// a cpuRoot, whether or not they exist, and the contents of its
+// directories, so that walks, stats and directory reads of the same
+// names, which over a slow link come again and again, need not go to
+// the disk each time.
+//
+// Changes made through the root are seen at once. Where it can, e.g.
+// with inotify on Linux, the cache watches the directories it holds
+// for changes made otherwise; where it can not, they are seen once
+// what is cached expires.
+type attrCache struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	attrs map[string]attrEntry
+	dirs  map[string]dirEntry
+	// gen counts changes, so that what is read as something
+	// changes is not kept.
+	gen uint64
+	// w watches directories for changes, if it can.
+	w *cacheWatcher
+}
+
+// attrEntry is the attributes of a name, or, if it does not exist,
+// the error saying so.
+type attrEntry struct {
+	fi      *fileInfo
+	err     error
+	expires time.Time
+}
+
+// dirEntry is the contents of a directory.
+type dirEntry struct {
+	fis     []*fileInfo
+	expires time.Time
+}
+
+// newAttrCache returns an attrCache for r, whose entries last for ttl.
+func newAttrCache(r *cpuRoot, ttl time.Duration) *attrCache {
+	c := &attrCache{ttl: ttl, attrs: map[string]attrEntry{}, dirs: map[string]dirEntry{}}
+	w, err := newCacheWatcher(r, c)
+	if err != nil {
+		verbose("cache of %q can not watch for changes, which are seen after %v:%v", r.path, ttl, err)
+	}
+	c.w = w
+	return c
+}
+
+// lstat returns the attributes of name, from the cache, or as f
+// returns them.
+func (c *attrCache) lstat(name string, f func() (*fileInfo, error)) (*fileInfo, error) {
+	c.watch(filepath.Dir(name))
+	c.mu.Lock()
+	e, ok := c.attrs[name]
+	gen := c.gen
+	c.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.fi, e.err
+	}
+	fi, err := f()
+	// Only that a name does not exist is worth keeping; other
+	// errors may not last.
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		c.mu.Lock()
+		if gen == c.gen {
+			c.full()
+			c.attrs[name] = attrEntry{fi: fi, err: err, expires: time.Now().Add(c.ttl)}
+		}
+		c.mu.Unlock()
+	}
+	return fi, err
+}
+
+// readDir returns the contents of the directory name, from the cache,
+// or as f returns them. The attributes of each name in it are cached,
+// too, as a read of a directory is so often followed by a stat of
+// each name in it.
+func (c *attrCache) readDir(name string, f func() ([]*fileInfo, error)) ([]*fileInfo, error) {
+	c.watch(name)
+	c.mu.Lock()
+	e, ok := c.dirs[name]
+	gen := c.gen
+	c.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.fis, nil
+	}
+	fis, err := f()
+	if err != nil {
+		return nil, err
+	}
+	expires := time.Now().Add(c.ttl)
+	c.mu.Lock()
+	if gen == c.gen {
+		c.full()
+		c.dirs[name] = dirEntry{fis: fis, expires: expires}
+		for _, fi := range fis {
+			c.attrs[filepath.Join(name, fi.name)] = attrEntry{fi: fi, expires: expires}
+		}
+	}
+	c.mu.Unlock()
+	return fis, nil
+}
+
+// watch watches the directory name for changes, if it can.
+func (c *attrCache) watch(name string) {
+	if c.w != nil {
+		c.w.watch(name)
+	}
+}
+
+// full empties the cache if it is full. c.mu must be held.
+func (c *attrCache) full() {
+	if len(c.attrs)+len(c.dirs) < maxCached {
+		return
+	}
+	c.attrs, c.dirs = map[string]attrEntry{}, map[string]dirEntry{}
+}
+
+// changed removes name from the cache, and the directory it is in,
+// whose contents and times change with it.
+func (c *attrCache) changed(name string) {
+	dir := filepath.Dir(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for _, n := range []string{name, dir} {
+		delete(c.attrs, n)
+		delete(c.dirs, n)
+	}
+}
+
+// moved is changed, for a name that is removed or renamed, which takes
+// everything beneath it with it.
+func (c *attrCache) moved(name string) {
+	c.changed(name)
+	prefix := name + "/"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for n := range c.attrs {
+		if name == "." || strings.HasPrefix(n, prefix) {
+			delete(c.attrs, n)
+		}
+	}
+	for n := range c.dirs {
+		if name == "." || strings.HasPrefix(n, prefix) {
+			delete(c.dirs, n)
+		}
+	}
+}
+
+// flush empties the cache, e.g. when changes may have been missed.
+func (c *attrCache) flush() {
+	c.mu.Lock()
+	c.gen++
+	c.attrs, c.dirs = map[string]attrEntry{}, map[string]dirEntry{}
+	c.mu.Unlock()
+}
+
+// changed tells the cache of r, if it has one, that name changed.
+func (r *cpuRoot) changed(name string) {
+	if r.cache != nil {
+		r.cache.changed(name)
+	}
+}
+
+// moved tells the cache of r, if it has one, that name was removed or
+// renamed.
+func (r *cpuRoot) moved(name string) {
+	if r.cache != nil {
+		r.cache.moved(name)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchMask is the inotify events that change what an attrCache holds.
+const watchMask = unix.IN_ATTRIB | unix.IN_MODIFY | unix.IN_CREATE | unix.IN_DELETE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF |
+	unix.IN_ONLYDIR | unix.IN_DONT_FOLLOW
+
+// cacheWatcher watches, with inotify, the directories of an attrCache,
+// and removes from it what changes in them.
+type cacheWatcher struct {
+	r  *cpuRoot
+	c  *attrCache
+	fd int
+
+	mu sync.Mutex
+	// wds is the names of the watched directories, by watch
+	// descriptor, and names their watch descriptors.
+	wds   map[int32]string
+	names map[string]int32
+}
+
+// newCacheWatcher returns a cacheWatcher of r, for c.
+func newCacheWatcher(r *cpuRoot, c *attrCache) (*cacheWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	w := &cacheWatcher{r: r, c: c, fd: fd, wds: map[int32]string{}, names: map[string]int32{}}
+	go w.run()
+	return w, nil
+}
+
+// watch watches the directory name, if it is not already watched. If
+// it can not be watched, what is cached of it is seen to change only
+// when it expires.
+func (w *cacheWatcher) watch(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.names[name]; ok {
+		return
+	}
+	var wd int
+	if err := w.r.lname("inotify_add_watch", name, func(p string) error {
+		var err error
+		wd, err = unix.InotifyAddWatch(w.fd, p, watchMask)
+		return err
+	}); err != nil {
+		verbose("cache:%v", err)
+		return
+	}
+	// The directory may be watched by another name, e.g. once
+	// it is renamed; it is now watched by this one.
+	if old, ok := w.wds[int32(wd)]; ok {
+		delete(w.names, old)
+	}
+	w.wds[int32(wd)], w.names[name] = name, int32(wd)
+}
+
+// run reads events, for as long as the watcher lasts, and removes from
+// the cache what they change.
+func (w *cacheWatcher) run() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := unix.Read(w.fd, buf)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			// Changes are now seen only as what is
+			// cached expires.
+			verbose("cache:inotify read:%v; no longer watching", err)
+			w.c.flush()
+			return
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(ev.Len)]
+			if i := bytes.IndexByte(name, 0); i >= 0 {
+				name = name[:i]
+			}
+			w.event(ev.Wd, ev.Mask, string(name))
+			off += unix.SizeofInotifyEvent + int(ev.Len)
+		}
+	}
+}
+
+// event removes from the cache what the event, of the directory with
+// watch descriptor wd, about name in it, or, if name is empty, the
+// directory itself, changes.
+func (w *cacheWatcher) event(wd int32, mask uint32, name string) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		w.c.flush()
+		return
+	}
+	w.mu.Lock()
+	dir, ok := w.wds[wd]
+	if ok && mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF|unix.IN_IGNORED) != 0 {
+		// The directory is gone, or has another name: what is
+		// left of the watch is of no use.
+		delete(w.wds, wd)
+		delete(w.names, dir)
+		if mask&unix.IN_IGNORED == 0 {
+			unix.InotifyRmWatch(w.fd, uint32(wd))
+		}
+	}
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+	switch {
+	case name == "" && mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0:
+		w.c.moved(dir)
+	case name == "":
+		w.c.changed(dir)
+	case mask&(unix.IN_CREATE|unix.IN_DELETE|unix.IN_MOVED_FROM|unix.IN_MOVED_TO) != 0:
+		w.c.moved(filepath.Join(dir, name))
+	default:
+		w.c.changed(filepath.Join(dir, name))
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !windows && !plan9
+// +build !linux,!windows,!plan9
+
+package client
+
+import "os"
+
+// cacheWatcher would watch the directories of an attrCache, but there
+// is no inotify; changes made other than through the root are seen as
+// what is cached expires.
+type cacheWatcher struct{}
+
+// newCacheWatcher returns os.ErrInvalid: there is nothing to watch
+// with.
+func newCacheWatcher(r *cpuRoot, c *attrCache) (*cacheWatcher, error) {
+	return nil, os.ErrInvalid
+}
+
+// watch does nothing.
+func (w *cacheWatcher) watch(name string) {}
@@ -0,0 +1,214 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/hugelgupf/p9/p9"
+)
+
+// getAttr walks from f to names, and returns the attributes there.
+func getAttr(f p9.File, names ...string) (p9.Attr, error) {
+	_, nf, err := f.Walk(names)
+	if err != nil {
+		return p9.Attr{}, err
+	}
+	defer nf.Close()
+	_, _, attr, err := nf.GetAttr(p9.AttrMaskAll)
+	return attr, err
+}
+
+// readdir returns the names in the directory names, walked to from f.
+func readdir(f p9.File, names ...string) ([]string, error) {
+	_, d, err := f.Walk(names)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	if _, _, err := d.Open(p9.ReadOnly); err != nil {
+		return nil, err
+	}
+	dirents, err := d.Readdir(0, 1<<16)
+	if err != nil {
+		return nil, err
+	}
+	var n []string
+	for _, e := range dirents {
+		n = append(n, e.Name)
+	}
+	return n, nil
+}
+
+// eventually returns nil once f does, or its last error after a while.
+func eventually(f func() error) error {
+	var err error
+	for i := 0; i < 100; i++ {
+		if err = f(); err == nil {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return err
+}
+
+func TestCPU9PCache(t *testing.T) {
+	d := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(d, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []string{"a", "sub/f"} {
+		if err := os.WriteFile(filepath.Join(d, n), []byte(n), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := NewCPU9P(d, CPU9PCache(time.Hour)).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readdir(r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readdir(r, "sub"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getAttr(r, "x"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("getAttr(x): %v, want %v", err, os.ErrNotExist)
+	}
+
+	// Changes made through the CPU9P are seen at once.
+	_, a, err := r.Walk([]string{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetAttr(p9.SetAttrMask{Permissions: true}, p9.SetAttr{Permissions: 0o600}); err != nil {
+		t.Fatal(err)
+	}
+	if attr, err := getAttr(r, "a"); err != nil || attr.Mode.Permissions() != 0o600 {
+		t.Errorf("getAttr(a) after SetAttr: (%v, %v), want mode 0600", attr.Mode, err)
+	}
+	if _, _, _, err := r.Create("x", p9.WriteOnly, 0o644, p9.NoUID, p9.NoGID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getAttr(r, "x"); err != nil {
+		t.Errorf("getAttr(x) after Create: %v, want nil", err)
+	}
+	if n, err := readdir(r); err != nil || fmt.Sprint(n) != "[a sub x]" {
+		t.Errorf("readdir after Create: (%q, %v), want [a sub x]", n, err)
+	}
+	if err := r.RenameAt("sub", r, "sub2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getAttr(r, "sub", "f"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("getAttr(sub/f) after RenameAt: %v, want %v", err, os.ErrNotExist)
+	}
+	if _, err := getAttr(r, "sub2", "f"); err != nil {
+		t.Errorf("getAttr(sub2/f) after RenameAt: %v, want nil", err)
+	}
+
+	if runtime.GOOS != "linux" {
+		t.Skipf("Skipping changes made elsewhere: they are seen only with inotify")
+	}
+	// Changes made elsewhere are seen as inotify reports them.
+	if err := os.Chmod(filepath.Join(d, "a"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := eventually(func() error {
+		attr, err := getAttr(r, "a")
+		if err == nil && attr.Mode.Permissions() != 0o640 {
+			err = fmt.Errorf("mode %v, want 0640", attr.Mode)
+		}
+		return err
+	}); err != nil {
+		t.Errorf("getAttr(a) after chmod: %v", err)
+	}
+	if err := os.Rename(filepath.Join(d, "sub2"), filepath.Join(d, "sub3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := eventually(func() error {
+		if _, err := getAttr(r, "sub2", "f"); !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("getAttr(sub2/f): %v, want %v", err, os.ErrNotExist)
+		}
+		_, err := getAttr(r, "sub3", "f")
+		return err
+	}); err != nil {
+		t.Errorf("after rename of sub2 to sub3: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(d, "sub3", "g"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := eventually(func() error {
+		if n, err := readdir(r, "sub3"); err != nil || fmt.Sprint(n) != "[f g]" {
+			return fmt.Errorf("readdir(sub3): (%q, %v), want [f g]", n, err)
+		}
+		return nil
+	}); err != nil {
+		t.Errorf("after write of sub3/g: %v", err)
+	}
+}
+
+func TestCPU9PCacheExpires(t *testing.T) {
+	d := t.TempDir()
+	r, err := NewCPU9P(d, CPU9PCache(100*time.Millisecond)).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getAttr(r, "x"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("getAttr(x): %v, want %v", err, os.ErrNotExist)
+	}
+	if err := os.WriteFile(filepath.Join(d, "x"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if _, err := getAttr(r, "x"); err != nil {
+		t.Errorf("getAttr(x) once the cache expires: %v, want nil", err)
+	}
+}
+
+// BenchmarkCPU9PCache is ls -l of a directory: a read of it, and a walk
+// to, and stat of, each name in it.
+func BenchmarkCPU9PCache(b *testing.B) {
+	d := b.TempDir()
+	for i := 0; i < 256; i++ {
+		if err := os.WriteFile(filepath.Join(d, fmt.Sprintf("f%03d", i)), nil, 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, bb := range []struct {
+		name string
+		opts []CPU9POption
+	}{
+		{name: "none"},
+		{name: "cache", opts: []CPU9POption{CPU9PCache(time.Minute)}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			r, err := NewCPU9P(d, bb.opts...).Attach()
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				names, err := readdir(r)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, n := range names {
+					if _, err := getAttr(r, n); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
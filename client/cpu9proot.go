@@ -0,0 +1,455 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+// cpuRoot is the directory a CPU9P exports. Names are resolved beneath
+// it, relative to an fd open on it, so that neither "..", symbolic
+// links, absolute or relative, nor renames racing with a walk, can
+// reach outside it. The last element of a name is never followed if
+// it is a symbolic link: 9P clients read links, and walk to what they
+// name themselves.
+type cpuRoot struct {
+	path string
+	// xattrs is the namespaces of the extended attributes served,
+	// e.g. user, for user.*.
+	xattrs []string
+	// ids maps the ids of the files served; nil is the identity.
+	ids *IDMap
+	// cache, if set, caches attributes and directory contents.
+	cache *attrCache
+
+	once sync.Once
+	fd   int
+	err  error
+}
+
+// open opens the root, once.
+func (r *cpuRoot) open() error {
+	r.once.Do(func() {
+		r.fd, r.err = unix.Open(r.path, oPath|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+		if r.err != nil {
+			r.err = &os.PathError{Op: "open", Path: r.path, Err: r.err}
+		}
+	})
+	return r.err
+}
+
+// openat opens name, resolved beneath the root. perm is used only if
+// flags has O_CREAT.
+func (r *cpuRoot) openat(name string, flags int, perm uint32) (*os.File, error) {
+	fd, err := r.openfd(name, flags, perm)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(r.path, name)), nil
+}
+
+// openfd is openat, returning an fd.
+func (r *cpuRoot) openfd(name string, flags int, perm uint32) (int, error) {
+	fd, err := openBeneath(r.fd, name, flags|unix.O_CLOEXEC, perm)
+	if err != nil {
+		return -1, &os.PathError{Op: "open", Path: filepath.Join(r.path, name), Err: err}
+	}
+	return fd, nil
+}
+
+// dir opens the directory name is in, and returns its fd, which the
+// caller closes, and the last element of name, for the *at system
+// calls. The root is its own directory, with the last element ".".
+func (r *cpuRoot) dir(name string) (int, string, error) {
+	fd, err := r.openfd(filepath.Dir(name), oPath|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return -1, "", err
+	}
+	return fd, filepath.Base(name), nil
+}
+
+// at calls f with the directory name is in and its last element, as
+// dir returns them, and wraps any error f returns as op on name.
+func (r *cpuRoot) at(op, name string, f func(dir int, base string) error) error {
+	d, base, err := r.dir(name)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(d)
+	if err := f(d, base); err != nil {
+		return &os.PathError{Op: op, Path: filepath.Join(r.path, name), Err: err}
+	}
+	return nil
+}
+
+// link is at, for the two names of a rename or link, oldname beneath
+// r, and newname beneath nr.
+func (r *cpuRoot) link(op, oldname string, nr *cpuRoot, newname string, f func(od int, obase string, d int, base string) error) error {
+	od, obase, err := r.dir(oldname)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(od)
+	d, base, err := nr.dir(newname)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(d)
+	if err := f(od, obase, d, base); err != nil {
+		return &os.LinkError{Op: op, Old: filepath.Join(r.path, oldname), New: filepath.Join(nr.path, newname), Err: err}
+	}
+	return nil
+}
+
+// lstat returns the attributes of name, which, if it is a symbolic
+// link, are those of the link.
+func (r *cpuRoot) lstat(name string) (*fileInfo, error) {
+	if r.cache != nil {
+		return r.cache.lstat(name, func() (*fileInfo, error) {
+			return r.lstatDisk(name)
+		})
+	}
+	return r.lstatDisk(name)
+}
+
+// lstatDisk is lstat, with no cache.
+func (r *cpuRoot) lstatDisk(name string) (*fileInfo, error) {
+	fi := &fileInfo{name: filepath.Base(name)}
+	if err := r.at("lstat", name, func(d int, base string) error {
+		return unix.Fstatat(d, base, &fi.st, unix.AT_SYMLINK_NOFOLLOW)
+	}); err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+// readDir returns the names in the directory name, sorted, with the
+// attributes of each, as os.ReadDir, skipping any that vanish as they
+// are read.
+func (r *cpuRoot) readDir(name string) ([]*fileInfo, error) {
+	if r.cache != nil {
+		return r.cache.readDir(name, func() ([]*fileInfo, error) {
+			return r.readDirDisk(name)
+		})
+	}
+	return r.readDirDisk(name)
+}
+
+// readDirDisk is readDir, with no cache.
+func (r *cpuRoot) readDirDisk(name string) ([]*fileInfo, error) {
+	f, err := r.openat(name, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	var fis []*fileInfo
+	c, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Control(func(fd uintptr) {
+		for _, n := range names {
+			fi := &fileInfo{name: n}
+			if unix.Fstatat(int(fd), n, &fi.st, unix.AT_SYMLINK_NOFOLLOW) == nil {
+				fis = append(fis, fi)
+			}
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return fis, nil
+}
+
+// remove removes name, a file or an empty directory, as os.Remove.
+func (r *cpuRoot) remove(name string) error {
+	return r.at("remove", name, func(d int, base string) error {
+		err := unix.Unlinkat(d, base, 0)
+		if err == nil {
+			return nil
+		}
+		err1 := unix.Unlinkat(d, base, unix.AT_REMOVEDIR)
+		if err1 == nil {
+			return nil
+		}
+		// Both failed: as os.Remove, report the error of the one
+		// that fits what name is.
+		if err1 != unix.ENOTDIR {
+			err = err1
+		}
+		return err
+	})
+}
+
+// readlink returns what the symbolic link name names.
+func (r *cpuRoot) readlink(name string) (string, error) {
+	var s string
+	err := r.at("readlink", name, func(d int, base string) error {
+		var err error
+		s, err = readlinkat(d, base)
+		return err
+	})
+	return s, err
+}
+
+// truncate sets the size of the file name.
+func (r *cpuRoot) truncate(name string, size int64) error {
+	f, err := r.openat(name, unix.O_WRONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Truncate(size)
+}
+
+// chtimes sets the access and modification times of name.
+func (r *cpuRoot) chtimes(name string, atime, mtime time.Time) error {
+	return r.at("chtimes", name, func(d int, base string) error {
+		ts := []unix.Timespec{unix.NsecToTimespec(atime.UnixNano()), unix.NsecToTimespec(mtime.UnixNano())}
+		return unix.UtimesNanoAt(d, base, ts, unix.AT_SYMLINK_NOFOLLOW)
+	})
+}
+
+// chown sets the owner and group of name; -1 leaves either as it is.
+func (r *cpuRoot) chown(name string, uid, gid int) error {
+	return r.at("chown", name, func(d int, base string) error {
+		return unix.Fchownat(d, base, uid, gid, unix.AT_SYMLINK_NOFOLLOW)
+	})
+}
+
+// xattrOK returns true if attr is in a namespace the root serves.
+func (r *cpuRoot) xattrOK(attr string) bool {
+	ns, _, ok := strings.Cut(attr, ".")
+	if !ok {
+		return false
+	}
+	for _, n := range r.xattrs {
+		if n == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// lgetxattr returns the value of the extended attribute attr of name,
+// or, if it is a symbolic link, of the link.
+func lgetxattr(name, attr string) ([]byte, error) {
+	for {
+		n, err := unix.Lgetxattr(name, attr, nil)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, n)
+		n, err = unix.Lgetxattr(name, attr, b)
+		// ERANGE is the value growing between the two calls.
+		if err == unix.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+
+// llistxattr returns the names of the extended attributes of name, or,
+// if it is a symbolic link, of the link.
+func llistxattr(name string) ([]string, error) {
+	for {
+		n, err := unix.Llistxattr(name, nil)
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		b := make([]byte, n)
+		n, err = unix.Llistxattr(name, b)
+		if err == unix.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var attrs []string
+		for _, a := range strings.Split(string(b[:n]), "\x00") {
+			if len(a) > 0 {
+				attrs = append(attrs, a)
+			}
+		}
+		return attrs, nil
+	}
+}
+
+// statfs returns the attributes of the file system name is on.
+func (r *cpuRoot) statfs(name string) (p9.FSStat, error) {
+	fd, err := r.openfd(name, oPath, 0)
+	if err != nil {
+		return p9.FSStat{}, err
+	}
+	defer unix.Close(fd)
+	var st unix.Statfs_t
+	if err := unix.Fstatfs(fd, &st); err != nil {
+		return p9.FSStat{}, &os.PathError{Op: "statfs", Path: filepath.Join(r.path, name), Err: err}
+	}
+	return fsStat(&st), nil
+}
+
+// fsid returns a file system ID as one number.
+func fsid(f unix.Fsid) uint64 {
+	return uint64(uint32(f.Val[0])) | uint64(uint32(f.Val[1]))<<32
+}
+
+// maxLinks is the most symbolic links followed resolving a name, as in
+// Linux.
+const maxLinks = 40
+
+// walkBeneath opens name relative to the directory fd, one element at
+// a time, never following a symbolic link out of it: links are read,
+// and what they name resolved in turn, with ".." never going above fd,
+// and absolute links refused with EXDEV, as openat2 does with
+// RESOLVE_BENEATH. It is used where openat2 is not.
+func walkBeneath(fd int, name string, flags int, perm uint32) (int, error) {
+	// dirs is the directories walked through, from fd; the fds
+	// after the first are closed when done.
+	dirs := []int{fd}
+	defer func() {
+		for _, d := range dirs[1:] {
+			unix.Close(d)
+		}
+	}()
+	elems, links := split(name), 0
+	for len(elems) > 0 {
+		e := elems[0]
+		elems = elems[1:]
+		cur := dirs[len(dirs)-1]
+		if e == ".." {
+			if len(dirs) == 1 {
+				return -1, unix.EXDEV
+			}
+			unix.Close(cur)
+			dirs = dirs[:len(dirs)-1]
+			continue
+		}
+		last := len(elems) == 0
+		if !last || flags&unix.O_NOFOLLOW == 0 {
+			// If e is a link, walk what it names instead. If
+			// it is made a link after this, the O_NOFOLLOW of
+			// the open below catches it.
+			var st unix.Stat_t
+			if unix.Fstatat(cur, e, &st, unix.AT_SYMLINK_NOFOLLOW) == nil && st.Mode&unix.S_IFMT == unix.S_IFLNK {
+				if links++; links > maxLinks {
+					return -1, unix.ELOOP
+				}
+				t, err := readlinkat(cur, e)
+				if err != nil {
+					return -1, err
+				}
+				if filepath.IsAbs(t) {
+					return -1, unix.EXDEV
+				}
+				elems = append(split(t), elems...)
+				if len(elems) == 0 {
+					// The link named the directory it is in.
+					elems = []string{"."}
+				}
+				continue
+			}
+		}
+		if last {
+			return unix.Openat(cur, e, flags|unix.O_NOFOLLOW, perm)
+		}
+		d, err := unix.Openat(cur, e, oPath|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return -1, err
+		}
+		dirs = append(dirs, d)
+	}
+	// name was the root, or ended in "..".
+	return unix.Openat(dirs[len(dirs)-1], ".", flags, perm)
+}
+
+// split splits a name into its elements, leaving out empty ones and
+// ".".
+func split(name string) []string {
+	var elems []string
+	for _, e := range strings.Split(name, "/") {
+		if len(e) > 0 && e != "." {
+			elems = append(elems, e)
+		}
+	}
+	return elems
+}
+
+// readlinkat returns what the symbolic link name, in the directory
+// fd, names.
+func readlinkat(fd int, name string) (string, error) {
+	for n := 256; ; n *= 2 {
+		b := make([]byte, n)
+		l, err := unix.Readlinkat(fd, name, b)
+		if err != nil {
+			return "", err
+		}
+		if l < n {
+			return string(b[:l]), nil
+		}
+	}
+}
+
+// fileInfo is an os.FileInfo made from a unix.Stat_t, which is its Sys.
+type fileInfo struct {
+	name string
+	st   unix.Stat_t
+}
+
+var _ os.FileInfo = &fileInfo{}
+
+// fileStat returns the attributes of an open file.
+func fileStat(f *os.File) (*fileInfo, error) {
+	fi := &fileInfo{name: filepath.Base(f.Name())}
+	c, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	if cerr := c.Control(func(fd uintptr) {
+		err = unix.Fstat(int(fd), &fi.st)
+	}); cerr != nil {
+		return nil, cerr
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "fstat", Path: f.Name(), Err: err}
+	}
+	return fi, nil
+}
+
+// Name implements os.FileInfo.Name.
+func (fi *fileInfo) Name() string { return fi.name }
+
+// Size implements os.FileInfo.Size.
+func (fi *fileInfo) Size() int64 { return int64(fi.st.Size) }
+
+// Mode implements os.FileInfo.Mode.
+func (fi *fileInfo) Mode() os.FileMode { return p9.FileMode(fi.st.Mode).OSMode() }
+
+// ModTime implements os.FileInfo.ModTime.
+func (fi *fileInfo) ModTime() time.Time { return time.Unix(fi.st.Mtim.Unix()) }
+
+// IsDir implements os.FileInfo.IsDir.
+func (fi *fileInfo) IsDir() bool { return fi.Mode().IsDir() }
+
+// Sys implements os.FileInfo.Sys. It is a *unix.Stat_t.
+func (fi *fileInfo) Sys() interface{} { return &fi.st }
@@ -0,0 +1,38 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"path/filepath"
+
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+// mknod makes name, a device, FIFO or socket, as mode says. With no
+// mknodat, it is made by its name under the root, once the directory
+// it is in is checked to resolve beneath it; as with byName, a rename
+// after the check can still move it out.
+func (r *cpuRoot) mknod(name string, mode uint32, major, minor uint32) error {
+	return r.at("mknod", name, func(int, string) error {
+		return unix.Mknod(filepath.Join(r.path, name), mode, int(unix.Mkdev(major, minor)))
+	})
+}
+
+// fsStat returns the p9.FSStat of a unix.Statfs_t. Darwin does not
+// say how long names can be; 255 is what its file systems allow.
+func fsStat(st *unix.Statfs_t) p9.FSStat {
+	return p9.FSStat{
+		Type:            st.Type,
+		BlockSize:       st.Bsize,
+		Blocks:          st.Blocks,
+		BlocksFree:      st.Bfree,
+		BlocksAvailable: st.Bavail,
+		Files:           st.Files,
+		FilesFree:       st.Ffree,
+		FSID:            fsid(st.Fsid),
+		NameLength:      255,
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+// mknod makes name, a device, FIFO or socket, as mode says.
+func (r *cpuRoot) mknod(name string, mode uint32, major, minor uint32) error {
+	return r.at("mknod", name, func(d int, base string) error {
+		return unix.Mknodat(d, base, mode, unix.Mkdev(major, minor))
+	})
+}
+
+// fsStat returns the p9.FSStat of a unix.Statfs_t. The block counts
+// are in units of the fragment size, Bsize.
+func fsStat(st *unix.Statfs_t) p9.FSStat {
+	return p9.FSStat{
+		Type:            st.Type,
+		BlockSize:       uint32(st.Bsize),
+		Blocks:          st.Blocks,
+		BlocksFree:      st.Bfree,
+		BlocksAvailable: uint64(max(st.Bavail, 0)),
+		Files:           st.Files,
+		FilesFree:       uint64(max(st.Ffree, 0)),
+		FSID:            fsid(st.Fsid),
+		NameLength:      st.Namemax,
+	}
+}
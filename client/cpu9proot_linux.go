@@ -0,0 +1,124 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+// oPath opens a file only to name it, e.g. as the directory of the *at
+// system calls, with no need to be able to read it.
+const oPath = unix.O_PATH
+
+// noOpenat2 is set once openat2, new in Linux 5.6, is found missing.
+var noOpenat2 atomic.Bool
+
+// openBeneath opens name relative to the directory fd, with openat2
+// and RESOLVE_BENEATH, so that the kernel refuses to resolve any of it
+// outside fd, and RESOLVE_NO_MAGICLINKS, so that /proc/self/fd and the
+// like can not be used to jump out. Without openat2, it walks name
+// itself.
+func openBeneath(fd int, name string, flags int, perm uint32) (int, error) {
+	if !noOpenat2.Load() {
+		how := &unix.OpenHow{
+			Flags:   uint64(flags),
+			Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS,
+		}
+		if flags&unix.O_CREAT != 0 {
+			how.Mode = uint64(perm)
+		}
+		for i := 0; ; i++ {
+			nfd, err := unix.Openat2(fd, name, how)
+			// EAGAIN is a rename racing with a "..", which
+			// the kernel could not check; try again.
+			if err == unix.EAGAIN && i < 16 {
+				continue
+			}
+			if err != unix.ENOSYS {
+				return nfd, err
+			}
+			verbose("openat2: %v; resolving names beneath the root without it", err)
+			noOpenat2.Store(true)
+			break
+		}
+	}
+	return walkBeneath(fd, name, flags, perm)
+}
+
+// chmod sets the permissions of name. Linux can not change the mode of
+// a symbolic link, and only fchmodat2, new in Linux 6.6, can refuse to
+// follow one; without it, name is opened O_PATH, which fchmod can not
+// use, and changed through /proc/self/fd.
+func (r *cpuRoot) chmod(name string, perm uint32) error {
+	err := r.at("chmod", name, func(d int, base string) error {
+		return unix.Fchmodat(d, base, perm, unix.AT_SYMLINK_NOFOLLOW)
+	})
+	if err == nil || !errors.Is(err, unix.EOPNOTSUPP) {
+		return err
+	}
+	return r.byName("chmod", name, func(p string) error {
+		return unix.Chmod(p, perm)
+	})
+}
+
+// byName calls f with a name for name that system calls taking only
+// names can use: a /proc/self/fd name, of name opened O_PATH. name must
+// not be a symbolic link, which would be followed.
+func (r *cpuRoot) byName(op, name string, f func(string) error) error {
+	fd, err := r.openfd(name, unix.O_PATH|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return &os.PathError{Op: op, Path: filepath.Join(r.path, name), Err: err}
+	}
+	if st.Mode&unix.S_IFMT == unix.S_IFLNK {
+		return &os.PathError{Op: op, Path: filepath.Join(r.path, name), Err: unix.EOPNOTSUPP}
+	}
+	if err := f(fmt.Sprintf("/proc/self/fd/%d", fd)); err != nil {
+		return &os.PathError{Op: op, Path: filepath.Join(r.path, name), Err: err}
+	}
+	return nil
+}
+
+// lname calls f with a name for name that the l* system calls, which do
+// not follow a symbolic link at the end of a name, can use: the last
+// element of name, in the /proc/self/fd name of the directory it is in.
+func (r *cpuRoot) lname(op, name string, f func(string) error) error {
+	return r.at(op, name, func(d int, base string) error {
+		return f(fmt.Sprintf("/proc/self/fd/%d/%s", d, base))
+	})
+}
+
+// mknod makes name, a device, FIFO or socket, as mode says.
+func (r *cpuRoot) mknod(name string, mode uint32, major, minor uint32) error {
+	return r.at("mknod", name, func(d int, base string) error {
+		return unix.Mknodat(d, base, mode, int(unix.Mkdev(major, minor)))
+	})
+}
+
+// fsStat returns the p9.FSStat of a unix.Statfs_t.
+func fsStat(st *unix.Statfs_t) p9.FSStat {
+	return p9.FSStat{
+		Type:            uint32(st.Type),
+		BlockSize:       uint32(st.Bsize),
+		Blocks:          st.Blocks,
+		BlocksFree:      st.Bfree,
+		BlocksAvailable: st.Bavail,
+		Files:           st.Files,
+		FilesFree:       st.Ffree,
+		FSID:            fsid(st.Fsid),
+		NameLength:      uint32(st.Namelen),
+	}
+}
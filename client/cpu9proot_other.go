@@ -0,0 +1,68 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !windows && !plan9
+// +build !linux,!windows,!plan9
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// oPath opens a file only to name it, e.g. as the directory of the *at
+// system calls. Without O_PATH, it must be readable, and, so that a
+// FIFO does not block, it is opened O_NONBLOCK.
+const oPath = unix.O_RDONLY | unix.O_NONBLOCK
+
+// openBeneath opens name relative to the directory fd, walking it one
+// element at a time, as there is no openat2.
+func openBeneath(fd int, name string, flags int, perm uint32) (int, error) {
+	return walkBeneath(fd, name, flags, perm)
+}
+
+// chmod sets the permissions of name, or, if it is a symbolic link,
+// of the link.
+func (r *cpuRoot) chmod(name string, perm uint32) error {
+	return r.at("chmod", name, func(d int, base string) error {
+		return unix.Fchmodat(d, base, perm, unix.AT_SYMLINK_NOFOLLOW)
+	})
+}
+
+// byName calls f with a name for name that system calls taking only
+// names can use. With no /proc/self/fd, it is the name under the root,
+// once it is checked to resolve beneath it, and not to be a symbolic
+// link; a rename after the check can still move it out.
+func (r *cpuRoot) byName(op, name string, f func(string) error) error {
+	fi, err := r.lstat(name)
+	if err != nil {
+		return err
+	}
+	p := filepath.Join(r.path, name)
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return &os.PathError{Op: op, Path: p, Err: unix.EOPNOTSUPP}
+	}
+	if err := f(p); err != nil {
+		return &os.PathError{Op: op, Path: p, Err: err}
+	}
+	return nil
+}
+
+// lname calls f with a name for name that the l* system calls, which do
+// not follow a symbolic link at the end of a name, can use. As for
+// byName, it is the name under the root, once it is checked to resolve
+// beneath it.
+func (r *cpuRoot) lname(op, name string, f func(string) error) error {
+	if _, err := r.lstat(name); err != nil {
+		return err
+	}
+	p := filepath.Join(r.path, name)
+	if err := f(p); err != nil {
+		return &os.PathError{Op: op, Path: p, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package client
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+// escapeTree makes a directory, with a file, secret, and a root in it,
+// whose links try to reach secret, and returns the root and secret.
+func escapeTree(t *testing.T) (string, string) {
+	d := t.TempDir()
+	secret := filepath.Join(d, "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(d, "root")
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "f"), []byte("f"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []struct{ old, new string }{
+		{old: secret, new: "abs"},
+		{old: "../secret", new: "up"},
+		{old: "sub/../../secret", new: "subup"},
+		{old: "..", new: "parent"},
+		{old: "sub/../f", new: "in"},
+		{old: "..", new: "sub/up"},
+		{old: "loop", new: "loop"},
+	} {
+		if err := os.Symlink(l.old, filepath.Join(root, l.new)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, secret
+}
+
+func TestOpenBeneath(t *testing.T) {
+	root, _ := escapeTree(t)
+	rfd, err := unix.Open(root, unix.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(rfd)
+	for _, open := range []struct {
+		name string
+		open func(int, string, int, uint32) (int, error)
+	}{
+		{name: "openBeneath", open: openBeneath},
+		{name: "walkBeneath", open: walkBeneath},
+	} {
+		for _, tt := range []struct {
+			name string
+			want string
+			err  error
+		}{
+			{name: "f", want: "f"},
+			{name: "in", want: "f"},
+			{name: "sub/up/f", want: "f"},
+			{name: "sub/../f", want: "f"},
+			{name: "abs", err: unix.EXDEV},
+			{name: "up", err: unix.EXDEV},
+			{name: "subup", err: unix.EXDEV},
+			{name: "parent/secret", err: unix.EXDEV},
+			{name: "sub/up/up/secret", err: unix.EXDEV},
+			{name: "../secret", err: unix.EXDEV},
+			{name: "loop", err: unix.ELOOP},
+			{name: "f/x", err: unix.ENOTDIR},
+		} {
+			fd, err := open.open(rfd, tt.name, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%s(%q): %v, want %v", open.name, tt.name, err, tt.err)
+			}
+			if err != nil {
+				continue
+			}
+			b := make([]byte, 16)
+			n, _ := unix.Read(fd, b)
+			unix.Close(fd)
+			if string(b[:n]) != tt.want {
+				t.Errorf("%s(%q): read %q, want %q", open.name, tt.name, b[:n], tt.want)
+			}
+		}
+		// With O_NOFOLLOW, a link is not followed, even within
+		// the root.
+		if fd, err := open.open(rfd, "in", unix.O_RDONLY|unix.O_NOFOLLOW, 0); err == nil {
+			unix.Close(fd)
+			t.Errorf("%s(%q, O_NOFOLLOW): nil, want an error", open.name, "in")
+		}
+	}
+}
+
+func TestCPU9PBeneath(t *testing.T) {
+	root, secret := escapeTree(t)
+	r := walk(t, root)
+
+	// Links can be walked to, and read, but not opened.
+	for _, n := range []string{"abs", "up", "subup", "in"} {
+		_, f, err := r.Walk([]string{n})
+		if err != nil {
+			t.Errorf("Walk(%q): %v, want nil", n, err)
+			continue
+		}
+		if _, _, err := f.Open(p9.ReadOnly); err == nil {
+			t.Errorf("Open(%q): nil, want an error", n)
+		}
+		if _, err := f.Readlink(); err != nil {
+			t.Errorf("Readlink(%q): %v, want nil", n, err)
+		}
+		// Nor do changes to a link reach what it names.
+		f.SetAttr(p9.SetAttrMask{Permissions: true, Size: true}, p9.SetAttr{Permissions: 0o600}) //nolint
+		f.SetXattr("user.cpu", []byte("x"), 0)                                                   //nolint
+	}
+	if fi, err := os.Stat(secret); err != nil || fi.Mode().Perm() != 0o644 || fi.Size() != 6 {
+		t.Errorf("secret after SetAttr through links: %v, %v, want mode %v, size 6", fi.Mode(), err, os.FileMode(0o644))
+	}
+
+	// Walking through a link goes only where it stays beneath the
+	// root.
+	for _, tt := range []struct {
+		names []string
+		ok    bool
+	}{
+		{names: []string{"sub", "up", "f"}, ok: true},
+		{names: []string{"parent", "secret"}},
+		{names: []string{"sub", "up", "up", "secret"}},
+	} {
+		if _, _, err := r.Walk(tt.names); (err == nil) != tt.ok {
+			t.Errorf("Walk(%q): %v, want ok %v", tt.names, err, tt.ok)
+		}
+	}
+
+	// The link itself can be walked to, but not through.
+	_, p, err := r.Walk([]string{"sub", "up", "up"})
+	if err != nil {
+		t.Fatalf("Walk to a link: %v, want nil", err)
+	}
+	if _, _, _, err := p.Create("escaped", p9.WriteOnly, 0o644, 0, 0); err == nil {
+		t.Errorf("Create through an escaping link: nil, want an error")
+	}
+	if _, err := p.Mkdir("escaped", 0o755, 0, 0); err == nil {
+		t.Errorf("Mkdir through an escaping link: nil, want an error")
+	}
+	if err := r.RenameAt("f", walk(t, root, "sub"), "../../moved"); err == nil {
+		t.Errorf("RenameAt to ../../moved: nil, want an error")
+	}
+	if err := r.RenameAt("up", walk(t, root, "sub"), "g"); err != nil {
+		t.Errorf("RenameAt of a link: %v, want nil", err)
+	}
+	if _, err := os.Lstat(filepath.Join(filepath.Dir(root), "escaped")); err == nil {
+		t.Errorf("a file was made outside the root")
+	}
+	if _, err := os.Stat(secret); err != nil {
+		t.Errorf("secret: %v, want nil", err)
+	}
+}
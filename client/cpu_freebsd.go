@@ -11,8 +11,16 @@ import (
 	"github.com/hugelgupf/p9/p9"
 )
 
+// osflags returns the open(2) flags for the mode and Tlopen flags
+// that CPU9P honours.
 func osflags(fi os.FileInfo, mode p9.OpenFlags) int {
-	flags := int(mode)
+	flags := mode.OSFlags()
+	if mode&lopenTrunc != 0 {
+		flags |= syscall.O_TRUNC
+	}
+	if mode&lopenAppend != 0 {
+		flags |= syscall.O_APPEND
+	}
 	if fi.IsDir() {
 		flags |= syscall.O_DIRECTORY
 	}
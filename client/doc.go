@@ -11,4 +11,13 @@
 // the remote server reads SHELL and starts a shell.
 // Similarly, because the root for the client namespace is known only to the client.
 // it is settable in the Cmd struct.
+//
+// Files can be copied to and from the host of a Cmd, over SFTP, with
+// Copy, as cpu cp does; SFTP returns an SFTP client for other uses.
+//
+// Binds in the NameSpace can be read-only, and names can be hidden
+// with Hide; the Policy these set is kept by the client's 9P and NFS
+// servers, whatever the remote does with its mounts. The user and
+// group ids of the files served can be mapped, with IDMap. Over slow
+// links, the 9P and NFS channels can be compressed, with Compress.
 package client
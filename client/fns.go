@@ -64,10 +64,13 @@ func (n nonce) String() string {
 }
 
 // UserKeyConfig sets up authentication for a User Key.
-// It is required in almost all cases.
+// It is required in almost all cases. With a prompt (WithPrompt),
+// password and keyboard-interactive authentication are set up too,
+// and the key need not exist.
 func (c *Cmd) UserKeyConfig() error {
 	if c.DisablePrivateKey {
 		verbose("Not using a key file to encrypt the ssh connection")
+		c.passwordConfig()
 		return nil
 	}
 	kf := c.PrivateKeyFile
@@ -83,6 +86,12 @@ func (c *Cmd) UserKeyConfig() error {
 		kf = filepath.Join(os.Getenv("HOME"), kf[1:])
 	}
 	key, err := os.ReadFile(kf)
+	if err != nil && c.prompt != nil {
+		// A password may do.
+		verbose("unable to read private key %q: %v", kf, err)
+		c.passwordConfig()
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("unable to read private key %q: %w", kf, err)
 	}
@@ -92,9 +101,42 @@ func (c *Cmd) UserKeyConfig() error {
 		return fmt.Errorf("ParsePrivateKey %q: %v", kf, err)
 	}
 	c.config.Auth = append(c.config.Auth, ssh.PublicKeys(signer))
+	c.passwordConfig()
 	return nil
 }
 
+// passwordConfig sets up keyboard-interactive and password
+// authentication, after any key, if there is a prompt to ask for them.
+func (c *Cmd) passwordConfig() {
+	if c.prompt == nil {
+		return
+	}
+	c.config.Auth = append(c.config.Auth,
+		ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			var answers []string
+			for i, q := range questions {
+				// The name and instruction, if any, are shown
+				// before the first question.
+				if i == 0 {
+					for _, s := range []string{instruction, name} {
+						if len(s) > 0 {
+							q = s + "\n" + q
+						}
+					}
+				}
+				a, err := c.prompt(q, echos[i])
+				if err != nil {
+					return nil, err
+				}
+				answers = append(answers, a)
+			}
+			return answers, nil
+		}),
+		ssh.PasswordCallback(func() (string, error) {
+			return c.prompt(fmt.Sprintf("%s@%s's password: ", c.config.User, c.HostName), false)
+		}))
+}
+
 // HostKeyConfig sets the host key. It is optional.
 func (c *Cmd) HostKeyConfig(hostKeyFile string) error {
 	hk, err := os.ReadFile(hostKeyFile)
@@ -282,9 +324,18 @@ func (c *Cmd) Outputs() ([]bytes.Buffer, error) {
 // ParseBinds parses a CPU_NAMESPACE-style string to a
 // an fstab format string.
 func ParseBinds(s string) (string, error) {
+	fstab, _, err := parseBinds(s)
+	return fstab, err
+}
+
+// parseBinds is ParseBinds, also returning the Policy that the ,ro and
+// ,rw at the end of binds set: a bind ending in ,ro is read-only, and
+// one ending in ,rw read-write, even if in a read-only one.
+func parseBinds(s string) (string, *Policy, error) {
 	var fstab string
+	p := &Policy{}
 	if len(s) == 0 {
-		return fstab, nil
+		return fstab, p, nil
 	}
 	// This is bit tricky. For now we have to assume
 	// cpud is on Linux, since only Linux has the features we
@@ -294,7 +345,17 @@ func ParseBinds(s string) (string, error) {
 	binds := strings.Split(s, ":")
 	for i, bind := range binds {
 		if len(bind) == 0 {
-			return "", fmt.Errorf("bind: element %d is zero length:%w", i, strconv.ErrSyntax)
+			return "", nil, fmt.Errorf("bind: element %d is zero length:%w", i, strconv.ErrSyntax)
+		}
+		// A bind ending in ,ro is mounted read-only, and
+		// served so; one ending in ,rw is served read-write.
+		opts := "defaults,bind"
+		ro, rw := strings.HasSuffix(bind, ",ro"), strings.HasSuffix(bind, ",rw")
+		if ro || rw {
+			bind = bind[:len(bind)-len(",ro")]
+		}
+		if ro {
+			opts += ",ro"
 		}
 		// If the value is local=remote, len(c) will be 2.
 		// The value might be some weird degenerate form such as
@@ -306,27 +367,33 @@ func ParseBinds(s string) (string, error) {
 		var local, remote string
 		switch len(c) {
 		case 0:
-			return fstab, fmt.Errorf("bind: element %d(%q): empty elements are not supported:%w", i, bind, strconv.ErrSyntax)
+			return fstab, nil, fmt.Errorf("bind: element %d(%q): empty elements are not supported:%w", i, bind, strconv.ErrSyntax)
 		case 1:
 			local, remote = c[0], c[0]
 		case 2:
 			local, remote = c[0], c[1]
 		default:
-			return fstab, fmt.Errorf("bind: element %d(%q): too many elements around = sign:%w", i, bind, strconv.ErrSyntax)
+			return fstab, nil, fmt.Errorf("bind: element %d(%q): too many elements around = sign:%w", i, bind, strconv.ErrSyntax)
 		}
 		if len(local) == 0 {
-			return fstab, fmt.Errorf("bind: element %d(%q): local is 0 length:%w", i, bind, strconv.ErrSyntax)
+			return fstab, nil, fmt.Errorf("bind: element %d(%q): local is 0 length:%w", i, bind, strconv.ErrSyntax)
 		}
 		if len(remote) == 0 {
-			return fstab, fmt.Errorf("bind: element %d(%q): remote is 0 length:%w", i, bind, strconv.ErrSyntax)
+			return fstab, nil, fmt.Errorf("bind: element %d(%q): remote is 0 length:%w", i, bind, strconv.ErrSyntax)
 		}
 
 		// The convention is that the remote side is relative to filepath.Join(tmpMnt, "cpu")
 		// and the left side is taken exactly as written. Further, recall that in bind mounts, the
 		// remote side is the "device", and the local side is the "target."
-		fstab = fstab + fmt.Sprintf("%s %s none defaults,bind 0 0\n", filepath.Join(tmpMnt, "cpu", remote), local)
+		fstab = fstab + fmt.Sprintf("%s %s none %s 0 0\n", filepath.Join(tmpMnt, "cpu", remote), local, opts)
+		switch {
+		case ro:
+			p.ReadOnly = append(p.ReadOnly, cleanName(remote))
+		case rw:
+			p.ReadWrite = append(p.ReadWrite, cleanName(remote))
+		}
 	}
-	return fstab, nil
+	return fstab, p, nil
 }
 
 // JoinFSTab joins an arbitrary number of fstab-style strings.
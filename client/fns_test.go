@@ -67,6 +67,9 @@ func TestParseBinds(t *testing.T) {
 		// note also that only remote names contain = signs pending
 		// more complex parsing. Perhaps it should not be allowed at all.
 		{"/a:/bin==/b/bin", td("cpu/a") + " /a none defaults,bind 0 0\n" + td("cpu/=/b/bin") + " /bin none defaults,bind 0 0\n", nil},
+		{"/usr,ro:/usr/local,rw", td("cpu/usr") + " /usr none defaults,bind,ro 0 0\n" + td("cpu/usr/local") + " /usr/local none defaults,bind 0 0\n", nil},
+		{"/home/rob=/Users/rob,ro", td("cpu/Users/rob") + " /home/rob none defaults,bind,ro 0 0\n", nil},
+		{",rw:/a,ro", "", strconv.ErrSyntax},
 	} {
 		f, err := ParseBinds(tt.namespace)
 		if !errors.Is(err, tt.err) {
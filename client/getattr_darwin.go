@@ -15,8 +15,6 @@
 package client
 
 import (
-	"syscall"
-
 	"github.com/hugelgupf/p9/p9"
 )
 
@@ -29,22 +27,22 @@ func (l *CPU9P) GetAttr(req p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
 		return qid, p9.AttrMask{}, p9.Attr{}, err
 	}
 
-	stat := fi.Sys().(*syscall.Stat_t)
+	stat := &fi.st
 	attr := p9.Attr{
 		Mode:             p9.FileMode(stat.Mode),
-		UID:              p9.UID(stat.Uid),
-		GID:              p9.GID(stat.Gid),
+		UID:              l.root.ids.remoteUID(stat.Uid),
+		GID:              l.root.ids.remoteGID(stat.Gid),
 		NLink:            p9.NLink(stat.Nlink),
 		RDev:             p9.Dev(stat.Rdev),
 		Size:             uint64(stat.Size),
 		BlockSize:        uint64(stat.Blksize),
 		Blocks:           uint64(stat.Blocks),
-		ATimeSeconds:     uint64(stat.Atimespec.Sec),
-		ATimeNanoSeconds: uint64(stat.Atimespec.Nsec),
-		MTimeSeconds:     uint64(stat.Mtimespec.Sec),
-		MTimeNanoSeconds: uint64(stat.Mtimespec.Nsec),
-		CTimeSeconds:     uint64(stat.Ctimespec.Sec),
-		CTimeNanoSeconds: uint64(stat.Ctimespec.Nsec),
+		ATimeSeconds:     uint64(stat.Atim.Sec),
+		ATimeNanoSeconds: uint64(stat.Atim.Nsec),
+		MTimeSeconds:     uint64(stat.Mtim.Sec),
+		MTimeNanoSeconds: uint64(stat.Mtim.Nsec),
+		CTimeSeconds:     uint64(stat.Ctim.Sec),
+		CTimeNanoSeconds: uint64(stat.Ctim.Nsec),
 	}
 	valid := p9.AttrMask{
 		Mode:   true,
@@ -15,8 +15,6 @@
 package client
 
 import (
-	"syscall"
-
 	"github.com/hugelgupf/p9/p9"
 )
 
@@ -29,11 +27,11 @@ func (l *CPU9P) GetAttr(req p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
 		return qid, p9.AttrMask{}, p9.Attr{}, err
 	}
 
-	stat := fi.Sys().(*syscall.Stat_t)
+	stat := &fi.st
 	attr := p9.Attr{
 		Mode:             p9.FileMode(stat.Mode),
-		UID:              p9.UID(stat.Uid),
-		GID:              p9.GID(stat.Gid),
+		UID:              l.root.ids.remoteUID(stat.Uid),
+		GID:              l.root.ids.remoteGID(stat.Gid),
 		NLink:            p9.NLink(stat.Nlink),
 		RDev:             p9.Dev(stat.Rdev),
 		Size:             uint64(stat.Size),
@@ -0,0 +1,156 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hugelgupf/p9/p9"
+)
+
+// An IDMap maps the user and group ids of the files CPU9P serves, as
+// the client has them, to those the remote sees, and back. A nil
+// IDMap is the identity. IDMaps are made by ParseIDMap.
+type IDMap struct {
+	// If squash is set, every file is owned by uid and gid, the
+	// client's user and group, as the remote sees it, and
+	// everything the remote makes or changes the owner of is
+	// owned by them, as the client sees it.
+	squash   bool
+	uid, gid uint32
+	// Otherwise, uids and gids map client ids to remote ids, and
+	// ruids and rgids remote ids to client ids; ids not in them
+	// are not changed.
+	uids, gids   map[uint32]uint32
+	ruids, rgids map[uint32]uint32
+}
+
+// ParseIDMap parses an id map: identity, or empty, for the identity;
+// squash, to squash all ids to the client's user and group; or a
+// comma-separated table of u<client>=<remote> and g<client>=<remote>,
+// e.g. u1000=0,g1000=0, each id mapped at most once each way.
+func ParseIDMap(s string) (*IDMap, error) {
+	switch s {
+	case "", "identity":
+		return nil, nil
+	case "squash":
+		return &IDMap{squash: true, uid: uint32(os.Getuid()), gid: uint32(os.Getgid())}, nil
+	}
+	m := &IDMap{
+		uids:  map[uint32]uint32{},
+		gids:  map[uint32]uint32{},
+		ruids: map[uint32]uint32{},
+		rgids: map[uint32]uint32{},
+	}
+	for _, e := range strings.Split(s, ",") {
+		var ids, rids map[uint32]uint32
+		switch {
+		case strings.HasPrefix(e, "u"):
+			ids, rids = m.uids, m.ruids
+		case strings.HasPrefix(e, "g"):
+			ids, rids = m.gids, m.rgids
+		default:
+			return nil, fmt.Errorf("%q in id map %q is not u<id>=<id> or g<id>=<id>:%w", e, s, strconv.ErrSyntax)
+		}
+		l, r, ok := strings.Cut(e[1:], "=")
+		if !ok {
+			return nil, fmt.Errorf("%q in id map %q has no =:%w", e, s, strconv.ErrSyntax)
+		}
+		lid, err := parseID(l)
+		if err != nil {
+			return nil, fmt.Errorf("%q in id map %q:%w", e, s, err)
+		}
+		rid, err := parseID(r)
+		if err != nil {
+			return nil, fmt.Errorf("%q in id map %q:%w", e, s, err)
+		}
+		if _, ok := ids[lid]; ok {
+			return nil, fmt.Errorf("%d in id map %q is mapped twice:%w", lid, s, strconv.ErrSyntax)
+		}
+		if _, ok := rids[rid]; ok {
+			return nil, fmt.Errorf("%d in id map %q is mapped to twice:%w", rid, s, strconv.ErrSyntax)
+		}
+		ids[lid], rids[rid] = rid, lid
+	}
+	return m, nil
+}
+
+// parseID parses a user or group id, which can not be that of no
+// user or group.
+func parseID(s string) (uint32, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if id == uint64(p9.NoUID) {
+		return 0, fmt.Errorf("%d is no id:%w", id, strconv.ErrRange)
+	}
+	return uint32(id), nil
+}
+
+// remoteUID returns the remote id of client user id uid.
+func (m *IDMap) remoteUID(uid uint32) p9.UID {
+	if m == nil {
+		return p9.UID(uid)
+	}
+	if m.squash {
+		return p9.UID(m.uid)
+	}
+	if r, ok := m.uids[uid]; ok {
+		return p9.UID(r)
+	}
+	return p9.UID(uid)
+}
+
+// remoteGID returns the remote id of client group id gid.
+func (m *IDMap) remoteGID(gid uint32) p9.GID {
+	if m == nil {
+		return p9.GID(gid)
+	}
+	if m.squash {
+		return p9.GID(m.gid)
+	}
+	if r, ok := m.gids[gid]; ok {
+		return p9.GID(r)
+	}
+	return p9.GID(gid)
+}
+
+// localUID returns the client id of remote user id uid, or -1, as
+// chown(2) takes it, if it is no user.
+func (m *IDMap) localUID(uid p9.UID) int {
+	switch {
+	case !uid.Ok():
+		return -1
+	case m == nil:
+		return int(uid)
+	case m.squash:
+		return int(m.uid)
+	}
+	if l, ok := m.ruids[uint32(uid)]; ok {
+		return int(l)
+	}
+	return int(uid)
+}
+
+// localGID returns the client id of remote group id gid, or -1, as
+// chown(2) takes it, if it is no group.
+func (m *IDMap) localGID(gid p9.GID) int {
+	switch {
+	case !gid.Ok():
+		return -1
+	case m == nil:
+		return int(gid)
+	case m.squash:
+		return int(m.gid)
+	}
+	if l, ok := m.rgids[uint32(gid)]; ok {
+		return int(l)
+	}
+	return int(gid)
+}
@@ -0,0 +1,75 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/hugelgupf/p9/p9"
+)
+
+func TestParseIDMap(t *testing.T) {
+	for _, s := range []string{"", "identity"} {
+		if m, err := ParseIDMap(s); m != nil || err != nil {
+			t.Errorf("ParseIDMap(%q): (%v, %v), want (nil, nil)", s, m, err)
+		}
+	}
+	var m *IDMap
+	if u, g := m.remoteUID(5), m.localGID(6); u != 5 || g != 6 {
+		t.Errorf("identity: %d, %d, want 5, 6", u, g)
+	}
+
+	m, err := ParseIDMap("squash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	if u, g, lu, lg := m.remoteUID(5), m.remoteGID(6), m.localUID(7), m.localGID(8); u != p9.UID(uid) || g != p9.GID(gid) || lu != uid || lg != gid {
+		t.Errorf("squash: %d, %d, %d, %d, want %d, %d, %d, %d", u, g, lu, lg, uid, gid, uid, gid)
+	}
+
+	if m, err = ParseIDMap("u1000=0,g100=0,u0=1000"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name      string
+		got, want int
+	}{
+		{name: "remoteUID(1000)", got: int(m.remoteUID(1000)), want: 0},
+		{name: "remoteUID(0)", got: int(m.remoteUID(0)), want: 1000},
+		{name: "remoteUID(5)", got: int(m.remoteUID(5)), want: 5},
+		{name: "remoteGID(100)", got: int(m.remoteGID(100)), want: 0},
+		{name: "remoteGID(1000)", got: int(m.remoteGID(1000)), want: 1000},
+		{name: "localUID(0)", got: m.localUID(0), want: 1000},
+		{name: "localUID(1000)", got: m.localUID(1000), want: 0},
+		{name: "localUID(NoUID)", got: m.localUID(p9.NoUID), want: -1},
+		{name: "localGID(0)", got: m.localGID(0), want: 100},
+		{name: "localGID(NoGID)", got: m.localGID(p9.NoGID), want: -1},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		s   string
+		err error
+	}{
+		{s: "x1=2", err: strconv.ErrSyntax},
+		{s: "u1", err: strconv.ErrSyntax},
+		{s: "u1=2,u1=3", err: strconv.ErrSyntax},
+		{s: "u1=2,u3=2", err: strconv.ErrSyntax},
+		{s: "ua=1", err: strconv.ErrSyntax},
+		{s: "u1=4294967295", err: strconv.ErrRange},
+		{s: "g1=4294967296", err: strconv.ErrRange},
+	} {
+		if _, err := ParseIDMap(tt.s); !errors.Is(err, tt.err) {
+			t.Errorf("ParseIDMap(%q): %v, want %v", tt.s, err, tt.err)
+		}
+	}
+}
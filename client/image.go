@@ -0,0 +1,618 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/u-root/u-root/pkg/cpio"
+)
+
+// Images are served, read-only, as a cpio is, from a docker save
+// tarball or an OCI image layout directory (or a tar of one), with no
+// need to flatten them to a cpio first. The layers of the image are
+// applied in order, as a container runtime does: whiteouts, .wh.name,
+// remove a name from the layers below, and opaque directories, marked
+// with .wh..wh..opq, hide what the layers below have in them.
+//
+// Layers that are not compressed are read where they are; those that
+// are, with gzip or zstd, are decompressed, once, to a temporary file.
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// ParseImage splits an image reference, path[:ref], in its path and
+// the ref that selects an image in it: a tag, e.g. alpine:3.19 or
+// 3.19, or a digest, e.g. sha256:...; it may be empty. As a tag has a
+// :, too, the path is the longest prefix, ending before a :, that
+// exists.
+func ParseImage(s string) (string, string) {
+	for i := len(s); i > 0; i = strings.LastIndex(s[:i], ":") {
+		if _, err := os.Stat(s[:i]); err == nil {
+			if i == len(s) {
+				return s, ""
+			}
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
+
+// imageSource is where the blobs of an image are: a directory or a
+// tar of one.
+type imageSource interface {
+	// open returns the contents of the file name, and its size.
+	open(name string) (io.ReaderAt, int64, error)
+}
+
+// dirSource is an image in a directory.
+type dirSource string
+
+func (d dirSource) open(name string) (io.ReaderAt, int64, error) {
+	if !filepath.IsLocal(name) {
+		return nil, 0, fmt.Errorf("image file %q is not in %q:%w", name, string(d), os.ErrInvalid)
+	}
+	f, err := os.Open(filepath.Join(string(d), filepath.FromSlash(name)))
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+// tarSource is an image in a tar, e.g. from docker save. Files are
+// read where they are in it.
+type tarSource struct {
+	files map[string]*io.SectionReader
+	// links are the symlinks, e.g. those docker save makes for
+	// layers that are the same as others.
+	links map[string]string
+}
+
+func (t *tarSource) open(name string) (io.ReaderAt, int64, error) {
+	n := imageName(name)
+	for i := 0; i < 8; i++ {
+		l, ok := t.links[n]
+		if !ok {
+			break
+		}
+		n = imageName(path.Join(path.Dir(n), l))
+	}
+	s, ok := t.files[n]
+	if !ok {
+		return nil, 0, fmt.Errorf("image file %q:%w", name, os.ErrNotExist)
+	}
+	return s, s.Size(), nil
+}
+
+// newTarSource returns a tarSource of the tar r, of size bytes.
+func newTarSource(r io.ReaderAt, size int64) (*tarSource, error) {
+	t := &tarSource{files: map[string]*io.SectionReader{}, links: map[string]string{}}
+	err := walkTar(r, size, func(hdr *tar.Header, data io.ReaderAt) error {
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			t.files[imageName(hdr.Name)] = io.NewSectionReader(data, 0, hdr.Size)
+		case tar.TypeSymlink:
+			t.links[imageName(hdr.Name)] = hdr.Linkname
+		}
+		return nil
+	})
+	return t, err
+}
+
+// walkTar calls f with each header of the tar r, of size bytes, and
+// the data of its file, which, unless the file is sparse, is read
+// where it is in r.
+func walkTar(r io.ReaderAt, size int64, f func(*tar.Header, io.ReaderAt) error) error {
+	// archive/tar seeks past what is not read, so that the
+	// position of sr is where the data of each file starts.
+	sr := io.NewSectionReader(r, 0, size)
+	tr := tar.NewReader(sr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var data io.ReaderAt
+		if sparse(hdr) {
+			b, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			data = bytes.NewReader(b)
+		} else {
+			off, err := sr.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return err
+			}
+			data = io.NewSectionReader(r, off, hdr.Size)
+		}
+		if err := f(hdr, data); err != nil {
+			return err
+		}
+	}
+}
+
+// sparse returns true if the file of hdr is sparse: its data is not
+// stored as it is read.
+func sparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// decompress returns r, of size bytes, and its size, if it is not
+// compressed; if it is, with gzip or zstd, it returns it decompressed
+// to a temporary file.
+func decompress(r io.ReaderAt, size int64) (io.ReaderAt, int64, error) {
+	var d io.Reader
+	switch compression(r) {
+	case "gzip":
+		z, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, 0, err
+		}
+		d = z
+	case "zstd":
+		z, err := zstd.NewReader(io.NewSectionReader(r, 0, size), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, 0, err
+		}
+		defer z.Close()
+		d = z
+	default:
+		return r, size, nil
+	}
+	f, err := os.CreateTemp("", "cpu-image")
+	if err != nil {
+		return nil, 0, err
+	}
+	// The file is gone once it is closed, or cpu exits.
+	os.Remove(f.Name())
+	n64, err := io.Copy(f, d)
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, n64, nil
+}
+
+// imageManifest is an image in a source: its tags, digests, and the
+// names of its layers, from the lowest up.
+type imageManifest struct {
+	tags    []string
+	digests []string
+	layers  []string
+}
+
+// matches returns true if ref selects m: it is one of its digests,
+// one of its tags, or the end of one, after a : or /, e.g. 3.19 or
+// alpine:3.19 for docker.io/library/alpine:3.19.
+func (m *imageManifest) matches(ref string) bool {
+	ref = strings.TrimPrefix(ref, "@")
+	for _, d := range m.digests {
+		if ref == d {
+			return true
+		}
+	}
+	for _, t := range m.tags {
+		if ref == t || strings.HasSuffix(t, ":"+ref) || strings.HasSuffix(t, "/"+ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// blobName returns the name of the blob with digest d in an OCI
+// image layout.
+func blobName(d string) (string, error) {
+	alg, hex, ok := strings.Cut(d, ":")
+	if !ok || alg == "" || hex == "" || strings.ContainsAny(d, "/\\") {
+		return "", fmt.Errorf("digest %q:%w", d, os.ErrInvalid)
+	}
+	return path.Join("blobs", alg, hex), nil
+}
+
+// readJSON decodes the JSON file name of src into v.
+func readJSON(src imageSource, name string, v interface{}) error {
+	r, size, err := src.open(name)
+	if err != nil {
+		return err
+	}
+	if err := json.NewDecoder(io.NewSectionReader(r, 0, size)).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// dockerManifests returns the images of a docker save manifest.json.
+func dockerManifests(src imageSource) ([]imageManifest, error) {
+	var dm []struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	if err := readJSON(src, "manifest.json", &dm); err != nil {
+		return nil, err
+	}
+	var ms []imageManifest
+	for _, d := range dm {
+		m := imageManifest{tags: d.RepoTags, layers: d.Layers}
+		// The config is <hex>.json, or, as docker now saves
+		// an OCI layout, too, blobs/sha256/<hex>; its digest
+		// is the image ID.
+		c := strings.TrimSuffix(d.Config, ".json")
+		if dir, hex := path.Split(c); dir == "blobs/sha256/" || dir == "" {
+			m.digests = append(m.digests, "sha256:"+hex)
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+// ociDescriptor is a descriptor, as in the OCI image spec.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform"`
+}
+
+// index reports whether d is of an index, of manifests for several
+// platforms, and not of a manifest.
+func (d *ociDescriptor) index() bool {
+	return d.MediaType == "application/vnd.oci.image.index.v1+json" ||
+		d.MediaType == "application/vnd.docker.distribution.manifest.list.v2+json"
+}
+
+// ociManifests returns the images of an OCI image layout, from its
+// index.json. An index of manifests for several platforms is the
+// image for linux on this architecture.
+func ociManifests(src imageSource) ([]imageManifest, error) {
+	var idx struct {
+		Manifests []ociDescriptor `json:"manifests"`
+	}
+	if err := readJSON(src, "index.json", &idx); err != nil {
+		return nil, err
+	}
+	var ms []imageManifest
+	for _, d := range idx.Manifests {
+		m := imageManifest{digests: []string{d.Digest}}
+		for _, a := range []string{"org.opencontainers.image.ref.name", "io.containerd.image.name"} {
+			if t, ok := d.Annotations[a]; ok {
+				m.tags = append(m.tags, t)
+			}
+		}
+		desc, err := ociPlatform(src, d)
+		if err != nil {
+			return nil, err
+		}
+		var om struct {
+			Layers []ociDescriptor `json:"layers"`
+		}
+		n, err := blobName(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if err := readJSON(src, n, &om); err != nil {
+			return nil, err
+		}
+		for _, l := range om.Layers {
+			n, err := blobName(l.Digest)
+			if err != nil {
+				return nil, err
+			}
+			m.layers = append(m.layers, n)
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+// ociPlatform returns d, if it is of a manifest, or, if it is of an
+// index, the manifest in it for linux on this architecture, or the
+// only one.
+func ociPlatform(src imageSource, d ociDescriptor) (ociDescriptor, error) {
+	for depth := 0; d.index(); depth++ {
+		if depth > 8 {
+			return d, fmt.Errorf("index %s: too deep:%w", d.Digest, os.ErrInvalid)
+		}
+		n, err := blobName(d.Digest)
+		if err != nil {
+			return d, err
+		}
+		var idx struct {
+			Manifests []ociDescriptor `json:"manifests"`
+		}
+		if err := readJSON(src, n, &idx); err != nil {
+			return d, err
+		}
+		var found bool
+		for _, m := range idx.Manifests {
+			if len(idx.Manifests) == 1 || (m.Platform != nil && m.Platform.OS == "linux" && m.Platform.Architecture == runtime.GOARCH) {
+				d, found = m, true
+				break
+			}
+		}
+		if !found {
+			return d, fmt.Errorf("index %s: no manifest for linux/%s:%w", d.Digest, runtime.GOARCH, os.ErrNotExist)
+		}
+	}
+	return d, nil
+}
+
+// openImage returns the source of the image at p, a directory or a
+// tar, possibly compressed, and its manifests.
+func openImage(p string) (imageSource, []imageManifest, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	var src imageSource = dirSource(p)
+	if !fi.IsDir() {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		r, size, err := decompress(f, fi.Size())
+		if err != nil {
+			return nil, nil, fmt.Errorf("image %q: %w", p, err)
+		}
+		if src, err = newTarSource(r, size); err != nil {
+			return nil, nil, fmt.Errorf("image %q: %w", p, err)
+		}
+	}
+	// docker save writes a manifest.json, and, of late, an OCI
+	// layout, too; the manifest.json has the tags.
+	ms, err := dockerManifests(src)
+	if errors.Is(err, os.ErrNotExist) {
+		ms, err = ociManifests(src)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("image %q: %w", p, err)
+	}
+	return src, ms, nil
+}
+
+// selectImage returns the manifest ref selects, or, if ref is empty,
+// the only one.
+func selectImage(ms []imageManifest, ref string) (*imageManifest, error) {
+	var found []*imageManifest
+	for i := range ms {
+		if ref == "" || ms[i].matches(ref) {
+			found = append(found, &ms[i])
+		}
+	}
+	switch {
+	case len(found) == 0 && ref == "":
+		return nil, fmt.Errorf("no images:%w", os.ErrNotExist)
+	case len(found) == 0:
+		return nil, fmt.Errorf("no image %q:%w", ref, os.ErrNotExist)
+	case len(found) > 1:
+		var tags []string
+		for _, m := range found {
+			tags = append(tags, m.tags...)
+		}
+		return nil, fmt.Errorf("%d images, %q, match %q; select one by tag or digest:%w", len(found), tags, ref, os.ErrInvalid)
+	}
+	return found[0], nil
+}
+
+// imageName returns the name of a file in a layer as cpio names it:
+// relative, and clean, with the root ".".
+func imageName(n string) string {
+	if n = strings.TrimPrefix(path.Clean("/"+n), "/"); n == "" {
+		return "."
+	}
+	return n
+}
+
+// tarRecord returns the cpio.Record of a file in a layer, and false if
+// it is of a type a cpio has no record for.
+func tarRecord(name string, hdr *tar.Header, data io.ReaderAt) (cpio.Record, bool) {
+	info := cpio.Info{
+		Name:  name,
+		Mode:  uint64(hdr.Mode) & 0o7777,
+		UID:   uint64(hdr.Uid),
+		GID:   uint64(hdr.Gid),
+		NLink: 1,
+		MTime: uint64(hdr.ModTime.Unix()),
+	}
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeGNUSparse:
+		info.Mode |= cpio.S_IFREG
+		info.FileSize = uint64(hdr.Size)
+		return cpio.Record{ReaderAt: data, Info: info}, true
+	case tar.TypeDir:
+		info.Mode |= cpio.S_IFDIR
+	case tar.TypeSymlink:
+		info.Mode |= cpio.S_IFLNK
+		info.FileSize = uint64(len(hdr.Linkname))
+		return cpio.Record{ReaderAt: strings.NewReader(hdr.Linkname), Info: info}, true
+	case tar.TypeChar:
+		info.Mode |= cpio.S_IFCHR
+		info.Rmajor, info.Rminor = uint64(hdr.Devmajor), uint64(hdr.Devminor)
+	case tar.TypeBlock:
+		info.Mode |= cpio.S_IFBLK
+		info.Rmajor, info.Rminor = uint64(hdr.Devmajor), uint64(hdr.Devminor)
+	case tar.TypeFifo:
+		info.Mode |= cpio.S_IFIFO
+	default:
+		return cpio.Record{}, false
+	}
+	return cpio.Record{ReaderAt: bytes.NewReader(nil), Info: info}, true
+}
+
+// under returns true if n is beneath one of dirs, or is one of them,
+// if self is true.
+func under(n string, dirs map[string]bool, self bool) bool {
+	if self && dirs[n] {
+		return true
+	}
+	for n != "." {
+		n = path.Dir(n)
+		if dirs[n] {
+			return true
+		}
+	}
+	return false
+}
+
+// applyLayer applies the layer, a tar, possibly compressed, of size
+// bytes, to files.
+func applyLayer(files map[string]cpio.Record, layer io.ReaderAt, size int64) error {
+	r, size, err := decompress(layer, size)
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		name string
+		hdr  *tar.Header
+		data io.ReaderAt
+	}
+	var (
+		entries []entry
+		// removed are the names whiteouts remove, and opaque
+		// the directories whose contents they hide.
+		removed = map[string]bool{}
+		opaque  = map[string]bool{}
+	)
+	if err := walkTar(r, size, func(hdr *tar.Header, data io.ReaderAt) error {
+		n := imageName(hdr.Name)
+		dir, base := path.Split(n)
+		dir = imageName(dir)
+		switch {
+		case base == whiteoutOpaque:
+			opaque[dir] = true
+		case strings.HasPrefix(base, whiteoutPrefix):
+			removed[path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))] = true
+		default:
+			entries = append(entries, entry{name: n, hdr: hdr, data: data})
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	// Whiteouts apply to the layers below, so they are applied
+	// before the files of this layer are added.
+	if len(removed)+len(opaque) > 0 {
+		for n := range files {
+			if n != "." && (under(n, removed, true) || under(n, opaque, false)) {
+				delete(files, n)
+			}
+		}
+	}
+	for _, e := range entries {
+		if e.hdr.Typeflag == tar.TypeLink {
+			t, ok := files[imageName(e.hdr.Linkname)]
+			if !ok {
+				verbose("image:hard link %q to %q: not there", e.name, e.hdr.Linkname)
+				continue
+			}
+			t.Info.Name = e.name
+			files[e.name] = t
+			continue
+		}
+		rec, ok := tarRecord(e.name, e.hdr, e.data)
+		if !ok {
+			continue
+		}
+		// What a directory has in it goes with it, if it is
+		// replaced with something else.
+		if old, ok := files[e.name]; ok && old.Mode&cpio.S_IFMT == cpio.S_IFDIR && rec.Mode&cpio.S_IFMT != cpio.S_IFDIR {
+			gone := map[string]bool{e.name: true}
+			for n := range files {
+				if under(n, gone, false) {
+					delete(files, n)
+				}
+			}
+		}
+		files[e.name] = rec
+	}
+	return nil
+}
+
+// ImageRecords returns the files of the image ref, which may be empty
+// if there is only one, in the docker save tarball or OCI image layout
+// at p, with its layers applied, as cpio records, in the order a cpio
+// has them: each directory before what is in it, starting with ".".
+func ImageRecords(p, ref string) ([]cpio.Record, error) {
+	src, ms, err := openImage(p)
+	if err != nil {
+		return nil, err
+	}
+	m, err := selectImage(ms, ref)
+	if err != nil {
+		return nil, fmt.Errorf("image %q: %w", p, err)
+	}
+	files := map[string]cpio.Record{}
+	for _, l := range m.layers {
+		verbose("image:apply layer %q", l)
+		r, size, err := src.open(l)
+		if err != nil {
+			return nil, fmt.Errorf("image %q: layer %q: %w", p, l, err)
+		}
+		if err := applyLayer(files, r, size); err != nil {
+			return nil, fmt.Errorf("image %q: layer %q: %w", p, l, err)
+		}
+	}
+	// Layers need not have the directories their files are in.
+	for n := range files {
+		for d := path.Dir(n); ; d = path.Dir(d) {
+			if _, ok := files[d]; ok {
+				break
+			}
+			files[d] = cpio.Directory(d, 0o755)
+			if d == "." {
+				break
+			}
+		}
+	}
+	if _, ok := files["."]; !ok {
+		files["."] = cpio.Directory(".", 0o755)
+	}
+	recs := make([]cpio.Record, 0, len(files))
+	for _, r := range files {
+		recs = append(recs, r)
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		if recs[i].Name == "." || recs[j].Name == "." {
+			return recs[i].Name == "."
+		}
+		return recs[i].Name < recs[j].Name
+	})
+	return recs, nil
+}
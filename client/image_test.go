@@ -0,0 +1,384 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/hugelgupf/p9/p9"
+	"github.com/klauspost/compress/zstd"
+)
+
+// tarFile is a file in a test tar: a file, unless it ends in /, or
+// has a link.
+type tarFile struct {
+	name, data string
+	symlink    string
+	hardlink   string
+}
+
+// mkTar returns a tar of files.
+func mkTar(t *testing.T, files ...tarFile) []byte {
+	var b bytes.Buffer
+	w := tar.NewWriter(&b)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.data)), Typeflag: tar.TypeReg}
+		switch {
+		case strings.HasSuffix(f.name, "/"):
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0o755
+		case f.symlink != "":
+			hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, f.symlink
+		case f.hardlink != "":
+			hdr.Typeflag, hdr.Linkname = tar.TypeLink, f.hardlink
+		}
+		if err := w.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := w.Write([]byte(f.data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func gzipped(t *testing.T, b []byte) []byte {
+	var z bytes.Buffer
+	w := gzip.NewWriter(&z)
+	if _, err := w.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return z.Bytes()
+}
+
+func zstded(t *testing.T, b []byte) []byte {
+	w, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.EncodeAll(b, nil)
+}
+
+// testLayers are the layers of the test image, the second gzipped and
+// the third compressed with zstd.
+func testLayers(t *testing.T) [][]byte {
+	return [][]byte{
+		mkTar(t,
+			tarFile{name: "bin/"},
+			tarFile{name: "bin/sh", data: "sh1"},
+			tarFile{name: "bin/ls", symlink: "sh"},
+			tarFile{name: "bin/sh2", hardlink: "bin/sh"},
+			tarFile{name: "etc/"},
+			tarFile{name: "etc/passwd", data: "root"},
+			tarFile{name: "etc/old", data: "old"},
+			tarFile{name: "opt/"},
+			tarFile{name: "opt/a", data: "a"},
+			tarFile{name: "opt/b/"},
+			tarFile{name: "opt/b/c", data: "c"},
+		),
+		gzipped(t, mkTar(t,
+			tarFile{name: "./"},
+			tarFile{name: "./etc/.wh.old"},
+			tarFile{name: "./opt/.wh..wh..opq"},
+			tarFile{name: "./opt/d", data: "d"},
+			tarFile{name: "./bin/sh", data: "sh2"},
+			tarFile{name: "./usr/lib/x", data: "x"},
+		)),
+		zstded(t, mkTar(t,
+			tarFile{name: "etc/passwd/"},
+			tarFile{name: "var/log/y", data: "y"},
+		)),
+	}
+}
+
+// testImage is what the test image has in it.
+var testImage = map[string]string{
+	".":          "",
+	"bin":        "",
+	"bin/ls":     "sh",
+	"bin/sh":     "sh2",
+	"bin/sh2":    "sh1",
+	"etc":        "",
+	"etc/passwd": "",
+	"opt":        "",
+	"opt/d":      "d",
+	"usr":        "",
+	"usr/lib":    "",
+	"usr/lib/x":  "x",
+	"var":        "",
+	"var/log":    "",
+	"var/log/y":  "y",
+}
+
+// writeFiles writes files, by name, in d.
+func writeFiles(t *testing.T, d string, files map[string][]byte) {
+	for n, b := range files {
+		p := filepath.Join(d, n)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// tarDir returns a tar of the directory d.
+func tarDir(t *testing.T, d string) []byte {
+	var files []tarFile
+	if err := filepath.Walk(d, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		n, _ := filepath.Rel(d, p)
+		if fi.Mode()&os.ModeSymlink != 0 {
+			l, err := os.Readlink(p)
+			files = append(files, tarFile{name: n, symlink: l})
+			return err
+		}
+		b, err := os.ReadFile(p)
+		files = append(files, tarFile{name: n, data: string(b)})
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return mkTar(t, files...)
+}
+
+// dockerSave returns a docker save tarball with the test image, tagged
+// example.com/test:1.0, and another, other:2, of its first layer,
+// which docker save has as a symlink.
+func dockerSave(t *testing.T) []byte {
+	d := t.TempDir()
+	files := map[string][]byte{"cfg.json": []byte("{}")}
+	var layers []string
+	for i, l := range testLayers(t) {
+		n := fmt.Sprintf("l%d/layer.tar", i)
+		files[n] = l
+		layers = append(layers, n)
+	}
+	m, err := json.Marshal([]map[string]interface{}{
+		{"Config": "0123abcd.json", "RepoTags": []string{"example.com/test:1.0"}, "Layers": layers},
+		{"Config": "4567ef01.json", "RepoTags": []string{"other:2"}, "Layers": []string{"l3/layer.tar"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["manifest.json"] = m
+	writeFiles(t, d, files)
+	if err := os.Mkdir(filepath.Join(d, "l3"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../l0/layer.tar", filepath.Join(d, "l3", "layer.tar")); err != nil {
+		t.Fatal(err)
+	}
+	return tarDir(t, d)
+}
+
+// ociLayout writes, in d, an OCI image layout with the test image, in
+// an index, named 1.0, of it, for linux on this architecture, and
+// another, and returns the digest of the index.
+func ociLayout(t *testing.T, d string) string {
+	files := map[string][]byte{"oci-layout": []byte(`{"imageLayoutVersion":"1.0.0"}`)}
+	blob := func(b []byte) string {
+		dg := fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+		files["blobs/sha256/"+strings.TrimPrefix(dg, "sha256:")] = b
+		return dg
+	}
+	desc := func(mt string, b []byte) map[string]interface{} {
+		return map[string]interface{}{"mediaType": mt, "digest": blob(b), "size": len(b)}
+	}
+	mustJSON := func(v interface{}) []byte {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	var layers []interface{}
+	for _, l := range testLayers(t) {
+		layers = append(layers, desc("application/vnd.oci.image.layer.v1.tar", l))
+	}
+	manifest := mustJSON(map[string]interface{}{
+		"schemaVersion": 2,
+		"config":        desc("application/vnd.oci.image.config.v1+json", []byte("{}")),
+		"layers":        layers,
+	})
+	other := mustJSON(map[string]interface{}{
+		"schemaVersion": 2,
+		"config":        desc("application/vnd.oci.image.config.v1+json", []byte("{}")),
+		"layers":        layers[:1],
+	})
+	md := desc("application/vnd.oci.image.manifest.v1+json", manifest)
+	md["platform"] = map[string]string{"os": "linux", "architecture": runtime.GOARCH}
+	od := desc("application/vnd.oci.image.manifest.v1+json", other)
+	od["platform"] = map[string]string{"os": "linux", "architecture": "not" + runtime.GOARCH}
+	idx := desc("application/vnd.oci.image.index.v1+json", mustJSON(map[string]interface{}{
+		"schemaVersion": 2,
+		"manifests":     []interface{}{od, md},
+	}))
+	idx["annotations"] = map[string]string{"org.opencontainers.image.ref.name": "1.0"}
+	files["index.json"] = mustJSON(map[string]interface{}{
+		"schemaVersion": 2,
+		"manifests":     []interface{}{idx, desc("application/vnd.oci.image.manifest.v1+json", other)},
+	})
+	writeFiles(t, d, files)
+	return idx["digest"].(string)
+}
+
+// checkImage checks that the 9P server of an image has what the test
+// image has in it.
+func checkImage(t *testing.T, s *CPIO9P) {
+	var names []string
+	for i := uint64(0); i < s.recs.count(); i++ {
+		r, err := s.recs.record(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, r.Name)
+		want, ok := testImage[r.Name]
+		if !ok {
+			t.Errorf("%q is in the image, and should not be", r.Name)
+			continue
+		}
+		if r.Mode&0o170000 == 0o040000 {
+			continue
+		}
+		b := make([]byte, r.FileSize)
+		if _, err := r.ReadAt(b, 0); err != nil || string(b) != want {
+			t.Errorf("%q: (%q, %v), want %q", r.Name, b, err, want)
+		}
+	}
+	if len(names) != len(testImage) || names[0] != "." {
+		t.Errorf("image has %q, want %d names, starting with .", names, len(testImage))
+	}
+	root, err := s.Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := root.Walk([]string{"etc", "old"}); err == nil {
+		t.Errorf("walk to etc/old, which is whited out: nil, want an error")
+	}
+	_, f, err := root.Walk([]string{"usr", "lib", "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := f.Open(p9.ReadOnly); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 8)
+	if n, err := f.ReadAt(b, 0); (err != nil && err != io.EOF) || string(b[:n]) != "x" {
+		t.Errorf("read of usr/lib/x: (%q, %v), want x", b[:n], err)
+	}
+	if l, err := readdir(root, "opt"); err != nil || fmt.Sprint(l) != "[. d]" {
+		t.Errorf("readdir(opt), which is opaque: (%q, %v), want [. d]", l, err)
+	}
+}
+
+func TestImageDockerSave(t *testing.T) {
+	d := t.TempDir()
+	p := filepath.Join(d, "test.tar")
+	if err := os.WriteFile(p, dockerSave(t), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewImage9P(p, ""); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("NewImage9P with no tag, of two images: %v, want %v", err, os.ErrInvalid)
+	}
+	if _, err := NewImage9P(p, "3.0"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("NewImage9P with tag 3.0: %v, want %v", err, os.ErrNotExist)
+	}
+	for _, ref := range []string{"example.com/test:1.0", "test:1.0", "1.0", "sha256:0123abcd"} {
+		s, err := NewImage9P(ParseImage(p + ":" + ref))
+		if err != nil {
+			t.Errorf("NewImage9P(%q): %v", ref, err)
+			continue
+		}
+		checkImage(t, s)
+	}
+	// The other image is the first layer, a symlink to it.
+	s, err := NewImage9P(p, "other:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.recs.lookup("etc/old"); !ok || s.recs.count() != 12 {
+		t.Errorf("image other:2 has %d names, want the 12 of the first layer", s.recs.count())
+	}
+}
+
+func TestImageOCI(t *testing.T) {
+	d := t.TempDir()
+	digest := ociLayout(t, d)
+	for _, ref := range []string{"1.0", digest, "@" + digest} {
+		s, err := NewImage9P(d, ref)
+		if err != nil {
+			t.Errorf("NewImage9P(%q): %v", ref, err)
+			continue
+		}
+		checkImage(t, s)
+	}
+	// An OCI layout in a tar is the same.
+	p := filepath.Join(t.TempDir(), "oci.tar")
+	if err := os.WriteFile(p, gzipped(t, tarDir(t, d)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewImage9P(ParseImage(p + ":1.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkImage(t, s)
+
+	fs, err := NewfsImage(p, "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := fs.lookup("bin/ls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, err := l.(*file).Readlink(); err != nil || l != "sh" {
+		t.Errorf("Readlink(bin/ls): (%q, %v), want sh", l, err)
+	}
+}
+
+func TestParseImage(t *testing.T) {
+	d := t.TempDir()
+	p := filepath.Join(d, "img.tar")
+	if err := os.WriteFile(p, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		in, path, ref string
+	}{
+		{in: p, path: p},
+		{in: p + ":1.0", path: p, ref: "1.0"},
+		{in: p + ":alpine:3.19", path: p, ref: "alpine:3.19"},
+		{in: p + ":sha256:abcd", path: p, ref: "sha256:abcd"},
+		{in: d + "/nothere:1.0", path: d + "/nothere:1.0"},
+	} {
+		path, ref := ParseImage(tt.in)
+		if path != tt.path || ref != tt.ref {
+			t.Errorf("ParseImage(%q): (%q, %q), want (%q, %q)", tt.in, path, ref, tt.path, tt.ref)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Policy is what of the names a client exports the remote may see, and
+// change. Names are as the remote sees them, beneath /tmp/cpu, which
+// are the client's names beneath its root. The policy is kept by the
+// client's file servers, 9P and NFS, not by mount flags on the remote,
+// which a compromised remote could ignore.
+type Policy struct {
+	// ReadOnly and ReadWrite are trees of names which can not, or
+	// can, be changed. The longest of them that a name is in
+	// decides; names in none of them can be changed.
+	ReadOnly  []string
+	ReadWrite []string
+	// Hide is globs, as in path.Match, of names which are not
+	// there, nor is anything beneath them. A glob with no / is
+	// matched against the last element of a name, e.g. .ssh.
+	Hide []string
+}
+
+// empty returns true if p allows everything.
+func (p *Policy) empty() bool {
+	return p == nil || (len(p.ReadOnly) == 0 && len(p.Hide) == 0)
+}
+
+// cleanName returns name, cleaned, as an absolute name.
+func cleanName(name string) string {
+	return path.Clean("/" + name)
+}
+
+// within returns true if name is tree, or beneath it.
+func within(name, tree string) bool {
+	return tree == "/" || name == tree || strings.HasPrefix(name, tree+"/")
+}
+
+// Hidden returns true if name, or any directory it is in, matches one
+// of the Hide globs.
+func (p *Policy) Hidden(name string) bool {
+	if p == nil {
+		return false
+	}
+	for n := cleanName(name); n != "/"; n = path.Dir(n) {
+		for _, g := range p.Hide {
+			s := n
+			if !strings.Contains(g, "/") {
+				s = path.Base(n)
+			}
+			if ok, _ := path.Match(g, s); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Writable returns true if name can be changed.
+func (p *Policy) Writable(name string) bool {
+	if p == nil {
+		return true
+	}
+	n := cleanName(name)
+	best, w := -1, true
+	for _, t := range p.ReadWrite {
+		if within(n, t) && len(t) > best {
+			best, w = len(t), true
+		}
+	}
+	// Where a tree is named both ways, read-only wins.
+	for _, t := range p.ReadOnly {
+		if within(n, t) && len(t) >= best {
+			best, w = len(t), false
+		}
+	}
+	return w
+}
+
+// parseHide parses a :-separated list of globs for Policy.Hide. A ~ at
+// the start of one is the client's home directory, as the remote sees
+// it, beneath root, and so it must be in root.
+func parseHide(s, root string) ([]string, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	var hide []string
+	for i, g := range strings.Split(s, ":") {
+		if len(g) == 0 {
+			return nil, fmt.Errorf("hide: element %d is zero length:%w", i, strconv.ErrSyntax)
+		}
+		if g == "~" || strings.HasPrefix(g, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("hide: element %d(%q):%w", i, g, err)
+			}
+			r, h := cleanName(root), cleanName(home)
+			if !within(h, r) {
+				return nil, fmt.Errorf("hide: element %d(%q): home %q is not in root %q:%w", i, g, h, r, os.ErrInvalid)
+			}
+			g = cleanName(strings.TrimPrefix(h, r)) + g[1:]
+		}
+		if strings.Contains(g, "/") {
+			g = cleanName(g)
+		}
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("hide: element %d(%q):%v:%w", i, g, err, strconv.ErrSyntax)
+		}
+		hide = append(hide, g)
+	}
+	return hide, nil
+}
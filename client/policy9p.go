@@ -0,0 +1,259 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"path"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+// policy9P is a p9.Attacher which serves another, a CPU9P, Union9P or
+// CPIO9P, as a Policy allows: hidden names are not there, and names
+// that can not be changed are read-only.
+type policy9P struct {
+	a p9.Attacher
+	p *Policy
+}
+
+// policy9PFile is a p9.File of a policy9P. Its name, from the root,
+// is what the policy is checked against.
+type policy9PFile struct {
+	p9.File
+	p    *Policy
+	name string
+}
+
+var (
+	_ p9.Attacher = &policy9P{}
+	_ p9.File     = &policy9PFile{}
+)
+
+// Attach implements p9.Attacher.Attach.
+func (a *policy9P) Attach() (p9.File, error) {
+	f, err := a.a.Attach()
+	if err != nil {
+		return nil, err
+	}
+	return &policy9PFile{File: f, p: a.p, name: "/"}, nil
+}
+
+// file returns o as a policy9PFile, which it must be, as files of a
+// policy9P are only ever given others of it.
+func (f *policy9PFile) file(o p9.File) (*policy9PFile, error) {
+	pf, ok := o.(*policy9PFile)
+	if !ok {
+		return nil, linux.EXDEV
+	}
+	return pf, nil
+}
+
+// change returns an error if name, in f, can not be made or changed.
+func (f *policy9PFile) change(name string) error {
+	n := path.Join(f.name, name)
+	if f.p.Hidden(n) {
+		return linux.EACCES
+	}
+	if !f.p.Writable(n) {
+		return linux.EROFS
+	}
+	return nil
+}
+
+// Walk implements p9.File.Walk. It walks one name at a time, as what
+// a name is checked against must be where the walk goes: a walk
+// through something not a directory, e.g. a symbolic link the server
+// follows, could go anywhere, and is refused.
+func (f *policy9PFile) Walk(names []string) ([]p9.QID, p9.File, error) {
+	if len(names) == 0 {
+		qids, nf, err := f.File.Walk(nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		return qids, &policy9PFile{File: nf, p: f.p, name: f.name}, nil
+	}
+	var qids []p9.QID
+	cur, name := f.File, f.name
+	for i, n := range names {
+		name = path.Join(name, n)
+		if f.p.Hidden(name) {
+			if i > 0 {
+				cur.Close()
+			}
+			return nil, nil, linux.ENOENT
+		}
+		q, nf, err := cur.Walk([]string{n})
+		if i > 0 {
+			cur.Close()
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		qids = append(qids, q...)
+		if i < len(names)-1 && q[len(q)-1].Type&p9.TypeDir == 0 {
+			nf.Close()
+			return nil, nil, linux.ENOTDIR
+		}
+		cur = nf
+	}
+	return qids, &policy9PFile{File: cur, p: f.p, name: name}, nil
+}
+
+// WalkGetAttr implements p9.File.WalkGetAttr, as p9.DefaultWalkGetAttr,
+// so that the server uses Walk and GetAttr.
+func (f *policy9PFile) WalkGetAttr([]string) ([]p9.QID, p9.File, p9.AttrMask, p9.Attr, error) {
+	return nil, nil, p9.AttrMask{}, p9.Attr{}, linux.ENOSYS
+}
+
+// Readdir implements p9.File.Readdir, leaving out hidden names.
+func (f *policy9PFile) Readdir(offset uint64, count uint32) (p9.Dirents, error) {
+	d, err := f.File.Readdir(offset, count)
+	if err != nil {
+		return nil, err
+	}
+	var dirents p9.Dirents
+	for _, e := range d {
+		if !f.p.Hidden(path.Join(f.name, e.Name)) {
+			dirents = append(dirents, e)
+		}
+	}
+	return dirents, nil
+}
+
+// Open implements p9.File.Open. Opening to truncate, append or
+// create changes the file, even if it is opened read-only.
+func (f *policy9PFile) Open(mode p9.OpenFlags) (p9.QID, uint32, error) {
+	if mode.Mode() != p9.ReadOnly || mode&(lopenTrunc|lopenAppend|lopenCreate) != 0 {
+		if err := f.change(""); err != nil {
+			return p9.QID{}, 0, err
+		}
+	}
+	return f.File.Open(mode)
+}
+
+// SetAttr implements p9.File.SetAttr.
+func (f *policy9PFile) SetAttr(valid p9.SetAttrMask, attr p9.SetAttr) error {
+	if err := f.change(""); err != nil {
+		return err
+	}
+	return f.File.SetAttr(valid, attr)
+}
+
+// SetXattr implements p9.File.SetXattr.
+func (f *policy9PFile) SetXattr(attr string, data []byte, flags p9.XattrFlags) error {
+	if err := f.change(""); err != nil {
+		return err
+	}
+	return f.File.SetXattr(attr, data, flags)
+}
+
+// RemoveXattr implements p9.File.RemoveXattr.
+func (f *policy9PFile) RemoveXattr(attr string) error {
+	if err := f.change(""); err != nil {
+		return err
+	}
+	return f.File.RemoveXattr(attr)
+}
+
+// Create implements p9.File.Create.
+func (f *policy9PFile) Create(name string, flags p9.OpenFlags, permissions p9.FileMode, uid p9.UID, gid p9.GID) (p9.File, p9.QID, uint32, error) {
+	if err := f.change(name); err != nil {
+		return nil, p9.QID{}, 0, err
+	}
+	nf, qid, iounit, err := f.File.Create(name, flags, permissions, uid, gid)
+	if err != nil {
+		return nil, p9.QID{}, 0, err
+	}
+	return &policy9PFile{File: nf, p: f.p, name: path.Join(f.name, name)}, qid, iounit, nil
+}
+
+// Mkdir implements p9.File.Mkdir.
+func (f *policy9PFile) Mkdir(name string, permissions p9.FileMode, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	if err := f.change(name); err != nil {
+		return p9.QID{}, err
+	}
+	return f.File.Mkdir(name, permissions, uid, gid)
+}
+
+// Symlink implements p9.File.Symlink.
+func (f *policy9PFile) Symlink(oldName string, newName string, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	if err := f.change(newName); err != nil {
+		return p9.QID{}, err
+	}
+	return f.File.Symlink(oldName, newName, uid, gid)
+}
+
+// Mknod implements p9.File.Mknod.
+func (f *policy9PFile) Mknod(name string, mode p9.FileMode, major uint32, minor uint32, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	if err := f.change(name); err != nil {
+		return p9.QID{}, err
+	}
+	return f.File.Mknod(name, mode, major, minor, uid, gid)
+}
+
+// Link implements p9.File.Link. A link to a file that can not be
+// changed would be a way to change it, so both must be changeable.
+func (f *policy9PFile) Link(target p9.File, newName string) error {
+	t, err := f.file(target)
+	if err != nil {
+		return err
+	}
+	if err := t.change(""); err != nil {
+		return err
+	}
+	if err := f.change(newName); err != nil {
+		return err
+	}
+	return f.File.Link(t.File, newName)
+}
+
+// Rename implements p9.File.Rename.
+func (f *policy9PFile) Rename(newDir p9.File, newName string) error {
+	d, err := f.file(newDir)
+	if err != nil {
+		return err
+	}
+	if err := f.change(""); err != nil {
+		return err
+	}
+	if err := d.change(newName); err != nil {
+		return err
+	}
+	return f.File.Rename(d.File, newName)
+}
+
+// RenameAt implements p9.File.RenameAt.
+func (f *policy9PFile) RenameAt(oldName string, newDir p9.File, newName string) error {
+	d, err := f.file(newDir)
+	if err != nil {
+		return err
+	}
+	if err := f.change(oldName); err != nil {
+		return err
+	}
+	if err := d.change(newName); err != nil {
+		return err
+	}
+	return f.File.RenameAt(oldName, d.File, newName)
+}
+
+// Renamed implements p9.File.Renamed.
+func (f *policy9PFile) Renamed(newDir p9.File, newName string) {
+	d, err := f.file(newDir)
+	if err != nil {
+		return
+	}
+	f.name = path.Join(d.name, newName)
+	f.File.Renamed(d.File, newName)
+}
+
+// UnlinkAt implements p9.File.UnlinkAt.
+func (f *policy9PFile) UnlinkAt(name string, flags uint32) error {
+	if err := f.change(name); err != nil {
+		return err
+	}
+	return f.File.UnlinkAt(name, flags)
+}
@@ -0,0 +1,123 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package client
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+func TestPolicy9P(t *testing.T) {
+	d := t.TempDir()
+	for _, n := range []string{"ro/rw", "home/.ssh", "home/sub"} {
+		if err := os.MkdirAll(filepath.Join(d, n), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range []string{"ro/f", "home/f", "home/.ssh/id"} {
+		if err := os.WriteFile(filepath.Join(d, n), []byte(n), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(".ssh", filepath.Join(d, "home", "keys")); err != nil {
+		t.Fatal(err)
+	}
+	a := &policy9P{a: NewCPU9P(d), p: &Policy{ReadOnly: []string{"/ro"}, ReadWrite: []string{"/ro/rw"}, Hide: []string{"/home/.ssh"}}}
+	r, err := a.Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	walk := func(names ...string) p9.File {
+		t.Helper()
+		_, f, err := r.Walk(names)
+		if err != nil {
+			t.Fatalf("Walk(%q): %v, want nil", names, err)
+		}
+		return f
+	}
+
+	// Hidden names can not be walked to, even through a link, nor
+	// are they read.
+	for _, tt := range []struct {
+		names []string
+		err   error
+	}{
+		{names: []string{"home", ".ssh"}, err: linux.ENOENT},
+		{names: []string{"home", ".ssh", "id"}, err: linux.ENOENT},
+		{names: []string{"home", "keys", "id"}, err: linux.ENOTDIR},
+		{names: []string{"home", "f", "x"}, err: linux.ENOTDIR},
+	} {
+		if _, _, err := r.Walk(tt.names); !errors.Is(err, tt.err) {
+			t.Errorf("Walk(%q): %v, want %v", tt.names, err, tt.err)
+		}
+	}
+	home := walk("home")
+	if _, _, err := home.Open(p9.ReadOnly); err != nil {
+		t.Fatal(err)
+	}
+	dirents, err := home.Readdir(0, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range dirents {
+		names = append(names, e.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"f", "keys", "sub"}) {
+		t.Errorf("Readdir(home): %q, want [f keys sub]", names)
+	}
+
+	// Nothing read-only can be changed.
+	ro, f := walk("ro"), walk("ro", "f")
+	for _, tt := range []struct {
+		name string
+		op   func() error
+		err  error
+	}{
+		{name: "Open for writing", op: func() error { _, _, err := f.Open(p9.WriteOnly); return err }, err: linux.EROFS},
+		{name: "Open to truncate", op: func() error { _, _, err := walk("ro", "f").Open(p9.ReadOnly | lopenTrunc); return err }, err: linux.EROFS},
+		{name: "Open to append", op: func() error { _, _, err := walk("ro", "f").Open(p9.ReadOnly | lopenAppend); return err }, err: linux.EROFS},
+		{name: "SetAttr", op: func() error {
+			return f.SetAttr(p9.SetAttrMask{Size: true}, p9.SetAttr{})
+		}, err: linux.EROFS},
+		{name: "SetXattr", op: func() error { return f.SetXattr("user.cpu", []byte("x"), 0) }, err: linux.EROFS},
+		{name: "Create", op: func() error { _, _, _, err := ro.Create("g", p9.WriteOnly, 0o644, 0, 0); return err }, err: linux.EROFS},
+		{name: "Mkdir", op: func() error { _, err := ro.Mkdir("d", 0o755, 0, 0); return err }, err: linux.EROFS},
+		{name: "Symlink", op: func() error { _, err := ro.Symlink("f", "l", 0, 0); return err }, err: linux.EROFS},
+		{name: "UnlinkAt", op: func() error { return ro.UnlinkAt("f", 0) }, err: linux.EROFS},
+		{name: "RenameAt out", op: func() error { return ro.RenameAt("f", walk("home"), "g") }, err: linux.EROFS},
+		{name: "RenameAt in", op: func() error { return walk("home").RenameAt("f", ro, "g") }, err: linux.EROFS},
+		{name: "Link to", op: func() error { return walk("home").Link(walk("ro", "f"), "l") }, err: linux.EROFS},
+		{name: "Create hidden", op: func() error {
+			_, _, _, err := walk("home").Create(".ssh", p9.WriteOnly, 0o644, 0, 0)
+			return err
+		}, err: linux.EACCES},
+		{name: "Open for reading", op: func() error { _, _, err := walk("ro", "f").Open(p9.ReadOnly); return err }},
+		{name: "Create read-write in read-only", op: func() error {
+			_, _, _, err := walk("ro", "rw").Create("g", p9.WriteOnly, 0o644, 0, 0)
+			return err
+		}},
+		{name: "RenameAt read-write", op: func() error { return walk("home").RenameAt("f", walk("home", "sub"), "g") }},
+	} {
+		if err := tt.op(); !errors.Is(err, tt.err) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.err)
+		}
+	}
+	if b, err := os.ReadFile(filepath.Join(d, "ro", "f")); err != nil || string(b) != "ro/f" {
+		t.Errorf("ro/f: %q, %v, want %q, nil", b, err, "ro/f")
+	}
+	if _, err := os.Stat(filepath.Join(d, "home", "sub", "g")); err != nil {
+		t.Errorf("home/sub/g, renamed from home/f: %v, want nil", err)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	_, p, err := parseBinds("/lib:/usr,ro:/usr/local,rw:/etc=/x/etc,ro:/home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.ReadOnly, []string{"/usr", "/x/etc"}) || !reflect.DeepEqual(p.ReadWrite, []string{"/usr/local"}) {
+		t.Fatalf("parseBinds: %+v, want ReadOnly [/usr /x/etc], ReadWrite [/usr/local]", p)
+	}
+	t.Setenv("HOME", "/home/rob")
+	if p.Hide, err = parseHide("~/.ssh:/home/*/.gnupg:*.pem", "/"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.Hide, []string{"/home/rob/.ssh", "/home/*/.gnupg", "*.pem"}) {
+		t.Fatalf("parseHide: %q, want [/home/rob/.ssh /home/*/.gnupg *.pem]", p.Hide)
+	}
+	for _, tt := range []struct {
+		name     string
+		hidden   bool
+		writable bool
+	}{
+		{name: "/", writable: true},
+		{name: "/lib/libc.so", writable: true},
+		{name: "/usr"},
+		{name: "usr/bin/ls"},
+		{name: "/usrx", writable: true},
+		{name: "/usr/local/bin/cpu", writable: true},
+		{name: "/usr/local/../bin", writable: false},
+		{name: "/x/etc/passwd"},
+		{name: "/etc/passwd", writable: true},
+		{name: "/home/rob/.ssh", hidden: true, writable: true},
+		{name: "/home/rob/.ssh/id_ed25519", hidden: true, writable: true},
+		{name: "/home/rob/.sshx", writable: true},
+		{name: "/home/ken/.gnupg/x", hidden: true, writable: true},
+		{name: "/home/ken/x/.gnupg", writable: true},
+		{name: "/usr/share/key.pem", hidden: true},
+	} {
+		if got := p.Hidden(tt.name); got != tt.hidden {
+			t.Errorf("Hidden(%q): %v, want %v", tt.name, got, tt.hidden)
+		}
+		if got := p.Writable(tt.name); got != tt.writable {
+			t.Errorf("Writable(%q): %v, want %v", tt.name, got, tt.writable)
+		}
+	}
+
+	for _, s := range []string{"a::b", "[", "/a/["} {
+		if _, err := parseHide(s, "/"); !errors.Is(err, strconv.ErrSyntax) {
+			t.Errorf("parseHide(%q): %v, want %v", s, err, strconv.ErrSyntax)
+		}
+	}
+
+	// Names are beneath the root, and so is ~.
+	for _, tt := range []struct {
+		root string
+		hide []string
+		err  error
+	}{
+		{root: "", hide: []string{"/home/rob/.ssh"}},
+		{root: "/home", hide: []string{"/rob/.ssh"}},
+		{root: "/home/rob/", hide: []string{"/.ssh"}},
+		{root: "/home/ro", err: os.ErrInvalid},
+		{root: "/srv", err: os.ErrInvalid},
+	} {
+		h, err := parseHide("~/.ssh", tt.root)
+		if !errors.Is(err, tt.err) || !reflect.DeepEqual(h, tt.hide) {
+			t.Errorf("parseHide(~/.ssh, %q): %q, %v, want %q, %v", tt.root, h, err, tt.hide, tt.err)
+		}
+	}
+	p.Hide, _ = parseHide("~/.ssh", "/home/rob")
+	if !p.Hidden("/.ssh/id_ed25519") || p.Hidden("/home/rob/.ssh") {
+		t.Errorf("Hidden with root $HOME: /.ssh/id_ed25519 %v, /home/rob/.ssh %v, want true, false", p.Hidden("/.ssh/id_ed25519"), p.Hidden("/home/rob/.ssh"))
+	}
+	if _, p, err = parseBinds("/lib:/home"); err != nil || !p.empty() {
+		t.Errorf("parseBinds with no ,ro: %+v, %v, want an empty Policy", p, err)
+	}
+}
+
+func TestPolicyFS(t *testing.T) {
+	d := t.TempDir()
+	for _, n := range []string{"ro/sub", "rw/.ssh", "rw/sub"} {
+		if err := os.MkdirAll(filepath.Join(d, n), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range []string{"ro/f", "rw/f", "rw/.ssh/id"} {
+		if err := os.WriteFile(filepath.Join(d, n), []byte(n), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("../ro", filepath.Join(d, "rw", "up")); err != nil {
+		t.Fatal(err)
+	}
+	fs := policyFS{Filesystem: COS{NewOSFS(d)}, p: &Policy{ReadOnly: []string{"/ro"}, Hide: []string{".ssh"}}}
+
+	if _, err := fs.Stat("ro/f"); err != nil {
+		t.Errorf("Stat(ro/f): %v, want nil", err)
+	}
+	for _, tt := range []struct {
+		name string
+		op   func() error
+		err  error
+	}{
+		{name: "Stat hidden", op: func() error { _, err := fs.Stat("rw/.ssh/id"); return err }, err: os.ErrNotExist},
+		{name: "Open beneath a link", op: func() error { _, err := fs.Open("rw/up/f"); return err }, err: os.ErrNotExist},
+		{name: "Create read-only", op: func() error { _, err := fs.Create("ro/g"); return err }, err: os.ErrPermission},
+		{name: "OpenFile read-only for writing", op: func() error { _, err := fs.OpenFile("ro/f", os.O_WRONLY, 0); return err }, err: os.ErrPermission},
+		{name: "Remove read-only", op: func() error { return fs.Remove("ro/f") }, err: os.ErrPermission},
+		{name: "Rename into read-only", op: func() error { return fs.Rename("rw/f", "ro/g") }, err: os.ErrPermission},
+		{name: "Chmod read-only", op: func() error { return fs.Chmod("ro/f", 0o600) }, err: os.ErrPermission},
+		{name: "MkdirAll read-only", op: func() error { return fs.MkdirAll("ro/sub/x", 0o755) }, err: os.ErrPermission},
+		{name: "Create read-write", op: func() error {
+			f, err := fs.Create("rw/g")
+			if err == nil {
+				f.Close()
+			}
+			return err
+		}},
+		{name: "Chmod read-write", op: func() error { return fs.Chmod("rw/f", 0o600) }},
+	} {
+		if err := tt.op(); !errors.Is(err, tt.err) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.err)
+		}
+	}
+	fis, err := fs.ReadDir("rw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"f", "g", "sub", "up"}) {
+		t.Errorf("ReadDir(rw): %q, want [f g sub up]", names)
+	}
+	if b, err := os.ReadFile(filepath.Join(d, "ro", "f")); err != nil || string(b) != "ro/f" {
+		t.Errorf("ro/f: %q, %v, want %q, nil", b, err, "ro/f")
+	}
+}
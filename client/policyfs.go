@@ -0,0 +1,217 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"os"
+	"path"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// policyFS is a billy.Filesystem, for the NFS server, which serves
+// another, with billy.Change, as a Policy allows. Hidden names are
+// not there, and changes to names that can not be changed are refused
+// with os.ErrPermission, which NFS reports as EACCES.
+type policyFS struct {
+	billy.Filesystem
+	p *Policy
+}
+
+var (
+	_ billy.Filesystem = policyFS{}
+	_ billy.Change     = policyFS{}
+	_ billy.Capable    = policyFS{}
+)
+
+// check returns an error if op, which changes name if write, may not be
+// done: if name is hidden, or can not be changed, or is beneath a
+// symbolic link, which could take it anywhere.
+func (fs policyFS) check(op, name string, write bool) error {
+	n := cleanName(name)
+	if fs.p.Hidden(n) {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	if write && !fs.p.Writable(n) {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	}
+	for d := path.Dir(n); d != "/"; d = path.Dir(d) {
+		if fi, err := fs.Filesystem.Lstat(d[1:]); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+		}
+	}
+	return nil
+}
+
+// Capabilities implements billy.Capable.
+func (fs policyFS) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.Filesystem)
+}
+
+// Create implements billy.Basic.Create.
+func (fs policyFS) Create(filename string) (billy.File, error) {
+	if err := fs.check("create", filename, true); err != nil {
+		return nil, err
+	}
+	return fs.Filesystem.Create(filename)
+}
+
+// Open implements billy.Basic.Open.
+func (fs policyFS) Open(filename string) (billy.File, error) {
+	if err := fs.check("open", filename, false); err != nil {
+		return nil, err
+	}
+	return fs.Filesystem.Open(filename)
+}
+
+// OpenFile implements billy.Basic.OpenFile.
+func (fs policyFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	write := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+	if err := fs.check("open", filename, write); err != nil {
+		return nil, err
+	}
+	return fs.Filesystem.OpenFile(filename, flag, perm)
+}
+
+// Stat implements billy.Basic.Stat.
+func (fs policyFS) Stat(filename string) (os.FileInfo, error) {
+	if err := fs.check("stat", filename, false); err != nil {
+		return nil, err
+	}
+	return fs.Filesystem.Stat(filename)
+}
+
+// Rename implements billy.Basic.Rename.
+func (fs policyFS) Rename(oldpath, newpath string) error {
+	if err := fs.check("rename", oldpath, true); err != nil {
+		return err
+	}
+	if err := fs.check("rename", newpath, true); err != nil {
+		return err
+	}
+	return fs.Filesystem.Rename(oldpath, newpath)
+}
+
+// Remove implements billy.Basic.Remove.
+func (fs policyFS) Remove(filename string) error {
+	if err := fs.check("remove", filename, true); err != nil {
+		return err
+	}
+	return fs.Filesystem.Remove(filename)
+}
+
+// TempFile implements billy.TempFile.TempFile.
+func (fs policyFS) TempFile(dir, prefix string) (billy.File, error) {
+	if err := fs.check("tempfile", path.Join(dir, prefix), true); err != nil {
+		return nil, err
+	}
+	return fs.Filesystem.TempFile(dir, prefix)
+}
+
+// ReadDir implements billy.Dir.ReadDir, leaving out hidden names.
+func (fs policyFS) ReadDir(p string) ([]os.FileInfo, error) {
+	if err := fs.check("readdir", p, false); err != nil {
+		return nil, err
+	}
+	fis, err := fs.Filesystem.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	var ret []os.FileInfo
+	for _, fi := range fis {
+		if !fs.p.Hidden(path.Join(cleanName(p), fi.Name())) {
+			ret = append(ret, fi)
+		}
+	}
+	return ret, nil
+}
+
+// MkdirAll implements billy.Dir.MkdirAll.
+func (fs policyFS) MkdirAll(filename string, perm os.FileMode) error {
+	if err := fs.check("mkdir", filename, true); err != nil {
+		return err
+	}
+	return fs.Filesystem.MkdirAll(filename, perm)
+}
+
+// Lstat implements billy.Symlink.Lstat.
+func (fs policyFS) Lstat(filename string) (os.FileInfo, error) {
+	if err := fs.check("lstat", filename, false); err != nil {
+		return nil, err
+	}
+	return fs.Filesystem.Lstat(filename)
+}
+
+// Symlink implements billy.Symlink.Symlink.
+func (fs policyFS) Symlink(target, link string) error {
+	if err := fs.check("symlink", link, true); err != nil {
+		return err
+	}
+	return fs.Filesystem.Symlink(target, link)
+}
+
+// Readlink implements billy.Symlink.Readlink.
+func (fs policyFS) Readlink(link string) (string, error) {
+	if err := fs.check("readlink", link, false); err != nil {
+		return "", err
+	}
+	return fs.Filesystem.Readlink(link)
+}
+
+// Chroot implements billy.Chroot.Chroot. It is not supported, as the
+// names of the new root would not be those the policy names.
+func (fs policyFS) Chroot(p string) (billy.Filesystem, error) {
+	return nil, billy.ErrNotSupported
+}
+
+// change returns the billy.Change of fs, if it has one, once op on
+// name is checked.
+func (fs policyFS) change(op, name string) (billy.Change, error) {
+	if err := fs.check(op, name, true); err != nil {
+		return nil, err
+	}
+	c, ok := fs.Filesystem.(billy.Change)
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: name, Err: billy.ErrNotSupported}
+	}
+	return c, nil
+}
+
+// Chmod implements billy.Change.Chmod.
+func (fs policyFS) Chmod(name string, mode os.FileMode) error {
+	c, err := fs.change("chmod", name)
+	if err != nil {
+		return err
+	}
+	return c.Chmod(name, mode)
+}
+
+// Lchown implements billy.Change.Lchown.
+func (fs policyFS) Lchown(name string, uid, gid int) error {
+	c, err := fs.change("lchown", name)
+	if err != nil {
+		return err
+	}
+	return c.Lchown(name, uid, gid)
+}
+
+// Chown implements billy.Change.Chown.
+func (fs policyFS) Chown(name string, uid, gid int) error {
+	c, err := fs.change("chown", name)
+	if err != nil {
+		return err
+	}
+	return c.Chown(name, uid, gid)
+}
+
+// Chtimes implements billy.Change.Chtimes.
+func (fs policyFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	c, err := fs.change("chtimes", name)
+	if err != nil {
+		return err
+	}
+	return c.Chtimes(name, atime, mtime)
+}
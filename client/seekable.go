@@ -0,0 +1,287 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxChunk is the most, decompressed, a chunk of a seekable file may
+// have: one is kept in memory, to read from.
+var maxChunk int64 = 16 << 20
+
+// errBigChunk is returned by chunks if a chunk has more than maxChunk.
+var errBigChunk = errors.New("chunk is too big to keep in memory")
+
+// compression returns how r is compressed: "gzip", "zstd", or "", if
+// it is not.
+func compression(r io.ReaderAt) string {
+	var magic [4]byte
+	n, _ := r.ReadAt(magic[:], 0)
+	switch {
+	case n >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		return "gzip"
+	case n == 4 && bytes.Equal(magic[:], []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return "zstd"
+	}
+	return ""
+}
+
+// A chunk is a part of a compressed file that decompresses alone: a
+// gzip member, or a zstd frame. Files from bgzip, or from pzstd, or
+// zstd with -B, are made of many.
+type chunk struct {
+	// Off is where it is in the file, and UOff where what it
+	// decompresses to is in what the file decompresses to.
+	Off, UOff int64
+}
+
+// chunks returns the chunks of r, of at most size bytes, compressed
+// as format, followed by one for where it ends.
+func chunks(r io.ReaderAt, size int64, format string) ([]chunk, error) {
+	switch format {
+	case "gzip":
+		return gzipChunks(r, size)
+	case "zstd":
+		return zstdChunks(r, size)
+	}
+	return nil, fmt.Errorf("%q:%w", format, os.ErrInvalid)
+}
+
+// countReader counts what is read from a bufio.Reader. It is a
+// flate.Reader, so that gzip reads no more than a member has in it,
+// and the count is where the next one starts.
+type countReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+// Read implements io.Reader.
+func (c *countReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// ReadByte implements io.ByteReader.
+func (c *countReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// gzipChunks returns the members of a gzip file. They can only be
+// found by decompressing them.
+func gzipChunks(r io.ReaderAt, size int64) ([]chunk, error) {
+	c := &countReader{r: bufio.NewReaderSize(io.NewSectionReader(r, 0, size), 1<<16)}
+	z, err := gzip.NewReader(c)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		cs  []chunk
+		u   int64
+		off int64
+	)
+	for {
+		z.Multistream(false)
+		n, err := io.Copy(io.Discard, z)
+		if err != nil {
+			return nil, fmt.Errorf("gzip member at %d:%w", off, err)
+		}
+		if n > maxChunk {
+			return nil, fmt.Errorf("gzip member at %d has %d bytes:%w", off, n, errBigChunk)
+		}
+		cs = append(cs, chunk{Off: off, UOff: u})
+		u += n
+		// The next member starts where this one ended, before
+		// its header is read.
+		off = c.n
+		if err := z.Reset(c); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("gzip member at %d:%w", off, err)
+		}
+	}
+	return append(cs, chunk{Off: off, UOff: u}), nil
+}
+
+// zstdChunks returns the frames of a zstd file. Where each ends is
+// found from the headers of its blocks; what it decompresses to, from
+// its header, if it says, or by decompressing it, if not.
+func zstdChunks(r io.ReaderAt, size int64) ([]chunk, error) {
+	d, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	var (
+		cs  []chunk
+		u   int64
+		off int64
+	)
+	for off < size {
+		var b [zstd.HeaderMaxSize]byte
+		n, err := r.ReadAt(b[:], off)
+		if n == 0 && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		var h zstd.Header
+		if err := h.Decode(b[:n]); err != nil {
+			return nil, fmt.Errorf("zstd frame at %d:%w", off, err)
+		}
+		// Skippable frames go with the chunk before them.
+		if h.Skippable {
+			off += int64(h.HeaderSize) + int64(h.SkippableSize)
+			continue
+		}
+		end := off + int64(h.HeaderSize)
+		for last := false; !last; {
+			var bh [3]byte
+			if _, err := r.ReadAt(bh[:], end); err != nil {
+				return nil, fmt.Errorf("zstd block at %d:%w", end, err)
+			}
+			v := uint32(bh[0]) | uint32(bh[1])<<8 | uint32(bh[2])<<16
+			last = v&1 == 1
+			end += 3
+			switch (v >> 1) & 3 {
+			case 1: // RLE: one byte, however many it stands for.
+				end++
+			case 3:
+				return nil, fmt.Errorf("zstd block at %d is reserved:%w", end-3, os.ErrInvalid)
+			default:
+				end += int64(v >> 3)
+			}
+		}
+		if h.HasCheckSum {
+			end += 4
+		}
+		fs := int64(h.FrameContentSize)
+		if !h.HasFCS {
+			frame := make([]byte, end-off)
+			if _, err := r.ReadAt(frame, off); err != nil {
+				return nil, fmt.Errorf("zstd frame at %d:%w", off, err)
+			}
+			b, err := d.DecodeAll(frame, nil)
+			if err != nil {
+				return nil, fmt.Errorf("zstd frame at %d:%w", off, err)
+			}
+			fs = int64(len(b))
+		}
+		if fs > maxChunk {
+			return nil, fmt.Errorf("zstd frame at %d has %d bytes:%w", off, fs, errBigChunk)
+		}
+		cs = append(cs, chunk{Off: off, UOff: u})
+		u += fs
+		off = end
+	}
+	if len(cs) == 0 {
+		return nil, fmt.Errorf("zstd:no frames:%w", os.ErrInvalid)
+	}
+	return append(cs, chunk{Off: off, UOff: u}), nil
+}
+
+// seekable is an io.ReaderAt of what a compressed file, made of
+// chunks, decompresses to. A read decompresses the chunk it is in,
+// which is kept, as the next read is likely to be in it too.
+type seekable struct {
+	r      io.ReaderAt
+	format string
+	chunks []chunk
+
+	mu  sync.Mutex
+	cur int
+	buf []byte
+	zr  *zstd.Decoder
+}
+
+// newSeekable returns a seekable of r, compressed as format, with
+// chunks, as returned by chunks.
+func newSeekable(r io.ReaderAt, format string, chunks []chunk) *seekable {
+	return &seekable{r: r, format: format, chunks: chunks, cur: -1}
+}
+
+// load decompresses chunk i into s.buf.
+func (s *seekable) load(i int) error {
+	if i == s.cur {
+		return nil
+	}
+	c, next := s.chunks[i], s.chunks[i+1]
+	s.cur = -1
+	if int64(cap(s.buf)) < next.UOff-c.UOff {
+		s.buf = make([]byte, next.UOff-c.UOff)
+	}
+	s.buf = s.buf[:next.UOff-c.UOff]
+	src := io.NewSectionReader(s.r, c.Off, next.Off-c.Off)
+	switch s.format {
+	case "gzip":
+		z, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		z.Multistream(false)
+		if _, err := io.ReadFull(z, s.buf); err != nil {
+			return err
+		}
+	case "zstd":
+		if s.zr == nil {
+			zr, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return err
+			}
+			s.zr = zr
+		}
+		b := make([]byte, next.Off-c.Off)
+		if _, err := src.ReadAt(b, 0); err != nil {
+			return err
+		}
+		d, err := s.zr.DecodeAll(b, s.buf[:0])
+		if err != nil {
+			return err
+		}
+		if len(d) != len(s.buf) {
+			return fmt.Errorf("zstd frame at %d: %d bytes, want %d:%w", c.Off, len(d), len(s.buf), io.ErrUnexpectedEOF)
+		}
+		s.buf = d
+	}
+	s.cur = i
+	return nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (s *seekable) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	end := s.chunks[len(s.chunks)-1].UOff
+	var n int
+	for n < len(p) && off < end {
+		i := sort.Search(len(s.chunks), func(i int) bool { return s.chunks[i].UOff > off }) - 1
+		if err := s.load(i); err != nil {
+			return n, err
+		}
+		m := copy(p[n:], s.buf[off-s.chunks[i].UOff:])
+		n += m
+		off += int64(m)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
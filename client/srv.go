@@ -5,19 +5,68 @@
 package client
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"sync"
 
 	"github.com/hugelgupf/p9/p9"
 	"github.com/u-root/u-root/pkg/ulog"
 )
 
-// Made harder as you can't set a read deadline on ssh.Conn
+// srv serves the 9P connections, one for each of the channels, that
+// cpud makes to l, once each says the nonce. Each is served as it is
+// made, as cpud mounts it before it makes the next.
 func (c *Cmd) srv(l net.Listener) error {
-	// We only accept once
-	defer l.Close()
+	// If we are debugging, add the option to trace records.
+	var opts []p9.ServerOpt
+	if Debug9p {
+		if Dump9p {
+			log.SetOutput(DumpWriter)
+			log.SetFlags(log.Ltime | log.Lmicroseconds)
+			ulog.Log = log.New(DumpWriter, "9p", log.Ltime|log.Lmicroseconds)
+		}
+		opts = append(opts, p9.WithServerLogger(ulog.Log))
+	}
+
+	// Each connection has its own server, so that they do not wait
+	// on each other; they share the file server.
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs error
+	)
+	for i := 0; i < c.channels(); i++ {
+		s, err := c.accept(l)
+		if err != nil {
+			mu.Lock()
+			errs = errors.Join(errs, fmt.Errorf("srv: %v", err))
+			mu.Unlock()
+			break
+		}
+		verbose("Start serving on %v, channel %d", c.Root, i)
+		wg.Add(1)
+		go func(s net.Conn) {
+			defer wg.Done()
+			if err := p9.NewServer(c.fileServer, opts...).Handle(s, s); err != nil && err != io.EOF {
+				log.Printf("Serving cpu remote: %v", err)
+				mu.Lock()
+				errs = errors.Join(errs, err)
+				mu.Unlock()
+			}
+		}(s)
+	}
+	// We only accept as many as there are channels.
+	l.Close()
+	wg.Wait()
+	return errs
+}
+
+// accept accepts a 9P connection on l, and checks it says the nonce.
+// Made harder as you can't set a read deadline on ssh.Conn
+func (c *Cmd) accept(l net.Listener) (net.Conn, error) {
 	var (
 		errs = make(chan error)
 		s    net.Conn
@@ -53,25 +102,10 @@ func (c *Cmd) srv(l net.Listener) error {
 	// we can now do a cpu session without the 9p server. The timeout
 	// is no longer important, since not all cpu sessions need 9p.
 	if err := <-errs; err != nil {
-		return fmt.Errorf("srv: %v", err)
-	}
-	// If we are debugging, add the option to trace records.
-	verbose("Start serving on %v", c.Root)
-	var opts []p9.ServerOpt
-	if Debug9p {
-		if Dump9p {
-			log.SetOutput(DumpWriter)
-			log.SetFlags(log.Ltime | log.Lmicroseconds)
-			ulog.Log = log.New(DumpWriter, "9p", log.Ltime|log.Lmicroseconds)
-		}
-		opts = append(opts, p9.WithServerLogger(ulog.Log))
-	}
-
-	if err := p9.NewServer(c.fileServer, opts...).Handle(s, s); err != nil {
-		if err != io.EOF {
-			log.Printf("Serving cpu remote: %v", err)
-			return err
+		if s != nil {
+			s.Close()
 		}
+		return nil, err
 	}
-	return nil
+	return s, nil
 }
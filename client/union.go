@@ -112,17 +112,14 @@ type MountPoint struct {
 // we built a union mount and CPIO file system for 9p. This merge
 // of the two makes for less code, and slightly easier to understand
 // rules: always check the mounts first, and always fall back to the
-// CPIO fs if those fail. More than one CPIO file can be used to
-// construct the recs []cpio.Record, which is why there is only the
-// one slice.
+// CPIO fs if those fail.
 type fsCPIO struct {
-	m    map[string]uint64
-	recs []cpio.Record
+	recs cpioRecords
 	mnts []MountPoint
 }
 
 // hasMount determines if a path can be resolved in the mnts slice,
-// or must be looked for in the recs slice.
+// or must be looked for in the recs.
 func (f *fsCPIO) hasMount(n string) (*MountPoint, string, error) {
 	verbose("hasMount %q in %d mounts", n, len(f.mnts))
 	if !filepath.IsAbs(n) {
@@ -192,12 +189,16 @@ func (fs *fsCPIO) ReadDir(filename string) ([]os.FileInfo, error) {
 
 // Name implements billy.Name
 func (f *fsCPIO) Name() string {
-	return f.recs[0].Name
+	return f.recs.name(0)
 }
 
 // Size implements billy.Size
 func (f *fsCPIO) Size() int64 {
-	return int64(f.recs[0].FileSize)
+	r, err := f.recs.record(0)
+	if err != nil {
+		return 0
+	}
+	return int64(r.FileSize)
 }
 
 // uToGo converts Unix mode to Go os.FileMode.
@@ -233,7 +234,11 @@ func uToGo(m uint64) os.FileMode {
 
 // Mode implements billy.Mode
 func (f *fsCPIO) Mode() os.FileMode {
-	m := uToGo(f.recs[0].Mode)
+	r, err := f.recs.record(0)
+	if err != nil {
+		return fs.ModeDir
+	}
+	m := uToGo(r.Mode)
 	verbose("fsCPIO mode: %v %#x", m, uint64(m))
 	return m
 }
@@ -345,41 +350,32 @@ func (u ufstat) Name() string {
 // This allows the use of flattened docker containers,
 // so that one does not always need to run docker
 // to use a docker container.
+// The CPIO file is read as NewCPIO9P reads it.
 func NewfsCPIO(c string, mounts ...MountPoint) (*fsCPIO, error) {
-	recs := []cpio.Record{cpio.Directory(".", 0755)}
+	var recs cpioRecords = newMemRecords([]cpio.Record{cpio.Directory(".", 0755)})
 	if len(c) > 0 {
-		f, err := os.Open(c)
-		if err != nil {
-			return nil, err
-		}
-
-		archive, err := cpio.Format("newc")
-		if err != nil {
-			return nil, err
-		}
-
-		rr, err := archive.NewFileReader(f)
-		if err != nil {
-			return nil, err
-		}
-
-		recs, err = cpio.ReadAllRecords(rr)
-		if len(recs) == 0 {
-			return nil, fmt.Errorf("cpio:No records: %w", os.ErrInvalid)
-		}
-
-		if err != nil {
+		var err error
+		if recs, err = openCPIO(c); err != nil {
 			return nil, err
 		}
-
 	}
-	m := map[string]uint64{}
-	for i, r := range recs {
-		v("put %s in %d", r.Info.Name, i)
-		m[r.Info.Name] = uint64(i)
+	return newfsCPIO(recs, mounts...)
+}
+
+// NewfsImage returns a fsCPIO whose "backing root" is the image ref,
+// which may be empty if there is only one, in the docker save tarball
+// or OCI image layout at path, as described in ImageRecords.
+func NewfsImage(path, ref string, mounts ...MountPoint) (*fsCPIO, error) {
+	recs, err := ImageRecords(path, ref)
+	if err != nil {
+		return nil, err
 	}
+	return newfsCPIO(newMemRecords(recs), mounts...)
+}
 
-	fs := &fsCPIO{recs: recs, m: m}
+// newfsCPIO returns a fsCPIO of recs and mounts.
+func newfsCPIO(recs cpioRecords, mounts ...MountPoint) (*fsCPIO, error) {
+	fs := &fsCPIO{recs: recs}
 	for _, m := range mounts {
 		if err := fs.mount(m); err != nil {
 			return nil, err
@@ -448,11 +444,11 @@ func (fs *fsCPIO) Stat(filename string) (os.FileInfo, error) {
 		return nil, err
 	}
 
-	x := l.(*file).Path
-	if int(x) > len(fs.recs) {
-		return nil, fmt.Errorf("Stat:%q: index %d is too big", filename, x)
+	r, err := fs.recs.record(l.(*file).Path)
+	if err != nil {
+		return nil, fmt.Errorf("Stat:%q:%w", filename, err)
 	}
-	fi := &fstat{Record: &fs.recs[x]}
+	fi := &fstat{Record: r}
 	return fi, nil
 }
 
@@ -469,16 +465,21 @@ func (fs *fsCPIO) Lstat(filename string) (os.FileInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &fstat{Record: &fs.recs[l.(*file).Path]}, nil
+	r, err := fs.recs.record(l.(*file).Path)
+	if err != nil {
+		return nil, err
+	}
+	return &fstat{Record: r}, nil
 }
 
 // rec returns a cpio.Record for a file.
 func (l *file) rec() (*cpio.Record, error) {
-	if int(l.Path) > len(l.fs.recs) {
-		return nil, os.ErrNotExist
+	r, err := l.fs.recs.record(l.Path)
+	if err != nil {
+		return nil, err
 	}
-	v("cpio:rec for %v is %v", l, l.fs.recs[l.Path])
-	return &l.fs.recs[l.Path], nil
+	v("cpio:rec for %v is %v", l, r)
+	return r, nil
 }
 
 // getfs returns the filesystem, or error, for a given filename.
@@ -496,10 +497,10 @@ func (fs *fsCPIO) getfs(filename string) (billy.Filesystem, string, error) {
 // the root is assumed (this is what billy seems to require).
 func (fs *fsCPIO) lookup(filename string) (billy.File, error) {
 	var ino uint64
-	verbose("lookup(%q) in %d recs", filename, len(fs.recs))
+	verbose("lookup(%q) in %d recs", filename, fs.recs.count())
 	if len(filename) > 0 {
 		var ok bool
-		ino, ok = fs.m[filename]
+		ino, ok = fs.recs.lookup(filename)
 		verbose("lookup %q ino %d %v", filename, ino, ok)
 		if !ok {
 			return nil, os.ErrNotExist
@@ -626,27 +627,9 @@ func (l *file) readdir() ([]uint64, error) {
 	if err != nil {
 		return nil, err
 	}
-	dn := r.Info.Name
 	verbose("cpio:readdir starts from %v %v", l, r)
-	// while the name is a prefix of the records we are scanning,
-	// append the record.
-	// This can not be returned as a range as we do not want
-	// contents of all subdirs.
-	var list []uint64
-	for i, r := range l.fs.recs[l.Path+1:] {
-		// filepath.Rel fails, we're done here.
-		b, err := filepath.Rel(dn, r.Name)
-		if err != nil {
-			verbose("cpio:r.Name %q: DONE", r.Name)
-			break
-		}
-		dir, _ := filepath.Split(b)
-		if len(dir) > 0 {
-			continue
-		}
-		verbose("cpio:readdir: %v", i)
-		list = append(list, uint64(i)+l.Path+1)
-	}
+	list := children(l.fs.recs, l.Path)
+	verbose("cpio:readdir: %v", list)
 	return list, nil
 }
 
@@ -710,18 +693,47 @@ func (l *file) Readlink() (string, error) {
 // This API will change if needs dictate.
 // So far its simplicity has been sufficient.
 func SrvNFS(cl *Cmd, n string, dir string) (func() error, string, error) {
+	mnt, err := nfsMount(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	mem, err := NewfsCPIO(n, mnt)
+	if err != nil {
+		return nil, "", err
+	}
+	return srvNFS(cl, mem)
+}
+
+// SrvNFSImage is SrvNFS, with an image, path[:ref], as ParseImage
+// takes it, for the "backing root", in place of a CPIO file.
+func SrvNFSImage(cl *Cmd, image string, dir string) (func() error, string, error) {
+	mnt, err := nfsMount(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	p, ref := ParseImage(image)
+	mem, err := NewfsImage(p, ref, mnt)
+	if err != nil {
+		return nil, "", err
+	}
+	return srvNFS(cl, mem)
+}
+
+// nfsMount returns the mount of dir in the file system SrvNFS serves.
+func nfsMount(dir string) (MountPoint, error) {
 	// The osnfs will be absolute, so the mountdir has to be
 	// relative to the osnfs
 	mdir, err := filepath.Rel("/", dir)
 	if err != nil {
-		return nil, "", err
+		return MountPoint{}, err
 	}
 	osfs := NewOSFS(dir)
 	verbose("Create New OSFS @ %q with relative mount %q", dir, mdir)
-	mem, err := NewfsCPIO(n, WithMount(mdir, osfs))
-	if err != nil {
-		return nil, "", err
-	}
+	return WithMount(mdir, osfs), nil
+}
+
+// srvNFS sets up an nfs server of mem.
+func srvNFS(cl *Cmd, mem *fsCPIO) (func() error, string, error) {
 	l, err := cl.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		// If ipv4 isn't available, try ipv6.  It's not enough
@@ -749,7 +761,11 @@ func SrvNFS(cl *Cmd, n string, dir string) (func() error, string, error) {
 	if err != nil {
 		return nil, "", err
 	}
-	handler := NewNullAuthHandler(l, COS{mem}, u.String())
+	var bfs billy.Filesystem = COS{mem}
+	if cl.policy != nil {
+		bfs = policyFS{Filesystem: bfs, p: cl.policy}
+	}
+	handler := NewNullAuthHandler(l, bfs, u.String())
 	verbose("uuid is %q", u.String())
 	cacheHelper := nfshelper.NewCachingHandler(handler, 1024*1024)
 	f := func() error {
@@ -0,0 +1,67 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/u-root/cpu/client"
+	"golang.org/x/term"
+)
+
+// cp copies files to or from a host, with no namespace, as in
+//
+//	cpu [options] cp [-r] [-q] src... host:dst
+//	cpu [options] cp [-r] [-q] host:src... dst
+func cp(args []string) error {
+	f := flag.NewFlagSet("cp", flag.ExitOnError)
+	recursive := f.Bool("r", false, "copy directories, and all in them")
+	quiet := f.Bool("q", false, "do not show progress")
+	f.Usage = func() {
+		fmt.Fprintf(f.Output(), "Usage: cpu [options] cp [-r] [-q] src... host:dst\n       cpu [options] cp [-r] [-q] host:src... dst\n")
+		f.PrintDefaults()
+	}
+	f.Parse(args) //nolint
+	a := f.Args()
+	if len(a) < 2 {
+		f.Usage()
+		os.Exit(2)
+	}
+	var host string
+	for _, n := range a {
+		if h, _, ok := client.SplitRemote(n); ok {
+			host = h
+			break
+		}
+	}
+	if len(host) == 0 {
+		return fmt.Errorf("no file is on a host, as host:path")
+	}
+	*keyFile = getKeyFile(host, *keyFile)
+	*port = getPort(host, *port)
+	verbose("copying %q to %q on %q port %q", a[:len(a)-1], a[len(a)-1], host, *port)
+
+	c := client.Command(host)
+	defer c.Close()
+	if err := c.SetOptions(
+		client.WithDisablePrivateKey(!*useKey),
+		client.WithPrivateKeyFile(*keyFile),
+		client.WithHostKeyFile(*hostKeyFile),
+		client.WithPrompt(prompt),
+		client.WithPort(*port),
+		client.WithNetwork(*network)); err != nil {
+		return err
+	}
+	if err := c.Dial(); err != nil {
+		return fmt.Errorf("Dial: %v", err)
+	}
+	opts := []client.CopyOption{client.CopyRecursive(*recursive)}
+	if !*quiet && term.IsTerminal(int(os.Stderr.Fd())) {
+		opts = append(opts, client.CopyProgress(os.Stderr))
+	}
+	return client.Copy(c, a[:len(a)-1], a[len(a)-1], opts...)
+}
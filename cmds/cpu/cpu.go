@@ -37,6 +37,11 @@ var (
 	dbg9p       = flag.Bool("dbg9p", false, "show 9p io")
 	dump        = flag.Bool("dump", false, "Dump copious output, including a 9p trace, to a temp file at exit")
 	fstab       = flag.String("fstab", "", "pass an fstab to the cpud")
+	xattrs      = flag.String("xattrs", "user", "namespaces of extended attributes to serve, e.g. user,security, or none")
+	hide        = flag.String("hide", "", "globs of names not to serve to the remote, e.g. ~/.ssh:~/.gnupg")
+	compression = flag.String("compress", "none", "compression of the 9p and nfs channels over slow links, e.g. zstd, zlib, or zstd,zlib to offer both; none compresses nothing")
+	cache       = flag.String("cache", "0s", "how long to cache file attributes and directories, e.g. 5s, over slow links; 0s caches nothing")
+	idmap       = flag.String("idmap", "identity", "how file user and group ids are mapped: identity, squash, or a table, e.g. u1000=0,g1000=0")
 	hostKeyFile = flag.String("hk", "" /*"/etc/ssh/ssh_host_rsa_key"*/, "file for host key")
 	keyFile     = flag.String("key", "", "key file")
 	useKey      = flag.Bool("useKey", true, "Use key file to encrypt the ssh connection")
@@ -46,9 +51,15 @@ var (
 	root        = flag.String("root", "/", "9p root")
 	timeout9P   = flag.String("timeout9p", "100ms", "time to wait for the 9p mount to happen.")
 	ninep       = flag.Bool("9p", true, "Enable the 9p mount in the client")
+	channels    = flag.Int("channels", 1, "number of 9p connections, with the namespace binds striped over them")
+	msize       = flag.Int("msize", 0, "largest 9p message; 0 chooses it from the round trip time")
+	limits      = flag.String("limits", "", "resource limits to request for the session, e.g. memory.max=1G,pids.max=512")
+	namespaces  = flag.String("ns", "", "namespaces to request for the session, e.g. pid,net,uts,ipc")
 
-	srvnfs   = flag.Bool("nfs", false, "start nfs")
-	cpioRoot = flag.String("cpio", "", "cpio initrd")
+	srvnfs    = flag.Bool("nfs", false, "start nfs")
+	cpioRoot  = flag.String("cpio", "", "cpio initrd")
+	cpioIndex = flag.Bool("cpioindex", false, "write an index of the cpio beside it, as cpio.idx, so that it opens at once the next time")
+	image     = flag.String("image", "", "docker save tarball or OCI image layout to serve, as path[:tag or digest], in place of the 9p root, or of the cpio for nfs")
 
 	ssh  = flag.Bool("ssh", false, "ssh only, no internal 9p, nfs, or mounts")
 	sshd = flag.Bool("sshd", false, "server is sshd, not cpud")
@@ -68,6 +79,9 @@ func flags() {
 	if *dump && *debug {
 		log.Fatalf("You can only set either dump OR debug")
 	}
+	if len(*cpioRoot) > 0 && len(*image) > 0 {
+		log.Fatalf("You can only set either cpio OR image")
+	}
 	if *debug {
 		v = log.Printf
 		client.SetVerbose(verbose)
@@ -175,12 +189,31 @@ func newCPU(host string, args ...string) (retErr error) {
 		client.WithPort(*port),
 		client.WithRoot(*root),
 		client.WithNameSpace(*namespace),
+		client.WithHide(*hide),
+		client.WithXattrs(*xattrs),
+		client.WithIDMap(*idmap),
+		client.WithCache(*cache),
 		client.With9P(*ninep),
+		client.WithChannels(*channels),
+		client.WithMsize(*msize),
+		client.WithCompress(*compression),
 		client.WithFSTab(*fstab),
 		client.WithNetwork(*network),
+		client.WithLimits(*limits),
+		client.WithNamespaces(*namespaces),
+		client.WithPrompt(prompt),
 		client.WithTimeout(*timeout9P)); err != nil {
 		log.Fatal(err)
 	}
+	if len(*image) > 0 && *ninep {
+		s, err := client.NewImage9P(client.ParseImage(*image))
+		if err != nil {
+			return err
+		}
+		if err := c.SetOptions(client.WithServer(s)); err != nil {
+			return err
+		}
+	}
 	if err := c.Dial(); err != nil {
 		return fmt.Errorf("Dial: %v", err)
 	}
@@ -205,7 +238,21 @@ func newCPU(host string, args ...string) (retErr error) {
 			}
 			break
 		}
-		f, nfsmount, err := client.SrvNFS(c, *cpioRoot, "/")
+		var (
+			f        func() error
+			nfsmount string
+			err      error
+		)
+		if *cpioIndex && len(*cpioRoot) > 0 {
+			if err := client.WriteCPIOIndex(*cpioRoot); err != nil {
+				log.Printf("cpio index: %v", err)
+			}
+		}
+		if len(*image) > 0 {
+			f, nfsmount, err = client.SrvNFSImage(c, *image, "/")
+		} else {
+			f, nfsmount, err = client.SrvNFS(c, *cpioRoot, "/")
+		}
 		if err != nil {
 			return err
 		}
@@ -260,7 +307,7 @@ func usage() {
 	var b bytes.Buffer
 	flag.CommandLine.SetOutput(&b)
 	flag.PrintDefaults()
-	log.Fatalf("Usage: cpu [options] host [shell command]:\n%v", b.String())
+	log.Fatalf("Usage: cpu [options] host [shell command]\n       cpu [options] cp [-r] [-q] src... host:dst\n       cpu [options] cp [-r] [-q] host:src... dst\n%v", b.String())
 }
 
 func main() {
@@ -269,6 +316,13 @@ func main() {
 	if len(args) == 0 {
 		usage()
 	}
+	// cpu cp copies files; there is no session.
+	if args[0] == "cp" {
+		if err := cp(args[1:]); err != nil {
+			log.Fatalf("cp: %v", err)
+		}
+		return
+	}
 	host := args[0]
 	a := args[1:]
 	if len(a) == 0 {
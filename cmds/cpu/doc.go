@@ -73,20 +73,97 @@
 //
 //	-9p bool
 //	      enable the 9p server in the client (default enabled)
+//	-cache duration
+//	      how long the 9p server caches the attributes of files and
+//	      the contents of directories (default 0s, nothing), for slow
+//	      links, where each stat is a round trip. The 9p server sees
+//	      changes made by the remote at once, and, on Linux, those made
+//	      on the client; elsewhere, it sees them as what it caches
+//	      expires. cpud mounts it with cache=loose, so the remote
+//	      caches, too, and may not see changes made on the client:
+//	      it is for trees that do not change much, e.g. /usr.
+//	-channels int
+//	      number of 9p connections, each over its own ssh channel and
+//	      served on its own (default 1). cpud mounts the first on
+//	      /tmp/cpu and the others on /tmp/cpu.1 and so on, and the
+//	      binds of -namespace are striped over them, so that reads in
+//	      different binds, e.g. /usr and /lib, do not wait on each other.
+//	      If cpud mounts fewer, e.g. it is an older cpud, which mounts
+//	      one, only that many are served.
+//	-compress string
+//	      compression of the 9p and nfs channels (default none), for
+//	      slow links: the files they carry, e.g. binaries and source,
+//	      compress well. zstd and zlib are known; a comma-separated
+//	      list, e.g. zstd,zlib, offers each, in that order, and cpud
+//	      chooses the first it knows. If it knows none, e.g. it is
+//	      sshd, nothing is compressed. zstd is faster, and compresses
+//	      more.
+//	-cpio string
+//	      a cpio, used, with -nfs, as the backing root. It may be
+//	      compressed with gzip or zstd. Its records are read as they
+//	      are used, so that a cpio of many GiB opens in the time it
+//	      takes to read its headers, and holds no more in memory than
+//	      their names. A compressed cpio made of many gzip members or
+//	      zstd frames, e.g. by bgzip or pzstd, is read a member or frame
+//	      at a time; others are decompressed, once, to a temporary file.
+//	-cpioindex
+//	      write an index of the -cpio beside it, as cpio.idx, if there
+//	      is not one that is up to date, so that the next time it is
+//	      used, its headers need not be read, nor, if it is compressed,
+//	      need it be decompressed, to know what is in it.
 //	-d
 //	      enable debug prints
 //	-dbg9p
 //	      show 9p io
 //	-dump
 //	      Dump all debug output and 9p packets to a file in /tmp
+//	-hide string
+//	      globs, : separated, of names the client does not serve to
+//	      the remote: they are not there, nor is anything in them, e.g.
+//	      -hide ~/.ssh:~/.gnupg:*.pem
+//	      A ~ is the home directory of the client, which must be in
+//	      -root; a glob with no / matches the last element of a name,
+//	      anywhere.
 //	-hk string
 //	      host key file
+//	-idmap string
+//	      how the user and group ids of files the 9p server serves are
+//	      mapped (default identity, not at all). With squash, all are
+//	      owned by your user and group, and so is everything the
+//	      remote makes or gives away. Otherwise, a comma-separated
+//	      table of ids, u<client>=<remote> and g<client>=<remote>,
+//	      e.g. -idmap u1000=0,g1000=0; ids not in it are not mapped.
+//	      cpud mounts the 9p server with dfltuid and dfltgid the ids
+//	      of your user and group, as the remote sees them.
+//	-image string
+//	      a docker save tarball, or an OCI image layout directory, or
+//	      a tar of one, served, read-only, in place of the 9p root, or,
+//	      with -nfs, of the -cpio backing root, with no need to flatten
+//	      it to a cpio first. If it has more than one image, select
+//	      one by tag or digest, e.g.
+//	      -image alpine.tar:alpine:3.19 or -image oci:sha256:...
+//	      gzip and zstd layers are decompressed, once, to a temporary
+//	      file; others are read where they are.
 //	-key string
 //	      key file (default "$HOME/.ssh/cpu_rsa")
+//	      If the key is missing, or the host refuses it, cpu asks on
+//	      the terminal for a password, and a verification code if the
+//	      host wants one, as cpud -passwords does.
+//	-limits string
+//	      resource limits to request for the remote session, as a
+//	      comma-separated list of cgroup v2 file=value pairs, e.g.
+//	      memory.max=1G,pids.max=512,cpu.max=50000 100000
+//	      cpud caps these with its own limits.
 //	-mountopts string
 //	      extra options for the 9p mount, default "". Lightly tested.
-//	-msize uint
-//	      max size for 9p packets, default 1 MiB
+//	-msize int
+//	      max size for 9p messages (default 0: from the round trip
+//	      time to the host, 64 KiB under a millisecond, doubling as
+//	      it does, to at most 1 MiB)
+//	-ns string
+//	      namespaces to request for the remote session, as a
+//	      comma-separated list of pid, net, uts, ipc, user and veth.
+//	      cpud refuses namespaces it does not allow.
 //	-namespace string
 //	      namespace defines the bind mounts that are done by cpud.
 //	      The format is of a : separated string, in the style of PATH
@@ -99,6 +176,11 @@
 //	      but we might want it to appears as /home/rob on Linux.
 //	      This change is accomplished with
 //	      -namespace /lib:/lib64:/usr:/bin:/etc:/home/rob=/Users/rob
+//	      A bind ending in ,ro is read-only: it is mounted read-only,
+//	      and, as the remote could undo that, the client refuses
+//	      changes to it too. A bind ending in ,rw can be changed, even
+//	      if it is in a read-only one, e.g.
+//	      -namespace /usr,ro:/usr/local,rw:/home/rob=/Users/rob
 //	-network string
 //	      network to use (default "tcp")
 //	-port9p string
@@ -109,12 +191,32 @@
 //	      Root for 9p server, default "/"
 //	      If you are cpu'ing from, eg., x86 to arm, you might
 //	      use, e.g., /amd64
+//	      Nothing outside the root is served: "..", and symbolic
+//	      links, are resolved beneath it, so the remote can not
+//	      reach the rest of your files through them.
 //	-sp string
 //	     remote port, default 17010
 //	-srv string
 //	      what server to run (default none; use internal)
 //	-timeout9p time.Duration
 //	      How long to wait for the server to connect to 9p (default100ms)
+//	-xattrs string
+//	      namespaces of the extended attributes the 9p server serves,
+//	      comma-separated, e.g. user,security,trusted (default user).
+//	      Others are not listed, read or set. With none, cpud mounts
+//	      the 9p server with noxattr.
+//
+// Copying files:
+//
+//	cpu [OPTIONS] cp [-r] [-q] src... host:dst
+//	cpu [OPTIONS] cp [-r] [-q] host:src... dst
+//
+//	cpu cp copies files to or from a host, over SFTP, as scp does,
+//	with no namespace and no session. Modes and modification times
+//	are kept. If dst is a directory, the files are copied into it.
+//	-r copies directories, and all in them; -q does not show the
+//	progress of each file, which is shown if stderr is a terminal.
+//	Since cpu cp only needs SFTP, it works with sshd as well as cpud.
 //
 // Cpud environment variables:
 //   - CPUD_IGNORE_CMD_ERROR: passed from cpud to cpud --remote.  If the
@@ -0,0 +1,35 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// prompt asks a question on the terminal, as ssh does for passwords
+// and verification codes. With no terminal, e.g. in a script, it fails,
+// and authentication fails rather than waits.
+func prompt(question string, echo bool) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("no terminal to ask %q: %w", question, err)
+	}
+	defer tty.Close()
+	if _, err := fmt.Fprint(tty, question); err != nil {
+		return "", err
+	}
+	if echo {
+		l, err := bufio.NewReader(tty).ReadString('\n')
+		return strings.TrimRight(l, "\r\n"), err
+	}
+	b, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	return string(b), err
+}
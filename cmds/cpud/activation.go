@@ -0,0 +1,120 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mdlayher/vsock"
+	"golang.org/x/sys/unix"
+)
+
+// listenFDsStart is the first socket passed by systemd.
+const listenFDsStart = 3
+
+// activatedSocket is a socket passed to cpud by systemd, and its name,
+// from FileDescriptorName= in the socket unit.
+type activatedSocket struct {
+	name string
+	f    *os.File
+}
+
+// activated are the sockets passed to cpud by systemd. They stay open,
+// so that connections wait in them while cpud drains and restarts.
+var activated []activatedSocket
+
+// activation returns the sockets passed by systemd, as sd_listen_fds(3)
+// describes, if LISTEN_PID is cpud. The variables are unset, and the
+// sockets closed on exec, so that sessions do not inherit them.
+func activation() ([]activatedSocket, error) {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	for _, e := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(e)
+	}
+	if len(pid) == 0 || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("LISTEN_FDS=%q:%w", fds, strconv.ErrSyntax)
+	}
+	var name []string
+	if len(names) > 0 {
+		name = strings.Split(names, ":")
+	}
+	var socks []activatedSocket
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		unix.CloseOnExec(fd)
+		s := activatedSocket{name: "unknown", f: os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))}
+		if i < len(name) {
+			s.name = name[i]
+		}
+		socks = append(socks, s)
+	}
+	return socks, nil
+}
+
+// passActivated passes the sockets from systemd on to the program
+// cpud executes next, which is cpud itself.
+func passActivated() error {
+	if len(activated) == 0 {
+		return nil
+	}
+	var names []string
+	for _, s := range activated {
+		if _, err := unix.FcntlInt(s.f.Fd(), unix.F_SETFD, 0); err != nil {
+			return fmt.Errorf("passing %s: %w", s.f.Name(), err)
+		}
+		names = append(names, s.name)
+	}
+	for _, e := range [][2]string{
+		{"LISTEN_PID", strconv.Itoa(os.Getpid())},
+		{"LISTEN_FDS", strconv.Itoa(len(activated))},
+		{"LISTEN_FDNAMES", strings.Join(names, ":")},
+	} {
+		if err := os.Setenv(e[0], e[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// activatedListeners returns listeners for the sockets from systemd
+// named name, or all of them, if name is empty. The listeners are
+// copies; closing them leaves the sockets open.
+func activatedListeners(name string) ([]net.Listener, error) {
+	var lns []net.Listener
+	for _, s := range activated {
+		if len(name) > 0 && s.name != name {
+			continue
+		}
+		ln, err := net.FileListener(s.f)
+		if err != nil {
+			// The net package does not know vsock.
+			vl, verr := vsock.FileListener(s.f)
+			if verr != nil {
+				closeAll(lns)
+				return nil, fmt.Errorf("socket %q from systemd: %w", s.name, err)
+			}
+			ln = vl
+		}
+		lns = append(lns, ln)
+	}
+	if len(lns) == 0 {
+		return nil, fmt.Errorf("no socket %q from systemd:%w", name, os.ErrNotExist)
+	}
+	return lns, nil
+}
+
+func closeAll(lns []net.Listener) {
+	for _, ln := range lns {
+		ln.Close()
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestActivationChild is run by TestActivation, with sockets on fds 3
+// and 4, as systemd would run cpud.
+func TestActivationChild(t *testing.T) {
+	if os.Getenv("CPUD_TEST_ACTIVATION") != "1" {
+		t.Skip("run by TestActivation")
+	}
+	os.Setenv("LISTEN_PID", fmt.Sprint(os.Getpid()))
+	var err error
+	if activated, err = activation(); err != nil {
+		t.Fatalf("activation: %v", err)
+	}
+	if v := os.Getenv("LISTEN_FDS"); v != "" {
+		t.Errorf("LISTEN_FDS after activation: %q, want it unset", v)
+	}
+	defer func() { listens = nil }()
+	for _, tt := range []struct {
+		listens listFlag
+		want    []string
+		err     bool
+	}{
+		{want: []string{"tcp", "unix"}},
+		{listens: listFlag{"systemd:cpud-unix cpu-ns=pid"}, want: []string{"unix"}},
+		{listens: listFlag{"systemd:cpud-tcp", "systemd:cpud-unix"}, want: []string{"tcp", "unix"}},
+		{listens: listFlag{"systemd:cpud-vsock"}, err: true},
+	} {
+		listens = tt.listens
+		lns, err := listeners()
+		if (err != nil) != tt.err {
+			t.Errorf("%q: listeners: %v, want error %v", tt.listens, err, tt.err)
+			continue
+		}
+		var got []string
+		for _, ln := range lns {
+			got = append(got, ln.Addr().Network())
+			ln.Close()
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%q: listeners on %q, want %q", tt.listens, got, tt.want)
+		}
+	}
+	// Closing the listeners leaves the sockets open, to pass on.
+	if err := passActivated(); err != nil {
+		t.Fatalf("passActivated: %v", err)
+	}
+	if got, want := os.Getenv("LISTEN_FDNAMES"), "cpud-tcp:cpud-unix"; got != want {
+		t.Errorf("LISTEN_FDNAMES: %q, want %q", got, want)
+	}
+	if _, err := activatedListeners(""); err != nil {
+		t.Errorf("activatedListeners after the listeners closed: %v", err)
+	}
+}
+
+func TestActivation(t *testing.T) {
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	ul, err := net.Listen("unix", filepath.Join(t.TempDir(), "cpud.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ul.Close()
+	var files []*os.File
+	for _, ln := range []interface{ File() (*os.File, error) }{tl.(*net.TCPListener), ul.(*net.UnixListener)} {
+		f, err := ln.File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+
+	c := exec.Command(os.Args[0], "-test.run=^TestActivationChild$", "-test.v")
+	c.Env = append(os.Environ(), "CPUD_TEST_ACTIVATION=1", "LISTEN_FDS=2", "LISTEN_FDNAMES=cpud-tcp:cpud-unix")
+	c.ExtraFiles = files
+	out, err := c.CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "--- PASS: TestActivationChild") {
+		t.Errorf("TestActivationChild: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notInConfig are the flags a configuration file can not set.
+var notInConfig = map[string]bool{"config": true, "remote": true, "port9p": true}
+
+// liveFlags are the flags whose changes take effect when the
+// configuration file is reloaded. Changes to others, e.g. keys,
+// listeners and mDNS, take effect when cpud restarts, on SIGHUP.
+var liveFlags = map[string]bool{
+	"limits": true, "cgroup": true, "acct": true,
+	"ns": true, "nsallow": true,
+	"caps": true, "nnp": true, "seccomp": true, "seccompdir": true,
+	"forward": true, "users": true, "defaultuser": true, "rootless": true,
+	"maxsessions": true, "maxsessionsper": true, "maxstartups": true, "logingrace": true,
+	"authbackoff": true, "authbackoffmax": true, "maxsessiontime": true,
+	"drain": true,
+}
+
+// parseConfig parses a configuration file. Each line is the name of a
+// flag, and its value, which is the rest of the line, e.g.
+//
+//	pk /etc/cpud/authorized_keys
+//	forward allow local *.lab:22; deny any *:*
+//	nnp
+//
+// A flag with no value, such as nnp, is true. Blank lines, and lines
+// starting with #, are ignored. The values of a flag named on more than
+// one line, such as listen, are joined by newlines.
+func parseConfig(data []byte) (map[string]string, error) {
+	c := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+		if len(l) == 0 || l[0] == '#' {
+			continue
+		}
+		name, val := l, "true"
+		if i := strings.IndexAny(l, " \t"); i > 0 {
+			name, val = l[:i], strings.TrimSpace(l[i+1:])
+		}
+		name = strings.TrimLeft(name, "-")
+		if v, dup := c[name]; dup {
+			val = v + "\n" + val
+		}
+		c[name] = val
+	}
+	return c, s.Err()
+}
+
+// listFlag is a flag that can be set more than once. Its value is
+// the values it was set to, one per line.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, "\n")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// setFlag sets a flag to a value, as String returns it.
+func setFlag(f *flag.Flag, v string) error {
+	l, ok := f.Value.(*listFlag)
+	if !ok {
+		return f.Value.Set(v)
+	}
+	*l = nil
+	if v != "" {
+		*l = strings.Split(v, "\n")
+	}
+	return nil
+}
+
+// config is the configuration file of cpud, and the flags it sets.
+type config struct {
+	file string
+	// required is true if the file must exist, as it does if it
+	// is named on the command line.
+	required bool
+	fs       *flag.FlagSet
+	// cmdline are the flags set on the command line, which the
+	// file does not override.
+	cmdline map[string]bool
+	// mu is held while flags are set.
+	mu sync.Mutex
+	// stat is the file as it was last loaded, or nil.
+	stat os.FileInfo
+}
+
+func newConfig(file string, fs *flag.FlagSet) *config {
+	c := &config{file: file, fs: fs, cmdline: map[string]bool{}}
+	fs.Visit(func(f *flag.Flag) {
+		c.cmdline[f.Name] = true
+	})
+	c.required = c.cmdline["config"]
+	return c
+}
+
+// load sets the flags named in the configuration file to their values
+// in it, and any others that were set by it before to their defaults,
+// unless they are set on the command line. It returns the values the
+// flags that changed had before. If a value is not valid, no flag
+// changes.
+func (c *config) load() (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fi, err := os.Stat(c.file)
+	if errors.Is(err, os.ErrNotExist) && !c.required {
+		fi, err = nil, nil
+	}
+	// A file that is not valid is not loaded again until it changes.
+	c.stat = fi
+	if err != nil {
+		return nil, err
+	}
+	vals := map[string]string{}
+	if fi != nil {
+		data, err := os.ReadFile(c.file)
+		if err != nil {
+			return nil, err
+		}
+		if vals, err = parseConfig(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", c.file, err)
+		}
+	}
+	for name, v := range vals {
+		f := c.fs.Lookup(name)
+		if f == nil || notInConfig[name] {
+			return nil, fmt.Errorf("%s: %q is not a flag a configuration file can set:%w", c.file, name, os.ErrInvalid)
+		}
+		if _, ok := f.Value.(*listFlag); !ok && strings.Contains(v, "\n") {
+			return nil, fmt.Errorf("%s: %q is set more than once:%w", c.file, name, strconv.ErrSyntax)
+		}
+	}
+
+	old := map[string]string{}
+	c.fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || c.cmdline[f.Name] || notInConfig[f.Name] {
+			return
+		}
+		want, ok := vals[f.Name]
+		if !ok {
+			want = f.DefValue
+		}
+		if f.Value.String() == want {
+			return
+		}
+		old[f.Name] = f.Value.String()
+		if err = setFlag(f, want); err != nil {
+			err = fmt.Errorf("%s: %s %q: %w", c.file, f.Name, want, err)
+		}
+	})
+	if err != nil {
+		c.restore(old)
+		return nil, err
+	}
+	return old, nil
+}
+
+// restore sets flags back to the values load returned.
+func (c *config) restore(old map[string]string) {
+	for name, v := range old {
+		setFlag(c.fs.Lookup(name), v) //nolint
+	}
+}
+
+// modified returns true if the file changed since it was loaded.
+func (c *config) modified() bool {
+	fi, err := os.Stat(c.file)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil || c.stat == nil {
+		return (err == nil) != (c.stat != nil)
+	}
+	return !os.SameFile(fi, c.stat) || !fi.ModTime().Equal(c.stat.ModTime()) || fi.Size() != c.stat.Size()
+}
+
+// watch checks the file every poll and, when it changes, loads it and
+// calls reload, if any flags that take effect without a restart
+// changed. If reload fails, the flags are set back.
+func (c *config) watch(poll time.Duration, reload func() error) {
+	for range time.Tick(poll) {
+		if !c.modified() {
+			continue
+		}
+		old, err := c.load()
+		if err != nil {
+			log.Printf("CPUD:configuration not reloaded: %v", err)
+			continue
+		}
+		var live, restart []string
+		for name := range old {
+			if liveFlags[name] {
+				live = append(live, name)
+			} else {
+				restart = append(restart, name)
+			}
+		}
+		sort.Strings(live)
+		sort.Strings(restart)
+		if len(restart) > 0 {
+			log.Printf("CPUD:configuration: %q change when cpud restarts, on SIGHUP", restart)
+		}
+		if len(live) == 0 {
+			continue
+		}
+		c.mu.Lock()
+		if err = reload(); err != nil {
+			c.restore(old)
+		}
+		c.mu.Unlock()
+		if err != nil {
+			log.Printf("CPUD:configuration: %q not reloaded: %v", live, err)
+			continue
+		}
+		log.Printf("CPUD:configuration: reloaded %q", live)
+	}
+}
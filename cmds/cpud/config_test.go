@@ -0,0 +1,117 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		in   string
+		want map[string]string
+		err  bool
+	}{
+		{name: "empty", in: "", want: map[string]string{}},
+		{name: "comments", in: "# cpud\n\n  # more\n", want: map[string]string{}},
+		{name: "values", in: "pk /etc/cpud/keys\nforward allow local *.lab:22; deny any *:*\n", want: map[string]string{"pk": "/etc/cpud/keys", "forward": "allow local *.lab:22; deny any *:*"}},
+		{name: "tabs and dashes", in: "-sp\t\t17011  \n--ns pid,net", want: map[string]string{"sp": "17011", "ns": "pid,net"}},
+		{name: "bool", in: "nnp\n", want: map[string]string{"nnp": "true"}},
+		{name: "twice", in: "listen tcp::17010\nlisten vsock:17010\n", want: map[string]string{"listen": "tcp::17010\nvsock:17010"}},
+	} {
+		got, err := parseConfig([]byte(tt.in))
+		if (err != nil) != tt.err {
+			t.Errorf("%s: parseConfig: %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseConfig: %q != %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConfigLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cpud.conf")
+	fs := flag.NewFlagSet("cpud", flag.ContinueOnError)
+	sp := fs.String("sp", "17010", "")
+	ns := fs.String("ns", "", "")
+	nnp := fs.Bool("nnp", false, "")
+	drain := fs.Duration("drain", time.Minute, "")
+	var listen listFlag
+	fs.Var(&listen, "listen", "")
+	fs.String("config", "", "")
+	fs.Bool("remote", false, "")
+	if err := fs.Parse([]string{"-sp", "17011"}); err != nil {
+		t.Fatal(err)
+	}
+	c := newConfig(file, fs)
+
+	for _, tt := range []struct {
+		name    string
+		conf    string
+		err     bool
+		changed []string
+		sp, ns  string
+		nnp     bool
+		drain   time.Duration
+		listen  listFlag
+	}{
+		{name: "no file", sp: "17011", drain: time.Minute},
+		{name: "set", conf: "sp 1\nns pid\nnnp\ndrain 5s\n", changed: []string{"drain", "nnp", "ns"}, sp: "17011", ns: "pid", nnp: true, drain: 5 * time.Second},
+		{name: "reset", conf: "ns pid\n", changed: []string{"drain", "nnp"}, sp: "17011", ns: "pid", drain: time.Minute},
+		{name: "not valid", conf: "ns uts\nnnp maybe\n", err: true, sp: "17011", ns: "pid", drain: time.Minute},
+		{name: "unknown", conf: "nope 1\n", err: true, sp: "17011", ns: "pid", drain: time.Minute},
+		{name: "remote", conf: "remote\n", err: true, sp: "17011", ns: "pid", drain: time.Minute},
+		{name: "list", conf: "ns pid\nlisten tcp::1\nlisten unix:/s\n", changed: []string{"listen"}, sp: "17011", ns: "pid", drain: time.Minute, listen: listFlag{"tcp::1", "unix:/s"}},
+		{name: "list changed", conf: "ns pid\nlisten tcp::2\n", changed: []string{"listen"}, sp: "17011", ns: "pid", drain: time.Minute, listen: listFlag{"tcp::2"}},
+		{name: "twice", conf: "ns pid\nns net\nlisten tcp::2\n", err: true, sp: "17011", ns: "pid", drain: time.Minute, listen: listFlag{"tcp::2"}},
+	} {
+		if tt.name != "no file" {
+			if err := os.WriteFile(file, []byte(tt.conf), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		old, err := c.load()
+		if (err != nil) != tt.err {
+			t.Errorf("%s: load: %v, want error %v", tt.name, err, tt.err)
+		}
+		var changed []string
+		for name := range old {
+			changed = append(changed, name)
+		}
+		if len(changed) > 0 || len(tt.changed) > 0 {
+			sort.Strings(changed)
+			if !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("%s: changed %q, want %q", tt.name, changed, tt.changed)
+			}
+		}
+		if *sp != tt.sp || *ns != tt.ns || *nnp != tt.nnp || *drain != tt.drain {
+			t.Errorf("%s: sp %q ns %q nnp %v drain %v, want %q %q %v %v", tt.name, *sp, *ns, *nnp, *drain, tt.sp, tt.ns, tt.nnp, tt.drain)
+		}
+		if !reflect.DeepEqual(listen, tt.listen) {
+			t.Errorf("%s: listen %q, want %q", tt.name, listen, tt.listen)
+		}
+		if c.modified() {
+			t.Errorf("%s: modified after load: true, want false", tt.name)
+		}
+	}
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if !c.modified() {
+		t.Errorf("modified after removing the file: false, want true")
+	}
+	c.required = true
+	if _, err := c.load(); err == nil {
+		t.Errorf("load of a required file that does not exist: nil, want an error")
+	}
+}
@@ -13,22 +13,186 @@
 // Options:
 //
 //		-d    enable debug prints
+//		-config string
+//		      configuration file (default "/etc/cpud.conf"). Each line
+//		      is a flag name and its value, e.g.
+//		          pk /etc/cpud/authorized_keys
+//		          forward allow local *.lab:22; deny any *:*
+//		          nnp
+//		      A flag with no value is true; lines starting with # are
+//		      comments. Flags on the command line override the file.
+//		      The default file need not exist.
+//		-configpoll duration
+//		      how often to check the configuration file for changes
+//		      (default 5s; 0: never). Changes to session policies,
+//		      namespaces, limits and forwards are reloaded, and apply
+//		      to new sessions; changes to keys, listeners and mDNS
+//		      apply when cpud restarts, on SIGHUP.
 //		-dbg9p
 //		      show 9p io
 //		-hostkey string
 //		      host key file
+//		-hostkeydir string
+//		      directory of host keys, used with no host key file
+//		      (default /var/lib/cpud or, if cpud is not run by root,
+//		      $HOME/.local/state/cpud). Keys named ssh_host_TYPE_key
+//		      are used; those of -hostkeytypes are generated, on the
+//		      first start, if they do not exist. All the keys in it,
+//		      ssh_host_*_key, are announced to clients, with the
+//		      hostkeys-00@openssh.com extension, so that OpenSSH
+//		      clients with UpdateHostKeys learn them. To replace a
+//		      key, add the new one as, e.g., ssh_host_ed25519_next_key;
+//		      once clients have learned it, rename it to
+//		      ssh_host_ed25519_key, and restart cpud with SIGHUP.
+//		      Clients then forget the old key.
+//		-hostkeytypes string
+//		      types of host keys to generate: ed25519, ecdsa and rsa
+//		      (default "ed25519,ecdsa")
 //		-key string
 //		      key file (default "$HOME/.ssh/cpu_rsa")
 //		-network string
 //		      network to use (default "tcp")
 //		-p string
 //		      port to use (default "17010")
+//		-listen string
+//		      listen on network:address, e.g. tcp::17010, vsock:17010
+//		      or unix:/run/cpud.sock, instead of -network and -p. It
+//		      may be repeated; all listeners are served at once. After
+//		      white space, a listener can have options for its
+//		      connections, as a key does, e.g.
+//		          -listen 'tcp::17010 cpu-forward="deny any *:*",cpu-ns=pid'
+//		      They can be cpu-limits, cpu-ns, cpu-nsallow, cpu-caps,
+//		      cpu-seccomp, cpu-forward and cpu-user, and only restrict
+//		      what the key, or cpud, allows: the lower limits, the
+//		      namespaces and capabilities both allow, the forwards
+//		      both allow, and both seccomp profiles apply. A key for
+//		      another cpu-user is refused. In a configuration file,
+//		      each listener is on its own listen line.
+//		      systemd:NAME is the sockets systemd passed to cpud,
+//		      with FileDescriptorName=NAME, in socket activation.
+//		      With no -listen, cpud serves all the sockets systemd
+//		      passed to it, if any. They stay open while cpud drains
+//		      and restarts, on SIGHUP, so no connection is refused.
 //		-port9p string
 //		      port9p # on remote machine for 9p mount
 //		-remote
 //		      Indicates we are the remote side of the cpu session
 //		-srv string
 //		      what server to run (default none; use internal)
+//		-limits string
+//		      cgroup v2 resource limits for each session, as a
+//		      comma-separated list of file=value pairs, e.g.
+//		      cpu.weight=50,cpu.max=50000 100000,memory.max=1G,pids.max=512,
+//		      io.max=8:0 rbps=1048576 wbps=1048576
+//		      A key in the authorized keys file can have its own limits,
+//		      with the cpu-limits="..." option. Clients can request
+//		      limits, which are capped by the limits for their key.
+//		-cgroup string
+//		      cgroup v2 directory for session cgroups (default: the cgroup of cpud)
+//		-acct
+//		      put each session in a cgroup, even with no limits, and log
+//		      its resource usage when it ends
+//		-ns string
+//		      namespaces every session runs in, as a comma-separated
+//		      list of pid, net, uts, ipc, user and veth, e.g. pid,net
+//		      A key can have its own with the cpu-ns="..." option.
+//		-nsallow string
+//		      namespaces a client may request for its session
+//		      (default "pid,net,uts,ipc"). veth also needs a bridge,
+//		      given as bridge=br0; usermap=inside:outside:count sets
+//		      the id map for user namespaces. A key can have its
+//		      own with the cpu-nsallow="..." option.
+//		-caps string
+//		      capabilities to keep in the bounding set of session
+//		      commands, e.g. chown,net_bind_service, or none
+//		      (default: all). A key can have its own with the
+//		      cpu-caps="..." option.
+//		-nnp
+//		      set no_new_privs for session commands
+//		-seccomp string
+//		      seccomp profile for session commands: default, the
+//		      built-in profile, which denies mount, reboot, module
+//		      loading, ptrace, new namespaces, io_uring and the like,
+//		      or a file in -seccompdir. A profile file is "allow" or
+//		      "deny" followed by syscall names; in a deny profile,
+//		      clone:ns denies clone into new namespaces, and clone3.
+//		      A key can select its own profile with the
+//		      cpu-seccomp="..." option.
+//		-seccompdir string
+//		      directory of seccomp profiles
+//		-passwords string
+//		      file of users allowed password and keyboard-interactive
+//		      authentication, with lines of user:hash[:totp]; hash is
+//		      a bcrypt hash, as htpasswd -nB user makes, or an argon2id
+//		      hash in the PHC format, and totp, if set, the base32
+//		      secret of a TOTP authenticator, whose code is asked for
+//		      too. The file is read for each attempt. It is meant for
+//		      lab machines, on which managing keys is overkill, and
+//		      -pk "" too weak. (default: none)
+//		-forward string
+//		      port forwarding policy: rules separated by ; each
+//		      allow|deny local|remote|any host:port [user], e.g.
+//		      allow local *.lab:22 glenda; allow remote localhost:8000-8999
+//		      The first rule that matches a forward decides; others
+//		      are denied. Hosts and users are patterns; ports are *,
+//		      a port or a range. cpu's 9P and NFS back-channels are
+//		      always allowed. A key can have its own policy with the
+//		      cpu-forward="..." option. (default: allow remote
+//		      forwards, deny local forwards)
+//		-users string
+//		      run sessions as local accounts, not as cpud, with their
+//		      groups, HOME, USER, LOGNAME and SHELL. The account is
+//		      named by the first of, in the order given, e.g. key,name:
+//		      key, the cpu-user="..." option of the authorized key;
+//		      principal, the principal of a user certificate, signed
+//		      by a key with the cert-authority option; name, the SSH
+//		      user name. cpud must be root. The SSH user name is
+//		      chosen by the client, so it is only used if it is the
+//		      account named by the key or principal, if either names
+//		      one, and never for uid 0 unless one does.
+//		-defaultuser string
+//		      account for sessions that have no local account from
+//		      -users (default: refuse them)
+//		-rootless
+//		      run each session in a new user and mount namespace, in
+//		      which the user running cpud is mapped to itself, so that
+//		      cpud need not be root (default: true if cpud is not run
+//		      by root, on Linux). The client namespace is mounted with
+//		      FUSE, so /dev/fuse must be usable; the kernel 9P client
+//		      can not be mounted in a user namespace.
+//		-maxsessions int
+//		      most sessions that can run at once (default: no limit)
+//		-maxsessionsper int
+//		      most sessions that can run at once for one identity: an
+//		      authorized key, a certificate principal or, with no key
+//		      file, an SSH user name (default: no limit)
+//		-maxstartups int
+//		      most connections that can be waiting to authenticate;
+//		      more are refused (default: no limit)
+//		-logingrace duration
+//		      longest a connection can take to authenticate before it
+//		      is closed; 0 is no limit (default 2m0s)
+//		-authbackoff duration
+//		      refuse connections from a host that failed to
+//		      authenticate for this long, doubling with each further
+//		      failure, up to -authbackoffmax (default: never)
+//		-authbackoffmax duration
+//		      longest a host is refused; its failures are forgotten
+//		      after this long with no new one (default 5m0s)
+//		-maxsessiontime duration
+//		      longest a session can run before it is ended
+//		      (default: no limit)
+//		      Refused connections get an SSH disconnect, and refused
+//		      or ended sessions a message on stderr, saying why.
+//		-drain duration
+//		      on SIGHUP, cpud drains: it stops accepting connections,
+//		      withdraws its mDNS advertisement, unless -dsDrain is
+//		      false, and tells running sessions it is restarting.
+//		      Sessions still running after this long are ended. cpud
+//		      then re-executes itself, with the same arguments and
+//		      pid, so that it reads its configuration again, and stays
+//		      pid 1 if it is init.
+//		      (default 1m0s)
 //
 //	     For registering with a controller
 //	     -register netaddr
@@ -51,6 +215,8 @@
 //				  DNSSD Interface
 //			- dsTxt
 //				  Additional string key-value pair meta-data for host
+//			- dsDrain (default true)
+//				  withdraw the advertisement while draining, on SIGHUP
 //
 // cpud is the daemon side of a cpu session.
 // In the original Plan 9 implementation, cpu was a command that contained
@@ -125,4 +291,11 @@
 //
 // These rules make cpud easy to run as init, and as a daemon from the command
 // line, while also simplifying the init code.
+//
+// cpud also serves the sftp subsystem, for sftp and scp, which OpenSSH
+// runs over SFTP. The SFTP server is cpud, run as the command of a
+// session, with cpud -sftp; it is in the namespaces, cgroup and
+// confinement of a session, as its user. A client that serves its
+// namespace over 9P, and sets CPU_PORT9P to the port, as well as
+// CPUNONCE, can copy to and from its files in /tmp/cpu.
 package main
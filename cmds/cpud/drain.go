@@ -0,0 +1,91 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/u-root/cpu/server"
+)
+
+// restartEnv is set in the environment of a cpud that drained and
+// re-executed itself, so that it does not set up the node again.
+const restartEnv = "CPUD_RESTARTED"
+
+// stopGrace is how long sessions have to end once they are stopped,
+// after which their connections are closed.
+const stopGrace = 5 * time.Second
+
+// restarted returns true if cpud was re-executed after draining. It
+// is only true once; the variable that says so is not passed on.
+func restarted() bool {
+	r := os.Getenv(restartEnv) == "1"
+	os.Unsetenv(restartEnv)
+	return r
+}
+
+// drain stops s accepting connections on lns, and stops the modifiers,
+// which may withdraw the mDNS advertisement, then waits for its
+// sessions to end. Sessions still running after timeout are stopped.
+// lns are closed here, as well as by s, in case s has not started to
+// serve them yet.
+func drain(s *ssh.Server, lns []net.Listener, reg *server.Registry, timeout time.Duration) {
+	for _, ln := range lns {
+		if err := ln.Close(); err != nil {
+			verbose("draining: closing %v: %v", ln.Addr(), err)
+		}
+	}
+	for _, m := range modifiers {
+		if m.stop != nil {
+			verbose("stop modifier %s", m)
+			m.stop()
+		}
+	}
+	if n := reg.Len(); n > 0 {
+		log.Printf("CPUD:draining: waiting up to %v for %d sessions", timeout, n)
+		reg.Notify(fmt.Sprintf("cpud is restarting; sessions still running in %v will be ended", timeout))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err == nil {
+		return
+	}
+	for _, si := range reg.Sessions() {
+		reg.Stop(si.ID, "cpud is restarting; session ended") //nolint
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), stopGrace)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		log.Printf("CPUD:draining: closing %v connections that did not end", err)
+		s.Close() //nolint
+	}
+}
+
+// reexec replaces cpud with a new cpud, with the same arguments,
+// which reads its configuration anew. The executable is found again,
+// so a cpud that was upgraded runs the new one. The pid stays the
+// same, so a cpud that is init remains pid 1. Sockets from systemd
+// are passed on, with connections waiting in them.
+func reexec() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := os.Setenv(restartEnv, "1"); err != nil {
+		return err
+	}
+	if err := passActivated(); err != nil {
+		return err
+	}
+	log.Printf("CPUD:restarting %q", exe)
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
@@ -39,6 +39,15 @@ func cpuSetup() error {
 	//	libinit.SetEnv()
 	// 	libinit.CreateRootfs()
 	libinit.NetInit()
+	reap()
+
+	runtime.UnlockOSThread()
+	return nil
+}
+
+// reap waits for orphans, forever. It is also called by a cpud that
+// is re-executed as init, which is set up already.
+func reap() {
 	// Wait for orphans, forever.
 	// Since there is no way of knowning when we are
 	// done for good, our work here is never done.
@@ -75,7 +84,4 @@ func cpuSetup() error {
 			numReaped++
 		}
 	}()
-
-	runtime.UnlockOSThread()
-	return nil
 }
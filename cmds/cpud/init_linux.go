@@ -17,9 +17,8 @@ package main
 import (
 	"log"
 	"runtime"
-	"syscall"
-	"time"
 
+	"github.com/u-root/cpu/session"
 	"github.com/u-root/u-root/pkg/libinit"
 )
 
@@ -39,43 +38,14 @@ func cpuSetup() error {
 	libinit.SetEnv()
 	libinit.CreateRootfs()
 	libinit.NetInit()
-	// Wait for orphans, forever.
-	// Since there is no way of knowning when we are
-	// done for good, our work here is never done.
-	// A complication is that for long periods of time, there
-	// may be no orphans.In that case, sleep for one second,
-	// and try again. This background load is hardly enough
-	// to matter. And, in general, it will happen by definition
-	// when there is nothing to wait for, i.e. there is nothing
-	// on the node to be upset about.
-	// Were this ever to be a concern, an option is to kick off
-	// a process that will never exit, such that wait4 will always
-	// block and always return when any child process exits.
-	go func() {
-		var numReaped int
-		for {
-			var (
-				s syscall.WaitStatus
-				r syscall.Rusage
-			)
-			p, err := syscall.Wait4(-1, &s, 0, &r)
-			// Once per second, Wait 4 returns if there's nothing
-			// else to do.
-			if err != nil && err.Error() == "no child processes" {
-				continue
-			}
-			verbose("orphan reaper: returns with %v", p)
-			if p == -1 {
-				verbose("Nothing to wait for, %d wait for so far", numReaped)
-				time.Sleep(time.Second)
-			}
-			if err != nil {
-				log.Printf("CPUD: a process exited with %v, status %v, rusage %v, err %v", p, s, r, err)
-			}
-			numReaped++
-		}
-	}()
+	reap()
 
 	runtime.UnlockOSThread()
 	return nil
 }
+
+// reap waits for orphans, forever. It is also called by a cpud that
+// is re-executed as init, which is set up already.
+func reap() {
+	go session.Reap(0)
+}
@@ -14,6 +14,7 @@ import (
 	// It can not, however, unpack password-protected keys yet.
 
 	"github.com/u-root/cpu/session"
+	"github.com/u-root/cpu/sftp"
 )
 
 var (
@@ -22,6 +23,10 @@ var (
 	pubKeyFile  = flag.String("pk", "key.pub", "file for public key")
 	port        = flag.String("sp", "17010", "cpu default port")
 
+	// Without -hk, host keys are kept, and generated, in a directory.
+	hostKeyDir   = flag.String("hostkeydir", stateDir(), "directory of host keys, generated if need be, used with no -hk")
+	hostKeyTypes = flag.String("hostkeytypes", "ed25519,ecdsa", "types of host keys to generate: ed25519, ecdsa and rsa")
+
 	debug     = flag.Bool("d", false, "enable debug prints")
 	runAsInit = flag.Bool("init", false, "run as init (Debug only; normal test is if we are pid 1")
 	// v allows debug printing.
@@ -29,9 +34,56 @@ var (
 	v       = func(string, ...interface{}) {}
 	remote  = flag.Bool("remote", false, "indicates we are the remote side of the cpu session")
 	network = flag.String("net", "tcp", "network to use")
+	// listens are the listeners, network:address and options, which,
+	// if there are any, cpud listens on instead of -net and -sp.
+	listens listFlag
 	port9p  = flag.String("port9p", "", "port9p # on remote machine for 9p mount")
 	klog    = flag.Bool("klog", false, "Log cpud messages in kernel log, not stdout")
 
+	// The configuration file, which sets flags not set on the command line.
+	configFile = flag.String("config", "/etc/cpud.conf", "configuration file: lines of a flag name and its value")
+	configPoll = flag.Duration("configpoll", 5*time.Second, "how often to check the configuration file for changes, which are reloaded (0: never)")
+
+	// Resource limits and accounting for sessions.
+	limits    = flag.String("limits", "", "cgroup v2 resource limits for each session, e.g. memory.max=1G,pids.max=512")
+	cgroupDir = flag.String("cgroup", "", "cgroup v2 directory for session cgroups (default: the cgroup of cpud)")
+	acct      = flag.Bool("acct", false, "put each session in a cgroup and log its resource usage, even with no limits")
+
+	// Namespaces for sessions.
+	nsRequired = flag.String("ns", "", "namespaces every session runs in, e.g. pid,net,uts,ipc,user")
+	nsAllowed  = flag.String("nsallow", "pid,net,uts,ipc", "namespaces a client may request, plus bridge= and usermap= settings")
+
+	// Confinement of session commands.
+	caps       = flag.String("caps", "", "capabilities to keep in the bounding set of sessions, e.g. chown,net_bind_service, or none (default: all)")
+	noNewPrivs = flag.Bool("nnp", false, "set no_new_privs for sessions")
+	seccomp    = flag.String("seccomp", "", "seccomp profile for sessions: default, or a file in -seccompdir")
+	seccompDir = flag.String("seccompdir", "", "directory of seccomp profiles")
+
+	// Password and keyboard-interactive authentication.
+	passwords = flag.String("passwords", "", "file of user:hash[:totp] lines, for password authentication (default: none)")
+
+	// Port forwarding.
+	forwards = flag.String("forward", "", "port forwarding policy, e.g. allow local *.lab:22; deny any *:* (default: allow remote forwards only)")
+
+	// Local accounts for sessions.
+	users       = flag.String("users", "", "run sessions as local accounts, named by key, principal or name, e.g. key,name; a name must be the account of the key or principal, if any, and not uid 0 unless it is")
+	defaultUser = flag.String("defaultuser", "", "account for sessions with no local account of their own (default: refuse them)")
+
+	// Limits on connections and sessions.
+	maxSessions    = flag.Int("maxsessions", 0, "most sessions that can run at once (default: no limit)")
+	maxPerIdentity = flag.Int("maxsessionsper", 0, "most sessions that can run at once for one key or principal (default: no limit)")
+	maxStartups    = flag.Int("maxstartups", 0, "most connections that can be waiting to authenticate (default: no limit)")
+	loginGrace     = flag.Duration("logingrace", 2*time.Minute, "longest a connection can take to authenticate (0: no limit)")
+	authBackoff    = flag.Duration("authbackoff", 0, "refuse connections from a host that failed to authenticate for this long, doubling with each failure (default: never)")
+	authBackoffMax = flag.Duration("authbackoffmax", 5*time.Minute, "longest time a host that fails to authenticate is refused")
+	maxSessionTime = flag.Duration("maxsessiontime", 0, "longest a session can run (default: no limit)")
+
+	// Restarting, on SIGHUP.
+	drainTimeout = flag.Duration("drain", time.Minute, "on SIGHUP, how long sessions have to end before they are ended and cpud restarts")
+
+	// Rootless sessions, for a cpud that is not run by root.
+	rootless = flag.Bool("rootless", false, "run each session in a user namespace, so cpud need not be root")
+
 	// Some networks are not well behaved, and for them we implement registration.
 	registerAddr = flag.String("register", "", "address and port to register with after listen on cpu server port")
 	registerTO   = flag.Duration("registerTO", time.Duration(5*time.Second), "time.Duration for Dial address for registering")
@@ -42,6 +94,9 @@ var (
 	sleepBeforeServing = flag.Duration("sleepBeforeServing", 0, "add a sleep before serving -- usually only needed if cpud runs as init with mDNS")
 
 	pid1 bool
+
+	// cfg is the configuration file of the server.
+	cfg *config
 )
 
 func verbose(f string, a ...interface{}) {
@@ -59,6 +114,10 @@ func verbose(f string, a ...interface{}) {
 //     a client. Indicated by remote=true.
 //
 // case (3) overrides case 2 and 1.
+func init() {
+	flag.Var(&listens, "listen", "listen on network:address, with options for its connections, e.g. tcp::17010 cpu-forward=\"deny any *:*\" (may be repeated; default: -net and -sp)")
+}
+
 // This has evolved over the years, and, likely, the init and remote
 // switches ought to be renamed to 'role'. But so it goes.
 // The rules on arguments are very strict now. In the remote case,
@@ -66,6 +125,18 @@ func verbose(f string, a ...interface{}) {
 // the args to remote and the args to server are different.
 // This invocation requirement is known to the server package.
 func main() {
+	// The init of a session's namespaces is cpud, re-executed.
+	if len(os.Args) > 1 && os.Args[1] == session.NSInitArg {
+		os.Exit(session.NSInit(os.Args[2:]))
+	}
+	// So is the helper that confines a session's command.
+	if len(os.Args) > 1 && os.Args[1] == session.ConfineArg {
+		os.Exit(session.Confine(os.Args[2:]))
+	}
+	// And so is the SFTP server of an sftp subsystem session.
+	if len(os.Args) > 1 && os.Args[1] == sftp.SubsystemArg {
+		os.Exit(sftp.Main())
+	}
 	if len(os.Args) > 1 && (os.Args[1] == "-remote" || os.Args[1] == "-remote=true") {
 		*remote = true
 	}
@@ -90,6 +161,10 @@ func main() {
 		flag.Parse()
 		// If we are here, no matter what they may set, *remote must be false.
 		*remote = false
+		cfg = newConfig(*configFile, flag.CommandLine)
+		if _, err := cfg.load(); err != nil {
+			log.Fatal(err)
+		}
 		if err := commonsetup(); err != nil {
 			log.Fatal(err)
 		}
@@ -117,7 +192,13 @@ func main() {
 		}
 	} else {
 		log.Printf("CPUD:PID(%d):running as a server (a.k.a. starter of cpud's for sessions)", pid)
-		if *runAsInit {
+		// A cpud that restarted after draining has set up already.
+		if restarted() {
+			log.Printf("CPUD:restarted")
+			if *runAsInit {
+				reap()
+			}
+		} else if *runAsInit {
 			log.Printf("CPUD:also running as init")
 			if err := initsetup(); err != nil {
 				log.Fatal(err)
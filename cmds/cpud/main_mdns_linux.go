@@ -25,11 +25,21 @@ var (
 	dsService   = flag.String("dsService", "_ncpu._tcp", "DNSSD Service Type")
 	dsInterface = flag.String("dsInterface", "", "DNSSD Interface")
 	dsTxtStr    = flag.String("dsTxt", "", "DNSSD key-value pair string parameterizing advertisement")
+	dsDrain     = flag.Bool("dsDrain", true, "withdraw the DNSSD advertisement while draining, on SIGHUP")
 	dsTxt       map[string]string
 )
 
 func init() {
-	modifiers = append(modifiers, &modifier{f: servemDNS, name: "mDNS"})
+	modifiers = append(modifiers, &modifier{f: servemDNS, stop: stopmDNS, name: "mDNS"})
+}
+
+// stopmDNS withdraws the advertisement, unless -dsDrain=false. Then
+// clients keep finding cpud while it drains and restarts, and their
+// connections wait for the new cpud, if its sockets are passed on.
+func stopmDNS() {
+	if *dsDrain {
+		ds.Unregister()
+	}
 }
 
 type handleWrapper struct {
@@ -5,7 +5,7 @@
 package main
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -13,7 +13,9 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -22,6 +24,7 @@ import (
 	"github.com/gliderlabs/ssh"
 	"github.com/mdlayher/vsock"
 	"github.com/u-root/cpu/server"
+	"github.com/u-root/cpu/session"
 )
 
 const any = math.MaxUint32
@@ -29,6 +32,8 @@ const any = math.MaxUint32
 type modifier struct {
 	name string
 	f    func(*ssh.Server) error
+	// stop, if not nil, undoes what f did, when cpud drains.
+	stop func()
 }
 
 func (m *modifier) String() string {
@@ -58,9 +63,23 @@ func hang() {
 	log.Printf("done hang")
 }
 
+// stateDir is the directory cpud keeps its state, e.g. host keys, in:
+// /var/lib/cpud or, if cpud is not run by root, $HOME/.local/state/cpud.
+func stateDir() string {
+	if os.Geteuid() == 0 {
+		return "/var/lib/cpud"
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "state", "cpud")
+}
+
 func commonsetup() error {
 	if *debug {
 		server.SetVerbose(verbose)
+		session.SetVerbose(verbose)
 		v = log.Printf
 		if *klog {
 			//ulog.KernelLog.Reinit()
@@ -68,7 +87,9 @@ func commonsetup() error {
 			//v = ulog.KernelLog.Printf
 		}
 	}
-	return nil
+	var err error
+	activated, err = activation()
+	return err
 }
 
 func initsetup() error {
@@ -132,6 +153,112 @@ func listen(network, port string) (net.Listener, error) {
 	return ln, err
 }
 
+// parseListen parses a listener, network:address, and the options for
+// its connections, if any, after white space, e.g.
+//
+//	tcp::17010
+//	tcp4:10.0.0.1:17010 cpu-forward="deny any *:*"
+//	vsock:17010
+//	unix:/run/cpud.sock cpu-user=nobody
+//	systemd:cpud-vsock cpu-forward="allow any *:*"
+//
+// The address of a systemd listener is the name of the sockets
+// systemd passed to cpud, from FileDescriptorName= in the socket unit.
+func parseListen(spec string) (network, addr, options string, err error) {
+	spec = strings.TrimSpace(spec)
+	if i := strings.IndexAny(spec, " \t"); i > 0 {
+		spec, options = spec[:i], strings.TrimSpace(spec[i+1:])
+	}
+	network, addr, ok := strings.Cut(spec, ":")
+	if !ok || len(network) == 0 || len(addr) == 0 {
+		return "", "", "", fmt.Errorf("listener %q: want network:address:%w", spec, strconv.ErrSyntax)
+	}
+	return network, addr, options, nil
+}
+
+// listenSpec listens on a listener, as parseListen parses it. The
+// address of a TCP listener can be a port, or a host and port. A
+// systemd listener can be several sockets.
+func listenSpec(spec string) ([]net.Listener, error) {
+	network, addr, options, err := parseListen(spec)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		lns []net.Listener
+		ln  net.Listener
+	)
+	switch {
+	case network == "systemd":
+		lns, err = activatedListeners(addr)
+	case strings.HasPrefix(network, "tcp") && strings.Contains(addr, ":"):
+		ln, err = net.Listen(network, addr)
+	case strings.HasPrefix(network, "unix"):
+		removeStale(network, addr)
+		ln, err = listen(network, addr)
+	default:
+		ln, err = listen(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ln != nil {
+		lns = []net.Listener{ln}
+	}
+	if len(options) == 0 {
+		return lns, nil
+	}
+	scoped := make([]net.Listener, len(lns))
+	for i, ln := range lns {
+		if scoped[i], err = server.ScopeListener(ln, options); err != nil {
+			closeAll(lns)
+			return nil, fmt.Errorf("listener %q: %w", spec, err)
+		}
+	}
+	return scoped, nil
+}
+
+// removeStale removes a Unix socket left by a cpud that did not close
+// it, if no one is listening on it.
+func removeStale(network, path string) {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if c, err := net.Dial(network, path); err == nil {
+		c.Close()
+		return
+	}
+	verbose("removing stale socket %q", path)
+	os.Remove(path) //nolint
+}
+
+// listeners listens on all the -listen listeners or, if there are none,
+// on the sockets systemd passed to cpud or, if there are none, on -net
+// and -sp. If any listener fails, those opened are closed.
+func listeners() ([]net.Listener, error) {
+	if len(listens) == 0 && len(activated) > 0 {
+		return activatedListeners("")
+	}
+	if len(listens) == 0 {
+		ln, err := listen(*network, *port)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{ln}, nil
+	}
+	var lns []net.Listener
+	for _, spec := range listens {
+		l, err := listenSpec(spec)
+		if err != nil {
+			closeAll(lns)
+			return nil, err
+		}
+		lns = append(lns, l...)
+	}
+	return lns, nil
+}
+
 func register(network, addr string, timeout time.Duration) error {
 	if len(addr) == 0 {
 		return nil
@@ -155,50 +282,132 @@ func register(network, addr string, timeout time.Duration) error {
 	return nil
 }
 
+// options returns the server options set by flags.
+func options() ([]server.Set, error) {
+	for _, ns := range []string{*nsRequired, *nsAllowed} {
+		if _, err := session.ParseNamespaces(ns); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := session.ParseCaps(*caps); err != nil {
+		return nil, err
+	}
+	if _, err := session.LoadProfile(*seccompDir, *seccomp); err != nil {
+		return nil, err
+	}
+	opts := []server.Set{
+		server.WithLimits(*limits),
+		server.WithNamespaces(*nsRequired, *nsAllowed),
+		server.WithCaps(*caps),
+		server.WithNoNewPrivs(*noNewPrivs),
+		server.WithSeccomp(*seccompDir, *seccomp),
+		server.WithRootless(*rootless),
+		server.WithForwards(*forwards),
+		server.WithMaxSessions(*maxSessions, *maxPerIdentity),
+		server.WithMaxStartups(*maxStartups),
+		server.WithLoginGrace(*loginGrace),
+		server.WithAuthBackoff(*authBackoff, *authBackoffMax),
+		server.WithMaxSessionTime(*maxSessionTime),
+		server.WithPasswordFile(*passwords),
+	}
+	if len(*users) > 0 {
+		opts = append(opts, server.WithUsers(*users, *defaultUser))
+	}
+	if *acct || len(*cgroupDir) > 0 {
+		opts = append(opts, server.WithCgroup(*cgroupDir))
+	}
+	return opts, nil
+}
+
 func serve(cpud string) error {
-	s, err := server.New(*pubKeyFile, *hostKeyFile, cpud)
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+	reg, rl := server.NewRegistry(), &server.Reloader{}
+	opts = append(opts, server.WithRegistry(reg), server.WithReloader(rl))
+	if len(*hostKeyDir) > 0 {
+		opts = append(opts, server.WithHostKeyDir(*hostKeyDir, strings.Split(*hostKeyTypes, ",")...))
+	}
+	s, err := server.New(*pubKeyFile, *hostKeyFile, cpud, opts...)
 	if err != nil {
 		log.Printf(`New(%q, %q): %v`, *pubKeyFile, *hostKeyFile, err)
 		hang()
 	}
 	verbose("Server is %v", s)
 
-	ln, err := listen(*network, *port)
+	lns, err := listeners()
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Listening on %v", ln.Addr())
+	for _, ln := range lns {
+		log.Printf("Listening on %v %v", ln.Addr().Network(), ln.Addr())
+	}
 
 	// register can return an error, but it should not block serving.
 	if err := register(*network, *registerAddr, *registerTO); err != nil {
 		verbose("Register(%v, %v, %d): %v", *network, *registerAddr, *registerTO, err)
 	}
 
-	// If there is a hup, we stop serving.
+	// If there is a hup, we stop serving, drain, and start again,
+	// with a new configuration.
 	sigs := make(chan os.Signal, 1)
 
 	signal.Notify(sigs, syscall.SIGHUP)
 
+	draining, drained := make(chan struct{}), make(chan struct{})
 	go func() {
 		sig := <-sigs
-		log.Printf("Received %v, Shutdown cpud listen ...", sig)
-		if err := s.Shutdown(context.Background()); err != nil {
-			log.Printf("Shutdown done")
-		}
+		log.Printf("Received %v, draining cpud ...", sig)
+		close(draining)
+		cfg.mu.Lock()
+		timeout := *drainTimeout
+		cfg.mu.Unlock()
+		drain(s, lns, reg, timeout)
+		close(drained)
 	}()
 
+	// Changes to the configuration file are reloaded.
+	if *configPoll > 0 {
+		go cfg.watch(*configPoll, func() error {
+			opts, err := options()
+			if err != nil {
+				return err
+			}
+			return rl.Reload(opts...)
+		})
+	}
+
 	for _, m := range modifiers {
 		if err := m.f(s); err != nil {
 			log.Printf("Error %v from modifier %s", err, m)
 		}
 	}
 
-	if err := s.Serve(ln); err != ssh.ErrServerClosed {
-		log.Printf("s.Daemon(): %v != %v", err, ssh.ErrServerClosed)
+	// All the listeners are served by s. A listener may be closed by
+	// drain before Serve starts.
+	served := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func(ln net.Listener) {
+			served <- s.Serve(ln)
+		}(ln)
+	}
+	for range lns {
+		if err := <-served; err != ssh.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+			log.Printf("s.Serve(): %v != %v", err, ssh.ErrServerClosed)
+		}
+	}
+	select {
+	case <-draining:
+	default:
 		hang()
 	}
+	<-drained
 	verbose("Daemon returns")
+	if err := reexec(); err != nil {
+		log.Printf("CPUD:restarting: %v", err)
+	}
 	hang()
 	return nil
 }
@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"syscall"
 	"testing"
 	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/u-root/cpu/server"
 )
 
 func TestListen(t *testing.T) {
@@ -79,6 +83,68 @@ func TestListen(t *testing.T) {
 	}
 }
 
+func TestParseListen(t *testing.T) {
+	for _, tt := range []struct {
+		spec                   string
+		network, addr, options string
+		err                    bool
+	}{
+		{spec: "tcp::17010", network: "tcp", addr: ":17010"},
+		{spec: "tcp6:[::1]:17010", network: "tcp6", addr: "[::1]:17010"},
+		{spec: "vsock:17010", network: "vsock", addr: "17010"},
+		{spec: " unix:/run/cpud.sock \tcpu-user=nobody,cpu-forward=\"deny any *:*\" ", network: "unix", addr: "/run/cpud.sock", options: `cpu-user=nobody,cpu-forward="deny any *:*"`},
+		{spec: "17010", err: true},
+		{spec: "tcp:", err: true},
+		{spec: ":17010", err: true},
+	} {
+		network, addr, options, err := parseListen(tt.spec)
+		if (err != nil) != tt.err {
+			t.Errorf("parseListen(%q): %v, want error %v", tt.spec, err, tt.err)
+			continue
+		}
+		if network != tt.network || addr != tt.addr || options != tt.options {
+			t.Errorf("parseListen(%q): %q, %q, %q, want %q, %q, %q", tt.spec, network, addr, options, tt.network, tt.addr, tt.options)
+		}
+	}
+}
+
+func TestListeners(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "cpud.sock")
+	// A socket no one listens on is stale, and is replaced.
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	defer func() { listens = nil }()
+	for _, tt := range []struct {
+		name    string
+		listens listFlag
+		n       int
+		err     bool
+	}{
+		{name: "several", listens: listFlag{"tcp:127.0.0.1:0", "tcp4:0 cpu-ns=pid", "unix:" + sock + ` cpu-forward="deny any *:*"`}, n: 3},
+		{name: "bad option", listens: listFlag{"tcp:127.0.0.1:0", "tcp:127.0.0.1:0 cpu-nope=1"}, err: true},
+		{name: "bad listener", listens: listFlag{"tcp:127.0.0.1:0", "blarg:1"}, err: true},
+	} {
+		listens = tt.listens
+		lns, err := listeners()
+		if (err != nil) != tt.err {
+			t.Errorf("%s: listeners: %v, want error %v", tt.name, err, tt.err)
+		}
+		if len(lns) != tt.n {
+			t.Errorf("%s: %d listeners, want %d", tt.name, len(lns), tt.n)
+		}
+		for _, ln := range lns {
+			if err := ln.Close(); err != nil {
+				t.Errorf("%s: %v.Close: %v", tt.name, ln.Addr(), err)
+			}
+		}
+	}
+}
+
 func TestRegister(t *testing.T) {
 	// There is not a lot of consistency in errors and error values and messages across kernels.
 	// There are a few things we can count on:
@@ -143,3 +209,53 @@ func TestRegister(t *testing.T) {
 	}
 
 }
+
+func TestDrain(t *testing.T) {
+	reg := server.NewRegistry()
+	s, err := server.New("", "", os.Args[0], server.WithRegistry(reg))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	var lns []net.Listener
+	for _, spec := range []string{"tcp:127.0.0.1:0", "unix:" + filepath.Join(t.TempDir(), "cpud.sock")} {
+		l, err := listenSpec(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lns = append(lns, l...)
+	}
+	served := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func(ln net.Listener) { served <- s.Serve(ln) }(ln)
+	}
+	stopped := false
+	modifiers = []*modifier{{name: "test", stop: func() { stopped = true }}}
+	defer func() { modifiers = nil }()
+
+	start := time.Now()
+	drain(s, lns, reg, time.Minute)
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("drain with no sessions took %v", d)
+	}
+	if !stopped {
+		t.Errorf("drain did not stop the modifiers")
+	}
+	for _, ln := range lns {
+		if _, err := net.Dial(ln.Addr().Network(), ln.Addr().String()); err == nil {
+			t.Errorf("Dial %v after drain: nil, want an error", ln.Addr())
+		}
+		if err := <-served; err != ssh.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Serve after drain: %v, want %v or %v", err, ssh.ErrServerClosed, net.ErrClosed)
+		}
+	}
+}
+
+func TestRestarted(t *testing.T) {
+	t.Setenv(restartEnv, "1")
+	if !restarted() {
+		t.Errorf("restarted() with %s=1: false, want true", restartEnv)
+	}
+	if restarted() {
+		t.Errorf("restarted() again: true, want false")
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package compress compresses the 9P and NFS back-channels of cpu.
+//
+// cpu and cpud negotiate compression once, with the SSH global request
+// named by Request: the client offers the algorithms it wants, in the
+// order it prefers them, and the server answers with the first it
+// knows. From then on, the data of each channel cpud forwards to a
+// port the client listens on is compressed, in each direction, as a
+// stream, flushed with each write, so that a request and its answer
+// are not held back waiting for more.
+//
+// The data of 9P and NFS, e.g. binaries and source trees, compresses
+// well, and over a slow link it is much faster compressed.
+package compress
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Request is the SSH global request by which compression is
+// negotiated. Its payload, and that of the answer, is an SSH string:
+// the algorithms offered, separated by commas, and the one chosen.
+const Request = "compress@u-root.org"
+
+// Algorithms are those known, in the order cpu offers them by default.
+var Algorithms = []string{"zstd", "zlib"}
+
+// Parse parses a list of algorithms, separated by commas, as a client
+// offers them. An empty string, or none, returns nil.
+func Parse(s string) ([]string, error) {
+	if s == "" || s == "none" {
+		return nil, nil
+	}
+	var algs []string
+	for _, a := range strings.Split(s, ",") {
+		if !known(a) {
+			return nil, fmt.Errorf("compression %q: not one of %q:%w", a, Algorithms, os.ErrInvalid)
+		}
+		algs = append(algs, a)
+	}
+	return algs, nil
+}
+
+// Choose returns the first of the algorithms offered that is known,
+// or "" if none is.
+func Choose(offer []string) string {
+	for _, a := range offer {
+		if known(a) {
+			return a
+		}
+	}
+	return ""
+}
+
+func known(a string) bool {
+	for _, k := range Algorithms {
+		if a == k {
+			return true
+		}
+	}
+	return false
+}
+
+// Stream is an io.ReadWriteCloser that compresses what is written to,
+// and decompresses what is read from, another.
+type Stream struct {
+	rwc io.ReadWriteCloser
+	alg string
+
+	// The decompressor is made on the first read, as making one
+	// may read, and the other end may not have written.
+	rmu sync.Mutex
+	r   io.Reader
+
+	wmu sync.Mutex
+	w   flushWriteCloser
+	// bw holds what is compressed until it is flushed, as the
+	// compressors write it in many small pieces.
+	bw *bufio.Writer
+	// wdone is set once the compressed stream is ended.
+	wdone bool
+}
+
+type flushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// New returns a Stream of rwc, compressed with alg.
+func New(rwc io.ReadWriteCloser, alg string) (*Stream, error) {
+	s := &Stream{rwc: rwc, alg: alg, bw: bufio.NewWriterSize(rwc, 64*1024)}
+	switch alg {
+	case "zstd":
+		// One goroutine, and a window of a msize at most,
+		// keep the memory of each channel small.
+		w, err := zstd.NewWriter(s.bw, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithWindowSize(1<<20))
+		if err != nil {
+			return nil, err
+		}
+		s.w = w
+	case "zlib":
+		w, err := zlib.NewWriterLevel(s.bw, zlib.BestSpeed)
+		if err != nil {
+			return nil, err
+		}
+		s.w = w
+	default:
+		return nil, fmt.Errorf("compression %q:%w", alg, os.ErrInvalid)
+	}
+	return s, nil
+}
+
+// Read implements io.Reader.
+func (s *Stream) Read(b []byte) (int, error) {
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	if s.r == nil {
+		switch s.alg {
+		case "zstd":
+			d, err := zstd.NewReader(s.rwc, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+			if err != nil {
+				return 0, err
+			}
+			s.r = d.IOReadCloser()
+		case "zlib":
+			r, err := zlib.NewReader(s.rwc)
+			if err != nil {
+				return 0, err
+			}
+			s.r = r
+		}
+	}
+	return s.r.Read(b)
+}
+
+// Write implements io.Writer. What is written is flushed, so that it
+// is sent at once.
+func (s *Stream) Write(b []byte) (int, error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	n, err := s.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	if err := s.w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := s.bw.Flush(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// closeWrite ends the compressed stream, if it is not ended. s.wmu
+// must be held.
+func (s *Stream) closeWrite() error {
+	if s.wdone {
+		return nil
+	}
+	s.wdone = true
+	if err := s.w.Close(); err != nil {
+		return err
+	}
+	return s.bw.Flush()
+}
+
+// Close implements io.Closer. The compressed stream is ended, unless
+// a write is blocked, and what it is read from and written to closed.
+func (s *Stream) Close() error {
+	if s.wmu.TryLock() {
+		s.closeWrite()
+		s.wmu.Unlock()
+	}
+	err := s.rwc.Close()
+	// A read, if one is blocked, now returns.
+	s.rmu.Lock()
+	if c, ok := s.r.(io.Closer); ok {
+		c.Close()
+	}
+	s.rmu.Unlock()
+	return err
+}
+
+// conn is a net.Conn whose data is a Stream.
+type conn struct {
+	net.Conn
+	s *Stream
+}
+
+func (c *conn) Read(b []byte) (int, error)  { return c.s.Read(b) }
+func (c *conn) Write(b []byte) (int, error) { return c.s.Write(b) }
+func (c *conn) Close() error                { return c.s.Close() }
+
+// Conn returns c, compressed with alg.
+func Conn(c net.Conn, alg string) (net.Conn, error) {
+	s, err := New(c, alg)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, s: s}, nil
+}
+
+// listener is a net.Listener whose connections are compressed.
+type listener struct {
+	net.Listener
+	alg string
+}
+
+// Accept implements net.Listener.
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	cc, err := Conn(c, l.alg)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+// Listener returns l, whose connections are compressed with alg. If
+// alg is "", it returns l.
+func Listener(l net.Listener, alg string) net.Listener {
+	if alg == "" {
+		return l
+	}
+	return &listener{Listener: l, alg: alg}
+}
@@ -0,0 +1,227 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []string
+		err  error
+	}{
+		{in: ""},
+		{in: "none"},
+		{in: "zstd", want: []string{"zstd"}},
+		{in: "zlib,zstd", want: []string{"zlib", "zstd"}},
+		{in: "lz4", err: os.ErrInvalid},
+		{in: "zstd,", err: os.ErrInvalid},
+	} {
+		got, err := Parse(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Parse(%q): %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("Parse(%q): %q, want %q", tt.in, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Parse(%q): %q, want %q", tt.in, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestChoose(t *testing.T) {
+	for _, tt := range []struct {
+		offer []string
+		want  string
+	}{
+		{},
+		{offer: []string{"lz4"}},
+		{offer: []string{"lz4", "zlib"}, want: "zlib"},
+		{offer: []string{"zlib", "zstd"}, want: "zlib"},
+	} {
+		if got := Choose(tt.offer); got != tt.want {
+			t.Errorf("Choose(%q): %q, want %q", tt.offer, got, tt.want)
+		}
+	}
+}
+
+// pipe returns the two ends of a TCP connection on the loopback, which,
+// unlike a net.Pipe, buffers what is written, as the SSH channels of
+// cpu do.
+func pipe(tb testing.TB) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer l.Close()
+	c1, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	c2, err := l.Accept()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return c1, c2
+}
+
+// TestConn sends requests and answers back and forth, as 9P does: each
+// must arrive as it is written, and not wait for more.
+func TestConn(t *testing.T) {
+	for _, alg := range Algorithms {
+		t.Run(alg, func(t *testing.T) {
+			p1, p2 := pipe(t)
+			c1, err := Conn(p1, alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c2, err := Conn(p2, alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan error)
+			go func() {
+				defer c2.Close()
+				b := make([]byte, 4096)
+				for {
+					n, err := c2.Read(b)
+					if err != nil {
+						done <- err
+						return
+					}
+					if _, err := c2.Write(bytes.ToUpper(b[:n])); err != nil {
+						return
+					}
+				}
+			}()
+			b := make([]byte, 4096)
+			for _, m := range []string{"version", "attach", "walk", string(bytes.Repeat([]byte("read"), 1000))} {
+				if _, err := c1.Write([]byte(m)); err != nil {
+					t.Fatal(err)
+				}
+				c1.SetReadDeadline(time.Now().Add(5 * time.Second))
+				n, err := io.ReadAtLeast(c1, b, len(m))
+				if err != nil {
+					t.Fatalf("answer to %.10q: %v", m, err)
+				}
+				if got, want := string(b[:n]), string(bytes.ToUpper([]byte(m))); got != want {
+					t.Fatalf("answer to %.10q: %.10q, want %.10q", m, got, want)
+				}
+			}
+			// Once closed, the other end reads io.EOF.
+			c1.Close()
+			select {
+			case err := <-done:
+				if err != io.EOF {
+					t.Errorf("Read after close: %v, want %v", err, io.EOF)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("Read after close: still blocked")
+			}
+		})
+	}
+}
+
+// throttled is a net.Conn whose writes go at rate bytes a second.
+type throttled struct {
+	net.Conn
+	rate int
+}
+
+func (t *throttled) Write(b []byte) (int, error) {
+	time.Sleep(time.Duration(len(b)) * time.Second / time.Duration(t.rate))
+	return t.Conn.Write(b)
+}
+
+// BenchmarkThrottled sends source and a binary over a link of 10MB/s,
+// uncompressed, and compressed with each algorithm.
+func BenchmarkThrottled(b *testing.B) {
+	var src []byte
+	files, err := filepath.Glob("../*/*.go")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, f := range files {
+		d, err := os.ReadFile(f)
+		if err != nil {
+			b.Fatal(err)
+		}
+		src = append(src, d...)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		b.Fatal(err)
+	}
+	bin, err := os.ReadFile(exe)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, data := range []struct {
+		name string
+		d    []byte
+	}{
+		{name: "source", d: src},
+		{name: "binary", d: bin[:min(len(bin), 4<<20)]},
+	} {
+		for _, alg := range append([]string{"none"}, Algorithms...) {
+			b.Run(data.name+"/"+alg, func(b *testing.B) {
+				p1, p2 := pipe(b)
+				var c1, c2 net.Conn = &throttled{Conn: p1, rate: 10 << 20}, p2
+				if alg != "none" {
+					if c1, err = Conn(c1, alg); err != nil {
+						b.Fatal(err)
+					}
+					if c2, err = Conn(c2, alg); err != nil {
+						b.Fatal(err)
+					}
+				}
+				defer c1.Close()
+				defer c2.Close()
+				go func() {
+					b := make([]byte, len(data.d))
+					for {
+						if _, err := io.ReadFull(c2, b); err != nil {
+							return
+						}
+						if _, err := c2.Write([]byte{0}); err != nil {
+							return
+						}
+					}
+				}()
+				b.SetBytes(int64(len(data.d)))
+				b.ResetTimer()
+				ack := make([]byte, 1)
+				for i := 0; i < b.N; i++ {
+					// Writes are of a msize, as 9P's are.
+					for d := data.d; len(d) > 0; {
+						n := min(len(d), 1<<20)
+						if _, err := c1.Write(d[:n]); err != nil {
+							b.Fatal(err)
+						}
+						d = d[n:]
+					}
+					if _, err := io.ReadFull(c1, ack); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fuse9p serves a 9P file tree, from a p9.Client, as a FUSE
+// file system.
+//
+// cpud mounts the client namespace with the kernel 9P client, which
+// needs real root. A rootless cpud, running in a user namespace, can
+// not mount 9P, but can mount FUSE; it mounts the client namespace
+// with Mount, and serves it with Serve, for as long as the session
+// lasts.
+//
+// Permission checks are left to the 9P server, i.e. the cpu client,
+// and attributes and entries are cached by the kernel for a second.
+package fuse9p
@@ -0,0 +1,731 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse9p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// maxWrite is the largest write the kernel sends us, and
+	// the default maximum for the kernel as well.
+	maxWrite = 128 << 10
+	// bufSize is the size of a request buffer: a write, and
+	// its headers.
+	bufSize = maxWrite + 4096
+	// maxBackground is the most background requests, e.g.
+	// readahead, the kernel sends at once, and the number of
+	// requests Serve serves at once.
+	maxBackground = 16
+	// valid is how long, in seconds, the kernel may cache
+	// entries and attributes.
+	valid = 1
+)
+
+var v = func(string, ...interface{}) {}
+
+// SetVerbose sets the verbose printing function.
+// e.g., one might call SetVerbose(log.Printf)
+func SetVerbose(f func(string, ...interface{})) {
+	v = f
+}
+
+func verbose(f string, a ...interface{}) {
+	v("fuse9p:"+f, a...)
+}
+
+// node is a file the kernel knows about. nlookup counts the
+// lookups the kernel has not yet forgotten.
+type node struct {
+	file    p9.File
+	qid     uint64
+	nlookup uint64
+}
+
+// Server serves a 9P file tree as a FUSE file system.
+type Server struct {
+	dev *os.File
+	dir string
+
+	mu     sync.Mutex
+	nodes  map[uint64]*node
+	byQID  map[uint64]uint64
+	nextID uint64
+	files  map[uint64]p9.File
+	nextFh uint64
+
+	// uids and gids are the ids mapped in our user namespace.
+	uids, gids idRanges
+}
+
+// Mount mounts root, a 9P file, usually from p9.Client.Attach, on dir.
+// Requests are served once Serve is called.
+// Mounting FUSE file systems is allowed in user namespaces, unlike
+// mounting 9P file systems.
+func Mount(dir string, root p9.File) (*Server, error) {
+	qid, _, a, err := root.GetAttr(p9.AttrMaskAll)
+	if err != nil {
+		return nil, fmt.Errorf("fuse9p: root attributes: %w", err)
+	}
+	dev, err := os.OpenFile("/dev/fuse", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("fuse9p: %w", err)
+	}
+	opts := fmt.Sprintf("fd=%d,rootmode=%o,user_id=%d,group_id=%d", dev.Fd(), uint32(a.Mode.FileType()), os.Getuid(), os.Getgid())
+	verbose("mount %q fuse %q", dir, opts)
+	if err := unix.Mount("cpu", dir, "fuse", unix.MS_NOSUID|unix.MS_NODEV, opts); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("fuse9p: mount %q: %w", dir, err)
+	}
+	return newServer(dev, dir, root, qid), nil
+}
+
+// newServer returns a Server for root, whose requests are read from dev.
+func newServer(dev *os.File, dir string, root p9.File, qid p9.QID) *Server {
+	return &Server{
+		uids:   readIDRanges("/proc/self/uid_map"),
+		gids:   readIDRanges("/proc/self/gid_map"),
+		dev:    dev,
+		dir:    dir,
+		nodes:  map[uint64]*node{rootID: {file: root, qid: qid.Path, nlookup: 1}},
+		byQID:  map[uint64]uint64{qid.Path: rootID},
+		nextID: rootID + 1,
+		files:  map[uint64]p9.File{},
+		nextFh: 1,
+	}
+}
+
+// Serve serves requests until the file system is unmounted.
+// At most maxBackground requests are served at once; each is read
+// into a buffer of the goroutine serving it. As with libfuse, the
+// goroutines block reading the device, each on a thread of its own:
+// the device is not read through the poller.
+//
+// Starting a process whose executable or directory is on the mount,
+// or adding a file on it to epoll, blocks a thread in the kernel,
+// waiting for Serve, without letting go of its P. Programs doing so
+// must run with GOMAXPROCS of at least 2. Even so, a garbage
+// collection started meanwhile waits for that thread, which waits
+// for Serve, which waits for the collection; processes are better
+// started outside the mount, and changed into it.
+func (s *Server) Serve() error {
+	fd := int(s.dev.Fd())
+	errs := make(chan error)
+	for range maxBackground {
+		go func() {
+			errs <- s.serve(fd, make([]byte, bufSize))
+		}()
+	}
+	var err error
+	for range maxBackground {
+		err = errors.Join(err, <-errs)
+	}
+	return err
+}
+
+// serve reads requests from fd into b, and serves them, until the
+// file system is unmounted.
+func (s *Server) serve(fd int, b []byte) error {
+	for {
+		n, err := unix.Read(fd, b)
+		switch {
+		case errors.Is(err, unix.ENOENT), errors.Is(err, unix.EINTR), errors.Is(err, unix.EAGAIN):
+			// The request was interrupted before we read it.
+			continue
+		case errors.Is(err, unix.ENODEV), err == nil && n == 0:
+			// The device reads nothing once closed.
+			verbose("%q unmounted", s.dir)
+			return nil
+		case err != nil:
+			return fmt.Errorf("fuse9p: reading request: %w", err)
+		}
+		s.handle(b[:n])
+	}
+}
+
+// Close unmounts the file system.
+func (s *Server) Close() error {
+	err := unix.Unmount(s.dir, unix.MNT_DETACH)
+	return errors.Join(err, s.dev.Close())
+}
+
+func (s *Server) handle(b []byte) {
+	var h inHeader
+	n, err := binary.Decode(b, binary.NativeEndian, &h)
+	if err != nil {
+		log.Printf("fuse9p: bad request header: %v", err)
+		return
+	}
+	out, err := s.dispatch(&h, b[n:])
+	verbose("%d: op %d node %d: %d bytes, %v", h.Unique, h.Opcode, h.NodeID, len(out), err)
+	switch h.Opcode {
+	case opForget, opBatchForget, opInterrupt:
+		// These have no reply.
+		return
+	}
+	s.reply(h.Unique, out, err)
+}
+
+func (s *Server) reply(unique uint64, out []byte, err error) {
+	hdr := outHeader{Len: uint32(binary.Size(outHeader{}) + len(out)), Unique: unique}
+	if err != nil {
+		hdr.Len, hdr.Error, out = uint32(binary.Size(outHeader{})), -int32(errno(err)), nil
+	}
+	b, _ := binary.Append(nil, binary.NativeEndian, hdr)
+	// ENOENT means the request was interrupted, and there is
+	// no one left to reply to.
+	if _, err := s.dev.Write(append(b, out...)); err != nil && !errors.Is(err, unix.ENOENT) {
+		log.Printf("fuse9p: reply to %d: %v", unique, err)
+	}
+}
+
+// errno returns the errno for a 9P error.
+func errno(err error) unix.Errno {
+	var le linux.Errno
+	if errors.As(err, &le) {
+		return unix.Errno(le)
+	}
+	var ue unix.Errno
+	if errors.As(err, &ue) {
+		return ue
+	}
+	return unix.EIO
+}
+
+// enc encodes the fixed-size structs of a reply. The error, which
+// is not an errno, is replied to as EIO.
+func enc(a ...any) ([]byte, error) {
+	var b []byte
+	for _, v := range a {
+		var err error
+		if b, err = binary.Append(b, binary.NativeEndian, v); err != nil {
+			return nil, fmt.Errorf("fuse9p: encoding %T:%w", v, err)
+		}
+	}
+	return b, nil
+}
+
+// dec decodes the fixed-size struct at the start of a request,
+// returning the rest of the request.
+func dec(b []byte, v any) ([]byte, error) {
+	n, err := binary.Decode(b, binary.NativeEndian, v)
+	if err != nil {
+		return nil, unix.EINVAL
+	}
+	return b[n:], nil
+}
+
+// names returns the n NUL-terminated strings at the start of b.
+func names(b []byte, n int) ([]string, error) {
+	var s []string
+	for range n {
+		i := bytes.IndexByte(b, 0)
+		if i < 0 {
+			return nil, unix.EINVAL
+		}
+		s, b = append(s, string(b[:i])), b[i+1:]
+	}
+	return s, nil
+}
+
+func (s *Server) node(id uint64) (*node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[id]
+	if !ok {
+		return nil, unix.ESTALE
+	}
+	return n, nil
+}
+
+// addNode records a lookup of f. If the kernel already knows the
+// file, f is closed and the known node used instead.
+func (s *Server) addNode(f p9.File, qid p9.QID) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.byQID[qid.Path]; ok {
+		s.nodes[id].nlookup++
+		f.Close()
+		return id
+	}
+	id := s.nextID
+	s.nextID++
+	s.nodes[id] = &node{file: f, qid: qid.Path, nlookup: 1}
+	s.byQID[qid.Path] = id
+	return id
+}
+
+func (s *Server) forget(id, n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nd, ok := s.nodes[id]
+	if !ok || id == rootID {
+		return
+	}
+	if nd.nlookup > n {
+		nd.nlookup -= n
+		return
+	}
+	delete(s.nodes, id)
+	delete(s.byQID, nd.qid)
+	nd.file.Close()
+}
+
+func (s *Server) addFile(f p9.File) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fh := s.nextFh
+	s.nextFh++
+	s.files[fh] = f
+	return fh
+}
+
+func (s *Server) file(fh uint64) (p9.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[fh]
+	if !ok {
+		return nil, unix.EBADF
+	}
+	return f, nil
+}
+
+func (s *Server) release(fh uint64) error {
+	s.mu.Lock()
+	f, ok := s.files[fh]
+	delete(s.files, fh)
+	s.mu.Unlock()
+	if !ok {
+		return unix.EBADF
+	}
+	return f.Close()
+}
+
+// fuseAttr converts 9P attributes to FUSE attributes.
+// idRanges are the ids in the first column of a uid_map or gid_map.
+type idRanges [][2]uint64
+
+// readIDRanges reads the ids mapped in a uid_map or gid_map file.
+// If it can not be read, all ids are taken to be mapped.
+func readIDRanges(n string) idRanges {
+	b, err := os.ReadFile(n)
+	if err != nil {
+		return idRanges{{0, 1 << 32}}
+	}
+	var r idRanges
+	for _, l := range strings.Split(string(b), "\n") {
+		var first, host, count uint64
+		if _, err := fmt.Sscan(l, &first, &host, &count); err == nil {
+			r = append(r, [2]uint64{first, first + count})
+		}
+	}
+	return r
+}
+
+func (r idRanges) has(id uint32) bool {
+	for _, m := range r {
+		if uint64(id) >= m[0] && uint64(id) < m[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// attr converts 9P attributes. The kernel refuses to write files
+// whose owner is not mapped in the user namespace of the mount; as
+// the 9P server checks permissions, such files are shown as ours.
+func (s *Server) attr(qid p9.QID, a p9.Attr) attr {
+	uid, gid := uint32(a.UID), uint32(a.GID)
+	if !s.uids.has(uid) {
+		uid = uint32(os.Getuid())
+	}
+	if !s.gids.has(gid) {
+		gid = uint32(os.Getgid())
+	}
+	// FUSE devices are in the old, 32-bit, encoding.
+	major, minor := unix.Major(uint64(a.RDev)), unix.Minor(uint64(a.RDev))
+	return attr{
+		Ino:       qid.Path,
+		Size:      a.Size,
+		Blocks:    a.Blocks,
+		Atime:     a.ATimeSeconds,
+		Mtime:     a.MTimeSeconds,
+		Ctime:     a.CTimeSeconds,
+		Atimensec: uint32(a.ATimeNanoSeconds),
+		Mtimensec: uint32(a.MTimeNanoSeconds),
+		Ctimensec: uint32(a.CTimeNanoSeconds),
+		Mode:      uint32(a.Mode),
+		Nlink:     uint32(a.NLink),
+		UID:       uid,
+		GID:       gid,
+		Rdev:      minor&0xff | major<<8 | (minor&^0xff)<<12,
+		Blksize:   uint32(a.BlockSize),
+	}
+}
+
+func (s *Server) lookup(parent *node, name string) ([]byte, error) {
+	qids, f, _, a, err := parent.file.WalkGetAttr([]string{name})
+	if err != nil {
+		return nil, err
+	}
+	qid := qids[len(qids)-1]
+	id := s.addNode(f, qid)
+	return enc(entryOut{NodeID: id, EntryValid: valid, AttrValid: valid, Attr: s.attr(qid, a)})
+}
+
+func (s *Server) getattr(n *node) ([]byte, error) {
+	qid, _, a, err := n.file.GetAttr(p9.AttrMaskAll)
+	if err != nil {
+		return nil, err
+	}
+	return enc(attrOut{AttrValid: valid, Attr: s.attr(qid, a)})
+}
+
+func (s *Server) setattr(n *node, in *setattrIn) ([]byte, error) {
+	var (
+		m  p9.SetAttrMask
+		sa p9.SetAttr
+	)
+	if in.Valid&fattrMode != 0 {
+		m.Permissions, sa.Permissions = true, p9.FileMode(in.Mode).Permissions()
+	}
+	if in.Valid&fattrUID != 0 {
+		m.UID, sa.UID = true, p9.UID(in.UID)
+	}
+	if in.Valid&fattrGID != 0 {
+		m.GID, sa.GID = true, p9.GID(in.GID)
+	}
+	if in.Valid&fattrSize != 0 {
+		m.Size, sa.Size = true, in.Size
+	}
+	if in.Valid&(fattrAtime|fattrAtimeNow) != 0 {
+		m.ATime = true
+		if in.Valid&fattrAtimeNow == 0 {
+			m.ATimeNotSystemTime, sa.ATimeSeconds, sa.ATimeNanoSeconds = true, in.Atime, uint64(in.Atimensec)
+		}
+	}
+	if in.Valid&(fattrMtime|fattrMtimeNow) != 0 {
+		m.MTime = true
+		if in.Valid&fattrMtimeNow == 0 {
+			m.MTimeNotSystemTime, sa.MTimeSeconds, sa.MTimeNanoSeconds = true, in.Mtime, uint64(in.Mtimensec)
+		}
+	}
+	if err := n.file.SetAttr(m, sa); err != nil {
+		return nil, err
+	}
+	return s.getattr(n)
+}
+
+// open opens a new fid for n, so that the kernel can have many
+// opens of a file.
+func (s *Server) open(n *node, flags uint32) ([]byte, error) {
+	_, f, err := n.file.Walk(nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := f.Open(p9.OpenFlags(flags & unix.O_ACCMODE)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return enc(openOut{Fh: s.addFile(f)})
+}
+
+func (s *Server) create(parent *node, h *inHeader, in *createIn, name string) ([]byte, error) {
+	_, f, err := parent.file.Walk(nil)
+	if err != nil {
+		return nil, err
+	}
+	nf, _, _, err := f.Create(name, p9.OpenFlags(in.Flags&unix.O_ACCMODE), p9.FileMode(in.Mode).Permissions(), p9.UID(h.UID), p9.GID(h.GID))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	e, err := s.lookup(parent, name)
+	if err != nil {
+		nf.Close()
+		return nil, err
+	}
+	o, err := enc(openOut{Fh: s.addFile(nf)})
+	return append(e, o...), err
+}
+
+func (s *Server) readdir(in *readIn) ([]byte, error) {
+	f, err := s.file(in.Fh)
+	if err != nil {
+		return nil, err
+	}
+	ents, err := f.Readdir(in.Offset, in.Size)
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	for _, e := range ents {
+		t := uint32(unix.DT_UNKNOWN)
+		switch e.Type {
+		case p9.TypeDir:
+			t = unix.DT_DIR
+		case p9.TypeSymlink:
+			t = unix.DT_LNK
+		}
+		d, err := enc(dirent{Ino: e.QID.Path, Off: e.Offset, Namelen: uint32(len(e.Name)), Type: t})
+		if err != nil {
+			return nil, err
+		}
+		d = append(d, e.Name...)
+		d = append(d, make([]byte, (8-len(d)%8)%8)...)
+		if len(b)+len(d) > int(in.Size) {
+			break
+		}
+		b = append(b, d...)
+	}
+	return b, nil
+}
+
+func (s *Server) dispatch(h *inHeader, b []byte) ([]byte, error) {
+	switch h.Opcode {
+	case opInit:
+		var in initIn
+		if _, err := dec(b, &in); err != nil {
+			return nil, err
+		}
+		if in.Major != kernelVersion {
+			return nil, unix.EPROTO
+		}
+		return enc(initOut{
+			Major:               kernelVersion,
+			Minor:               min(in.Minor, kernelMinorVersion),
+			MaxReadahead:        in.MaxReadahead,
+			Flags:               in.Flags & (asyncRead | bigWrites),
+			MaxBackground:       maxBackground,
+			CongestionThreshold: maxBackground * 3 / 4,
+			MaxWrite:            maxWrite,
+			TimeGran:            1,
+		})
+	case opDestroy:
+		return nil, nil
+	case opInterrupt:
+		return nil, nil
+	case opForget:
+		var in forgetIn
+		if _, err := dec(b, &in); err == nil {
+			s.forget(h.NodeID, in.Nlookup)
+		}
+		return nil, nil
+	case opBatchForget:
+		var in batchForgetIn
+		b, err := dec(b, &in)
+		for i := uint32(0); err == nil && i < in.Count; i++ {
+			var f forgetOne
+			if b, err = dec(b, &f); err == nil {
+				s.forget(f.NodeID, f.Nlookup)
+			}
+		}
+		return nil, nil
+	}
+
+	n, err := s.node(h.NodeID)
+	if err != nil {
+		return nil, err
+	}
+	switch h.Opcode {
+	case opLookup:
+		nm, err := names(b, 1)
+		if err != nil {
+			return nil, err
+		}
+		return s.lookup(n, nm[0])
+	case opGetattr:
+		return s.getattr(n)
+	case opSetattr:
+		var in setattrIn
+		if _, err := dec(b, &in); err != nil {
+			return nil, err
+		}
+		return s.setattr(n, &in)
+	case opReadlink:
+		l, err := n.file.Readlink()
+		return []byte(l), err
+	case opSymlink:
+		nm, err := names(b, 2)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := n.file.Symlink(nm[1], nm[0], p9.UID(h.UID), p9.GID(h.GID)); err != nil {
+			return nil, err
+		}
+		return s.lookup(n, nm[0])
+	case opMknod:
+		var in mknodIn
+		b, err := dec(b, &in)
+		if err != nil {
+			return nil, err
+		}
+		nm, err := names(b, 1)
+		if err != nil {
+			return nil, err
+		}
+		major, minor := (in.Rdev&0xfff00)>>8, (in.Rdev&0xff)|((in.Rdev>>12)&0xfff00)
+		if _, err := n.file.Mknod(nm[0], p9.FileMode(in.Mode), major, minor, p9.UID(h.UID), p9.GID(h.GID)); err != nil {
+			return nil, err
+		}
+		return s.lookup(n, nm[0])
+	case opMkdir:
+		var in mkdirIn
+		b, err := dec(b, &in)
+		if err != nil {
+			return nil, err
+		}
+		nm, err := names(b, 1)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := n.file.Mkdir(nm[0], p9.FileMode(in.Mode).Permissions(), p9.UID(h.UID), p9.GID(h.GID)); err != nil {
+			return nil, err
+		}
+		return s.lookup(n, nm[0])
+	case opUnlink, opRmdir:
+		nm, err := names(b, 1)
+		if err != nil {
+			return nil, err
+		}
+		var flags uint32
+		if h.Opcode == opRmdir {
+			flags = unix.AT_REMOVEDIR
+		}
+		return nil, n.file.UnlinkAt(nm[0], flags)
+	case opRename:
+		var in renameIn
+		b, err := dec(b, &in)
+		if err != nil {
+			return nil, err
+		}
+		nm, err := names(b, 2)
+		if err != nil {
+			return nil, err
+		}
+		nd, err := s.node(in.Newdir)
+		if err != nil {
+			return nil, err
+		}
+		return nil, n.file.RenameAt(nm[0], nd.file, nm[1])
+	case opLink:
+		var in linkIn
+		b, err := dec(b, &in)
+		if err != nil {
+			return nil, err
+		}
+		nm, err := names(b, 1)
+		if err != nil {
+			return nil, err
+		}
+		old, err := s.node(in.Oldnodeid)
+		if err != nil {
+			return nil, err
+		}
+		if err := n.file.Link(old.file, nm[0]); err != nil {
+			return nil, err
+		}
+		return s.lookup(n, nm[0])
+	case opOpen, opOpendir:
+		var in openIn
+		if _, err := dec(b, &in); err != nil {
+			return nil, err
+		}
+		return s.open(n, in.Flags)
+	case opCreate:
+		var in createIn
+		b, err := dec(b, &in)
+		if err != nil {
+			return nil, err
+		}
+		nm, err := names(b, 1)
+		if err != nil {
+			return nil, err
+		}
+		return s.create(n, h, &in, nm[0])
+	case opRead:
+		var in readIn
+		if _, err := dec(b, &in); err != nil {
+			return nil, err
+		}
+		f, err := s.file(in.Fh)
+		if err != nil {
+			return nil, err
+		}
+		d := make([]byte, in.Size)
+		c, err := f.ReadAt(d, int64(in.Offset))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return d[:c], nil
+	case opWrite:
+		var in writeIn
+		b, err := dec(b, &in)
+		if err != nil || len(b) < int(in.Size) {
+			return nil, unix.EINVAL
+		}
+		f, err := s.file(in.Fh)
+		if err != nil {
+			return nil, err
+		}
+		c, err := f.WriteAt(b[:in.Size], int64(in.Offset))
+		if err != nil {
+			return nil, err
+		}
+		return enc(writeOut{Size: uint32(c)})
+	case opReaddir:
+		var in readIn
+		if _, err := dec(b, &in); err != nil {
+			return nil, err
+		}
+		return s.readdir(&in)
+	case opRelease, opReleasedir:
+		var in releaseIn
+		if _, err := dec(b, &in); err != nil {
+			return nil, err
+		}
+		return nil, s.release(in.Fh)
+	case opFsync, opFsyncdir:
+		var in fsyncIn
+		if _, err := dec(b, &in); err != nil {
+			return nil, err
+		}
+		f, err := s.file(in.Fh)
+		if err != nil {
+			return nil, err
+		}
+		return nil, f.FSync()
+	case opFlush:
+		return nil, nil
+	case opStatfs:
+		st, err := n.file.StatFS()
+		if err != nil {
+			return nil, err
+		}
+		return enc(statfsOut{
+			Blocks:  st.Blocks,
+			Bfree:   st.BlocksFree,
+			Bavail:  st.BlocksAvailable,
+			Files:   st.Files,
+			Ffree:   st.FilesFree,
+			Bsize:   st.BlockSize,
+			Namelen: st.NameLength,
+			Frsize:  st.BlockSize,
+		})
+	}
+	return nil, unix.ENOSYS
+}
@@ -0,0 +1,285 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse9p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hugelgupf/p9/p9"
+	"github.com/u-root/cpu/client"
+	"golang.org/x/sys/unix"
+)
+
+// dev is the kernel end of a Server: requests are written to it, and
+// replies read from it, a message at a time, as with /dev/fuse.
+type dev struct {
+	t      *testing.T
+	f      *os.File
+	unique uint64
+}
+
+// serve serves exp on a socket pair, in place of /dev/fuse.
+func serve(t *testing.T, exp string) *dev {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Fatalf("Socketpair: %v != nil", err)
+	}
+	k, d := os.NewFile(uintptr(fds[0]), "kernel"), os.NewFile(uintptr(fds[1]), "fuse")
+	cs, ss := net.Pipe()
+	go p9.NewServer(client.NewCPU9P(exp)).Handle(ss, ss) //nolint
+	c, err := p9.NewClient(cs)
+	if err != nil {
+		t.Fatalf("p9.NewClient: %v != nil", err)
+	}
+	root, err := c.Attach("/")
+	if err != nil {
+		t.Fatalf("Attach: %v != nil", err)
+	}
+	qid, _, _, err := root.GetAttr(p9.AttrMaskAll)
+	if err != nil {
+		t.Fatalf("GetAttr: %v != nil", err)
+	}
+	s := newServer(d, "", root, qid)
+	done := make(chan error)
+	go func() { done <- s.Serve() }()
+	t.Cleanup(func() {
+		k.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v != nil", err)
+		}
+		d.Close()
+		c.Close()
+	})
+	return &dev{t: t, f: k}
+}
+
+// send sends a request, of the structs and names in a, and returns
+// its unique id.
+func (d *dev) send(op opcode, node uint64, a ...any) uint64 {
+	d.t.Helper()
+	var b []byte
+	for _, v := range a {
+		if n, ok := v.(string); ok {
+			b = append(append(b, n...), 0)
+			continue
+		}
+		var err error
+		if b, err = binary.Append(b, binary.NativeEndian, v); err != nil {
+			d.t.Fatalf("encoding %T: %v != nil", v, err)
+		}
+	}
+	d.unique++
+	h, err := binary.Append(nil, binary.NativeEndian, inHeader{
+		Len:    uint32(binary.Size(inHeader{}) + len(b)),
+		Opcode: op,
+		Unique: d.unique,
+		NodeID: node,
+	})
+	if err != nil {
+		d.t.Fatalf("encoding header: %v != nil", err)
+	}
+	if _, err := d.f.Write(append(h, b...)); err != nil {
+		d.t.Fatalf("sending %d: %v != nil", op, err)
+	}
+	return d.unique
+}
+
+// reply reads the next reply, which must be to unique, and returns
+// its errno and body.
+func (d *dev) reply(unique uint64) (unix.Errno, []byte) {
+	d.t.Helper()
+	b := make([]byte, bufSize)
+	n, err := d.f.Read(b)
+	if err != nil {
+		d.t.Fatalf("reading reply: %v != nil", err)
+	}
+	var h outHeader
+	m, err := binary.Decode(b[:n], binary.NativeEndian, &h)
+	if err != nil || h.Unique != unique || int(h.Len) != n {
+		d.t.Fatalf("reply %+v, %v: want a reply of %d bytes to %d", h, err, n, unique)
+	}
+	return unix.Errno(-h.Error), b[m:n]
+}
+
+// call sends a request, and decodes its reply into out.
+func (d *dev) call(out any, op opcode, node uint64, a ...any) []byte {
+	d.t.Helper()
+	e, b := d.reply(d.send(op, node, a...))
+	if e != 0 {
+		d.t.Fatalf("op %d: %v != nil", op, e)
+	}
+	if out != nil {
+		if _, err := binary.Decode(b, binary.NativeEndian, out); err != nil {
+			d.t.Fatalf("decoding %T: %v != nil", out, err)
+		}
+	}
+	return b
+}
+
+func TestServe(t *testing.T) {
+	exp := t.TempDir()
+	if err := os.WriteFile(filepath.Join(exp, "a"), []byte("hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d := serve(t, exp)
+
+	var init initOut
+	d.call(&init, opInit, 0, initIn{Major: kernelVersion, Minor: kernelMinorVersion, Flags: asyncRead | 1<<31})
+	if init.MaxBackground != maxBackground || init.Flags != asyncRead || init.MaxWrite != maxWrite {
+		t.Errorf("init: %+v: want %d background requests, flags %#x, and writes of %d", init, maxBackground, asyncRead, maxWrite)
+	}
+
+	// A file looked up twice is one node, until it is forgotten twice.
+	var e, e2 entryOut
+	d.call(&e, opLookup, rootID, "a")
+	d.call(&e2, opLookup, rootID, "a")
+	if e.NodeID == rootID || e2.NodeID != e.NodeID || e.Attr.Size != 2 || e.Attr.Mode&0o777 != 0o644 {
+		t.Errorf("lookup a: %+v, %+v: want one node, of 2 bytes, mode 644", e, e2)
+	}
+	if errno, _ := d.reply(d.send(opLookup, rootID, "x")); errno != unix.ENOENT {
+		t.Errorf("lookup x: %v != %v", errno, unix.ENOENT)
+	}
+
+	var o openOut
+	d.call(&o, opOpen, e.NodeID, openIn{Flags: unix.O_RDWR})
+	var w writeOut
+	d.call(&w, opWrite, e.NodeID, writeIn{Fh: o.Fh, Offset: 2, Size: 5}, "there")
+	if w.Size != 5 {
+		t.Errorf("write: %d bytes != 5", w.Size)
+	}
+	if b := d.call(nil, opRead, e.NodeID, readIn{Fh: o.Fh, Size: 100}); string(b) != "hithere" {
+		t.Errorf("read: %q != %q", b, "hithere")
+	}
+	d.call(nil, opRelease, e.NodeID, releaseIn{Fh: o.Fh})
+	if errno, _ := d.reply(d.send(opRead, e.NodeID, readIn{Fh: o.Fh, Size: 100})); errno != unix.EBADF {
+		t.Errorf("read of released file: %v != %v", errno, unix.EBADF)
+	}
+
+	b := d.call(&e2, opCreate, rootID, createIn{Flags: unix.O_RDWR, Mode: 0o600}, "b")
+	if _, err := binary.Decode(b[binary.Size(entryOut{}):], binary.NativeEndian, &o); err != nil {
+		t.Fatalf("create: decoding %q: %v != nil", b, err)
+	}
+	d.call(&w, opWrite, e2.NodeID, writeIn{Fh: o.Fh, Size: 3}, "new")
+	d.call(nil, opRelease, e2.NodeID, releaseIn{Fh: o.Fh})
+	if b, err := os.ReadFile(filepath.Join(exp, "b")); err != nil || string(b) != "new" {
+		t.Errorf("created file: %q, %v != %q, nil", b, err, "new")
+	}
+
+	d.call(&o, opOpendir, rootID, openIn{})
+	b = d.call(nil, opReaddir, rootID, readIn{Fh: o.Fh, Size: 4096})
+	var ents []string
+	for len(b) > 0 {
+		var de dirent
+		n, err := binary.Decode(b, binary.NativeEndian, &de)
+		if err != nil || len(b) < n+int(de.Namelen) {
+			t.Fatalf("readdir: bad dirent in %q: %v", b, err)
+		}
+		ents = append(ents, string(b[n:n+int(de.Namelen)]))
+		b = b[(n+int(de.Namelen)+7)&^7:]
+	}
+	slices.Sort(ents)
+	if want := []string{"a", "b"}; !slices.Equal(slices.DeleteFunc(ents, func(n string) bool { return n[0] == '.' }), want) {
+		t.Errorf("readdir: %q != %q", ents, want)
+	}
+	d.call(nil, opReleasedir, rootID, releaseIn{Fh: o.Fh})
+
+	// Interrupts and forgets have no reply: the next reply is to
+	// the getattr. Requests are served at once, so the forget
+	// may be served after the getattr.
+	d.send(opInterrupt, 0, struct{ Unique uint64 }{d.unique})
+	d.send(opForget, e.NodeID, forgetIn{Nlookup: 2})
+	var errno unix.Errno
+	for range 100 {
+		if errno, _ = d.reply(d.send(opGetattr, e.NodeID)); errno != 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if errno != unix.ESTALE {
+		t.Errorf("getattr of forgotten node: %v != %v", errno, unix.ESTALE)
+	}
+	var a attrOut
+	d.call(&a, opGetattr, rootID)
+	if a.Attr.Mode&unix.S_IFDIR == 0 {
+		t.Errorf("getattr of root: mode %#o is not a directory", a.Attr.Mode)
+	}
+}
+
+func TestEnc(t *testing.T) {
+	if b, err := enc(writeOut{Size: 1}); err != nil || !bytes.Equal(b, []byte{1, 0, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("enc(writeOut{1}): %v, %v != [1 0 0 0 0 0 0 0], nil", b, err)
+	}
+	// Replies that can not be encoded are errors, not panics.
+	if _, err := enc(writeOut{}, 1); errno(err) != unix.EIO {
+		t.Errorf("enc(int): %v is not %v", err, unix.EIO)
+	}
+}
+
+func TestFUSE9P(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skipf("Skipping as we are not root")
+	}
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skipf("Skipping: %v", err)
+	}
+	exp, mnt := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(exp, "a"), []byte("hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cs, ss := net.Pipe()
+	go p9.NewServer(client.NewCPU9P(exp)).Handle(ss, ss) //nolint
+	c, err := p9.NewClient(cs)
+	if err != nil {
+		t.Fatalf("p9.NewClient: %v != nil", err)
+	}
+	root, err := c.Attach("/")
+	if err != nil {
+		t.Fatalf("Attach: %v != nil", err)
+	}
+	s, err := Mount(mnt, root)
+	if err != nil {
+		t.Fatalf("Mount(%q): %v != nil", mnt, err)
+	}
+	go s.Serve() //nolint
+	t.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			t.Errorf("Close: %v != nil", err)
+		}
+	})
+
+	// The file system is used by other processes, as it is by the
+	// session command.
+	// They change to it once started: while a process is being
+	// started, a thread of ours can not be stopped, and a garbage
+	// collection would wait on it, as it waits on us.
+	for _, tt := range []struct {
+		cmd  string
+		want string
+	}{
+		{cmd: "cat a", want: "hi"},
+		{cmd: "echo -n hello > b && cat " + filepath.Join(exp, "b"), want: "hello"},
+		{cmd: "truncate -s 2 b && stat -c '%s %a' b", want: "2 644\n"},
+		{cmd: "chmod 600 b && stat -c %a " + filepath.Join(exp, "b"), want: "600\n"},
+		{cmd: "mkdir d && mv b d/c && ln -s d/c l && readlink l && cat l", want: "d/c\nhe"},
+		{cmd: "ls", want: "a\nd\nl\n"},
+		{cmd: "rmdir d 2>/dev/null || echo not empty", want: "not empty\n"},
+		{cmd: "rm -r d && ls " + exp, want: "a\nl\n"},
+	} {
+		c := exec.Command("sh", "-c", "cd "+mnt+" && "+tt.cmd)
+		out, err := c.CombinedOutput()
+		if err != nil || string(out) != tt.want {
+			t.Errorf("%q: %q, %v != %q, nil", tt.cmd, out, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse9p
+
+// The FUSE kernel protocol, from linux/fuse.h, for the requests we
+// serve. We speak 7.31; the kernel adapts to older servers.
+const (
+	kernelVersion      = 7
+	kernelMinorVersion = 31
+	rootID             = 1
+)
+
+type opcode uint32
+
+const (
+	opLookup      opcode = 1
+	opForget      opcode = 2
+	opGetattr     opcode = 3
+	opSetattr     opcode = 4
+	opReadlink    opcode = 5
+	opSymlink     opcode = 6
+	opMknod       opcode = 8
+	opMkdir       opcode = 9
+	opUnlink      opcode = 10
+	opRmdir       opcode = 11
+	opRename      opcode = 12
+	opLink        opcode = 13
+	opOpen        opcode = 14
+	opRead        opcode = 15
+	opWrite       opcode = 16
+	opStatfs      opcode = 17
+	opRelease     opcode = 18
+	opFsync       opcode = 20
+	opFlush       opcode = 25
+	opInit        opcode = 26
+	opOpendir     opcode = 27
+	opReaddir     opcode = 28
+	opReleasedir  opcode = 29
+	opFsyncdir    opcode = 30
+	opCreate      opcode = 35
+	opInterrupt   opcode = 36
+	opDestroy     opcode = 38
+	opBatchForget opcode = 42
+)
+
+// The init flags we accept.
+const (
+	asyncRead = 1 << 0
+	bigWrites = 1 << 5
+)
+
+// The valid bits of setattrIn.
+const (
+	fattrMode     = 1 << 0
+	fattrUID      = 1 << 1
+	fattrGID      = 1 << 2
+	fattrSize     = 1 << 3
+	fattrAtime    = 1 << 4
+	fattrMtime    = 1 << 5
+	fattrAtimeNow = 1 << 7
+	fattrMtimeNow = 1 << 8
+)
+
+type inHeader struct {
+	Len         uint32
+	Opcode      opcode
+	Unique      uint64
+	NodeID      uint64
+	UID         uint32
+	GID         uint32
+	PID         uint32
+	TotalExtlen uint16
+	Padding     uint16
+}
+
+type outHeader struct {
+	Len    uint32
+	Error  int32
+	Unique uint64
+}
+
+type attr struct {
+	Ino       uint64
+	Size      uint64
+	Blocks    uint64
+	Atime     uint64
+	Mtime     uint64
+	Ctime     uint64
+	Atimensec uint32
+	Mtimensec uint32
+	Ctimensec uint32
+	Mode      uint32
+	Nlink     uint32
+	UID       uint32
+	GID       uint32
+	Rdev      uint32
+	Blksize   uint32
+	Flags     uint32
+}
+
+type entryOut struct {
+	NodeID         uint64
+	Generation     uint64
+	EntryValid     uint64
+	AttrValid      uint64
+	EntryValidNsec uint32
+	AttrValidNsec  uint32
+	Attr           attr
+}
+
+type attrOut struct {
+	AttrValid     uint64
+	AttrValidNsec uint32
+	Dummy         uint32
+	Attr          attr
+}
+
+type forgetIn struct {
+	Nlookup uint64
+}
+
+type batchForgetIn struct {
+	Count uint32
+	Dummy uint32
+}
+
+type forgetOne struct {
+	NodeID  uint64
+	Nlookup uint64
+}
+
+type setattrIn struct {
+	Valid     uint32
+	Padding   uint32
+	Fh        uint64
+	Size      uint64
+	LockOwner uint64
+	Atime     uint64
+	Mtime     uint64
+	Ctime     uint64
+	Atimensec uint32
+	Mtimensec uint32
+	Ctimensec uint32
+	Mode      uint32
+	Unused4   uint32
+	UID       uint32
+	GID       uint32
+	Unused5   uint32
+}
+
+type mknodIn struct {
+	Mode    uint32
+	Rdev    uint32
+	Umask   uint32
+	Padding uint32
+}
+
+type mkdirIn struct {
+	Mode  uint32
+	Umask uint32
+}
+
+type renameIn struct {
+	Newdir uint64
+}
+
+type linkIn struct {
+	Oldnodeid uint64
+}
+
+type openIn struct {
+	Flags     uint32
+	OpenFlags uint32
+}
+
+type openOut struct {
+	Fh        uint64
+	OpenFlags uint32
+	Padding   uint32
+}
+
+type createIn struct {
+	Flags     uint32
+	Mode      uint32
+	Umask     uint32
+	OpenFlags uint32
+}
+
+type readIn struct {
+	Fh        uint64
+	Offset    uint64
+	Size      uint32
+	ReadFlags uint32
+	LockOwner uint64
+	Flags     uint32
+	Padding   uint32
+}
+
+type writeIn struct {
+	Fh         uint64
+	Offset     uint64
+	Size       uint32
+	WriteFlags uint32
+	LockOwner  uint64
+	Flags      uint32
+	Padding    uint32
+}
+
+type writeOut struct {
+	Size    uint32
+	Padding uint32
+}
+
+type releaseIn struct {
+	Fh           uint64
+	Flags        uint32
+	ReleaseFlags uint32
+	LockOwner    uint64
+}
+
+type fsyncIn struct {
+	Fh         uint64
+	FsyncFlags uint32
+	Padding    uint32
+}
+
+type statfsOut struct {
+	Blocks  uint64
+	Bfree   uint64
+	Bavail  uint64
+	Files   uint64
+	Ffree   uint64
+	Bsize   uint32
+	Namelen uint32
+	Frsize  uint32
+	Padding uint32
+	Spare   [6]uint32
+}
+
+type initIn struct {
+	Major        uint32
+	Minor        uint32
+	MaxReadahead uint32
+	Flags        uint32
+}
+
+type initOut struct {
+	Major               uint32
+	Minor               uint32
+	MaxReadahead        uint32
+	Flags               uint32
+	MaxBackground       uint16
+	CongestionThreshold uint16
+	MaxWrite            uint32
+	TimeGran            uint32
+	MaxPages            uint16
+	MapAlignment        uint16
+	Flags2              uint32
+	MaxStackDepth       uint32
+	Unused              [6]uint32
+}
+
+// dirent is followed by its name, padded to 8 bytes.
+type dirent struct {
+	Ino     uint64
+	Off     uint64
+	Namelen uint32
+	Type    uint32
+}
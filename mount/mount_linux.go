@@ -110,6 +110,15 @@ func mount(m mounter, fstab string) error {
 		flags, data := parse(opts)
 		if e := m(dev, where, fstype, flags, data); e != nil {
 			err = errors.Join(err, fmt.Errorf("Mount(%q, %q, %q, %q=>(%#x, %q)): %w", dev, where, fstype, opts, flags, data, e))
+			continue
+		}
+		// A bind mount ignores all flags but MS_BIND and MS_REC:
+		// to make it read-only, nosuid, and so on, it is remounted.
+		if flags&unix.MS_BIND != 0 && flags&unix.MS_REMOUNT == 0 && flags&^(unix.MS_BIND|unix.MS_REC) != 0 {
+			rflags := unix.MS_REMOUNT | flags&^unix.MS_REC
+			if e := m("", where, "", rflags, ""); e != nil {
+				err = errors.Join(err, fmt.Errorf("Mount(%q, %q, %q, %q=>(%#x)): %w", "", where, "", opts, rflags, e))
+			}
 		}
 	}
 	return err
@@ -10,6 +10,7 @@ package mount
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"syscall"
 	"testing"
 
@@ -95,3 +96,34 @@ func TestParse(t *testing.T) {
 	}
 
 }
+
+func TestBindRemount(t *testing.T) {
+	d := t.TempDir()
+	type call struct {
+		source, target string
+		flags          uintptr
+	}
+	for _, tt := range []struct {
+		opts  string
+		calls []call
+	}{
+		{opts: "defaults,bind", calls: []call{{d, d, unix.MS_BIND}}},
+		{opts: "defaults,bind,ro", calls: []call{{d, d, unix.MS_BIND | unix.MS_RDONLY}, {"", d, unix.MS_REMOUNT | unix.MS_BIND | unix.MS_RDONLY}}},
+		{opts: "bind,rec,ro,nosuid", calls: []call{{d, d, unix.MS_BIND | unix.MS_REC | unix.MS_RDONLY | unix.MS_NOSUID}, {"", d, unix.MS_REMOUNT | unix.MS_BIND | unix.MS_RDONLY | unix.MS_NOSUID}}},
+		{opts: "remount,bind,ro", calls: []call{{d, d, unix.MS_REMOUNT | unix.MS_BIND | unix.MS_RDONLY}}},
+		{opts: "ro", calls: []call{{d, d, unix.MS_RDONLY}}},
+	} {
+		var calls []call
+		m := func(source, target, fstype string, flags uintptr, data string) error {
+			calls = append(calls, call{source, target, flags})
+			return nil
+		}
+		if err := mount(m, d+" "+d+" none "+tt.opts+" 0 0\n"); err != nil {
+			t.Errorf("mount(%q): %v, want nil", tt.opts, err)
+			continue
+		}
+		if !reflect.DeepEqual(calls, tt.calls) {
+			t.Errorf("mount(%q): calls %#x, want %#x", tt.opts, calls, tt.calls)
+		}
+	}
+}
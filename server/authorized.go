@@ -0,0 +1,178 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// contextKey is used to store cpud values in an ssh.Context.
+type contextKey string
+
+// keyOptionsKey is the ssh.Context key for the options of the
+// authorized key used to authenticate a connection.
+const keyOptionsKey = contextKey("cpud-key-options")
+
+// principalKey is the ssh.Context key for the principal of the user
+// certificate used to authenticate a connection.
+const principalKey = contextKey("cpud-principal")
+
+// identityKey is the ssh.Context key for the identity sessions are
+// counted against: the fingerprint of the authorized key, or the
+// principal of the user certificate.
+const identityKey = contextKey("cpud-identity")
+
+// keyOptions are the options of an authorized key, as in
+// the AUTHORIZED_KEYS FILE FORMAT section of sshd(8), e.g.
+//
+//	cpu-limits="memory.max=1G,pids.max=100" ssh-ed25519 AAAA... me@here
+//
+// Options with no value, e.g. no-pty, have an empty value.
+// cpud only interprets options starting with cpu-.
+type keyOptions map[string]string
+
+func parseKeyOptions(opts []string) keyOptions {
+	o := keyOptions{}
+	for _, opt := range opts {
+		k, v, _ := strings.Cut(opt, "=")
+		if len(v) > 1 && v[0] == '"' && v[len(v)-1] == '"' {
+			v = strings.ReplaceAll(v[1:len(v)-1], `\"`, `"`)
+		}
+		o[strings.ToLower(k)] = v
+	}
+	return o
+}
+
+// authorizedKey is one entry in an authorized_keys file.
+type authorizedKey struct {
+	key     ssh.PublicKey
+	comment string
+	options keyOptions
+}
+
+// parseAuthorizedKeys parses all the keys in an authorized_keys file.
+func parseAuthorizedKeys(data []byte) ([]authorizedKey, error) {
+	var keys []authorizedKey
+	for len(bytes.TrimSpace(data)) > 0 {
+		key, comment, options, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, authorizedKey{key: key, comment: comment, options: parseKeyOptions(options)})
+		data = rest
+	}
+	return keys, nil
+}
+
+// findAuthorizedKey reads the authorized keys file, and returns the
+// entry matching key. Entries with the cert-authority option are for
+// certificates, and are skipped.
+func findAuthorizedKey(file string, key ssh.PublicKey) (*authorizedKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseAuthorizedKeys(data)
+	for i := range keys {
+		if _, ca := keys[i].options["cert-authority"]; ca {
+			continue
+		}
+		if ssh.KeysEqual(key, keys[i].key) {
+			return &keys[i], nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("key %s not in %q:%w", gossh.FingerprintSHA256(key), file, os.ErrNotExist)
+}
+
+// findCertAuthority reads the authorized keys file, and returns the
+// entry, with the cert-authority option, for the key that signed
+// cert. cert must be a user certificate, currently valid, for the
+// principal user.
+func findCertAuthority(file, user string, cert *gossh.Certificate) (*authorizedKey, error) {
+	if cert.CertType != gossh.UserCert {
+		return nil, fmt.Errorf("certificate %s is not a user certificate:%w", gossh.FingerprintSHA256(cert), os.ErrPermission)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseAuthorizedKeys(data)
+	for i := range keys {
+		if _, ca := keys[i].options["cert-authority"]; !ca || !ssh.KeysEqual(cert.SignatureKey, keys[i].key) {
+			continue
+		}
+		c := &gossh.CertChecker{}
+		if err := c.CheckCert(user, cert); err != nil {
+			return nil, fmt.Errorf("certificate %s: %v:%w", gossh.FingerprintSHA256(cert), err, os.ErrPermission)
+		}
+		return &keys[i], nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("certificate authority %s not in %q:%w", gossh.FingerprintSHA256(cert.SignatureKey), file, os.ErrNotExist)
+}
+
+// findKey reads the authorized keys file, and returns the entry that
+// authorizes key for user: the entry of key, or, if key is a user
+// certificate, of its certificate authority.
+func findKey(file, user string, key ssh.PublicKey) (*authorizedKey, error) {
+	if cert, ok := key.(*gossh.Certificate); ok {
+		return findCertAuthority(file, user, cert)
+	}
+	return findAuthorizedKey(file, key)
+}
+
+// authorize authorizes a key, or a user certificate, for a connection
+// and saves the options of its entry, and the certificate principal,
+// in ctx. It must only be called once the client has proven it has
+// key: the SSH server checks every key a client offers, including
+// those it only asks about, and those are checked with findKey.
+func authorize(ctx ssh.Context, file string, key ssh.PublicKey) error {
+	k, err := findKey(file, ctx.User(), key)
+	if err != nil {
+		return err
+	}
+	ctx.SetValue(keyOptionsKey, k.options)
+	if _, ok := key.(*gossh.Certificate); !ok {
+		ctx.SetValue(identityKey, gossh.FingerprintSHA256(key))
+		return nil
+	}
+	ctx.SetValue(principalKey, ctx.User())
+	ctx.SetValue(identityKey, ctx.User())
+	return nil
+}
+
+// sessionIdentity returns the identity a session is counted against,
+// which, if no key authorized it, is the SSH user name.
+func sessionIdentity(ctx ssh.Context) string {
+	if id, ok := ctx.Value(identityKey).(string); ok {
+		return id
+	}
+	return ctx.User()
+}
+
+// sessionKeyOptions returns the key options for a session, which
+// are empty if there are none. The options of the listener the
+// connection was accepted on, if any, are applied on top of them;
+// see listenerOptions.
+func sessionKeyOptions(ctx ssh.Context) keyOptions {
+	o := keyOptions{}
+	if opts, ok := ctx.Value(keyOptionsKey).(keyOptions); ok {
+		for n, v := range opts {
+			o[n] = v
+		}
+	}
+	return o
+}
@@ -0,0 +1,215 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupMu serializes changes to the parent cgroup.
+var cgroupMu sync.Mutex
+
+// cgroup is the cgroup v2 directory of one session.
+type cgroup struct {
+	dir string
+	f   *os.File
+}
+
+// cgroupParent returns the cgroup v2 directory in which session
+// cgroups are created. If dir is not empty, it is used; else
+// the cgroup of cpud itself is used.
+func cgroupParent(dir string) (string, error) {
+	if len(dir) > 0 {
+		return dir, nil
+	}
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if p, ok := strings.CutPrefix(s.Text(), "0::"); ok {
+			return filepath.Join(cgroupRoot, p), nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup v2 hierarchy in /proc/self/cgroup:%w", os.ErrNotExist)
+}
+
+// prepare gets a parent cgroup ready to hold session cgroups.
+// cgroup v2 has a "no internal processes" rule: a cgroup which
+// delegates controllers to its children can not itself hold processes.
+// Any processes in the parent, usually just cpud, are moved to a
+// leaf cgroup called cpud.
+func prepare(parent string, controllers []string) error {
+	cgroupMu.Lock()
+	defer cgroupMu.Unlock()
+
+	b, err := os.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("cgroup v2 not available at %q: %w", parent, err)
+	}
+	avail := strings.Fields(string(b))
+	var enable []string
+	for _, c := range controllers {
+		if !slices.Contains(avail, c) {
+			return fmt.Errorf("cgroup controller %q not available in %q:%w", c, parent, os.ErrNotExist)
+		}
+		enable = append(enable, "+"+c)
+	}
+	if len(enable) == 0 {
+		return nil
+	}
+
+	if parent != cgroupRoot {
+		procs, err := os.ReadFile(filepath.Join(parent, "cgroup.procs"))
+		if err != nil {
+			return err
+		}
+		if pids := strings.Fields(string(procs)); len(pids) > 0 {
+			leaf := filepath.Join(parent, "cpud")
+			if err := os.Mkdir(leaf, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+				return err
+			}
+			for _, p := range pids {
+				// Processes may exit while we do this; that is not an error.
+				if err := os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(p), 0); err != nil {
+					verbose("moving %s to %q: %v", p, leaf, err)
+				}
+			}
+		}
+	}
+	return os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte(strings.Join(enable, " ")), 0)
+}
+
+// newCgroup creates a cgroup for a session, and sets its limits.
+func newCgroup(parent, id string, l *Limits) (*cgroup, error) {
+	parent, err := cgroupParent(parent)
+	if err != nil {
+		return nil, err
+	}
+	if err := prepare(parent, l.controllers()); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(parent, "session-"+id)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &cgroup{dir: dir}
+	for _, f := range l.files() {
+		verbose("cgroup %q: %s=%q", dir, f[0], f[1])
+		if err := os.WriteFile(filepath.Join(dir, f[0]), []byte(f[1]), 0); err != nil {
+			c.remove()
+			return nil, fmt.Errorf("setting %s to %q: %w", f[0], f[1], err)
+		}
+	}
+	if c.f, err = os.Open(dir); err != nil {
+		c.remove()
+		return nil, err
+	}
+	return c, nil
+}
+
+// attach arranges for cmd to start in the cgroup.
+func (c *cgroup) attach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(c.f.Fd())
+}
+
+// keyValues reads a cgroup file of the form "key value" per line.
+func (c *cgroup) keyValues(n string) map[string]uint64 {
+	kv := map[string]uint64{}
+	b, err := os.ReadFile(filepath.Join(c.dir, n))
+	if err != nil {
+		return kv
+	}
+	for _, l := range strings.Split(string(b), "\n") {
+		if f := strings.Fields(l); len(f) == 2 {
+			if v, err := strconv.ParseUint(f[1], 10, 64); err == nil {
+				kv[f[0]] = v
+			}
+		}
+	}
+	return kv
+}
+
+func (c *cgroup) value(n string) (uint64, bool) {
+	b, err := os.ReadFile(filepath.Join(c.dir, n))
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	return v, err == nil
+}
+
+// accounting returns a summary of the resources used by the session.
+// Not all files exist on all kernels; missing ones are skipped.
+func (c *cgroup) accounting() string {
+	var s []string
+	cpu := c.keyValues("cpu.stat")
+	for _, k := range []string{"usage_usec", "user_usec", "system_usec", "nr_throttled"} {
+		if v, ok := cpu[k]; ok {
+			s = append(s, fmt.Sprintf("cpu.%s=%d", k, v))
+		}
+	}
+	for _, n := range []string{"memory.peak", "pids.peak"} {
+		if v, ok := c.value(n); ok {
+			s = append(s, fmt.Sprintf("%s=%d", n, v))
+		}
+	}
+	var rbytes, wbytes uint64
+	if b, err := os.ReadFile(filepath.Join(c.dir, "io.stat")); err == nil {
+		for _, f := range strings.Fields(string(b)) {
+			k, v, ok := strings.Cut(f, "=")
+			if !ok {
+				continue
+			}
+			n, _ := strconv.ParseUint(v, 10, 64)
+			switch k {
+			case "rbytes":
+				rbytes += n
+			case "wbytes":
+				wbytes += n
+			}
+		}
+		s = append(s, fmt.Sprintf("io.rbytes=%d", rbytes), fmt.Sprintf("io.wbytes=%d", wbytes))
+	}
+	return strings.Join(s, " ")
+}
+
+// remove kills any processes left in the cgroup, and removes it.
+// Orphans may take a little while to be reaped, so the rmdir is retried.
+func (c *cgroup) remove() error {
+	if c.f != nil {
+		c.f.Close()
+	}
+	if err := os.WriteFile(filepath.Join(c.dir, "cgroup.kill"), []byte("1"), 0); err != nil {
+		verbose("cgroup.kill %q: %v", c.dir, err)
+	}
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = os.Remove(c.dir); err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
@@ -0,0 +1,31 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// cgroup is not supported on this kernel.
+type cgroup struct{}
+
+func newCgroup(parent, id string, l *Limits) (*cgroup, error) {
+	return nil, fmt.Errorf("session cgroups are only supported on Linux:%w", os.ErrInvalid)
+}
+
+func (c *cgroup) attach(cmd *exec.Cmd) {
+}
+
+func (c *cgroup) accounting() string {
+	return ""
+}
+
+func (c *cgroup) remove() error {
+	return nil
+}
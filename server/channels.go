@@ -0,0 +1,32 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// channelsRequest is the global request with which a client that would
+// serve several 9P connections asks how many of them sessions mount.
+// A cpud that does not know it mounts only one, on /tmp/cpu.
+const channelsRequest = "channels@u-root.org"
+
+// maxChannels is the most 9P connections a session mounts, on /tmp/cpu
+// and /tmp/cpu.1 to /tmp/cpu.15.
+const maxChannels = 16
+
+// handleChannels answers the channelsRequest of a client, for some
+// number of 9P connections, with how many of them sessions mount.
+func handleChannels(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+	var ask struct{ Channels uint32 }
+	if err := gossh.Unmarshal(req.Payload, &ask); err != nil || ask.Channels < 1 {
+		verbose("%s: %q is not a number of channels: %v", channelsRequest, req.Payload, err)
+		return false, nil
+	}
+	n := min(ask.Channels, maxChannels)
+	verbose("%s: mounting %d of %d channels", channelsRequest, n, ask.Channels)
+	return true, gossh.Marshal(&struct{ Channels uint32 }{n})
+}
@@ -0,0 +1,38 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestHandleChannels(t *testing.T) {
+	for _, tt := range []struct {
+		payload []byte
+		ok      bool
+		want    uint32
+	}{
+		{payload: nil},
+		{payload: gossh.Marshal(&struct{ Channels uint32 }{0})},
+		{payload: gossh.Marshal(&struct{ Channels uint32 }{1}), ok: true, want: 1},
+		{payload: gossh.Marshal(&struct{ Channels uint32 }{4}), ok: true, want: 4},
+		{payload: gossh.Marshal(&struct{ Channels uint32 }{100}), ok: true, want: maxChannels},
+	} {
+		ok, reply := handleChannels(nil, nil, &gossh.Request{Type: channelsRequest, Payload: tt.payload})
+		if ok != tt.ok {
+			t.Errorf("handleChannels(%q): %v, want %v", tt.payload, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		var got struct{ Channels uint32 }
+		if err := gossh.Unmarshal(reply, &got); err != nil || got.Channels != tt.want {
+			t.Errorf("handleChannels(%q): %d, %v, want %d, nil", tt.payload, got.Channels, err, tt.want)
+		}
+	}
+}
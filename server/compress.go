@@ -0,0 +1,160 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/u-root/cpu/compress"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// compressKey is the ssh.Context key for the algorithm the channels of
+// remote forwards are compressed with, if they are.
+const compressKey = contextKey("cpud-compress")
+
+// handleCompress answers the compress.Request of a client: it chooses
+// the first of the algorithms the client offers that cpud knows, and
+// the channels of the remote forwards of the connection are, from then
+// on, compressed with it.
+func handleCompress(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+	var offer struct{ Algorithms string }
+	if err := gossh.Unmarshal(req.Payload, &offer); err != nil {
+		verbose("%s: %v", compress.Request, err)
+		return false, nil
+	}
+	alg := compress.Choose(strings.Split(offer.Algorithms, ","))
+	if alg == "" {
+		verbose("%s: none of %q is known", compress.Request, offer.Algorithms)
+		return false, nil
+	}
+	ctx.SetValue(compressKey, alg)
+	verbose("%s: compressing with %s", compress.Request, alg)
+	return true, gossh.Marshal(&struct{ Algorithm string }{alg})
+}
+
+// The payloads of remote forwards, as in RFC 4254, section 7.
+type (
+	remoteForwardRequest struct {
+		BindAddr string
+		BindPort uint32
+	}
+	remoteForwardSuccess struct {
+		BindPort uint32
+	}
+	remoteForwardChannelData struct {
+		DestAddr   string
+		DestPort   uint32
+		OriginAddr string
+		OriginPort uint32
+	}
+)
+
+// forwardedTCPHandler serves remote forwards, as ssh.ForwardedTCPHandler
+// does, but compresses the channels of a connection that asked for it.
+// Forwards are known by the address they are bound to, so that those
+// of port 0, of which cpu makes many, are each canceled alone.
+type forwardedTCPHandler struct {
+	mu       sync.Mutex
+	forwards map[string]net.Listener
+}
+
+// HandleSSHRequest handles tcpip-forward and cancel-tcpip-forward.
+func (h *forwardedTCPHandler) HandleSSHRequest(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+	var r remoteForwardRequest
+	if err := gossh.Unmarshal(req.Payload, &r); err != nil {
+		verbose("%s: %v", req.Type, err)
+		return false, nil
+	}
+	switch req.Type {
+	case "tcpip-forward":
+		if srv.ReversePortForwardingCallback == nil || !srv.ReversePortForwardingCallback(ctx, r.BindAddr, r.BindPort) {
+			return false, []byte("port forwarding is disabled")
+		}
+		ln, err := net.Listen("tcp", net.JoinHostPort(r.BindAddr, strconv.Itoa(int(r.BindPort))))
+		if err != nil {
+			verbose("%s: %v", req.Type, err)
+			return false, nil
+		}
+		_, p, _ := net.SplitHostPort(ln.Addr().String())
+		port, _ := strconv.Atoi(p)
+		addr := net.JoinHostPort(r.BindAddr, p)
+		h.mu.Lock()
+		if h.forwards == nil {
+			h.forwards = map[string]net.Listener{}
+		}
+		h.forwards[addr] = ln
+		h.mu.Unlock()
+		go func() {
+			<-ctx.Done()
+			ln.Close()
+		}()
+		go h.serve(ctx, ln, addr, remoteForwardChannelData{DestAddr: r.BindAddr, DestPort: uint32(port)})
+		return true, gossh.Marshal(&remoteForwardSuccess{uint32(port)})
+	case "cancel-tcpip-forward":
+		h.mu.Lock()
+		ln, ok := h.forwards[net.JoinHostPort(r.BindAddr, strconv.Itoa(int(r.BindPort)))]
+		h.mu.Unlock()
+		if ok {
+			ln.Close()
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// serve accepts connections on ln, until it is closed, and forwards
+// each to the client.
+func (h *forwardedTCPHandler) serve(ctx ssh.Context, ln net.Listener, addr string, d remoteForwardChannelData) {
+	defer func() {
+		h.mu.Lock()
+		delete(h.forwards, addr)
+		h.mu.Unlock()
+	}()
+	conn := ctx.Value(ssh.ContextKeyConn).(*gossh.ServerConn)
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			host, p, _ := net.SplitHostPort(c.RemoteAddr().String())
+			port, _ := strconv.Atoi(p)
+			d.OriginAddr, d.OriginPort = host, uint32(port)
+			ch, reqs, err := conn.OpenChannel("forwarded-tcpip", gossh.Marshal(&d))
+			if err != nil {
+				log.Printf("CPUD:forward of %v: %v", c.RemoteAddr(), err)
+				c.Close()
+				return
+			}
+			go gossh.DiscardRequests(reqs)
+			var rwc io.ReadWriteCloser = ch
+			if alg, ok := ctx.Value(compressKey).(string); ok {
+				if rwc, err = compress.New(ch, alg); err != nil {
+					log.Printf("CPUD:forward of %v: %v", c.RemoteAddr(), err)
+					ch.Close()
+					c.Close()
+					return
+				}
+			}
+			go func() {
+				defer rwc.Close()
+				defer c.Close()
+				io.Copy(rwc, c)
+			}()
+			go func() {
+				defer rwc.Close()
+				defer c.Close()
+				io.Copy(c, rwc)
+			}()
+		}()
+	}
+}
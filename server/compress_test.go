@@ -0,0 +1,128 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/u-root/cpu/compress"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestCompressForwards(t *testing.T) {
+	d := t.TempDir()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ak := filepath.Join(d, "authorized_keys")
+	if err := os.WriteFile(ak, gossh.MarshalAuthorizedKey(signer.PublicKey()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(ak, "", os.Args[0])
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln) //nolint
+	defer s.Close()
+
+	data := bytes.Repeat([]byte("compressible "), 1000)
+	for _, tt := range []struct {
+		offer string
+		want  string
+	}{
+		{},
+		{offer: "lz4"},
+		{offer: "lz4,zlib", want: "zlib"},
+		{offer: "zstd,zlib", want: "zstd"},
+	} {
+		c, err := gossh.Dial("tcp", ln.Addr().String(), &gossh.ClientConfig{
+			User:            "glenda",
+			Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+			Timeout:         10 * time.Second,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if tt.offer != "" {
+			ok, reply, err := c.SendRequest(compress.Request, true, gossh.Marshal(&struct{ Algorithms string }{tt.offer}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var chosen struct{ Algorithm string }
+			if ok {
+				if err := gossh.Unmarshal(reply, &chosen); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if chosen.Algorithm != tt.want {
+				t.Errorf("offer %q: chose %q, want %q", tt.offer, chosen.Algorithm, tt.want)
+			}
+		}
+
+		// What is written to the forward arrives on the channel,
+		// compressed, if it was asked for, or not.
+		l, err := c.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		go func() {
+			f, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			f.Write(data)
+			f.Close()
+		}()
+		ch, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var raw bytes.Buffer
+		var r io.Reader = io.TeeReader(ch, &raw)
+		if tt.want != "" {
+			s, err := compress.New(struct {
+				io.Reader
+				io.WriteCloser
+			}{r, ch}, tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = s
+		}
+		got, err := io.ReadAll(r)
+		ch.Close()
+		if err != nil {
+			t.Errorf("offer %q: reading forward: %v", tt.offer, err)
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("offer %q: read %d bytes, want %d", tt.offer, len(got), len(data))
+		}
+		if compressed := raw.Len() < len(data); compressed != (tt.want != "") {
+			t.Errorf("offer %q: %d bytes on the channel for %d: compressed is %v, want %v", tt.offer, raw.Len(), len(data), compressed, tt.want != "")
+		}
+	}
+}
@@ -33,7 +33,13 @@
 // a call to a New(), preceded or followed by a call to net.Listen to get
 // a socket, and a call to Serve with the listener. For a usage example,
 // see TestDaemonConnect. The handler code is made a bit messy by the
-// need to support PTYs.
+// need to support PTYs. One server can Serve several listeners at
+// once, e.g. TCP, vsock and Unix sockets; ScopeListener gives the
+// connections accepted by a listener their own options, which only
+// restrict them further than the authorized key does. With
+// WithHostKeyDir, host keys are generated once, and kept, rather than
+// made up on each start, and are announced to clients, so that they
+// can be replaced.
 //
 // Each connection to the server results in the invocation of the
 // commands send from the client. The most common command is something
@@ -47,6 +53,65 @@
 // cpud is -port9p. The actual command, if non-empty, must not start
 // with a -.
 //
+// On Linux, each session can be run in its own cgroup v2, with
+// resource limits set server-wide (WithLimits), per key (the
+// cpu-limits authorized_keys option), or requested by the client
+// in the CPU_LIMITS environment variable and capped by the server.
+// The resources a session used are logged when it ends.
+//
+// Sessions can also be run in PID, network, UTS, IPC and user
+// namespaces. The namespaces a session must run in, and those a
+// client may request in CPU_NAMESPACES, are set server-wide
+// (WithNamespaces) or per key (the cpu-ns and cpu-nsallow
+// authorized_keys options). They are passed to the session in
+// CPUD_NAMESPACES_REQUIRED and CPUD_NAMESPACES_ALLOWED; clients
+// can not set CPUD_ variables.
+//
+// Session commands can be confined: the capability bounding set
+// reduced (WithCaps), no_new_privs set (WithNoNewPrivs), and a
+// seccomp profile installed (WithSeccomp). A key can select its own
+// capabilities and profile with the cpu-caps and cpu-seccomp
+// authorized_keys options.
+//
+// Port forwards are allowed or denied by a policy (WithForwards, or
+// the cpu-forward authorized_keys option), by direction, host, port
+// and SSH user name. The remote forwards cpu uses for 9P and NFS are
+// always allowed; by default, other remote forwards are too, and local
+// forwards are not. A client can ask, with the compress@u-root.org
+// global request, that the channels of its remote forwards be
+// compressed, with zstd or zlib, as described in package compress.
+// With the channels@u-root.org global request, a client that would
+// serve several 9P connections asks how many sessions mount.
+//
+// Sessions run as cpud, unless WithUsers maps them to local accounts:
+// the account is named by the cpu-user authorized_keys option, the
+// principal of a user certificate, or the SSH user name. Certificates
+// are signed by keys with the cert-authority option. The client
+// chooses the SSH user name, so it must be the account the key or
+// certificate names, if any, and can only be root if they name it.
+// The account is passed to the session in CPUD_USER.
+//
+// cpud need not be root. With WithRootless, each session starts in a
+// new user and mount namespace, in which the user running cpud is
+// mapped to itself and can mount tmpfs, bind mounts and FUSE. The
+// session mounts the client namespace with FUSE, not the kernel 9P
+// client, which needs real root.
+//
+// cpud can limit how many sessions run at once, in all and per
+// identity (WithMaxSessions), how long they run (WithMaxSessionTime),
+// how many connections wait to authenticate (WithMaxStartups) and
+// for how long (WithLoginGrace); hosts that fail to authenticate are refused for a time that grows
+// with each failure (WithAuthBackoff). Refused connections get an SSH
+// disconnect saying why. Running sessions are recorded in a Registry,
+// which can be shared with WithRegistry to list and stop them.
+//
+// The options of a running cpud can be changed with a Reloader
+// (WithReloader); new sessions use the new options.
+//
+// The sftp subsystem is served as a session is, by cpud run as an SFTP
+// server (see package sftp), in the namespaces and with the policies
+// of a session.
+//
 // This package also provides a Session type, created by a call to
 // NewSession.  Sessions are very similar to exec.Command, providing
 // access to Stdin, Stdout, Stderr and a Wait function, for example,
@@ -0,0 +1,163 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Forwards is a port forwarding policy: a list of rules, the first of
+// which to match a forward allows or denies it. Forwards no rule
+// matches are denied.
+//
+// Rules are separated by semicolons or newlines, and are written as
+//
+//	allow|deny local|remote|any host:port [user]
+//
+// Local forwards, e.g. ssh -L, are matched by the destination they
+// connect to; remote forwards, e.g. ssh -R, by the address they bind.
+// The host is a pattern, as in path.Match, for the host as the client
+// sent it, e.g. a name or an address; IPv6 addresses are in brackets.
+// The port is *, a number, or a range, e.g. 8000-8999. The user, if
+// present, is a pattern for the SSH user name, which is the principal
+// for certificates. e.g.
+//
+//	allow local *.lab.example.com:22 glenda; deny any *:*
+//
+// The remote forwards cpu uses for its 9P and NFS back-channels, which
+// bind port 0 on a loopback address, are always allowed.
+type Forwards []ForwardRule
+
+// ForwardRule is one rule of a Forwards policy.
+type ForwardRule struct {
+	Allow  bool
+	Local  bool
+	Remote bool
+	Host   string
+	// Lo and Hi are the range of ports.
+	Lo, Hi uint32
+	User   string
+}
+
+// defaultForwards allows remote forwards, as cpud always has, but
+// no local forwards, so that cpud is not an open proxy.
+var defaultForwards = Forwards{{Allow: true, Remote: true, Host: "*", Hi: 65535, User: "*"}}
+
+// ParseForwards parses a forwarding policy, as described in Forwards.
+// An empty string returns nil.
+func ParseForwards(s string) (Forwards, error) {
+	var f Forwards
+	for _, r := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == '\n' }) {
+		w := strings.Fields(r)
+		if len(w) == 0 {
+			continue
+		}
+		if len(w) < 3 || len(w) > 4 {
+			return nil, fmt.Errorf("forward rule %q: not action direction host:port [user]:%w", r, strconv.ErrSyntax)
+		}
+		rule := ForwardRule{User: "*"}
+		switch w[0] {
+		case "allow":
+			rule.Allow = true
+		case "deny":
+		default:
+			return nil, fmt.Errorf("forward rule %q: %q is not allow or deny:%w", r, w[0], strconv.ErrSyntax)
+		}
+		switch w[1] {
+		case "local":
+			rule.Local = true
+		case "remote":
+			rule.Remote = true
+		case "any":
+			rule.Local, rule.Remote = true, true
+		default:
+			return nil, fmt.Errorf("forward rule %q: %q is not local, remote or any:%w", r, w[1], strconv.ErrSyntax)
+		}
+		host, port, err := net.SplitHostPort(w[2])
+		if err != nil {
+			return nil, fmt.Errorf("forward rule %q: %v:%w", r, err, strconv.ErrSyntax)
+		}
+		if _, err := path.Match(host, ""); err != nil {
+			return nil, fmt.Errorf("forward rule %q: host %q: %v:%w", r, host, err, strconv.ErrSyntax)
+		}
+		rule.Host = strings.ToLower(host)
+		if rule.Lo, rule.Hi, err = parsePorts(port); err != nil {
+			return nil, fmt.Errorf("forward rule %q: %w", r, err)
+		}
+		if len(w) == 4 {
+			if _, err := path.Match(w[3], ""); err != nil {
+				return nil, fmt.Errorf("forward rule %q: user %q: %v:%w", r, w[3], err, strconv.ErrSyntax)
+			}
+			rule.User = w[3]
+		}
+		f = append(f, rule)
+	}
+	return f, nil
+}
+
+// parsePorts parses *, a port, or a range of ports.
+func parsePorts(s string) (uint32, uint32, error) {
+	if s == "*" {
+		return 0, 65535, nil
+	}
+	l, h, ok := strings.Cut(s, "-")
+	if !ok {
+		h = l
+	}
+	lo, err := strconv.ParseUint(l, 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("port %q: %w", s, err)
+	}
+	hi, err := strconv.ParseUint(h, 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("port %q: %w", s, err)
+	}
+	if lo > hi {
+		return 0, 0, fmt.Errorf("port %q: %w", s, strconv.ErrRange)
+	}
+	return uint32(lo), uint32(hi), nil
+}
+
+func (r *ForwardRule) match(local bool, user, host string, port uint32) bool {
+	if (local && !r.Local) || (!local && !r.Remote) || port < r.Lo || port > r.Hi {
+		return false
+	}
+	if ok, _ := path.Match(r.Host, strings.ToLower(host)); !ok {
+		return false
+	}
+	ok, _ := path.Match(r.User, user)
+	return ok
+}
+
+// backChannel returns true if a remote forward is one of those cpu
+// makes for 9P and NFS.
+func backChannel(host string, port uint32) bool {
+	if port != 0 {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Allow returns true if user may forward to, if local is true, or
+// bind, if it is not, host and port.
+func (f Forwards) Allow(local bool, user, host string, port uint32) bool {
+	if !local && backChannel(host, port) {
+		return true
+	}
+	for i := range f {
+		if f[i].match(local, user, host, port) {
+			return f[i].Allow
+		}
+	}
+	return false
+}
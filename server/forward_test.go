@@ -0,0 +1,89 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestParseForwards(t *testing.T) {
+	for _, tt := range []struct {
+		in    string
+		rules Forwards
+		err   error
+	}{
+		{in: "", rules: nil},
+		{in: "allow local host:22", rules: Forwards{{Allow: true, Local: true, Host: "host", Lo: 22, Hi: 22, User: "*"}}},
+		{in: "deny any *:* glenda", rules: Forwards{{Local: true, Remote: true, Host: "*", Hi: 65535, User: "glenda"}}},
+		{in: "allow remote [::1]:8000-8999;\n deny remote *.Lab:1 ", rules: Forwards{
+			{Allow: true, Remote: true, Host: "::1", Lo: 8000, Hi: 8999, User: "*"},
+			{Remote: true, Host: "*.lab", Lo: 1, Hi: 1, User: "*"},
+		}},
+		{in: "allow local host", err: strconv.ErrSyntax},
+		{in: "permit local host:22", err: strconv.ErrSyntax},
+		{in: "allow sideways host:22", err: strconv.ErrSyntax},
+		{in: "allow local host:ssh", err: strconv.ErrSyntax},
+		{in: "allow local host:70000", err: strconv.ErrRange},
+		{in: "allow local host:9-8", err: strconv.ErrRange},
+		{in: "allow local [a:22", err: strconv.ErrSyntax},
+		{in: "allow local host:22 glenda rob", err: strconv.ErrSyntax},
+		{in: "allow local host:22 [", err: strconv.ErrSyntax},
+	} {
+		f, err := ParseForwards(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ParseForwards(%q): %v != %v", tt.in, err, tt.err)
+			continue
+		}
+		if len(f) != len(tt.rules) {
+			t.Errorf("ParseForwards(%q): %+v != %+v", tt.in, f, tt.rules)
+			continue
+		}
+		for i := range f {
+			if f[i] != tt.rules[i] {
+				t.Errorf("ParseForwards(%q)[%d]: %+v != %+v", tt.in, i, f[i], tt.rules[i])
+			}
+		}
+	}
+}
+
+func TestForwardsAllow(t *testing.T) {
+	f, err := ParseForwards("allow local *.lab:22 glenda; deny local bad.lab:*; allow local *.lab:8000-8999; allow remote 127.0.0.1:*; deny any *:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		policy Forwards
+		local  bool
+		user   string
+		host   string
+		port   uint32
+		ok     bool
+	}{
+		{policy: f, local: true, user: "glenda", host: "a.lab", port: 22, ok: true},
+		{policy: f, local: true, user: "glenda", host: "A.LAB", port: 22, ok: true},
+		{policy: f, local: true, user: "rob", host: "a.lab", port: 22, ok: false},
+		{policy: f, local: true, user: "rob", host: "a.lab", port: 8080, ok: true},
+		{policy: f, local: true, user: "glenda", host: "bad.lab", port: 22, ok: true},
+		{policy: f, local: true, user: "rob", host: "bad.lab", port: 8080, ok: false},
+		{policy: f, local: true, user: "rob", host: "10.0.0.1", port: 8080, ok: false},
+		{policy: f, local: false, user: "rob", host: "127.0.0.1", port: 8080, ok: true},
+		{policy: f, local: false, user: "rob", host: "0.0.0.0", port: 8080, ok: false},
+		// The back-channels are always allowed.
+		{policy: f, local: false, user: "rob", host: "::1", port: 0, ok: true},
+		{policy: f, local: false, user: "rob", host: "localhost", port: 0, ok: true},
+		{policy: nil, local: false, user: "rob", host: "127.0.0.1", port: 0, ok: true},
+		{policy: nil, local: false, user: "rob", host: "0.0.0.0", port: 0, ok: false},
+		{policy: nil, local: true, user: "rob", host: "127.0.0.1", port: 0, ok: false},
+		// By default, only remote forwards are allowed.
+		{policy: defaultForwards, local: false, user: "rob", host: "0.0.0.0", port: 8080, ok: true},
+		{policy: defaultForwards, local: true, user: "rob", host: "127.0.0.1", port: 22, ok: false},
+	} {
+		if ok := tt.policy.Allow(tt.local, tt.user, tt.host, tt.port); ok != tt.ok {
+			t.Errorf("Allow(%v, %q, %q, %d): %v != %v", tt.local, tt.user, tt.host, tt.port, ok, tt.ok)
+		}
+	}
+}
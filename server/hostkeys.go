@@ -0,0 +1,261 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	// hostKeysRequest announces the host keys to a client, which
+	// can then learn new ones, as in OpenSSH's PROTOCOL.
+	hostKeysRequest = "hostkeys-00@openssh.com"
+	// hostKeysProve asks the server to prove it has the private
+	// keys of host keys it announced.
+	hostKeysProve = "hostkeys-prove-00@openssh.com"
+)
+
+// hostKeysSentKey is the ssh.Context key that is set once the host keys
+// have been announced on a connection.
+const hostKeysSentKey = contextKey("cpud-host-keys-sent")
+
+// DefaultHostKeyTypes are the types of host keys generated if there are
+// none of them.
+var DefaultHostKeyTypes = []string{"ed25519", "ecdsa"}
+
+// WithHostKeyDir sets the directory of host keys, used if there is no
+// host key file. Its private keys are files named ssh_host_*_key. Keys
+// named ssh_host_TYPE_key, for types ed25519, ecdsa and rsa, are used,
+// and are generated if there is none of that type; if no types are
+// given, DefaultHostKeyTypes are. Other keys, e.g.
+// ssh_host_ed25519_next_key, are not used, but, like the keys that
+// are, are announced to clients, so that keys can be replaced without
+// clients finding a key they do not know.
+func WithHostKeyDir(dir string, types ...string) Set {
+	return func(d *daemon) error {
+		if len(types) == 0 {
+			types = DefaultHostKeyTypes
+		}
+		for _, t := range types {
+			if _, ok := hostKeyTypes[t]; !ok {
+				return fmt.Errorf("host key type %q: not ed25519, ecdsa or rsa:%w", t, os.ErrInvalid)
+			}
+		}
+		d.hostKeyDir, d.hostKeyTypes = dir, types
+		return nil
+	}
+}
+
+// hostKeyTypes generate private keys of each type of host key.
+var hostKeyTypes = map[string]func() (crypto.Signer, error){
+	"ed25519": func() (crypto.Signer, error) {
+		_, k, err := ed25519.GenerateKey(rand.Reader)
+		return k, err
+	},
+	"ecdsa": func() (crypto.Signer, error) {
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	},
+	"rsa": func() (crypto.Signer, error) {
+		return rsa.GenerateKey(rand.Reader, 3072)
+	},
+}
+
+// hostKeyFile is the name of the host key of a type that is used.
+func hostKeyFile(dir, t string) string {
+	return filepath.Join(dir, "ssh_host_"+t+"_key")
+}
+
+// writeHostKey writes a private key, and its public key, to file and
+// file.pub. The private key is written to a temporary file, renamed
+// when complete, so that a key is never half written.
+func writeHostKey(file string, k crypto.Signer) (gossh.Signer, error) {
+	b, err := gossh.MarshalPrivateKey(k, "cpud")
+	if err != nil {
+		return nil, err
+	}
+	s, err := gossh.NewSignerFromSigner(k)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(file+".pub", gossh.MarshalAuthorizedKey(s.PublicKey()), 0o644); err != nil {
+		return nil, err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(b), 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return s, nil
+}
+
+// hostKeys are the host keys from a directory.
+type hostKeys struct {
+	// used are the keys used in key exchange.
+	used []gossh.Signer
+	// announced are the keys announced to clients, including those
+	// used.
+	announced []gossh.Signer
+}
+
+// loadHostKeys reads the host keys in dir, which is created if need be,
+// and generates keys of types that it does not have.
+func loadHostKeys(dir string, types []string) (*hostKeys, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "ssh_host_*_key"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	hk, have := &hostKeys{}, map[string]bool{}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		s, err := gossh.ParsePrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("host key %q: %w", f, err)
+		}
+		hk.announced = append(hk.announced, s)
+		t := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), "ssh_host_"), "_key")
+		if _, ok := hostKeyTypes[t]; ok && f == hostKeyFile(dir, t) {
+			hk.used = append(hk.used, s)
+			have[t] = true
+		}
+	}
+	for _, t := range types {
+		if have[t] {
+			continue
+		}
+		k, err := hostKeyTypes[t]()
+		if err != nil {
+			return nil, err
+		}
+		f := hostKeyFile(dir, t)
+		s, err := writeHostKey(f, k)
+		if err != nil {
+			return nil, fmt.Errorf("host key %q: %w", f, err)
+		}
+		log.Printf("CPUD:generated host key %q %s", f, gossh.FingerprintSHA256(s.PublicKey()))
+		hk.used = append(hk.used, s)
+		hk.announced = append(hk.announced, s)
+		have[t] = true
+	}
+	return hk, nil
+}
+
+// announce sends the host keys to the client of a connection, once.
+func (hk *hostKeys) announce(ctx ssh.Context, conn gossh.Conn) {
+	ctx.Lock()
+	sent := ctx.Value(hostKeysSentKey) != nil
+	if !sent {
+		ctx.SetValue(hostKeysSentKey, true)
+	}
+	ctx.Unlock()
+	if sent {
+		return
+	}
+	var b []byte
+	for _, s := range hk.announced {
+		b = append(b, gossh.Marshal(struct{ Key []byte }{s.PublicKey().Marshal()})...)
+	}
+	if _, _, err := conn.SendRequest(hostKeysRequest, false, b); err != nil {
+		verbose("announcing host keys: %v", err)
+	}
+}
+
+// channelHandler returns a handler that announces the host keys before
+// it calls h. There is no hook for a connection once it is
+// authenticated, so they are announced on its first channel or
+// request.
+func (hk *hostKeys) channelHandler(h ssh.ChannelHandler) ssh.ChannelHandler {
+	return func(srv *ssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx ssh.Context) {
+		hk.announce(ctx, conn)
+		h(srv, conn, newChan, ctx)
+	}
+}
+
+// requestHandler is channelHandler for global requests.
+func (hk *hostKeys) requestHandler(h ssh.RequestHandler) ssh.RequestHandler {
+	return func(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+		if conn, ok := ctx.Value(ssh.ContextKeyConn).(gossh.Conn); ok {
+			hk.announce(ctx, conn)
+		}
+		return h(ctx, srv, req)
+	}
+}
+
+// prove answers a request from a client to prove the server has the
+// private keys of host keys it announced, with a signature, for each,
+// of the request name, the session ID and the key.
+func (hk *hostKeys) prove(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+	conn, ok := ctx.Value(ssh.ContextKeyConn).(gossh.Conn)
+	if !ok {
+		return false, nil
+	}
+	var sigs []byte
+	for rest := req.Payload; len(rest) > 0; {
+		var k struct {
+			Key  []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := gossh.Unmarshal(rest, &k); err != nil {
+			verbose("%s: %v", hostKeysProve, err)
+			return false, nil
+		}
+		rest = k.Rest
+		sig, err := hk.sign(k.Key, gossh.Marshal(struct {
+			Request   string
+			SessionID []byte
+			Key       []byte
+		}{hostKeysProve, conn.SessionID(), k.Key}))
+		if err != nil {
+			verbose("%s: %v", hostKeysProve, err)
+			return false, nil
+		}
+		sigs = append(sigs, gossh.Marshal(struct{ Sig []byte }{gossh.Marshal(sig)})...)
+	}
+	return true, sigs
+}
+
+// sign signs data with the announced host key whose public key is key.
+// RSA keys sign with SHA-512, as OpenSSH prefers.
+func (hk *hostKeys) sign(key, data []byte) (*gossh.Signature, error) {
+	pk, err := gossh.ParsePublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range hk.announced {
+		if !bytes.Equal(s.PublicKey().Marshal(), key) {
+			continue
+		}
+		if as, ok := s.(gossh.AlgorithmSigner); ok && s.PublicKey().Type() == gossh.KeyAlgoRSA {
+			return as.SignWithAlgorithm(rand.Reader, data, gossh.KeyAlgoRSASHA512)
+		}
+		return s.Sign(rand.Reader, data)
+	}
+	return nil, fmt.Errorf("%s is not an announced host key:%w", gossh.FingerprintSHA256(pk), os.ErrNotExist)
+}
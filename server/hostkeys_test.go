@@ -0,0 +1,236 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// fingerprints returns the sorted fingerprints of keys.
+func fingerprints(keys []gossh.Signer) []string {
+	var f []string
+	for _, k := range keys {
+		f = append(f, gossh.FingerprintSHA256(k.PublicKey()))
+	}
+	sort.Strings(f)
+	return f
+}
+
+func TestLoadHostKeys(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cpud")
+	if err := WithHostKeyDir(dir, "dsa")(&daemon{}); err == nil {
+		t.Errorf("WithHostKeyDir with dsa keys: nil, want an error")
+	}
+	hk, err := loadHostKeys(dir, DefaultHostKeyTypes)
+	if err != nil {
+		t.Fatalf("loadHostKeys: %v", err)
+	}
+	if len(hk.used) != 2 || len(hk.announced) != 2 {
+		t.Fatalf("loadHostKeys: %d keys used and %d announced, want 2 and 2", len(hk.used), len(hk.announced))
+	}
+	for _, tt := range DefaultHostKeyTypes {
+		fi, err := os.Stat(hostKeyFile(dir, tt))
+		if err != nil {
+			t.Errorf("%s key: %v", tt, err)
+			continue
+		}
+		if fi.Mode().Perm() != 0o600 {
+			t.Errorf("%s key mode: %v, want %v", tt, fi.Mode().Perm(), os.FileMode(0o600))
+		}
+		if _, err := os.Stat(hostKeyFile(dir, tt) + ".pub"); err != nil {
+			t.Errorf("%s public key: %v", tt, err)
+		}
+	}
+
+	// The keys are kept, and a next key is only announced.
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := writeHostKey(filepath.Join(dir, "ssh_host_ed25519_next_key"), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := loadHostKeys(dir, DefaultHostKeyTypes)
+	if err != nil {
+		t.Fatalf("loadHostKeys again: %v", err)
+	}
+	if got, want := fingerprints(again.used), fingerprints(hk.used); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys used after loading again: %q, want %q", got, want)
+	}
+	if got, want := fingerprints(again.announced), fingerprints(append(hk.used, next)); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys announced with a next key: %q, want %q", got, want)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "ssh_host_bad_key"), []byte("no key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadHostKeys(dir, DefaultHostKeyTypes); err == nil {
+		t.Errorf("loadHostKeys with a key that is not valid: nil, want an error")
+	}
+
+	// A host key directory that can not be made, or read, does not
+	// stop cpud: it uses a key that lasts until it exits.
+	for _, d := range []string{filepath.Join(dir, "ssh_host_bad_key", "cpud"), dir} {
+		s, err := New("", "", os.Args[0], WithHostKeyDir(d))
+		if err != nil {
+			t.Errorf("New with host key directory %q: %v, want nil", d, err)
+			continue
+		}
+		if _, ok := s.RequestHandlers[hostKeysProve]; ok {
+			t.Errorf("New with host key directory %q: %s handled, want not", d, hostKeysProve)
+		}
+	}
+}
+
+func TestHostKeysAnnounced(t *testing.T) {
+	d := t.TempDir()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ak := filepath.Join(d, "authorized_keys")
+	if err := os.WriteFile(ak, gossh.MarshalAuthorizedKey(signer.PublicKey()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(d, "hostkeys")
+	if _, err := loadHostKeys(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, nextPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeHostKey(filepath.Join(dir, "ssh_host_ed25519_next_key"), nextPriv); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(ak, "", os.Args[0], WithHostKeyDir(dir))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln) //nolint
+	defer s.Close()
+
+	nc, err := net.DialTimeout("tcp", ln.Addr().String(), 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hostKey gossh.PublicKey
+	c, _, reqs, err := gossh.NewClientConn(nc, ln.Addr().String(), &gossh.ClientConfig{
+		User: "glenda",
+		Auth: []gossh.AuthMethod{gossh.PublicKeys(signer)},
+		HostKeyCallback: func(_ string, _ net.Addr, key gossh.PublicKey) error {
+			hostKey = key
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+	defer c.Close()
+	if _, err := os.Stat(hostKeyFile(dir, "ecdsa")); err != nil {
+		t.Errorf("ecdsa host key: %v", err)
+	}
+	ch, _, err := c.OpenChannel("session", nil)
+	if err != nil {
+		t.Fatalf("OpenChannel: %v", err)
+	}
+	defer ch.Close()
+
+	var req *gossh.Request
+	select {
+	case req = <-reqs:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("no host keys announced")
+	}
+	if req.Type != hostKeysRequest || req.WantReply {
+		t.Fatalf("request %q, want reply %v: want %q with no reply", req.Type, req.WantReply, hostKeysRequest)
+	}
+	var keys []gossh.PublicKey
+	for rest := req.Payload; len(rest) > 0; {
+		var k struct {
+			Key  []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := gossh.Unmarshal(rest, &k); err != nil {
+			t.Fatalf("host keys: %v", err)
+		}
+		rest = k.Rest
+		pk, err := gossh.ParsePublicKey(k.Key)
+		if err != nil {
+			t.Fatalf("host key: %v", err)
+		}
+		keys = append(keys, pk)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("%d host keys announced, want 3", len(keys))
+	}
+	found := false
+	for _, k := range keys {
+		found = found || bytes.Equal(k.Marshal(), hostKey.Marshal())
+	}
+	if !found {
+		t.Errorf("the host key %s is not announced", gossh.FingerprintSHA256(hostKey))
+	}
+
+	// Ask for proof of all of them.
+	var b []byte
+	for _, k := range keys {
+		b = append(b, gossh.Marshal(struct{ Key []byte }{k.Marshal()})...)
+	}
+	ok, reply, err := c.SendRequest(hostKeysProve, true, b)
+	if err != nil || !ok {
+		t.Fatalf("%s: %v, %v, want true, nil", hostKeysProve, ok, err)
+	}
+	for i, rest := 0, reply; len(rest) > 0; i++ {
+		var sig struct {
+			Sig  []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := gossh.Unmarshal(rest, &sig); err != nil {
+			t.Fatalf("proof: %v", err)
+		}
+		rest = sig.Rest
+		var s gossh.Signature
+		if err := gossh.Unmarshal(sig.Sig, &s); err != nil {
+			t.Fatalf("signature: %v", err)
+		}
+		if i >= len(keys) {
+			t.Fatalf("more signatures than keys")
+		}
+		data := gossh.Marshal(struct {
+			Request   string
+			SessionID []byte
+			Key       []byte
+		}{hostKeysProve, c.SessionID(), keys[i].Marshal()})
+		if err := keys[i].Verify(data, &s); err != nil {
+			t.Errorf("proof for %s: %v", gossh.FingerprintSHA256(keys[i]), err)
+		}
+	}
+
+	// A key that is not announced can not be proven.
+	if ok, _, err := c.SendRequest(hostKeysProve, true, gossh.Marshal(struct{ Key []byte }{signer.PublicKey().Marshal()})); err != nil || ok {
+		t.Errorf("%s of a key that is not a host key: %v, %v, want false, nil", hostKeysProve, ok, err)
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// unlimited is the value used for a cgroup v2 limit that is set to "max".
+const unlimited = math.MaxInt64
+
+// Limits are the resource limits for a session, as set in
+// a cgroup v2. A zero value for a field means it is not set.
+//
+// Limits are written as a comma-separated list of key=value
+// pairs, where the keys are the names of the cgroup v2 control
+// files, e.g.
+//
+//	cpu.weight=50,cpu.max=50000 100000,memory.max=1G,pids.max=512,io.max=8:0 rbps=1048576
+//
+// io.max may be repeated, once per device.
+type Limits struct {
+	// CPUWeight is cpu.weight, in the range 1-10000.
+	CPUWeight uint64
+	// CPUQuota and CPUPeriod are the two values in cpu.max, in µs.
+	// A CPUQuota of unlimited is written as max.
+	CPUQuota  int64
+	CPUPeriod int64
+	// MemoryMax is memory.max, in bytes.
+	MemoryMax int64
+	// PidsMax is pids.max.
+	PidsMax int64
+	// IOMax is io.max, indexed by the device major:minor.
+	// The values are indexed by rbps, wbps, riops and wiops.
+	IOMax map[string]map[string]int64
+}
+
+var ioKeys = []string{"rbps", "wbps", "riops", "wiops"}
+
+// parseMax parses a cgroup value, which may be "max", with an optional
+// K, M, G or T suffix.
+func parseMax(s string) (int64, error) {
+	if s == "max" {
+		return unlimited, nil
+	}
+	mul := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k', 'K':
+			mul = 1 << 10
+		case 'm', 'M':
+			mul = 1 << 20
+		case 'g', 'G':
+			mul = 1 << 30
+		case 't', 'T':
+			mul = 1 << 40
+		}
+		if mul != 1 {
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return 0, err
+	}
+	if v <= 0 || v > unlimited/mul {
+		return 0, fmt.Errorf("%q:%w", s, strconv.ErrRange)
+	}
+	return v * mul, nil
+}
+
+func formatMax(v int64) string {
+	if v == unlimited {
+		return "max"
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// ParseLimits parses a limit string, as described in Limits.
+// An empty string returns nil.
+func ParseLimits(s string) (*Limits, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	l := &Limits{}
+	for _, kv := range strings.Split(s, ",") {
+		k, val, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok {
+			return nil, fmt.Errorf("limit %q: not key=value:%w", kv, strconv.ErrSyntax)
+		}
+		var err error
+		switch k {
+		case "cpu.weight":
+			l.CPUWeight, err = strconv.ParseUint(val, 0, 64)
+			if err == nil && (l.CPUWeight < 1 || l.CPUWeight > 10000) {
+				err = strconv.ErrRange
+			}
+		case "cpu.max":
+			f := strings.Fields(val)
+			if len(f) == 0 || len(f) > 2 {
+				err = strconv.ErrSyntax
+				break
+			}
+			if l.CPUQuota, err = parseMax(f[0]); err != nil {
+				break
+			}
+			l.CPUPeriod = 100000
+			if len(f) == 2 {
+				l.CPUPeriod, err = strconv.ParseInt(f[1], 0, 64)
+				if err == nil && l.CPUPeriod <= 0 {
+					err = strconv.ErrRange
+				}
+			}
+		case "memory.max":
+			l.MemoryMax, err = parseMax(val)
+		case "pids.max":
+			l.PidsMax, err = parseMax(val)
+		case "io.max":
+			err = l.parseIOMax(val)
+		default:
+			return nil, fmt.Errorf("limit %q: unknown or unsupported:%w", k, strconv.ErrSyntax)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("limit %q: %w", kv, err)
+		}
+	}
+	return l, nil
+}
+
+func (l *Limits) parseIOMax(val string) error {
+	f := strings.Fields(val)
+	if len(f) < 2 {
+		return strconv.ErrSyntax
+	}
+	if _, _, ok := strings.Cut(f[0], ":"); !ok {
+		return fmt.Errorf("device %q is not major:minor:%w", f[0], strconv.ErrSyntax)
+	}
+	if l.IOMax == nil {
+		l.IOMax = map[string]map[string]int64{}
+	}
+	dev := l.IOMax[f[0]]
+	if dev == nil {
+		dev = map[string]int64{}
+		l.IOMax[f[0]] = dev
+	}
+	for _, kv := range f[1:] {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return strconv.ErrSyntax
+		}
+		if !slices.Contains(ioKeys, k) {
+			return fmt.Errorf("io.max key %q:%w", k, strconv.ErrSyntax)
+		}
+		n, err := parseMax(v)
+		if err != nil {
+			return err
+		}
+		dev[k] = n
+	}
+	return nil
+}
+
+// lower returns the more restrictive of two limits, where 0
+// means unset.
+func lower(a, b int64) int64 {
+	switch {
+	case a == 0:
+		return b
+	case b == 0:
+		return a
+	case a < b:
+		return a
+	}
+	return b
+}
+
+// Cap returns the limits in l, made no less restrictive than policy.
+// It is used to cap a client's request with the server's policy:
+// a client can ask for less than the policy allows, never more.
+// Either l or policy may be nil.
+func (l *Limits) Cap(policy *Limits) *Limits {
+	if l == nil && policy == nil {
+		return nil
+	}
+	var r, a, b Limits
+	if l != nil {
+		a = *l
+	}
+	if policy != nil {
+		b = *policy
+	}
+	r.CPUWeight = uint64(lower(int64(a.CPUWeight), int64(b.CPUWeight)))
+	// cpu.max is a ratio; compare quota/period.
+	switch {
+	case a.CPUPeriod == 0:
+		r.CPUQuota, r.CPUPeriod = b.CPUQuota, b.CPUPeriod
+	case b.CPUPeriod == 0:
+		r.CPUQuota, r.CPUPeriod = a.CPUQuota, a.CPUPeriod
+	case float64(a.CPUQuota)/float64(a.CPUPeriod) < float64(b.CPUQuota)/float64(b.CPUPeriod):
+		r.CPUQuota, r.CPUPeriod = a.CPUQuota, a.CPUPeriod
+	default:
+		r.CPUQuota, r.CPUPeriod = b.CPUQuota, b.CPUPeriod
+	}
+	r.MemoryMax = lower(a.MemoryMax, b.MemoryMax)
+	r.PidsMax = lower(a.PidsMax, b.PidsMax)
+	for _, m := range []map[string]map[string]int64{a.IOMax, b.IOMax} {
+		for dev, kv := range m {
+			if r.IOMax == nil {
+				r.IOMax = map[string]map[string]int64{}
+			}
+			if r.IOMax[dev] == nil {
+				r.IOMax[dev] = map[string]int64{}
+			}
+			for k, v := range kv {
+				r.IOMax[dev][k] = lower(r.IOMax[dev][k], v)
+			}
+		}
+	}
+	return &r
+}
+
+// files returns the cgroup v2 control files and values for the limits.
+// The files are returned in a fixed order.
+func (l *Limits) files() [][2]string {
+	var f [][2]string
+	if l == nil {
+		return f
+	}
+	if l.CPUWeight != 0 {
+		f = append(f, [2]string{"cpu.weight", strconv.FormatUint(l.CPUWeight, 10)})
+	}
+	if l.CPUPeriod != 0 {
+		f = append(f, [2]string{"cpu.max", fmt.Sprintf("%s %d", formatMax(l.CPUQuota), l.CPUPeriod)})
+	}
+	if l.MemoryMax != 0 {
+		f = append(f, [2]string{"memory.max", formatMax(l.MemoryMax)})
+	}
+	if l.PidsMax != 0 {
+		f = append(f, [2]string{"pids.max", formatMax(l.PidsMax)})
+	}
+	for _, dev := range slices.Sorted(maps.Keys(l.IOMax)) {
+		s := dev
+		for _, k := range ioKeys {
+			if v, ok := l.IOMax[dev][k]; ok {
+				s += fmt.Sprintf(" %s=%s", k, formatMax(v))
+			}
+		}
+		f = append(f, [2]string{"io.max", s})
+	}
+	return f
+}
+
+// controllers returns the cgroup v2 controllers needed for the limits.
+func (l *Limits) controllers() []string {
+	var c []string
+	for _, f := range l.files() {
+		n, _, _ := strings.Cut(f[0], ".")
+		if !slices.Contains(c, n) {
+			c = append(c, n)
+		}
+	}
+	return c
+}
+
+// String implements fmt.Stringer. The output can be parsed by ParseLimits.
+func (l *Limits) String() string {
+	var s []string
+	for _, f := range l.files() {
+		s = append(s, f[0]+"="+f[1])
+	}
+	return strings.Join(s, ",")
+}
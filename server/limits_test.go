@@ -0,0 +1,76 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestParseLimits(t *testing.T) {
+	for _, tt := range []struct {
+		in  string
+		out string
+		err error
+	}{
+		{in: "", out: ""},
+		{in: "memory.max=1G", out: "memory.max=1073741824"},
+		{in: "memory.max=max,pids.max=512", out: "memory.max=max,pids.max=512"},
+		{in: "cpu.max=50000", out: "cpu.max=50000 100000"},
+		{in: "cpu.max=max 20000,cpu.weight=50", out: "cpu.weight=50,cpu.max=max 20000"},
+		{in: "io.max=8:0 rbps=1M,io.max=8:0 wiops=100,io.max=8:16 wbps=max", out: "io.max=8:0 rbps=1048576 wiops=100,io.max=8:16 wbps=max"},
+		{in: "memory.max", err: strconv.ErrSyntax},
+		{in: "memory.high=1G", err: strconv.ErrSyntax},
+		{in: "cpu.weight=0", err: strconv.ErrRange},
+		{in: "pids.max=-1", err: strconv.ErrRange},
+		{in: "io.max=sda rbps=1", err: strconv.ErrSyntax},
+		{in: "io.max=8:0 bps=1", err: strconv.ErrSyntax},
+		{in: "cpu.max=1 2 3", err: strconv.ErrSyntax},
+	} {
+		l, err := ParseLimits(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ParseLimits(%q): %v != %v", tt.in, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if l.String() != tt.out {
+			t.Errorf("ParseLimits(%q): %q != %q", tt.in, l.String(), tt.out)
+		}
+	}
+}
+
+func TestCapLimits(t *testing.T) {
+	for _, tt := range []struct {
+		req    string
+		policy string
+		out    string
+	}{
+		{req: "", policy: "", out: ""},
+		{req: "memory.max=1G", policy: "", out: "memory.max=1073741824"},
+		{req: "", policy: "memory.max=1G", out: "memory.max=1073741824"},
+		{req: "memory.max=2G", policy: "memory.max=1G", out: "memory.max=1073741824"},
+		{req: "memory.max=512M", policy: "memory.max=1G", out: "memory.max=536870912"},
+		{req: "memory.max=max", policy: "memory.max=1G", out: "memory.max=1073741824"},
+		{req: "pids.max=10", policy: "memory.max=1G", out: "memory.max=1073741824,pids.max=10"},
+		{req: "cpu.max=max", policy: "cpu.max=50000 100000", out: "cpu.max=50000 100000"},
+		{req: "cpu.max=1000 10000", policy: "cpu.max=50000 100000", out: "cpu.max=1000 10000"},
+		{req: "io.max=8:0 rbps=2M wbps=1", policy: "io.max=8:0 rbps=1M", out: "io.max=8:0 rbps=1048576 wbps=1"},
+	} {
+		req, err := ParseLimits(tt.req)
+		if err != nil {
+			t.Fatalf("ParseLimits(%q): %v != nil", tt.req, err)
+		}
+		policy, err := ParseLimits(tt.policy)
+		if err != nil {
+			t.Fatalf("ParseLimits(%q): %v != nil", tt.policy, err)
+		}
+		if out := req.Cap(policy).String(); out != tt.out {
+			t.Errorf("%q.Cap(%q): %q != %q", tt.req, tt.policy, out, tt.out)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// WithPasswordFile allows password and keyboard-interactive
+// authentication, for the users in file, which is read for each
+// attempt. Each line of the file, as made by htpasswd -B, is
+//
+//	user:hash[:totp]
+//
+// where hash is a bcrypt hash ($2a$, $2b$ or $2y$), or an argon2id or
+// argon2i hash in the PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=4$salt$hash, and totp, if present, is
+// the base32 secret of a TOTP (RFC 6238) authenticator, e.g. Google
+// Authenticator. A user with a TOTP secret is asked for a verification
+// code as well, and so can only use keyboard-interactive
+// authentication. Blank lines and lines starting with # are ignored.
+//
+// Passwords are allowed if the file is set when New is called; it can
+// be changed, or removed, by a Reload.
+func WithPasswordFile(file string) Set {
+	return func(d *daemon) error {
+		d.passwordFile = file
+		return nil
+	}
+}
+
+// passwordEntry is the entry of a user in a password file.
+type passwordEntry struct {
+	hash string
+	totp []byte
+}
+
+// findPassword reads a password file, and returns the entry of user.
+func findPassword(file, user string) (*passwordEntry, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+		if len(l) == 0 || l[0] == '#' {
+			continue
+		}
+		f := strings.Split(l, ":")
+		if f[0] != user {
+			continue
+		}
+		if len(f) < 2 || len(f) > 3 || len(f[1]) == 0 {
+			return nil, fmt.Errorf("%s:%d: not user:hash[:totp]:%w", file, n, strconv.ErrSyntax)
+		}
+		e := &passwordEntry{hash: f[1]}
+		if len(f) == 3 && len(f[2]) > 0 {
+			k, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(strings.TrimRight(f[2], "=")))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: TOTP secret: %v:%w", file, n, err, strconv.ErrSyntax)
+			}
+			e.totp = k
+		}
+		return e, nil
+	}
+	return nil, fmt.Errorf("user %q not in %q:%w", user, file, os.ErrNotExist)
+}
+
+// noUser is a bcrypt hash checked for users that are not in the file,
+// so that they take as long to refuse as a wrong password.
+var noUser = sync.OnceValue(func() string {
+	h, _ := bcrypt.GenerateFromPassword([]byte("no such user"), bcrypt.DefaultCost)
+	return string(h)
+})
+
+// maxArgon2Memory is the most memory, in KiB, an argon2 hash can use,
+// which is 4 GiB, the most argon2 itself allows being 4 TiB.
+const maxArgon2Memory = 4 << 20
+
+// checkPassword checks password against a bcrypt or argon2 hash.
+func checkPassword(hash, password string) error {
+	if strings.HasPrefix(hash, "$2") {
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+			return fmt.Errorf("%v:%w", err, os.ErrPermission)
+		}
+		return nil
+	}
+	var (
+		variant, params, salt64, key64 string
+		version                        int
+	)
+	f := strings.Split(hash, "$")
+	if len(f) == 6 && len(f[0]) == 0 {
+		variant, params, salt64, key64 = f[1], f[3], f[4], f[5]
+		if _, err := fmt.Sscanf(f[2], "v=%d", &version); err != nil || version != argon2.Version {
+			return fmt.Errorf("argon2 version %q, want v=%d:%w", f[2], argon2.Version, strconv.ErrSyntax)
+		}
+	}
+	var m, t uint32
+	var p uint8
+	if _, err := fmt.Sscanf(params, "m=%d,t=%d,p=%d", &m, &t, &p); err != nil || (variant != "argon2id" && variant != "argon2i") {
+		return fmt.Errorf("hash is not bcrypt, argon2id or argon2i:%w", strconv.ErrSyntax)
+	}
+	// argon2 panics if t or p are 0, and needs 8 KiB per thread.
+	if t < 1 || p < 1 || m < 8*uint32(p) || m > maxArgon2Memory {
+		return fmt.Errorf("argon2 parameters %q: need t>=1, p>=1 and 8p<=m<=%d:%w", params, maxArgon2Memory, strconv.ErrRange)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(salt64)
+	if err != nil {
+		return fmt.Errorf("argon2 salt: %v:%w", err, strconv.ErrSyntax)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(key64)
+	if err != nil || len(key) == 0 {
+		return fmt.Errorf("argon2 hash: %v:%w", err, strconv.ErrSyntax)
+	}
+	derive := argon2.IDKey
+	if variant == "argon2i" {
+		derive = argon2.Key
+	}
+	if subtle.ConstantTimeCompare(derive([]byte(password), salt, t, m, p, uint32(len(key))), key) != 1 {
+		return fmt.Errorf("argon2: wrong password:%w", os.ErrPermission)
+	}
+	return nil
+}
+
+// totpStep is the time step of TOTP codes, in seconds, as in Google
+// Authenticator, and most others.
+const totpStep = 30
+
+// totpCode returns the 6 digit TOTP code of the time step n, as in RFC
+// 6238.
+func totpCode(key []byte, n uint64) string {
+	h := hmac.New(sha1.New, key)
+	binary.Write(h, binary.BigEndian, n) //nolint
+	s := h.Sum(nil)
+	o := s[len(s)-1] & 0xf
+	v := binary.BigEndian.Uint32(s[o:o+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", v%1000000)
+}
+
+// totpUsed is the last time step whose code each user used, so that a
+// code can not be used again.
+var totpUsed = struct {
+	sync.Mutex
+	step map[string]uint64
+}{step: map[string]uint64{}}
+
+// checkTOTP checks a TOTP code of user, at now, allowing for a step of
+// clock skew either way.
+func checkTOTP(user string, key []byte, code string, now time.Time) error {
+	cur := uint64(now.Unix()) / totpStep
+	totpUsed.Lock()
+	defer totpUsed.Unlock()
+	for _, n := range []uint64{cur - 1, cur, cur + 1} {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, n)), []byte(code)) != 1 {
+			continue
+		}
+		if n <= totpUsed.step[user] {
+			return fmt.Errorf("verification code of %q used already:%w", user, os.ErrPermission)
+		}
+		totpUsed.step[user] = n
+		return nil
+	}
+	return fmt.Errorf("wrong verification code for %q:%w", user, os.ErrPermission)
+}
+
+// passwordAuth checks the password of user, and, if they have a TOTP
+// secret, the code from code, which is called only then. A user that
+// authenticates is its own identity.
+func passwordAuth(ctx ssh.Context, file, user, password string, code func() (string, error)) error {
+	e, err := findPassword(file, user)
+	if err != nil {
+		checkPassword(noUser(), password) //nolint
+		return err
+	}
+	if err := checkPassword(e.hash, password); err != nil {
+		return fmt.Errorf("password of %q: %w", user, err)
+	}
+	if len(e.totp) > 0 {
+		if code == nil {
+			return fmt.Errorf("%q needs a verification code, with keyboard-interactive authentication:%w", user, os.ErrPermission)
+		}
+		c, err := code()
+		if err != nil {
+			return err
+		}
+		if err := checkTOTP(user, e.totp, strings.TrimSpace(c), time.Now()); err != nil {
+			return err
+		}
+	}
+	ctx.SetValue(identityKey, user)
+	return nil
+}
+
+// passwordHandler is the ssh.PasswordHandler of a password file.
+func passwordHandler(file func() string) ssh.PasswordHandler {
+	return func(ctx ssh.Context, password string) bool {
+		f := file()
+		if len(f) == 0 {
+			return false
+		}
+		if err := passwordAuth(ctx, f, ctx.User(), password, nil); err != nil {
+			log.Printf("CPUD:%v", err)
+			return false
+		}
+		return true
+	}
+}
+
+// keyboardInteractiveHandler is the ssh.KeyboardInteractiveHandler of a
+// password file. It asks for the password, and then, if the user has a
+// TOTP secret, for a verification code.
+func keyboardInteractiveHandler(file func() string) ssh.KeyboardInteractiveHandler {
+	return func(ctx ssh.Context, challenge gossh.KeyboardInteractiveChallenge) bool {
+		f := file()
+		if len(f) == 0 {
+			return false
+		}
+		ask := func(q string) (string, error) {
+			a, err := challenge("", "", []string{q}, []bool{false})
+			if err != nil {
+				return "", err
+			}
+			if len(a) != 1 {
+				return "", fmt.Errorf("%d answers to %q, want 1:%w", len(a), q, os.ErrInvalid)
+			}
+			return a[0], nil
+		}
+		p, err := ask("Password: ")
+		if err != nil {
+			verbose("keyboard-interactive: %v", err)
+			return false
+		}
+		if err := passwordAuth(ctx, f, ctx.User(), p, func() (string, error) {
+			return ask("Verification code: ")
+		}); err != nil {
+			log.Printf("CPUD:%v", err)
+			return false
+		}
+		return true
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// The SHA1 test vectors of RFC 6238, of which the codes are the
+	// last 6 digits.
+	key := []byte("12345678901234567890")
+	for _, tt := range []struct {
+		t    int64
+		want string
+	}{
+		{t: 59, want: "287082"},
+		{t: 1111111109, want: "081804"},
+		{t: 1111111111, want: "050471"},
+		{t: 1234567890, want: "005924"},
+		{t: 2000000000, want: "279037"},
+	} {
+		if got := totpCode(key, uint64(tt.t)/totpStep); got != tt.want {
+			t.Errorf("totpCode at %d: %q, want %q", tt.t, got, tt.want)
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+	if err := checkTOTP("glenda", key, "005924", now); err != nil {
+		t.Errorf("checkTOTP: %v, want nil", err)
+	}
+	if err := checkTOTP("glenda", key, "005924", now); err == nil {
+		t.Errorf("checkTOTP of a code used already: nil, want an error")
+	}
+	if err := checkTOTP("glenda", key, totpCode(key, 1234567890/totpStep+1), now); err != nil {
+		t.Errorf("checkTOTP of the next code: %v, want nil", err)
+	}
+	if err := checkTOTP("glenda", key, totpCode(key, 1234567890/totpStep+3), now); err == nil {
+		t.Errorf("checkTOTP of a code far in the future: nil, want an error")
+	}
+}
+
+// argon2Hash returns an argon2id hash of password in the PHC format.
+func argon2Hash(password string) string {
+	salt := []byte("saltsaltsaltsalt")
+	key := argon2.IDKey([]byte(password), salt, 1, 1024, 1, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=1024,t=1,p=1$%s$%s", argon2.Version, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func TestCheckPassword(t *testing.T) {
+	b, err := bcrypt.GenerateFromPassword([]byte("rob"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		hash, password string
+		ok             bool
+	}{
+		{hash: string(b), password: "rob", ok: true},
+		{hash: string(b), password: "ken"},
+		{hash: argon2Hash("rob"), password: "rob", ok: true},
+		{hash: argon2Hash("rob"), password: "ken"},
+		{hash: "$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$c2FsdA", password: "rob"},
+		{hash: fmt.Sprintf("$argon2id$v=%d$m=1024,t=0,p=1$c2FsdA$c2FsdA", argon2.Version), password: "rob"},
+		{hash: fmt.Sprintf("$argon2id$v=%d$m=1024,t=1,p=0$c2FsdA$c2FsdA", argon2.Version), password: "rob"},
+		{hash: fmt.Sprintf("$argon2id$v=%d$m=15,t=1,p=2$c2FsdA$c2FsdA", argon2.Version), password: "rob"},
+		{hash: fmt.Sprintf("$argon2id$v=%d$m=4294967295,t=1,p=1$c2FsdA$c2FsdA", argon2.Version), password: "rob"},
+		{hash: "$1$md5$crypt", password: "rob"},
+		{hash: "rob", password: "rob"},
+	} {
+		if err := checkPassword(tt.hash, tt.password); (err == nil) != tt.ok {
+			t.Errorf("checkPassword(%q, %q): %v, want ok %v", tt.hash, tt.password, err, tt.ok)
+		}
+	}
+}
+
+func TestPasswordAuth(t *testing.T) {
+	d := t.TempDir()
+	b, err := bcrypt.GenerateFromPassword([]byte("rob"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("12345678901234567890")
+	secret := base32.StdEncoding.EncodeToString(key)
+	pw := filepath.Join(d, "passwords")
+	if err := os.WriteFile(pw, []byte(fmt.Sprintf("# lab users\nrob:%s\n\nken:%s:%s\nbad\n", b, argon2Hash("ken"), secret)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := findPassword(pw, "bad"); err == nil {
+		t.Errorf("findPassword of a line with no hash: nil, want an error")
+	}
+
+	s, err := New("", "", os.Args[0], WithPasswordFile(pw))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln) //nolint
+	defer s.Close()
+
+	for _, tt := range []struct {
+		name string
+		user string
+		auth gossh.AuthMethod
+		ok   bool
+	}{
+		{name: "password", user: "rob", auth: gossh.Password("rob"), ok: true},
+		{name: "wrong password", user: "rob", auth: gossh.Password("ken")},
+		{name: "no such user", user: "doug", auth: gossh.Password("rob")},
+		{name: "password with TOTP", user: "ken", auth: gossh.Password("ken")},
+		{name: "keyboard-interactive", user: "rob", auth: gossh.KeyboardInteractive(func(_, _ string, q []string, _ []bool) ([]string, error) {
+			return []string{"rob"}, nil
+		}), ok: true},
+		{name: "keyboard-interactive with TOTP", user: "ken", auth: gossh.KeyboardInteractive(func(_, _ string, q []string, _ []bool) ([]string, error) {
+			if q[0] == "Password: " {
+				return []string{"ken"}, nil
+			}
+			return []string{totpCode(key, uint64(time.Now().Unix())/totpStep)}, nil
+		}), ok: true},
+		{name: "keyboard-interactive with a wrong code", user: "ken", auth: gossh.KeyboardInteractive(func(_, _ string, q []string, _ []bool) ([]string, error) {
+			if q[0] == "Password: " {
+				return []string{"ken"}, nil
+			}
+			return []string{"000000x"}, nil
+		})},
+	} {
+		c, err := gossh.Dial("tcp", ln.Addr().String(), &gossh.ClientConfig{
+			User:            tt.user,
+			Auth:            []gossh.AuthMethod{tt.auth},
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+			Timeout:         10 * time.Second,
+		})
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if err == nil {
+			c.Close()
+		}
+	}
+}
+
+// probeSigner asks about its key, but does not sign with it.
+type probeSigner struct {
+	gossh.Signer
+}
+
+func (probeSigner) Sign(io.Reader, []byte) (*gossh.Signature, error) {
+	return &gossh.Signature{Format: "none"}, nil
+}
+
+// TestPasswordKeyOptions tests that a connection that asks about an
+// authorized key, and then authenticates with a password, does not get
+// the options of the key.
+func TestPasswordKeyOptions(t *testing.T) {
+	d := t.TempDir()
+	b, err := bcrypt.GenerateFromPassword([]byte("rob"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw := filepath.Join(d, "passwords")
+	if err := os.WriteFile(pw, []byte(fmt.Sprintf("rob:%s\n", b)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ak := filepath.Join(d, "authorized_keys")
+	if err := os.WriteFile(ak, append([]byte(`cpu-forward="deny any *:*" `), gossh.MarshalAuthorizedKey(signer.PublicKey())...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(ak, "", os.Args[0], WithPasswordFile(pw), WithForwards("allow any *:*"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln) //nolint
+	defer s.Close()
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	for _, tt := range []struct {
+		name string
+		auth []gossh.AuthMethod
+		ok   bool
+	}{
+		{name: "key", auth: []gossh.AuthMethod{gossh.PublicKeys(signer)}},
+		{name: "password", auth: []gossh.AuthMethod{gossh.Password("rob")}, ok: true},
+		{name: "key asked about, then password", auth: []gossh.AuthMethod{gossh.PublicKeys(probeSigner{signer}), gossh.Password("rob")}, ok: true},
+	} {
+		c, err := gossh.Dial("tcp", ln.Addr().String(), &gossh.ClientConfig{
+			User:            "rob",
+			Auth:            tt.auth,
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+			Timeout:         10 * time.Second,
+		})
+		if err != nil {
+			t.Errorf("%s: %v != nil", tt.name, err)
+			continue
+		}
+		f, err := c.Dial("tcp", target.Addr().String())
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: forward: %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if err == nil {
+			f.Close()
+		}
+		c.Close()
+	}
+}
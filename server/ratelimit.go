@@ -0,0 +1,307 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// SSH disconnect reasons, from RFC 4253 section 11.1.
+const (
+	disconnectTooManyConnections = 12
+	disconnectNoMoreAuthMethods  = 14
+)
+
+// connStateKey is the ssh.Context key for the connState of a
+// connection.
+const connStateKey = contextKey("cpud-conn-state")
+
+// startups counts the connections that have not authenticated yet.
+type startups struct {
+	mu sync.Mutex
+	n  int
+	// max is the most there may be. 0 is no limit.
+	max int
+}
+
+// acquire counts a new connection, if there is room for it.
+func (s *startups) acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.max > 0 && s.n >= s.max {
+		return false
+	}
+	s.n++
+	return true
+}
+
+// setMax sets the most connections there may be.
+func (s *startups) setMax(max int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.max = max
+}
+
+// release stops counting a connection.
+func (s *startups) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n--
+}
+
+// backoff refuses connections from hosts that failed to authenticate,
+// for a time that doubles with each failure, from base up to max.
+// Failures are forgotten after max passes with no new one, or when
+// the host authenticates.
+type backoff struct {
+	mu        sync.Mutex
+	base, max time.Duration
+	hosts     map[string]*failures
+	now       func() time.Time
+}
+
+type failures struct {
+	n     int
+	until time.Time
+}
+
+func newBackoff(base, max time.Duration) *backoff {
+	if max < base {
+		max = base
+	}
+	return &backoff{base: base, max: max, hosts: map[string]*failures{}, now: time.Now}
+}
+
+// wait returns how long host must wait to connect again, or 0.
+func (b *backoff) wait(host string) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f, ok := b.hosts[host]
+	if !ok {
+		return 0
+	}
+	if w := f.until.Sub(b.now()); w > 0 {
+		return w
+	}
+	return 0
+}
+
+// fail records an authentication failure for host.
+func (b *backoff) fail(host string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	for h, f := range b.hosts {
+		if now.Sub(f.until) > b.max {
+			delete(b.hosts, h)
+		}
+	}
+	f, ok := b.hosts[host]
+	if !ok {
+		f = &failures{}
+		b.hosts[host] = f
+	}
+	f.n++
+	d := b.base
+	for i := 1; i < f.n && d < b.max; i++ {
+		d *= 2
+	}
+	f.until = now.Add(min(d, b.max))
+}
+
+// succeed forgets the failures of host.
+func (b *backoff) succeed(host string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.hosts, host)
+}
+
+// connState is the authentication state of a connection.
+type connState struct {
+	host    string
+	release sync.Once
+	mu      sync.Mutex
+	authed  bool
+	failed  bool
+	// grace, if not nil, closes the connection if it has not
+	// authenticated in time.
+	grace *time.Timer
+}
+
+// trackedConn calls done when it is closed.
+type trackedConn struct {
+	net.Conn
+	once sync.Once
+	done func()
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.done)
+	return c.Conn.Close()
+}
+
+// remoteHost returns the host of a remote address, which, for
+// addresses that are not host:port, is the whole address.
+func remoteHost(a net.Addr) string {
+	if h, _, err := net.SplitHostPort(a.String()); err == nil {
+		return h
+	}
+	return a.String()
+}
+
+// connect admits a new connection, or refuses it, with an SSH
+// disconnect saying why.
+func (d *daemon) connect(ctx ssh.Context, c net.Conn) net.Conn {
+	host := remoteHost(c.RemoteAddr())
+	if w := d.backoff.wait(host); w > 0 {
+		log.Printf("CPUD:refused connection from %s: authentication failures", c.RemoteAddr())
+		disconnect(c, disconnectNoMoreAuthMethods, fmt.Sprintf("too many authentication failures from %s; try again in %v", host, w.Round(time.Second)))
+		return nil
+	}
+	if !d.startups.acquire() {
+		log.Printf("CPUD:refused connection from %s: %d unauthenticated connections", c.RemoteAddr(), d.maxStartups)
+		disconnect(c, disconnectTooManyConnections, "too many unauthenticated connections; try again later")
+		return nil
+	}
+	if sc, ok := c.(*scopedConn); ok {
+		ctx.SetValue(listenerOptionsKey, sc.options)
+	}
+	st := &connState{host: host}
+	ctx.SetValue(connStateKey, st)
+	tc := &trackedConn{Conn: c, done: func() { d.closed(st) }}
+	// The SSH server resets deadlines on each read, so a timer,
+	// not a deadline, ends the wait.
+	if d.loginGrace > 0 {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		st.grace = time.AfterFunc(d.loginGrace, func() {
+			st.mu.Lock()
+			authed := st.authed
+			st.mu.Unlock()
+			if !authed {
+				log.Printf("CPUD:closing connection from %s: not authenticated in %v", c.RemoteAddr(), d.loginGrace)
+				tc.Close()
+			}
+		})
+	}
+	return tc
+}
+
+// authLog is called for each authentication attempt on the
+// connection of st. Failures of the none method, which clients
+// use to learn the methods they can use, are not counted.
+func (d *daemon) authLog(st *connState, method string, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err != nil {
+		if method != "none" {
+			st.failed = true
+		}
+		return
+	}
+	st.authed = true
+	if st.grace != nil {
+		st.grace.Stop()
+	}
+	st.release.Do(d.startups.release)
+	d.backoff.succeed(st.host)
+}
+
+// closed is called when the connection of st is closed. A
+// connection that fails to authenticate counts as one failure,
+// however many keys it tried.
+func (d *daemon) closed(st *connState) {
+	st.release.Do(d.startups.release)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.grace != nil {
+		st.grace.Stop()
+	}
+	if st.failed && !st.authed {
+		d.backoff.fail(st.host)
+	}
+}
+
+// serverConfig returns the SSH configuration for the connection of
+// ctx, which tracks its authentication.
+func (d *daemon) serverConfig(ctx ssh.Context) *gossh.ServerConfig {
+	c := &gossh.ServerConfig{}
+	if st, ok := ctx.Value(connStateKey).(*connState); ok {
+		c.AuthLogCallback = func(_ gossh.ConnMetadata, method string, err error) {
+			d.authLog(st, method, err)
+		}
+	}
+	return c
+}
+
+// disconnectMsg is the SSH_MSG_DISCONNECT message.
+type disconnectMsg struct {
+	Reason   uint32 `sshtype:"1"`
+	Message  string
+	Language string
+}
+
+// disconnectPacket returns an SSH_MSG_DISCONNECT packet, as it is
+// sent before keys are exchanged.
+func disconnectPacket(reason uint32, msg string) []byte {
+	payload := gossh.Marshal(&disconnectMsg{Reason: reason, Message: msg})
+	pad := 8 - (5+len(payload))%8
+	if pad < 4 {
+		pad += 8
+	}
+	p := make([]byte, 5+len(payload)+pad)
+	binary.BigEndian.PutUint32(p, uint32(1+len(payload)+pad))
+	p[4] = byte(pad)
+	copy(p[5:], payload)
+	return p
+}
+
+// disconnect refuses a connection with an SSH disconnect, which
+// clients show the user, and closes it. The client's version is read
+// first, so that the client reads the disconnect, instead of a reset.
+func disconnect(c net.Conn, reason uint32, msg string) {
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second)) //nolint
+	if _, err := fmt.Fprintf(c, "SSH-2.0-cpud\r\n"); err != nil {
+		return
+	}
+	// RFC 4253 allows other lines before the version.
+	r := bufio.NewReaderSize(c, 256)
+	for {
+		l, err := r.ReadSlice('\n')
+		if err != nil {
+			return
+		}
+		if len(l) >= 4 && string(l[:4]) == "SSH-" {
+			break
+		}
+	}
+	if _, err := c.Write(disconnectPacket(reason, msg)); err != nil {
+		return
+	}
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite() //nolint
+	}
+	io.Copy(io.Discard, r) //nolint
+}
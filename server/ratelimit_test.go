@@ -0,0 +1,204 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestBackoff(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newBackoff(time.Second, 10*time.Second)
+	b.now = func() time.Time { return now }
+	for _, tt := range []struct {
+		name    string
+		elapsed time.Duration
+		fail    bool
+		succeed bool
+		want    time.Duration
+	}{
+		{name: "no failures", want: 0},
+		{name: "first failure", fail: true, want: time.Second},
+		{name: "waited", elapsed: time.Second, want: 0},
+		{name: "second failure", fail: true, want: 2 * time.Second},
+		{name: "third failure", fail: true, want: 4 * time.Second},
+		{name: "fourth failure", fail: true, want: 8 * time.Second},
+		{name: "capped", fail: true, want: 10 * time.Second},
+		{name: "still capped", fail: true, want: 10 * time.Second},
+		{name: "waiting", elapsed: 3 * time.Second, want: 7 * time.Second},
+		{name: "forgotten", elapsed: 30 * time.Second, fail: true, want: time.Second},
+		{name: "authenticated", succeed: true, want: 0},
+		{name: "after authenticating", fail: true, want: time.Second},
+	} {
+		now = now.Add(tt.elapsed)
+		if tt.fail {
+			b.fail("10.0.0.1")
+		}
+		if tt.succeed {
+			b.succeed("10.0.0.1")
+		}
+		if w := b.wait("10.0.0.1"); w != tt.want {
+			t.Errorf("%s: wait: %v != %v", tt.name, w, tt.want)
+		}
+		if w := b.wait("10.0.0.2"); w != 0 {
+			t.Errorf("%s: wait for another host: %v != 0", tt.name, w)
+		}
+	}
+}
+
+// dial connects to a cpud, with a key it does not authorize.
+func dial(addr string, signer gossh.Signer) error {
+	c, err := gossh.Dial("tcp", addr, &gossh.ClientConfig{
+		User:            "glenda",
+		Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		Timeout:         10 * time.Second,
+	})
+	if err == nil {
+		c.Close()
+	}
+	return err
+}
+
+// disconnected returns true if err is a disconnect for reason.
+func disconnected(err error, reason int) bool {
+	return err != nil && strings.Contains(err.Error(), fmt.Sprintf("ssh: disconnect, reason %d:", reason))
+}
+
+func TestConnectionLimits(t *testing.T) {
+	d := t.TempDir()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ak := filepath.Join(d, "authorized_keys")
+	if err := os.WriteFile(ak, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(ak, "", os.Args[0], WithMaxStartups(1), WithAuthBackoff(time.Hour, time.Hour))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln) //nolint
+	defer s.Close()
+	addr := ln.Addr().String()
+
+	// A connection that does nothing holds the only startup.
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Wait for cpud to see it.
+	if _, err := c.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := dial(addr, signer); !disconnected(err, disconnectTooManyConnections) || !strings.Contains(err.Error(), "unauthenticated") {
+		t.Errorf("dial with no startups left: %v, want a disconnect about unauthenticated connections", err)
+	}
+	c.Close()
+
+	// The key is not authorized, so the host fails, and is then
+	// refused. The first connection may not be closed yet.
+	deadline := time.Now().Add(10 * time.Second)
+	err = dial(addr, signer)
+	for disconnected(err, disconnectTooManyConnections) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		err = dial(addr, signer)
+	}
+	if err == nil || strings.Contains(err.Error(), "disconnect") {
+		t.Fatalf("dial with an unauthorized key: %v, want an authentication failure", err)
+	}
+	err = dial(addr, signer)
+	for !disconnected(err, disconnectNoMoreAuthMethods) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		err = dial(addr, signer)
+	}
+	if !disconnected(err, disconnectNoMoreAuthMethods) || !strings.Contains(err.Error(), "authentication failures") {
+		t.Errorf("dial after failing: %v, want a disconnect about authentication failures", err)
+	}
+}
+
+func TestLoginGrace(t *testing.T) {
+	d := t.TempDir()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ak := filepath.Join(d, "authorized_keys")
+	if err := os.WriteFile(ak, gossh.MarshalAuthorizedKey(signer.PublicKey()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	const grace = 200 * time.Millisecond
+	s, err := New(ak, "", os.Args[0], WithMaxStartups(1), WithLoginGrace(grace))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln) //nolint
+	defer s.Close()
+	addr := ln.Addr().String()
+
+	// A connection that does nothing holds the only startup, until
+	// cpud closes it.
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(10 * time.Second)) //nolint
+	if _, err := io.ReadAll(c); err != nil {
+		t.Fatalf("reading from an idle connection: %v, want it closed by cpud", err)
+	}
+
+	// The startup is free again.
+	config := &gossh.ClientConfig{
+		User:            "glenda",
+		Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		Timeout:         10 * time.Second,
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	client, err := gossh.Dial("tcp", addr, config)
+	for disconnected(err, disconnectTooManyConnections) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		client, err = gossh.Dial("tcp", addr, config)
+	}
+	if err != nil {
+		t.Fatalf("dial after the idle connection was closed: %v != nil", err)
+	}
+	defer client.Close()
+
+	// An authenticated connection has no deadline.
+	time.Sleep(2 * grace)
+	if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		t.Errorf("request on an authenticated connection, after the grace time: %v != nil", err)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrTooManySessions is returned when a session would exceed the
+// session limits of a Registry.
+var ErrTooManySessions = errors.New("too many sessions")
+
+// SessionInfo describes a running session.
+type SessionInfo struct {
+	ID string
+	// Identity is who the session is counted against for the
+	// per-identity limit: the fingerprint of the authorized key,
+	// the principal of a user certificate, or, with no key file,
+	// the SSH user name.
+	Identity string
+	User     string
+	// Account is the local account the session runs as, if any.
+	Account string
+	Remote  string
+	Command []string
+	Start   time.Time
+
+	// notify tells the client of the session something, and stop
+	// ends the session.
+	notify func(msg string)
+	stop   func(reason string)
+}
+
+// Registry records the running sessions of a cpud, and enforces
+// the limits on how many there may be, in all and per identity.
+// A Registry can be shared with New, with WithRegistry, so that
+// the sessions of a cpud can be listed and stopped.
+type Registry struct {
+	mu       sync.Mutex
+	sessions map[string]*SessionInfo
+	count    map[string]int
+	// max and perIdentity are the limits on the number of
+	// sessions. 0 is no limit.
+	max, perIdentity int
+}
+
+// NewRegistry returns an empty Registry, with no limits.
+func NewRegistry() *Registry {
+	return &Registry{sessions: map[string]*SessionInfo{}, count: map[string]int{}}
+}
+
+// SetLimits sets the maximum number of sessions, in all and per
+// identity. 0 is no limit. Running sessions are not affected.
+func (r *Registry) SetLimits(max, perIdentity int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.max, r.perIdentity = max, perIdentity
+}
+
+// add records a session, if it does not exceed the limits.
+func (r *Registry) add(s *SessionInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.sessions[s.ID]; ok {
+		return fmt.Errorf("session %s: %w", s.ID, os.ErrExist)
+	}
+	if r.max > 0 && len(r.sessions) >= r.max {
+		return fmt.Errorf("%d sessions running, the most cpud allows:%w", len(r.sessions), ErrTooManySessions)
+	}
+	if r.perIdentity > 0 && r.count[s.Identity] >= r.perIdentity {
+		return fmt.Errorf("%d sessions running for %s, the most cpud allows:%w", r.count[s.Identity], s.Identity, ErrTooManySessions)
+	}
+	r.sessions[s.ID] = s
+	r.count[s.Identity]++
+	return nil
+}
+
+// remove forgets a session.
+func (r *Registry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.sessions[id]
+	if !ok {
+		return
+	}
+	delete(r.sessions, id)
+	if r.count[s.Identity]--; r.count[s.Identity] == 0 {
+		delete(r.count, s.Identity)
+	}
+}
+
+// Len returns the number of running sessions.
+func (r *Registry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.sessions)
+}
+
+// Sessions returns the running sessions, oldest first.
+func (r *Registry) Sessions() []SessionInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l := make([]SessionInfo, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		i := *s
+		i.notify, i.stop = nil, nil
+		l = append(l, i)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Start.Equal(l[j].Start) {
+			return l[i].ID < l[j].ID
+		}
+		return l[i].Start.Before(l[j].Start)
+	})
+	return l
+}
+
+// Stop ends a session, telling the client why.
+func (r *Registry) Stop(id, reason string) error {
+	r.mu.Lock()
+	s, ok := r.sessions[id]
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("session %s: %w", id, os.ErrNotExist)
+	}
+	if s.stop != nil {
+		s.stop(reason)
+	}
+	return nil
+}
+
+// Notify tells the clients of all running sessions something, e.g.
+// that they will be stopped.
+func (r *Registry) Notify(msg string) {
+	r.mu.Lock()
+	var l []*SessionInfo
+	for _, s := range r.sessions {
+		l = append(l, s)
+	}
+	r.mu.Unlock()
+	for _, s := range l {
+		if s.notify != nil {
+			s.notify(msg)
+		}
+	}
+}
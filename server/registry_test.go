@@ -0,0 +1,80 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	for _, tt := range []struct {
+		name             string
+		max, perIdentity int
+		add              []string
+		remove           []int
+		want             []error
+	}{
+		{name: "no limits", add: []string{"a", "a", "b"}, want: []error{nil, nil, nil}},
+		{name: "max", max: 2, add: []string{"a", "b", "c"}, want: []error{nil, nil, ErrTooManySessions}},
+		{name: "per identity", perIdentity: 1, add: []string{"a", "b", "a"}, want: []error{nil, nil, ErrTooManySessions}},
+		{name: "removed", max: 1, add: []string{"a", "a"}, remove: []int{0}, want: []error{nil, nil}},
+		{name: "removed per identity", perIdentity: 2, add: []string{"a", "a", "a"}, remove: []int{1}, want: []error{nil, nil, nil}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			r.SetLimits(tt.max, tt.perIdentity)
+			start := time.Now()
+			for i, id := range tt.add {
+				s := &SessionInfo{ID: string(rune('0' + i)), Identity: id, Start: start.Add(time.Duration(i))}
+				if err := r.add(s); !errors.Is(err, tt.want[i]) {
+					t.Fatalf("add %d for %q: %v != %v", i, id, err, tt.want[i])
+				}
+				for _, j := range tt.remove {
+					if j == i {
+						r.remove(s.ID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRegistrySessions(t *testing.T) {
+	r := NewRegistry()
+	start := time.Now()
+	var stopped, notified string
+	for _, s := range []*SessionInfo{
+		{ID: "2", Identity: "b", Start: start.Add(time.Second)},
+		{ID: "1", Identity: "a", Start: start, stop: func(reason string) { stopped = reason }, notify: func(msg string) { notified = msg }},
+	} {
+		if err := r.add(s); err != nil {
+			t.Fatalf("add %s: %v != nil", s.ID, err)
+		}
+	}
+	if err := r.add(&SessionInfo{ID: "1"}); !errors.Is(err, os.ErrExist) {
+		t.Errorf("add 1 again: %v != %v", err, os.ErrExist)
+	}
+	l := r.Sessions()
+	if len(l) != 2 || l[0].ID != "1" || l[1].ID != "2" {
+		t.Fatalf("Sessions(): %v, want sessions 1 and 2, oldest first", l)
+	}
+	if r.Notify("soon"); notified != "soon" {
+		t.Errorf("Notify(soon): notified %q, want soon", notified)
+	}
+	if err := r.Stop("1", "bye"); err != nil || stopped != "bye" {
+		t.Errorf("Stop(1, bye): %v, stopped with %q, want nil, bye", err, stopped)
+	}
+	if err := r.Stop("3", "bye"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stop(3, bye): %v != %v", err, os.ErrNotExist)
+	}
+	r.remove("1")
+	r.remove("1")
+	if r.Len() != 1 {
+		t.Errorf("Len(): %d != 1", r.Len())
+	}
+}
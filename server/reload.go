@@ -0,0 +1,57 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Reloader changes the options of a running cpud. It is given to New
+// with WithReloader. New connections and sessions use the options of
+// the latest Reload; those already running keep the ones they started
+// with. The key files given to New, and the listeners it serves, do
+// not change; the authorized keys file is read for each connection,
+// so changes to it take effect anyway.
+type Reloader struct {
+	mu sync.Mutex
+	d  atomic.Pointer[daemon]
+}
+
+// WithReloader makes r able to change the options of the cpud.
+func WithReloader(r *Reloader) Set {
+	return func(d *daemon) error {
+		d.reloader = r
+		return nil
+	}
+}
+
+// daemon returns the daemon in use.
+func (r *Reloader) daemon() *daemon {
+	return r.d.Load()
+}
+
+// Reload replaces the options of the cpud with opts, as they would be
+// given to New; options not in opts are reset. If an option is not
+// valid, the options are not changed. The sessions running, and the
+// connections not yet authenticated, are still counted against the
+// new limits.
+func (r *Reloader) Reload(opts ...Set) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old := r.daemon()
+	if old == nil {
+		return fmt.Errorf("reload: no cpud:%w", os.ErrInvalid)
+	}
+	d, err := newDaemon(old.cpud, old, opts...)
+	if err != nil {
+		return fmt.Errorf("reload: %w", err)
+	}
+	r.d.Store(d)
+	verbose("reloaded options")
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	r, reg := &Reloader{}, NewRegistry()
+	if err := r.Reload(); err == nil {
+		t.Fatalf("Reload before New: nil, want an error")
+	}
+	if _, err := New("", "", os.Args[0], WithReloader(r), WithRegistry(reg), WithMaxSessions(1, 0), WithAuthBackoff(time.Second, time.Minute)); err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	d := r.daemon()
+	d.startups.acquire()
+	d.backoff.fail("10.0.0.1")
+
+	for _, tt := range []struct {
+		name    string
+		opts    []Set
+		err     bool
+		max     int
+		backoff bool
+		kept    bool
+	}{
+		{name: "limits", opts: []Set{WithMaxSessions(2, 0), WithMaxStartups(3), WithAuthBackoff(time.Second, time.Minute)}, max: 2, backoff: true, kept: true},
+		{name: "not valid", opts: []Set{WithMaxSessions(-1, 0)}, err: true, max: 2, backoff: true, kept: true},
+		{name: "not valid together", opts: []Set{WithRootless(true), WithUsers("name", "")}, err: true, max: 2, backoff: true, kept: true},
+		{name: "new backoff", opts: []Set{WithAuthBackoff(time.Second, time.Hour)}, max: 0, backoff: true},
+		{name: "reset", max: 0},
+	} {
+		err := r.Reload(tt.opts...)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: Reload: %v, want error %v", tt.name, err, tt.err)
+		}
+		d := r.daemon()
+		if d.registry != reg || d.registry.max != tt.max {
+			t.Errorf("%s: registry %p with limit %d, want %p with limit %d", tt.name, d.registry, d.registry.max, reg, tt.max)
+		}
+		if d.startups.n != 1 {
+			t.Errorf("%s: %d connections counted, want 1", tt.name, d.startups.n)
+		}
+		if (d.backoff != nil) != tt.backoff {
+			t.Errorf("%s: backoff %v, want one %v", tt.name, d.backoff, tt.backoff)
+		}
+		if kept := d.backoff.wait("10.0.0.1") > 0; kept != tt.kept {
+			t.Errorf("%s: failures kept %v, want %v", tt.name, kept, tt.kept)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// listenerOptionsKey is the ssh.Context key for the options of the
+// listener a connection was accepted on.
+const listenerOptionsKey = contextKey("cpud-listener-options")
+
+// scopeOptions are the options a listener can have.
+var scopeOptions = map[string]bool{
+	"cpu-limits": true, "cpu-ns": true, "cpu-nsallow": true,
+	"cpu-caps": true, "cpu-seccomp": true, "cpu-forward": true,
+	"cpu-user": true,
+}
+
+// scopedListener accepts connections that have the options of the
+// listener.
+type scopedListener struct {
+	net.Listener
+	options keyOptions
+}
+
+type scopedConn struct {
+	net.Conn
+	options keyOptions
+}
+
+func (l *scopedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &scopedConn{Conn: c, options: l.options}, nil
+}
+
+// ScopeListener returns a listener whose connections have options, in
+// the format of those of an authorized key, e.g.
+//
+//	cpu-forward="deny any *:*",cpu-ns=pid,cpu-user=nobody
+//
+// The options are cpu-limits, cpu-ns, cpu-nsallow, cpu-caps,
+// cpu-seccomp, cpu-forward and cpu-user. They only ever restrict
+// sessions further than the key, or the server, does: limits are
+// the lower of the two, namespaces required by either are required,
+// namespaces and capabilities must be allowed by both, as must
+// forwards, both seccomp profiles are installed, and a key for
+// another account is refused. cpud can, for example, deny forwards
+// over TCP but not over vsock.
+func ScopeListener(ln net.Listener, options string) (net.Listener, error) {
+	opts, err := splitOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	o := parseKeyOptions(opts)
+	for k := range o {
+		if !scopeOptions[k] {
+			return nil, fmt.Errorf("listener option %q: not one of cpu-limits, cpu-ns, cpu-nsallow, cpu-caps, cpu-seccomp, cpu-forward or cpu-user:%w", k, strconv.ErrSyntax)
+		}
+	}
+	return &scopedListener{Listener: ln, options: o}, nil
+}
+
+// listenerOptions returns the options of the listener the connection
+// of ctx was accepted on, which are empty if there are none.
+func listenerOptions(ctx ssh.Context) keyOptions {
+	if o, ok := ctx.Value(listenerOptionsKey).(keyOptions); ok {
+		return o
+	}
+	return keyOptions{}
+}
+
+// listenerUser returns o, the options of a key, with the cpu-user
+// option of the listener of ctx, if it has one. A key for another
+// account can not be used on the listener.
+func listenerUser(ctx ssh.Context, o keyOptions) (keyOptions, error) {
+	u, ok := listenerOptions(ctx)["cpu-user"]
+	if !ok {
+		return o, nil
+	}
+	if k, ok := o["cpu-user"]; ok && k != u {
+		return nil, fmt.Errorf("the key is for account %q, and the listener for %q:%w", k, u, os.ErrPermission)
+	}
+	o["cpu-user"] = u
+	return o, nil
+}
+
+// listItems returns the items of a comma-separated list.
+func listItems(s string) []string {
+	var l []string
+	for _, i := range strings.Split(s, ",") {
+		if i = strings.TrimSpace(i); len(i) > 0 {
+			l = append(l, i)
+		}
+	}
+	return l
+}
+
+// unionList returns the items of lists a and b, e.g. the
+// namespaces required by either.
+func unionList(a, b string) string {
+	l := listItems(a)
+	for _, i := range listItems(b) {
+		if !slices.Contains(l, i) {
+			l = append(l, i)
+		}
+	}
+	return strings.Join(l, ",")
+}
+
+// intersectList returns the items of list a that are in list b,
+// e.g. the namespaces allowed by both.
+func intersectList(a, b string) string {
+	bl := listItems(b)
+	return strings.Join(slices.DeleteFunc(listItems(a), func(i string) bool {
+		return !slices.Contains(bl, i)
+	}), ",")
+}
+
+// intersectCaps returns the capabilities kept by both a and b, in
+// the format of ParseCaps in package session: "" and "all" keep all
+// capabilities, and "none" none.
+func intersectCaps(a, b string) string {
+	switch {
+	case a == "" || a == "all":
+		return b
+	case b == "" || b == "all":
+		return a
+	}
+	norm := func(s string) []string {
+		if s == "none" {
+			return nil
+		}
+		l := listItems(strings.ToLower(s))
+		for i := range l {
+			l[i] = strings.TrimPrefix(l[i], "cap_")
+		}
+		return l
+	}
+	if c := intersectList(strings.Join(norm(a), ","), strings.Join(norm(b), ",")); len(c) > 0 {
+		return c
+	}
+	return "none"
+}
+
+// splitOptions splits options at the commas that are not in quotes.
+func splitOptions(s string) ([]string, error) {
+	var (
+		opts  []string
+		quote bool
+		start int
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quote = !quote
+		case ',':
+			if !quote {
+				opts = append(opts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if quote {
+		return nil, fmt.Errorf("options %q: unterminated quote:%w", s, strconv.ErrSyntax)
+	}
+	if start < len(s) {
+		opts = append(opts, s[start:])
+	}
+	return opts, nil
+}
@@ -0,0 +1,229 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// valueContext is an ssh.Context with only values, and a user.
+type valueContext struct {
+	ssh.Context
+	user   string
+	values map[interface{}]interface{}
+}
+
+func (c *valueContext) User() string {
+	return c.user
+}
+
+func (c *valueContext) Value(k interface{}) interface{} {
+	return c.values[k]
+}
+
+func (c *valueContext) SetValue(k, v interface{}) {
+	c.values[k] = v
+}
+
+func TestSplitOptions(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{in: "", want: nil},
+		{in: "cpu-ns=pid", want: []string{"cpu-ns=pid"}},
+		{in: `cpu-limits="memory.max=1G,pids.max=64",cpu-user=nobody`, want: []string{`cpu-limits="memory.max=1G,pids.max=64"`, "cpu-user=nobody"}},
+		{in: `cpu-forward="deny \"any\" *:*,",cpu-caps=none`, want: []string{`cpu-forward="deny \"any\" *:*,"`, "cpu-caps=none"}},
+		{in: `cpu-ns="pid,net`, err: true},
+	} {
+		got, err := splitOptions(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("splitOptions(%q): %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitOptions(%q): %q != %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestScopeListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if _, err := ScopeListener(ln, "cert-authority"); err == nil {
+		t.Errorf("ScopeListener with cert-authority: nil, want an error")
+	}
+	sl, err := ScopeListener(ln, `cpu-ns=pid,cpu-forward="deny any *:*"`)
+	if err != nil {
+		t.Fatalf("ScopeListener: %v", err)
+	}
+	go func() {
+		if c, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := sl.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	defer c.Close()
+	sc, ok := c.(*scopedConn)
+	if !ok {
+		t.Fatalf("Accept: %T, want *scopedConn", c)
+	}
+
+	if want := (keyOptions{"cpu-ns": "pid", "cpu-forward": "deny any *:*"}); !reflect.DeepEqual(sc.options, want) {
+		t.Errorf("options: %q, want %q", sc.options, want)
+	}
+}
+
+// scopeSession is an ssh.Session with only a context.
+type scopeSession struct {
+	ssh.Session
+	ctx ssh.Context
+}
+
+func (s *scopeSession) Context() ssh.Context {
+	return s.ctx
+}
+
+func (s *scopeSession) Environ() []string {
+	return nil
+}
+
+// scopeContext returns a context for a connection with a key and a
+// listener with options.
+func scopeContext(key, listener keyOptions) *valueContext {
+	ctx := &valueContext{user: "glenda", values: map[interface{}]interface{}{}}
+	if key != nil {
+		ctx.SetValue(keyOptionsKey, key)
+	}
+	if listener != nil {
+		ctx.SetValue(listenerOptionsKey, listener)
+	}
+	return ctx
+}
+
+// TestListenerScope tests that the options of a listener only ever
+// restrict sessions further than those of the key, or the server.
+func TestListenerScope(t *testing.T) {
+	limits, err := ParseLimits("memory.max=2G")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &daemon{limits: limits, nsRequired: "pid", nsAllowed: "net,uts", caps: "chown,kill", seccomp: "default"}
+
+	for _, tt := range []struct {
+		name     string
+		key      keyOptions
+		listener keyOptions
+		env      []string
+		limits   string
+	}{
+		{
+			name:   "server",
+			env:    []string{"CPUD_NAMESPACES_REQUIRED=pid", "CPUD_NAMESPACES_ALLOWED=net,uts", "CPUD_CAPS=chown,kill", "CPUD_SECCOMP=default", "CPUD_SECCOMP_SCOPE="},
+			limits: "memory.max=2G",
+		},
+		{
+			name:     "listener",
+			listener: keyOptions{"cpu-ns": "net", "cpu-nsallow": "uts,ipc", "cpu-caps": "cap_kill,net_raw", "cpu-seccomp": "strict", "cpu-limits": "memory.max=1G,pids.max=10"},
+			env:      []string{"CPUD_NAMESPACES_REQUIRED=pid,net", "CPUD_NAMESPACES_ALLOWED=uts", "CPUD_CAPS=kill", "CPUD_SECCOMP=default", "CPUD_SECCOMP_SCOPE=strict"},
+			limits:   "memory.max=1G,pids.max=10",
+		},
+		{
+			name:     "listener can not widen the server",
+			listener: keyOptions{"cpu-nsallow": "net,uts,user", "cpu-caps": "all", "cpu-seccomp": "", "cpu-limits": "memory.max=4G"},
+			env:      []string{"CPUD_NAMESPACES_ALLOWED=net,uts", "CPUD_CAPS=chown,kill", "CPUD_SECCOMP=default"},
+			limits:   "memory.max=2G",
+		},
+		{
+			name:     "listener can not widen the key",
+			key:      keyOptions{"cpu-ns": "", "cpu-nsallow": "ipc", "cpu-caps": "none", "cpu-seccomp": "", "cpu-limits": "pids.max=5"},
+			listener: keyOptions{"cpu-nsallow": "ipc,user", "cpu-caps": "all", "cpu-limits": "pids.max=50"},
+			env:      []string{"CPUD_NAMESPACES_REQUIRED=", "CPUD_NAMESPACES_ALLOWED=ipc", "CPUD_CAPS=none", "CPUD_SECCOMP="},
+			limits:   "pids.max=5",
+		},
+		{
+			name:     "key and listener",
+			key:      keyOptions{"cpu-nsallow": "net,uts,ipc", "cpu-caps": "all", "cpu-limits": "memory.max=8G,pids.max=5"},
+			listener: keyOptions{"cpu-nsallow": "ipc,user", "cpu-caps": "sys_admin", "cpu-limits": "memory.max=1G,pids.max=50"},
+			env:      []string{"CPUD_NAMESPACES_ALLOWED=ipc", "CPUD_CAPS=sys_admin"},
+			limits:   "memory.max=1G,pids.max=5",
+		},
+		{
+			name:     "no capabilities in common",
+			listener: keyOptions{"cpu-caps": "net_raw"},
+			env:      []string{"CPUD_CAPS=none"},
+			limits:   "memory.max=2G",
+		},
+	} {
+		s := &scopeSession{ctx: scopeContext(tt.key, tt.listener)}
+		env := d.sessionEnv(s, "")
+		for _, e := range tt.env {
+			if !slices.Contains(env, e) {
+				t.Errorf("%s: sessionEnv: %q does not have %q", tt.name, env, e)
+			}
+		}
+		l, err := d.sessionLimits(s)
+		if err != nil {
+			t.Errorf("%s: sessionLimits: %v != nil", tt.name, err)
+			continue
+		}
+		if want, _ := ParseLimits(tt.limits); l.String() != want.String() {
+			t.Errorf("%s: sessionLimits: %q != %q", tt.name, l, want)
+		}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		key      keyOptions
+		listener keyOptions
+		local    bool
+		port     uint32
+		want     bool
+	}{
+		{name: "server", port: 80, want: true},
+		{name: "server local", local: true, port: 80},
+		{name: "listener denies", listener: keyOptions{"cpu-forward": "deny any *:*"}, port: 80},
+		{name: "listener can not widen the server", listener: keyOptions{"cpu-forward": "allow any *:*"}, local: true, port: 80},
+		{name: "key and listener", key: keyOptions{"cpu-forward": "allow any *:*"}, listener: keyOptions{"cpu-forward": "allow local *:22"}, local: true, port: 22, want: true},
+		{name: "key, but not listener", key: keyOptions{"cpu-forward": "allow any *:*"}, listener: keyOptions{"cpu-forward": "allow local *:22"}, local: true, port: 80},
+		{name: "listener, but not key", key: keyOptions{"cpu-forward": "allow local *:80"}, listener: keyOptions{"cpu-forward": "allow any *:*"}, local: true, port: 22},
+	} {
+		if got := d.allowForward(scopeContext(tt.key, tt.listener), tt.local, "example.com", tt.port); got != tt.want {
+			t.Errorf("%s: allowForward(%v, %d): %v != %v", tt.name, tt.local, tt.port, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		key      keyOptions
+		listener keyOptions
+		want     string
+		err      bool
+	}{
+		{name: "none"},
+		{name: "key", key: keyOptions{"cpu-user": "glenda"}, want: "glenda"},
+		{name: "listener", listener: keyOptions{"cpu-user": "nobody"}, want: "nobody"},
+		{name: "both", key: keyOptions{"cpu-user": "nobody"}, listener: keyOptions{"cpu-user": "nobody"}, want: "nobody"},
+		{name: "another account", key: keyOptions{"cpu-user": "glenda"}, listener: keyOptions{"cpu-user": "nobody"}, err: true},
+	} {
+		ctx := scopeContext(tt.key, tt.listener)
+		o, err := listenerUser(ctx, sessionKeyOptions(ctx))
+		if (err != nil) != tt.err || (err == nil && o["cpu-user"] != tt.want) {
+			t.Errorf("%s: listenerUser: %q, %v, want %q, error %v", tt.name, o["cpu-user"], err, tt.want, tt.err)
+		}
+	}
+}
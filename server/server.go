@@ -8,16 +8,24 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
+	"os/user"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 
 	// We use this ssh because it implements port redirection.
 	// It can not, however, unpack password-protected keys yet.
 	"github.com/creack/pty"
 	"github.com/gliderlabs/ssh"
+	"github.com/u-root/cpu/compress"
+	gossh "golang.org/x/crypto/ssh"
 	"golang.org/x/sys/unix"
 )
 
@@ -59,10 +67,603 @@ func errval(err error) error {
 	return err
 }
 
-func handler(s ssh.Session, cpud string) {
+// daemon holds the configuration shared by all the sessions of a cpud.
+type daemon struct {
+	cpud string
+	// limits are the server-wide resource limits. They can be
+	// replaced for a key with the cpu-limits authorized_keys option.
+	limits *Limits
+	// cgroup is the cgroup v2 directory in which session cgroups
+	// are made. If it is empty, the cgroup of cpud is used.
+	cgroup string
+	// cgroups forces a cgroup per session, for accounting, even
+	// if there are no limits.
+	cgroups bool
+	// nsRequired and nsAllowed are the namespace policy for
+	// sessions, as described in session.Namespaces. They can be
+	// replaced for a key with the cpu-ns and cpu-nsallow
+	// authorized_keys options.
+	nsRequired, nsAllowed string
+	// caps, noNewPrivs and seccomp are the confinement policy for
+	// sessions, as described in session.Policy. caps and seccomp can
+	// be replaced for a key with the cpu-caps and cpu-seccomp
+	// authorized_keys options.
+	caps       string
+	noNewPrivs bool
+	seccomp    string
+	seccompDir string
+	// rootless runs sessions in a user namespace of their own,
+	// so that cpud need not be root.
+	rootless bool
+	// users are the sources, in order, of the local account a
+	// session runs as, and defaultUser the account used if none of
+	// them is one. If users is nil, sessions run as cpud.
+	users       []string
+	defaultUser string
+	// forwards is the port forwarding policy. It can be replaced
+	// for a key with the cpu-forward authorized_keys option.
+	forwards Forwards
+	// registry records the running sessions, and limits how many
+	// there may be, in all (maxSessions) and per identity
+	// (maxPerIdentity).
+	registry                    *Registry
+	maxSessions, maxPerIdentity int
+	// startups counts the connections that have not authenticated,
+	// of which there may be at most maxStartups. It is kept by
+	// Reload, so that connections are counted across reloads.
+	startups    *startups
+	maxStartups int
+	// loginGrace, if not 0, is how long a connection may take to
+	// authenticate.
+	loginGrace time.Duration
+	// backoff refuses connections from hosts that fail to
+	// authenticate. If it is nil, they are not refused.
+	backoff *backoff
+	// maxSessionTime, if not 0, is how long a session may run.
+	maxSessionTime time.Duration
+	// reloader, if not nil, is given the daemon by New, so that
+	// its options can be reloaded.
+	reloader *Reloader
+	// hostKeyDir, if not empty, is the directory of host keys of
+	// hostKeyTypes, used if there is no host key file.
+	hostKeyDir   string
+	hostKeyTypes []string
+	// passwordFile, if not empty, is the file of users and password
+	// hashes, for password and keyboard-interactive authentication.
+	passwordFile string
+}
+
+// Set is the type of function used to set options in New.
+type Set func(*daemon) error
+
+// WithLimits sets the server-wide resource limits for sessions.
+// The limits are in the format accepted by ParseLimits.
+func WithLimits(limits string) Set {
+	return func(d *daemon) error {
+		l, err := ParseLimits(limits)
+		if err != nil {
+			return err
+		}
+		d.limits = l
+		return nil
+	}
+}
+
+// WithCgroup sets the cgroup v2 directory in which session cgroups
+// are created, and enables a cgroup for every session.
+// If dir is "", the cgroup of cpud is used.
+func WithCgroup(dir string) Set {
+	return func(d *daemon) error {
+		d.cgroup, d.cgroups = dir, true
+		return nil
+	}
+}
+
+// WithNamespaces sets the namespaces every session runs in, and
+// the further namespaces a client may request. Both are in the
+// format described in session.Namespaces; they are checked by
+// the session, on Linux.
+func WithNamespaces(required, allowed string) Set {
+	return func(d *daemon) error {
+		d.nsRequired, d.nsAllowed = required, allowed
+		return nil
+	}
+}
+
+// WithCaps sets the capabilities kept in the bounding set of
+// session commands, in the format accepted by session.ParseCaps.
+func WithCaps(caps string) Set {
+	return func(d *daemon) error {
+		d.caps = caps
+		return nil
+	}
+}
+
+// WithNoNewPrivs sets no_new_privs for session commands.
+func WithNoNewPrivs(nnp bool) Set {
+	return func(d *daemon) error {
+		d.noNewPrivs = nnp
+		return nil
+	}
+}
+
+// WithSeccomp sets the seccomp profile for session commands, as
+// loaded by session.LoadProfile: the built-in profile, "default",
+// or a file in dir. dir is also where the profiles named by the
+// cpu-seccomp authorized_keys option are found.
+func WithSeccomp(dir, profile string) Set {
+	return func(d *daemon) error {
+		d.seccompDir, d.seccomp = dir, profile
+		return nil
+	}
+}
+
+// WithRootless runs each session in a new user and mount namespace,
+// in which the user running cpud is mapped to itself, and has the
+// capabilities needed to build the session namespace. The client
+// namespace is mounted with FUSE, as 9P can not be mounted in a user
+// namespace. It is only supported on Linux.
+func WithRootless(rootless bool) Set {
+	return func(d *daemon) error {
+		d.rootless = rootless
+		return nil
+	}
+}
+
+// WithUsers runs sessions as local accounts, instead of as cpud.
+// sources is a comma-separated list of where to find the name of the
+// account, tried in order:
+//
+//	key: the cpu-user option of the authorized key
+//	principal: the principal of the user certificate, if one was used
+//	name: the SSH user name
+//
+// The SSH user name is chosen by the client, so any authorized key
+// could name any account. It is only used if it is the account named
+// by the key or certificate, if they name one, and it is never used
+// for an account with uid 0 unless they do.
+//
+// The first that names a local account is used. If none does, the
+// session runs as dflt or, if dflt is "", is refused. Sessions can
+// only change accounts if cpud is root, and not rootless.
+func WithUsers(sources, dflt string) Set {
+	return func(d *daemon) error {
+		d.users, d.defaultUser = []string{}, dflt
+		for _, src := range strings.Split(sources, ",") {
+			switch src {
+			case "key", "principal", "name":
+				d.users = append(d.users, src)
+			default:
+				return fmt.Errorf("user source %q: not key, principal or name:%w", src, os.ErrInvalid)
+			}
+		}
+		return nil
+	}
+}
+
+// WithForwards sets the port forwarding policy, in the format
+// accepted by ParseForwards. Without one, remote forwards are allowed,
+// and local forwards are not.
+func WithForwards(rules string) Set {
+	return func(d *daemon) error {
+		f, err := ParseForwards(rules)
+		if err != nil {
+			return err
+		}
+		d.forwards = f
+		return nil
+	}
+}
+
+// WithRegistry records sessions in r, rather than in a registry of
+// their own, so that they can be listed and stopped. The session
+// limits of r are set by New.
+func WithRegistry(r *Registry) Set {
+	return func(d *daemon) error {
+		d.registry = r
+		return nil
+	}
+}
+
+// WithMaxSessions limits the number of sessions that can run at
+// once, in all, and per identity: the authorized key, certificate
+// principal or, with no key file, SSH user name. 0 is no limit.
+func WithMaxSessions(max, perIdentity int) Set {
+	return func(d *daemon) error {
+		if max < 0 || perIdentity < 0 {
+			return fmt.Errorf("session limits %d, %d:%w", max, perIdentity, os.ErrInvalid)
+		}
+		d.maxSessions, d.maxPerIdentity = max, perIdentity
+		return nil
+	}
+}
+
+// WithMaxStartups limits the number of connections that have not
+// yet authenticated. Connections beyond it are refused. 0 is no
+// limit.
+func WithMaxStartups(n int) Set {
+	return func(d *daemon) error {
+		if n < 0 {
+			return fmt.Errorf("startup limit %d:%w", n, os.ErrInvalid)
+		}
+		d.maxStartups = n
+		return nil
+	}
+}
+
+// WithLoginGrace limits how long a connection can take to
+// authenticate. Connections that take longer are closed, so that
+// idle connections do not hold startups (WithMaxStartups) for
+// good. 0 is no limit.
+func WithLoginGrace(t time.Duration) Set {
+	return func(d *daemon) error {
+		if t < 0 {
+			return fmt.Errorf("login grace time %v:%w", t, os.ErrInvalid)
+		}
+		d.loginGrace = t
+		return nil
+	}
+}
+
+// WithAuthBackoff refuses connections from a host that failed to
+// authenticate, for base after its first failure, doubling with
+// each further failure up to max. A host's failures are forgotten
+// when it authenticates, or after max with no failures. If base is
+// 0, hosts are never refused.
+func WithAuthBackoff(base, max time.Duration) Set {
+	return func(d *daemon) error {
+		if base < 0 || max < 0 {
+			return fmt.Errorf("authentication backoff %v, %v:%w", base, max, os.ErrInvalid)
+		}
+		d.backoff = nil
+		if base > 0 {
+			d.backoff = newBackoff(base, max)
+		}
+		return nil
+	}
+}
+
+// WithMaxSessionTime limits how long a session can run. Sessions
+// that run longer are ended. 0 is no limit.
+func WithMaxSessionTime(t time.Duration) Set {
+	return func(d *daemon) error {
+		if t < 0 {
+			return fmt.Errorf("session time %v:%w", t, os.ErrInvalid)
+		}
+		d.maxSessionTime = t
+		return nil
+	}
+}
+
+// lookupUser looks up a local account.
+var lookupUser = user.Lookup
+
+var sessionCount atomic.Uint64
+
+// newSessionID returns a unique identifier for a session.
+func newSessionID() string {
+	return fmt.Sprintf("%d-%d", os.Getpid(), sessionCount.Add(1))
+}
+
+// stopGrace is how long a stopped session has to end, before its
+// channel is closed.
+const stopGrace = 5 * time.Second
+
+// stopper kills the command of a session, when it is stopped,
+// whether it has started yet or not.
+type stopper struct {
+	mu      sync.Mutex
+	p       *os.Process
+	stopped bool
+}
+
+// start records the process of a started command, and kills it if
+// the session has been stopped.
+func (st *stopper) start(p *os.Process) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.p = p
+	if st.stopped {
+		unix.Kill(-p.Pid, unix.SIGKILL) //nolint
+		p.Kill()                        //nolint
+	}
+}
+
+// stop kills the command, if it has started, and its process group.
+func (st *stopper) stop() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.stopped = true
+	if st.p != nil {
+		unix.Kill(-st.p.Pid, unix.SIGKILL) //nolint
+		st.p.Kill()                        //nolint
+	}
+}
+
+// sessionLimits returns the limits for a session. The limits come
+// from the cpu-limits option of the session's key, or, if that is not
+// set, the server-wide limits, capped by the cpu-limits option of
+// its listener, if any. The client can request limits in the
+// CPU_LIMITS environment variable; they are capped by the server
+// limits.
+func (d *daemon) sessionLimits(s ssh.Session) (*Limits, error) {
+	policy := d.limits
+	if o, ok := sessionKeyOptions(s.Context())["cpu-limits"]; ok {
+		l, err := ParseLimits(o)
+		if err != nil {
+			return nil, fmt.Errorf("cpu-limits key option: %w", err)
+		}
+		policy = l
+	}
+	if o, ok := listenerOptions(s.Context())["cpu-limits"]; ok {
+		l, err := ParseLimits(o)
+		if err != nil {
+			return nil, fmt.Errorf("cpu-limits listener option: %w", err)
+		}
+		policy = policy.Cap(l)
+	}
+	var req *Limits
+	for _, e := range s.Environ() {
+		if v, ok := strings.CutPrefix(e, "CPU_LIMITS="); ok {
+			l, err := ParseLimits(v)
+			if err != nil {
+				return nil, fmt.Errorf("CPU_LIMITS: %w", err)
+			}
+			req = l
+		}
+	}
+	return req.Cap(policy), nil
+}
+
+// allowForward returns true if the connection may forward to, if
+// local is true, or bind, if it is not, host and port. The policy is
+// that of the cpu-forward option of the key, if it has one, or else
+// that of the server; the cpu-forward option of the listener, if
+// any, must allow the forward as well.
+func (d *daemon) allowForward(ctx ssh.Context, local bool, host string, port uint32) bool {
+	policy, kind := d.forwards, "remote"
+	if local {
+		kind = "local"
+	}
+	if policy == nil {
+		policy = defaultForwards
+	}
+	if o, ok := sessionKeyOptions(ctx)["cpu-forward"]; ok {
+		f, err := ParseForwards(o)
+		if err != nil {
+			log.Printf("CPUD:cpu-forward key option: %v", err)
+			return false
+		}
+		policy = f
+	}
+	allowed := policy.Allow(local, ctx.User(), host, port)
+	if o, ok := listenerOptions(ctx)["cpu-forward"]; ok && allowed {
+		f, err := ParseForwards(o)
+		if err != nil {
+			log.Printf("CPUD:cpu-forward listener option: %v", err)
+			return false
+		}
+		allowed = f.Allow(local, ctx.User(), host, port)
+	}
+	if !allowed {
+		log.Printf("CPUD:denied %s forward of %s for %q", kind, net.JoinHostPort(host, strconv.Itoa(int(port))), ctx.User())
+		return false
+	}
+	verbose("%s forward of %s for %q granted", kind, net.JoinHostPort(host, strconv.Itoa(int(port))), ctx.User())
+	return true
+}
+
+// sessionCgroup creates a cgroup for a session, if one is needed.
+// A failure to create a cgroup is only an error if there are limits
+// to enforce.
+func (d *daemon) sessionCgroup(id string, l *Limits) (*cgroup, error) {
+	if l == nil && !d.cgroups {
+		return nil, nil
+	}
+	cg, err := newCgroup(d.cgroup, id, l)
+	if err != nil {
+		if l != nil {
+			return nil, fmt.Errorf("session cgroup for limits %q: %w", l, err)
+		}
+		log.Printf("CPUD:session %s: no cgroup, so no accounting: %v", id, err)
+		return nil, nil
+	}
+	return cg, nil
+}
+
+// sessionUser returns the local account for a session, given the
+// options of its key, its certificate principal, if any, and the SSH
+// user name. It is "" if sessions run as cpud.
+func (d *daemon) sessionUser(o keyOptions, principal, name string) (string, error) {
+	if d.users == nil {
+		return "", nil
+	}
+	for _, src := range d.users {
+		var u string
+		switch src {
+		case "key":
+			u = o["cpu-user"]
+		case "principal":
+			u = principal
+		case "name":
+			// The client chooses the name: if the key or
+			// certificate names an account, it must be that one.
+			if k := o["cpu-user"]; len(k) > 0 && k != name {
+				verbose("user %q from name: the key is for %q", name, k)
+				continue
+			}
+			if len(principal) > 0 && principal != name {
+				verbose("user %q from name: the certificate is for %q", name, principal)
+				continue
+			}
+			u = name
+		}
+		if len(u) == 0 {
+			continue
+		}
+		pw, err := lookupUser(u)
+		if err != nil {
+			verbose("user %q from %s: %v", u, src, err)
+			continue
+		}
+		// Nor can the client choose root, unless the key or
+		// certificate names it.
+		if src == "name" && pw.Uid == "0" && len(o["cpu-user"]) == 0 && len(principal) == 0 {
+			verbose("user %q from name: uid 0 must be named by the key or certificate", u)
+			continue
+		}
+		return u, nil
+	}
+	if len(d.defaultUser) > 0 {
+		return d.defaultUser, nil
+	}
+	return "", fmt.Errorf("no local account for the session:%w", os.ErrPermission)
+}
+
+// sessionEnv returns the environment for a session. Variables
+// starting with CPUD_ are set by cpud for the session, and only
+// cpud; any sent by the client are dropped. account is the local
+// account the session runs as, if any.
+func (d *daemon) sessionEnv(s ssh.Session, account string) []string {
+	var env []string
+	for _, e := range s.Environ() {
+		if strings.HasPrefix(e, "CPUD_") {
+			verbose("dropping client environment variable %q", e)
+			continue
+		}
+		env = append(env, e)
+	}
+	required, allowed := d.nsRequired, d.nsAllowed
+	o := sessionKeyOptions(s.Context())
+	if ns, ok := o["cpu-ns"]; ok {
+		required = ns
+	}
+	if ns, ok := o["cpu-nsallow"]; ok {
+		allowed = ns
+	}
+	caps, seccomp := d.caps, d.seccomp
+	if c, ok := o["cpu-caps"]; ok {
+		caps = c
+	}
+	if p, ok := o["cpu-seccomp"]; ok {
+		seccomp = p
+	}
+	// The listener only narrows what the key, or the server, allows.
+	l := listenerOptions(s.Context())
+	if ns, ok := l["cpu-ns"]; ok {
+		required = unionList(required, ns)
+	}
+	if ns, ok := l["cpu-nsallow"]; ok {
+		allowed = intersectList(allowed, ns)
+	}
+	if c, ok := l["cpu-caps"]; ok {
+		caps = intersectCaps(caps, c)
+	}
+	nnp := "0"
+	if d.noNewPrivs {
+		nnp = "1"
+	}
+	if d.rootless {
+		env = append(env, "CPUD_ROOTLESS=1")
+	}
+	if len(account) > 0 {
+		env = append(env, "CPUD_USER="+account)
+	}
+	return append(env,
+		"CPUD_NAMESPACES_REQUIRED="+required, "CPUD_NAMESPACES_ALLOWED="+allowed,
+		"CPUD_CAPS="+caps, "CPUD_NO_NEW_PRIVS="+nnp,
+		"CPUD_SECCOMP="+seccomp, "CPUD_SECCOMP_SCOPE="+l["cpu-seccomp"],
+		"CPUD_SECCOMP_DIR="+d.seccompDir)
+}
+
+func (d *daemon) handler(s ssh.Session) {
 	a := s.Command()
-	verbose("handler: cmd is %q", a)
-	cmd := command(cpud, append([]string{"-remote"}, a...)...)
+	if s.Subsystem() == "sftp" {
+		a = sftpCommand(d.cpud, s.Environ())
+	}
+	id := newSessionID()
+	verbose("handler: session %s: cmd is %q", id, a)
+	cmd := command(d.cpud, append([]string{"-remote"}, a...)...)
+	if d.rootless {
+		if err := userns(cmd); err != nil {
+			fmt.Fprintf(s.Stderr(), "CPUD:%v\n", err)
+			s.Exit(1) //nolint
+			return
+		}
+	}
+
+	principal, _ := s.Context().Value(principalKey).(string)
+	o, err := listenerUser(s.Context(), sessionKeyOptions(s.Context()))
+	var account string
+	if err == nil {
+		account, err = d.sessionUser(o, principal, s.User())
+	}
+	if err != nil {
+		log.Printf("CPUD:session %s: %v", id, err)
+		fmt.Fprintf(s.Stderr(), "CPUD:%v\n", err)
+		s.Exit(1) //nolint
+		return
+	}
+	if len(account) > 0 {
+		verbose("session %s: account %q", id, account)
+	}
+
+	st := &stopper{}
+	info := &SessionInfo{
+		ID:       id,
+		Identity: sessionIdentity(s.Context()),
+		User:     s.User(),
+		Account:  account,
+		Remote:   s.RemoteAddr().String(),
+		Command:  a,
+		Start:    time.Now(),
+		notify: func(msg string) {
+			fmt.Fprintf(s.Stderr(), "CPUD:%s\n", msg)
+		},
+		stop: func(reason string) {
+			log.Printf("CPUD:session %s: %s", id, reason)
+			fmt.Fprintf(s.Stderr(), "CPUD:%s\n", reason)
+			st.stop()
+			// The session ends when its command does, unless
+			// processes it left behind hold its output open.
+			time.AfterFunc(stopGrace, func() { s.Close() })
+		},
+	}
+	if err := d.registry.add(info); err != nil {
+		log.Printf("CPUD:session %s for %s refused: %v", id, info.Identity, err)
+		fmt.Fprintf(s.Stderr(), "CPUD:%v\n", err)
+		s.Exit(1) //nolint
+		return
+	}
+	defer d.registry.remove(id)
+	if d.maxSessionTime > 0 {
+		t := time.AfterFunc(d.maxSessionTime, func() {
+			info.stop(fmt.Sprintf("session ended after the longest time cpud allows, %v", d.maxSessionTime))
+		})
+		defer t.Stop()
+	}
+
+	limits, err := d.sessionLimits(s)
+	if err != nil {
+		fmt.Fprintf(s.Stderr(), "CPUD:%v\n", err)
+		s.Exit(1) //nolint
+		return
+	}
+	cg, err := d.sessionCgroup(id, limits)
+	if err != nil {
+		fmt.Fprintf(s.Stderr(), "CPUD:%v\n", err)
+		s.Exit(1) //nolint
+		return
+	}
+	if cg != nil {
+		cg.attach(cmd)
+		defer func() {
+			log.Printf("CPUD:session %s: accounting: %s", id, cg.accounting())
+			if err := cg.remove(); err != nil {
+				log.Printf("CPUD:session %s: removing cgroup: %v", id, err)
+			}
+		}()
+	}
 
 	sigChan := make(chan ssh.Signal, 1)
 	defer close(sigChan)
@@ -84,7 +685,10 @@ func handler(s ssh.Session, cpud string) {
 		}
 	}()
 
-	cmd.Env = append(cmd.Env, s.Environ()...)
+	cmd.Env = append(cmd.Env, d.sessionEnv(s, account)...)
+	if s.Subsystem() != "" && !hasEnv(cmd.Env, "PWD") {
+		cmd.Env = append(cmd.Env, "PWD=/")
+	}
 	ptyReq, winCh, isPty := s.Pty()
 	if isPty {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("TERM=%s", ptyReq.Term))
@@ -94,6 +698,7 @@ func handler(s ssh.Session, cpud string) {
 			verbose("err %v", err)
 			return
 		}
+		st.start(cmd.Process)
 		go func() {
 			for win := range winCh {
 				setWinsize(f, win.Width, win.Height)
@@ -126,8 +731,20 @@ func handler(s ssh.Session, cpud string) {
 
 	} else {
 		cmd.Stdin, cmd.Stdout, cmd.Stderr = s, s, s.Stderr()
+		// The command leads a process group, as it does a
+		// session with a pty, so that it can be stopped with the
+		// processes it starts.
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Setpgid = true
 		verbose("running command without pty")
-		if err := cmd.Run(); errval(err) != nil {
+		err := cmd.Start()
+		if err == nil {
+			st.start(cmd.Process)
+			err = cmd.Wait()
+		}
+		if errval(err) != nil {
 			verbose("err %v", err)
 			s.Exit(1) //nolint
 		}
@@ -135,56 +752,156 @@ func handler(s ssh.Session, cpud string) {
 	verbose("handler exits")
 }
 
+// newDaemon returns a daemon with opts set. If old is not nil, it
+// is the daemon being replaced by Reload, whose registry, connection
+// counts and, if its settings are unchanged, authentication failures,
+// are kept.
+func newDaemon(cpud string, old *daemon, opts ...Set) (*daemon, error) {
+	d := &daemon{cpud: cpud}
+	for _, o := range opts {
+		if err := o(d); err != nil {
+			return nil, err
+		}
+	}
+	if d.rootless && d.users != nil {
+		return nil, fmt.Errorf("rootless sessions can not run as other users:%w", os.ErrInvalid)
+	}
+	if old != nil {
+		d.registry, d.startups, d.reloader = old.registry, old.startups, old.reloader
+		if d.backoff != nil && old.backoff != nil && d.backoff.base == old.backoff.base && d.backoff.max == old.backoff.max {
+			d.backoff = old.backoff
+		}
+	}
+	if d.registry == nil {
+		d.registry = NewRegistry()
+	}
+	d.registry.SetLimits(d.maxSessions, d.maxPerIdentity)
+	if d.startups == nil {
+		d.startups = &startups{}
+	}
+	d.startups.setMax(d.maxStartups)
+	return d, nil
+}
+
 // New sets up a cpud. cpud is really just an SSH server with a special
 // handler and support for port forwarding for the 9p port.
-func New(publicKeyFile, hostKeyFile, cpud string) (*ssh.Server, error) {
+// Options, such as resource limits, can be set with opts.
+func New(publicKeyFile, hostKeyFile, cpud string, opts ...Set) (*ssh.Server, error) {
 	verbose("configure SSH server")
+	d, err := newDaemon(cpud, nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	r := d.reloader
+	if r == nil {
+		r = &Reloader{}
+	}
+	r.d.Store(d)
 
 	// Now we run as an ssh server, and each time we get a connection,
 	// we run that command after setting things up for it.
-	forwardHandler := &ssh.ForwardedTCPHandler{}
+	// The daemon is looked up for each connection and session, so
+	// that they use the options of the latest Reload.
+	// The channels of remote forwards, cpu's 9P and NFS back-channels,
+	// are compressed if the client asks for it.
+	forwardHandler := &forwardedTCPHandler{}
 	server := &ssh.Server{
 		LocalPortForwardingCallback: ssh.LocalPortForwardingCallback(func(ctx ssh.Context, dhost string, dport uint32) bool {
-			log.Println("CPUD:Accepted forward", dhost, dport)
-			return true
+			return r.daemon().allowForward(ctx, true, dhost, dport)
 		}),
 		// Pick a reasonable default, which can be used for a call to listen and which
 		// will be overridden later from a listen.Addr
 		Addr: ":" + defaultPort,
 		ReversePortForwardingCallback: ssh.ReversePortForwardingCallback(func(ctx ssh.Context, host string, port uint32) bool {
-			verbose("ReversePortForwardingCallback: attempt to bind %v %v granted", host, port)
-			return true
+			return r.daemon().allowForward(ctx, false, host, port)
 		}),
+		// Local forwards are direct-tcpip channels; no other
+		// channels are served.
+		ChannelHandlers: map[string]ssh.ChannelHandler{
+			"session":      ssh.DefaultSessionHandler,
+			"direct-tcpip": ssh.DirectTCPIPHandler,
+		},
 		RequestHandlers: map[string]ssh.RequestHandler{
 			"tcpip-forward":        forwardHandler.HandleSSHRequest,
 			"cancel-tcpip-forward": forwardHandler.HandleSSHRequest,
+			compress.Request:       handleCompress,
+			channelsRequest:        handleChannels,
 		},
 		Handler: func(s ssh.Session) {
-			handler(s, cpud)
+			r.daemon().handler(s)
+		},
+		// The SFTP server is run as a session is, in its
+		// namespace, with its policies.
+		SubsystemHandlers: map[string]ssh.SubsystemHandler{
+			"sftp": func(s ssh.Session) {
+				r.daemon().handler(s)
+			},
+		},
+		ConnCallback: func(ctx ssh.Context, c net.Conn) net.Conn {
+			return r.daemon().connect(ctx, c)
+		},
+		ServerConfigCallback: func(ctx ssh.Context) *gossh.ServerConfig {
+			return r.daemon().serverConfig(ctx)
 		},
 	}
 
+	// Offered keys are only checked, as a client can ask about keys,
+	// and then authenticate some other way. The options of a key are
+	// saved once the client has proven it has it, which is then how
+	// it authenticated.
 	if len(publicKeyFile) > 0 {
 		server.PublicKeyHandler = func(ctx ssh.Context, key ssh.PublicKey) bool {
-			data, err := os.ReadFile(publicKeyFile)
-			if err != nil {
-				fmt.Print(err)
+			if _, err := findKey(publicKeyFile, ctx.User(), key); err != nil {
+				log.Printf("CPUD:%v", err)
 				return false
 			}
-			allowed, _, _, _, err := ssh.ParseAuthorizedKey(data)
-			if err != nil {
-				fmt.Print(err)
-				return false
+			return true
+		}
+		server.ServerConfigCallback = func(ctx ssh.Context) *gossh.ServerConfig {
+			c := r.daemon().serverConfig(ctx)
+			c.VerifiedPublicKeyCallback = func(_ gossh.ConnMetadata, key gossh.PublicKey, p *gossh.Permissions, _ string) (*gossh.Permissions, error) {
+				if err := authorize(ctx, publicKeyFile, key); err != nil {
+					log.Printf("CPUD:%v", err)
+					return nil, err
+				}
+				return p, nil
 			}
-			return ssh.KeysEqual(key, allowed)
+			return c
 		}
 	} else {
 		log.Printf("Not encrypting SSH connections with a key file")
 	}
+	if len(d.passwordFile) > 0 {
+		file := func() string {
+			return r.daemon().passwordFile
+		}
+		server.PasswordHandler = passwordHandler(file)
+		server.KeyboardInteractiveHandler = keyboardInteractiveHandler(file)
+	}
 
-	// we ignore the SetOption error; if it does not work out, we
-	// actually don't care.
-	_ = server.SetOption(ssh.HostKeyFile(hostKeyFile))
+	// If there is no host key file, the keys in the host key
+	// directory, if any, are used. If there are no host keys, one is
+	// made up, which lasts until cpud exits, as it is if the host
+	// key directory can not be used, e.g. if it is read-only.
+	if err := server.SetOption(ssh.HostKeyFile(hostKeyFile)); err != nil && len(d.hostKeyDir) > 0 {
+		if len(hostKeyFile) > 0 {
+			log.Printf("CPUD:host key file: %v; using the keys in %q", err, d.hostKeyDir)
+		}
+		if hk, err := loadHostKeys(d.hostKeyDir, d.hostKeyTypes); err != nil {
+			log.Printf("CPUD:host keys in %q: %v; using a key that lasts until cpud exits", d.hostKeyDir, err)
+		} else {
+			for _, s := range hk.used {
+				server.AddHostKey(s)
+			}
+			for name, h := range server.ChannelHandlers {
+				server.ChannelHandlers[name] = hk.channelHandler(h)
+			}
+			for name, h := range server.RequestHandlers {
+				server.RequestHandlers[name] = hk.requestHandler(h)
+			}
+			server.RequestHandlers[hostKeysProve] = hk.prove
+		}
+	}
 	server.Version = fmt.Sprintf("%s %s", runtime.GOOS, runtime.GOARCH)
 	return server, nil
 }
@@ -5,6 +5,7 @@
 package server
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 )
@@ -23,6 +24,14 @@ func init() {
 	}
 }
 
+// selfExe is cpud, in a session, if it differs from the path of cpud.
+const selfExe = ""
+
 func command(n string, args ...string) *exec.Cmd {
 	return exec.Command(n, args...)
 }
+
+// userns is not supported: user namespaces are a Linux feature.
+func userns(*exec.Cmd) error {
+	return fmt.Errorf("CPUD:rootless sessions are only supported on Linux:%w", os.ErrInvalid)
+}
@@ -8,6 +8,8 @@ import (
 	"os"
 	"os/exec"
 	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
 // cpud can run in one of three modes
@@ -39,6 +41,10 @@ func init() {
 	}
 }
 
+// selfExe is cpud, in a session. Its path may be hidden by the mounts
+// of the session; /proc/self/exe is not.
+const selfExe = "/proc/self/exe"
+
 func command(n string, args ...string) *exec.Cmd {
 	cmd := exec.Command(n, args...)
 	// N.B.: in the go runtime, after not long ago, CLONE_NEWNS in the Unshareflags
@@ -50,3 +56,19 @@ func command(n string, args ...string) *exec.Cmd {
 	cmd.SysProcAttr = &syscall.SysProcAttr{Unshareflags: syscall.CLONE_NEWNS}
 	return cmd
 }
+
+// userns makes cmd start in a new user namespace, with the user and
+// group of cpud mapped to themselves. Unprivileged users can not
+// write a setgroups policy, so setgroups is denied. cmd keeps, in the
+// namespace and across exec, CAP_SYS_ADMIN, to mount and make
+// namespaces, CAP_NET_ADMIN, to set up their network, and CAP_SETPCAP,
+// to drop capabilities from the session command.
+func userns(cmd *exec.Cmd) error {
+	uid, gid := os.Getuid(), os.Getgid()
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}}
+	cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+	cmd.SysProcAttr.AmbientCaps = []uintptr{unix.CAP_SYS_ADMIN, unix.CAP_NET_ADMIN, unix.CAP_SETPCAP}
+	return nil
+}
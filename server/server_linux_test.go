@@ -66,6 +66,29 @@ func TestPrivateNameSpace(t *testing.T) {
 	}
 }
 
+// TestUserNameSpace tests that a rootless session, in a user
+// namespace, can make private mounts, as root or not.
+func TestUserNameSpace(t *testing.T) {
+	d := t.TempDir()
+	c := command(os.Args[0], "-test.run=TestHelperProcess", "-test.v")
+	if err := userns(c); err != nil {
+		t.Fatalf("userns: %v != nil", err)
+	}
+	c.Env = []string{"GO_WANT_HELPER_PROCESS=" + d}
+	o, err := c.CombinedOutput()
+	t.Logf("out %s", o)
+	if c.Process == nil {
+		t.Skipf("Skipping; no user namespaces: %v", err)
+	}
+	if err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	vanish := filepath.Join(d, "vanish")
+	if _, err := os.Stat(vanish); err == nil {
+		t.Fatalf("os.Stat(%q): nil != err", vanish)
+	}
+}
+
 // Now the fun begins. We have to be a demon.
 func TestDaemonSession(t *testing.T) {
 	if os.Getuid() != 0 {
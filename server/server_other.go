@@ -52,6 +52,9 @@ func osMounts() error {
 func logopts() {
 }
 
+// selfExe is cpud, in a session, if it differs from the path of cpud.
+const selfExe = ""
+
 func command(n string, args ...string) *exec.Cmd {
 	cmd := exec.Command(n, args...)
 	return cmd
@@ -61,3 +64,8 @@ func command(n string, args ...string) *exec.Cmd {
 func runSetup() error {
 	return nil
 }
+
+// userns is not supported: user namespaces are a Linux feature.
+func userns(*exec.Cmd) error {
+	return fmt.Errorf("CPUD:rootless sessions are only supported on Linux:%w", os.ErrInvalid)
+}
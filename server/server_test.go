@@ -6,17 +6,22 @@ package server
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/user"
 	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/gliderlabs/ssh"
 	"github.com/u-root/cpu/session"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 func TestNewServer(t *testing.T) {
@@ -151,3 +156,152 @@ func TestMain(m *testing.M) {
 	flag.Parse()
 	os.Exit(m.Run())
 }
+
+func TestAuthorizedKeys(t *testing.T) {
+	d := t.TempDir()
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(publicKey)
+	if err != nil {
+		t.Fatalf("ParseAuthorizedKey(publicKey): %v != nil", err)
+	}
+	other := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGmTuv9FMSj/bhGDyWbNgRyz0C5Gt5fF56FxvpDG6jmW other@xcpu\n"
+	keys := filepath.Join(d, "authorized_keys")
+	data := other + `no-pty,cpu-limits="memory.max=1G,pids.max=100" ` + string(publicKey) + "\n"
+	if err := os.WriteFile(keys, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	k, err := findAuthorizedKey(keys, pub)
+	if err != nil {
+		t.Fatalf("findAuthorizedKey(%q): %v != nil", keys, err)
+	}
+	if k.comment != "rminnich@xcpu" {
+		t.Errorf("comment: %q != %q", k.comment, "rminnich@xcpu")
+	}
+	if o, ok := k.options["no-pty"]; !ok || o != "" {
+		t.Errorf("no-pty option: (%q, %v) != (\"\", true)", o, ok)
+	}
+	if o := k.options["cpu-limits"]; o != "memory.max=1G,pids.max=100" {
+		t.Errorf("cpu-limits option: %q != %q", o, "memory.max=1G,pids.max=100")
+	}
+
+	if err := os.WriteFile(keys, []byte(other), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := findAuthorizedKey(keys, pub); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("findAuthorizedKey(%q): %v != %v", keys, err, os.ErrNotExist)
+	}
+}
+
+func TestCertAuthority(t *testing.T) {
+	newKey := func() gossh.Signer {
+		_, k, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := gossh.NewSignerFromKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	ca, user := newKey(), newKey()
+	newCert := func(typ uint32, principals ...string) *gossh.Certificate {
+		c := &gossh.Certificate{Key: user.PublicKey(), CertType: typ, ValidPrincipals: principals, ValidBefore: gossh.CertTimeInfinity}
+		if err := c.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	keys := filepath.Join(t.TempDir(), "authorized_keys")
+	data := `cert-authority,cpu-limits="pids.max=10" ` + string(gossh.MarshalAuthorizedKey(ca.PublicKey()))
+	if err := os.WriteFile(keys, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		user string
+		cert *gossh.Certificate
+		err  error
+	}{
+		{name: "ok", user: "glenda", cert: newCert(gossh.UserCert, "glenda", "rob")},
+		{name: "principal", user: "ken", cert: newCert(gossh.UserCert, "glenda"), err: os.ErrPermission},
+		{name: "host cert", user: "glenda", cert: newCert(gossh.HostCert, "glenda"), err: os.ErrPermission},
+	} {
+		k, err := findCertAuthority(keys, tt.user, tt.cert)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: findCertAuthority: %v != %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && k.options["cpu-limits"] != "pids.max=10" {
+			t.Errorf("%s: cpu-limits option: %q != %q", tt.name, k.options["cpu-limits"], "pids.max=10")
+		}
+	}
+	// A certificate authority does not authorize its own key.
+	if _, err := findAuthorizedKey(keys, ca.PublicKey()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("findAuthorizedKey(ca): %v != %v", err, os.ErrNotExist)
+	}
+	// Nor does a key authorize a certificate it signed.
+	if err := os.WriteFile(keys, gossh.MarshalAuthorizedKey(ca.PublicKey()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := findCertAuthority(keys, "glenda", newCert(gossh.UserCert, "glenda")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("findCertAuthority(no cert-authority): %v != %v", err, os.ErrNotExist)
+	}
+}
+
+func TestSessionUser(t *testing.T) {
+	lookupUser = func(name string) (*user.User, error) {
+		if name == "glenda" || name == "rob" || name == "none" {
+			return &user.User{Username: name, Uid: "1000"}, nil
+		}
+		if name == "root" {
+			return &user.User{Username: name, Uid: "0"}, nil
+		}
+		return nil, user.UnknownUserError(name)
+	}
+	defer func() { lookupUser = user.Lookup }()
+	for _, tt := range []struct {
+		sources   string
+		dflt      string
+		key       string
+		principal string
+		name      string
+		user      string
+		err       error
+	}{
+		{sources: "key,name", key: "rob", name: "glenda", user: "rob"},
+		{sources: "name,key", key: "rob", name: "glenda", user: "rob"},
+		{sources: "key,name", key: "ken", name: "glenda", err: os.ErrPermission},
+		{sources: "name", key: "rob", name: "glenda", err: os.ErrPermission},
+		{sources: "name", key: "rob", name: "rob", user: "rob"},
+		{sources: "name", principal: "rob", name: "glenda", err: os.ErrPermission},
+		{sources: "name", name: "glenda", user: "glenda"},
+		{sources: "name", name: "root", err: os.ErrPermission},
+		{sources: "name", name: "root", dflt: "none", user: "none"},
+		{sources: "name", key: "root", name: "root", user: "root"},
+		{sources: "name", principal: "root", name: "root", user: "root"},
+		{sources: "principal", principal: "rob", name: "rob", user: "rob"},
+		{sources: "principal", name: "rob", err: os.ErrPermission},
+		{sources: "name", name: "ken", dflt: "none", user: "none"},
+		{sources: "name", name: "ken", err: os.ErrPermission},
+		{sources: "uid", err: os.ErrInvalid},
+	} {
+		d := &daemon{}
+		if err := WithUsers(tt.sources, tt.dflt)(d); err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("WithUsers(%q): %v != %v", tt.sources, err, tt.err)
+			}
+			continue
+		}
+		o := keyOptions{}
+		if len(tt.key) > 0 {
+			o["cpu-user"] = tt.key
+		}
+		u, err := d.sessionUser(o, tt.principal, tt.name)
+		if !errors.Is(err, tt.err) || u != tt.user {
+			t.Errorf("sessionUser(%q, %q, %q) with %q: (%q, %v) != (%q, %v)", tt.key, tt.principal, tt.name, tt.sources, u, err, tt.user, tt.err)
+		}
+	}
+	if u, err := (&daemon{}).sessionUser(keyOptions{"cpu-user": "rob"}, "", "glenda"); err != nil || u != "" {
+		t.Errorf("sessionUser with no WithUsers: (%q, %v) != (\"\", nil)", u, err)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"strings"
+
+	"github.com/u-root/cpu/sftp"
+)
+
+// sftpCommand returns the command of an sftp subsystem session: cpud,
+// as an SFTP server, run as the command of a session. A client that
+// serves its namespace over 9P, as cpu does, names the port in
+// CPU_PORT9P, so that the SFTP server can reach /tmp/cpu.
+func sftpCommand(cpud string, env []string) []string {
+	var a []string
+	for _, e := range env {
+		if p, ok := strings.CutPrefix(e, "CPU_PORT9P="); ok && len(p) > 0 {
+			a = append(a, "-port9p", p)
+		}
+	}
+	if len(selfExe) > 0 {
+		cpud = selfExe
+	}
+	return append(a, cpud, sftp.SubsystemArg)
+}
+
+// hasEnv returns true if the variable name is set in env.
+func hasEnv(env []string, name string) bool {
+	for _, e := range env {
+		if strings.HasPrefix(e, name+"=") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/u-root/cpu/sftp"
+)
+
+func TestSFTPCommand(t *testing.T) {
+	cpud := "cpud"
+	if len(selfExe) > 0 {
+		cpud = selfExe
+	}
+	for _, tt := range []struct {
+		env  []string
+		want []string
+	}{
+		{env: nil, want: []string{cpud, sftp.SubsystemArg}},
+		{env: []string{"HOME=/", "CPU_PORT9P="}, want: []string{cpud, sftp.SubsystemArg}},
+		{env: []string{"CPU_PORT9P=5640", "CPUNONCE=x"}, want: []string{"-port9p", "5640", cpud, sftp.SubsystemArg}},
+	} {
+		if got := sftpCommand("cpud", tt.env); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sftpCommand(%q): %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Policy confines the command of a session: it can reduce the
+// capability bounding set, run the command as a local account, set
+// no_new_privs, and install a seccomp filter.
+//
+// The policy is applied by a small helper, the session executable
+// re-executed with ConfineArg, just before it execs the command.
+type Policy struct {
+	// Caps, if not nil, are the capabilities kept in the bounding
+	// set; all others are dropped. An empty, non-nil, Caps drops
+	// all capabilities. A session that is not root can not change
+	// its bounding set, and gets no_new_privs instead.
+	Caps []string
+	// User, if not nil, is the account the command runs as. Its
+	// groups and ids are set once the bounding set is reduced, and
+	// before no_new_privs and seccomp, which then no longer needs
+	// CAP_SYS_ADMIN.
+	User *User
+	// NoNewPrivs sets no_new_privs, so setuid and file capabilities
+	// no longer grant privileges. It is always set for a seccomp
+	// filter if the session is not root.
+	NoNewPrivs bool
+	// Seccomp is the seccomp profile, if any.
+	Seccomp *Profile
+	// ScopeSeccomp, if not nil, is a second profile, from the
+	// listener the session was accepted on. Both filters are
+	// installed, and a syscall must pass both.
+	ScopeSeccomp *Profile
+}
+
+// Any returns true if the policy confines the command at all.
+func (p *Policy) Any() bool {
+	return p != nil && (p.Caps != nil || p.User != nil || p.NoNewPrivs || p.Seccomp != nil || p.ScopeSeccomp != nil)
+}
+
+// capNames are the names of the capabilities, in capability order,
+// without the cap_ prefix.
+var capNames = []string{
+	"chown", "dac_override", "dac_read_search", "fowner", "fsetid",
+	"kill", "setgid", "setuid", "setpcap", "linux_immutable",
+	"net_bind_service", "net_broadcast", "net_admin", "net_raw", "ipc_lock",
+	"ipc_owner", "sys_module", "sys_rawio", "sys_chroot", "sys_ptrace",
+	"sys_pacct", "sys_admin", "sys_boot", "sys_nice", "sys_resource",
+	"sys_time", "sys_tty_config", "mknod", "lease", "audit_write",
+	"audit_control", "setfcap", "mac_override", "mac_admin", "syslog",
+	"wake_alarm", "block_suspend", "audit_read", "perfmon", "bpf",
+	"checkpoint_restore",
+}
+
+// ParseCaps parses a comma-separated list of capabilities to keep,
+// e.g. chown,net_bind_service. Names are case-insensitive and the
+// cap_ prefix is optional. "" and "all" keep all capabilities, and
+// return nil; "none" keeps none.
+func ParseCaps(s string) ([]string, error) {
+	switch s {
+	case "", "all":
+		return nil, nil
+	case "none":
+		return []string{}, nil
+	}
+	caps := []string{}
+	for _, c := range strings.Split(s, ",") {
+		n := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(c)), "cap_")
+		if _, ok := capNumber(n); !ok {
+			return nil, fmt.Errorf("capability %q: unknown:%w", c, strconv.ErrSyntax)
+		}
+		caps = append(caps, n)
+	}
+	return caps, nil
+}
+
+func capNumber(name string) (int, bool) {
+	for i, n := range capNames {
+		if n == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Profile is a seccomp profile: a list of syscalls that are allowed,
+// all others failing with EPERM, or that fail with EPERM, all others
+// being allowed.
+//
+// Profiles are written as "allow" or "deny", followed by syscall
+// names, separated by white space or commas. A # starts a comment
+// that runs to the end of the line, e.g.
+//
+//	# No kernel modules.
+//	deny
+//	init_module finit_module delete_module
+//
+// Syscalls unknown on an architecture are ignored. The command is
+// started by exec, after the filter is installed, so an allowlist
+// must allow execve.
+//
+// In a deny profile, CloneNamespaces denies clone with the flags for
+// new namespaces, which unshare would need, and makes clone3, whose
+// flags a filter can not see, fail with ENOSYS, so that libc, and
+// others, use clone.
+type Profile struct {
+	Allow    bool
+	Syscalls []string
+}
+
+// DefaultProfile is the name of the built-in profile, which denies
+// syscalls that change the system as a whole, e.g. mount, reboot
+// or module loading, or that escape the session, e.g. ptrace, setns,
+// or unshare or clone into new namespaces, and io_uring, which can
+// do what others do without the filter seeing them.
+const DefaultProfile = "default"
+
+// CloneNamespaces is the name, in a deny profile, for clone with the
+// flags for new namespaces, and clone3.
+const CloneNamespaces = "clone:ns"
+
+var defaultProfile = &Profile{
+	Syscalls: []string{
+		"acct", "add_key", "bpf", "clock_adjtime", "clock_settime",
+		CloneNamespaces, "create_module", "delete_module", "finit_module", "fsconfig",
+		"fsmount", "fsopen", "fspick", "get_kernel_syms", "init_module",
+		"io_uring_enter", "io_uring_register", "io_uring_setup", "ioperm",
+		"iopl", "kcmp", "kexec_file_load", "kexec_load", "keyctl",
+		"lookup_dcookie", "mount", "mount_setattr", "move_mount", "name_to_handle_at",
+		"nfsservctl", "open_by_handle_at", "open_tree", "perf_event_open", "pivot_root",
+		"process_vm_readv", "process_vm_writev", "ptrace", "query_module", "quotactl",
+		"reboot", "request_key", "setns", "settimeofday", "stime",
+		"swapoff", "swapon", "sysfs", "_sysctl", "umount",
+		"umount2", "unshare", "uselib", "userfaultfd", "ustat",
+		"vm86", "vm86old",
+	},
+}
+
+// ParseProfile parses a seccomp profile, as described in Profile.
+func ParseProfile(s string) (*Profile, error) {
+	var f []string
+	for _, l := range strings.Split(s, "\n") {
+		l, _, _ = strings.Cut(l, "#")
+		f = append(f, strings.FieldsFunc(l, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		})...)
+	}
+	if len(f) == 0 {
+		return nil, fmt.Errorf("seccomp profile: empty:%w", strconv.ErrSyntax)
+	}
+	p := &Profile{}
+	switch f[0] {
+	case "allow":
+		p.Allow = true
+	case "deny":
+	default:
+		return nil, fmt.Errorf("seccomp profile: %q is not allow or deny:%w", f[0], strconv.ErrSyntax)
+	}
+	p.Syscalls = f[1:]
+	if p.Allow && slices.Contains(p.Syscalls, CloneNamespaces) {
+		return nil, fmt.Errorf("seccomp profile: %s in an allow profile:%w", CloneNamespaces, strconv.ErrSyntax)
+	}
+	return p, nil
+}
+
+// LoadProfile returns the seccomp profile called name: "" is no
+// profile, DefaultProfile is the built-in profile, and any other
+// name is a file in dir.
+func LoadProfile(dir, name string) (*Profile, error) {
+	switch {
+	case name == "":
+		return nil, nil
+	case name == DefaultProfile:
+		return defaultProfile, nil
+	case len(dir) == 0:
+		return nil, fmt.Errorf("seccomp profile %q: no profile directory:%w", name, os.ErrNotExist)
+	case strings.ContainsRune(name, '/') || name == "." || name == "..":
+		return nil, fmt.Errorf("seccomp profile %q: not a file name:%w", name, os.ErrInvalid)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("seccomp profile %q: %w", name, err)
+	}
+	return ParseProfile(string(b))
+}
+
+// String implements fmt.Stringer. The output can be parsed by
+// ParseProfile.
+func (p *Profile) String() string {
+	if p == nil {
+		return ""
+	}
+	a := "deny"
+	if p.Allow {
+		a = "allow"
+	}
+	return strings.Join(append([]string{a}, p.Syscalls...), " ")
+}
+
+// sessionPolicy returns the policy for the session command, as set
+// by cpud in CPUD_CAPS, CPUD_NO_NEW_PRIVS, CPUD_SECCOMP,
+// CPUD_SECCOMP_SCOPE and CPUD_SECCOMP_DIR. None of them are passed
+// on to the command.
+func sessionPolicy() (*Policy, error) {
+	env := map[string]string{}
+	for _, e := range []string{"CPUD_CAPS", "CPUD_NO_NEW_PRIVS", "CPUD_SECCOMP", "CPUD_SECCOMP_SCOPE", "CPUD_SECCOMP_DIR"} {
+		env[e] = os.Getenv(e)
+		os.Unsetenv(e)
+	}
+	caps, err := ParseCaps(env["CPUD_CAPS"])
+	if err != nil {
+		return nil, fmt.Errorf("CPUD_CAPS: %w", err)
+	}
+	prof, err := LoadProfile(env["CPUD_SECCOMP_DIR"], env["CPUD_SECCOMP"])
+	if err != nil {
+		return nil, fmt.Errorf("CPUD_SECCOMP: %w", err)
+	}
+	scope, err := LoadProfile(env["CPUD_SECCOMP_DIR"], env["CPUD_SECCOMP_SCOPE"])
+	if err != nil {
+		return nil, fmt.Errorf("CPUD_SECCOMP_SCOPE: %w", err)
+	}
+	return &Policy{Caps: caps, NoNewPrivs: env["CPUD_NO_NEW_PRIVS"] == "1", Seccomp: prof, ScopeSeccomp: scope}, nil
+}
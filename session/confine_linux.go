@@ -0,0 +1,299 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ConfineArg is the first argument to the session executable, usually
+// cpud, when it is to apply a Policy and exec a command.
+// Executables that use Run with a Policy must call Confine when
+// they see it, e.g.
+//
+//	if len(os.Args) > 1 && os.Args[1] == session.ConfineArg {
+//		os.Exit(session.Confine(os.Args[2:]))
+//	}
+const ConfineArg = "-confine"
+
+// command wraps c so it is started by the confine helper, which
+// applies the policy and execs c.
+func (p *Policy) command(c *exec.Cmd) (*exec.Cmd, error) {
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	args := []string{ConfineArg}
+	if p.Caps != nil {
+		caps := strings.Join(p.Caps, ",")
+		if len(caps) == 0 {
+			caps = "none"
+		}
+		args = append(args, "-caps", caps)
+	}
+	if p.User != nil {
+		args = append(args, "-user", p.User.ids())
+	}
+	if p.NoNewPrivs {
+		args = append(args, "-nnp")
+	}
+	if p.Seccomp != nil {
+		args = append(args, "-seccomp", p.Seccomp.String())
+	}
+	if p.ScopeSeccomp != nil {
+		args = append(args, "-scopeseccomp", p.ScopeSeccomp.String())
+	}
+	args = append(append(args, "--", c.Path), c.Args...)
+	confine := exec.Command(self, args...)
+	confine.Stdin, confine.Stdout, confine.Stderr, confine.Dir, confine.Env = c.Stdin, c.Stdout, c.Stderr, c.Dir, c.Env
+	return confine, nil
+}
+
+// lastCap returns the highest capability the kernel knows.
+func lastCap() int {
+	b, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return unix.CAP_LAST_CAP
+	}
+	c, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return unix.CAP_LAST_CAP
+	}
+	return c
+}
+
+// boundCaps reduces the capability bounding set to caps, and removes
+// the others from the inheritable and ambient sets, so that they can
+// not be regained on exec, even by root.
+func boundCaps(caps []string) error {
+	keep := map[int]bool{}
+	for _, n := range caps {
+		c, ok := capNumber(n)
+		if !ok {
+			return fmt.Errorf("capability %q: unknown:%w", n, strconv.ErrSyntax)
+		}
+		keep[c] = true
+	}
+	var mask [2]uint32
+	for c := 0; c <= lastCap(); c++ {
+		if keep[c] {
+			mask[c/32] |= 1 << (c % 32)
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil && !errors.Is(err, unix.EINVAL) {
+			return fmt.Errorf("dropping capability %d from the bounding set: %w", c, err)
+		}
+	}
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("capget: %w", err)
+	}
+	for i := range data {
+		data[i].Inheritable &= mask[i]
+	}
+	if err := unix.Capset(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("capset: %w", err)
+	}
+	// Kernels before 4.3 have no ambient capabilities.
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil && !errors.Is(err, unix.EINVAL) {
+		return fmt.Errorf("clearing ambient capabilities: %w", err)
+	}
+	return nil
+}
+
+func bpfStmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// filter compiles the profile to a seccomp BPF program. Syscalls
+// from other architectures are fatal; those of the x32 ABI fail
+// with EPERM.
+func (p *Profile) filter() ([]unix.SockFilter, error) {
+	if syscalls == nil {
+		return nil, fmt.Errorf("seccomp: no syscall table for %s:%w", runtime.GOARCH, os.ErrInvalid)
+	}
+	const (
+		arch   = 4  // offsetof(struct seccomp_data, arch)
+		nr     = 0  // offsetof(struct seccomp_data, nr)
+		arg0   = 16 // offsetof(struct seccomp_data, args[0]), low 32 bits
+		ld     = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+		jeq    = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+		jge    = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+		jset   = unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K
+		ret    = unix.BPF_RET | unix.BPF_K
+		eperm  = unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+		enosys = unix.SECCOMP_RET_ERRNO | uint32(unix.ENOSYS)
+		newns  = unix.CLONE_NEWNS | unix.CLONE_NEWCGROUP | unix.CLONE_NEWUTS | unix.CLONE_NEWIPC | unix.CLONE_NEWUSER | unix.CLONE_NEWPID | unix.CLONE_NEWNET
+	)
+	match, other := uint32(eperm), uint32(unix.SECCOMP_RET_ALLOW)
+	if p.Allow {
+		match, other = other, match
+	}
+	f := []unix.SockFilter{
+		bpfStmt(ld, arch),
+		bpfJump(jeq, auditArch, 1, 0),
+		bpfStmt(ret, unix.SECCOMP_RET_KILL_PROCESS),
+		bpfStmt(ld, nr),
+	}
+	if x32Bit != 0 {
+		f = append(f, bpfJump(jge, x32Bit, 0, 1), bpfStmt(ret, eperm))
+	}
+	var cloneNS bool
+	for _, s := range p.Syscalls {
+		if s == CloneNamespaces {
+			cloneNS = !p.Allow
+			continue
+		}
+		n, ok := syscalls[s]
+		if !ok {
+			verbose("seccomp: no syscall %q on %s", s, runtime.GOARCH)
+			continue
+		}
+		f = append(f, bpfJump(jeq, uint32(n), 0, 1), bpfStmt(ret, match))
+	}
+	// clone's flags are its first argument, on amd64 and arm64.
+	if cloneNS {
+		f = append(f,
+			bpfJump(jeq, uint32(syscalls["clone3"]), 0, 1), bpfStmt(ret, enosys),
+			bpfJump(jeq, uint32(syscalls["clone"]), 0, 3),
+			bpfStmt(ld, arg0),
+			bpfJump(jset, newns, 0, 1), bpfStmt(ret, eperm),
+		)
+	}
+	f = append(f, bpfStmt(ret, other))
+	if len(f) > unix.BPF_MAXINSNS {
+		return nil, fmt.Errorf("seccomp: %d instructions, more than %d:%w", len(f), unix.BPF_MAXINSNS, os.ErrInvalid)
+	}
+	return f, nil
+}
+
+// setUser sets the groups, gid and uid of the process to those of
+// u. Changing the uid from 0 drops all capabilities.
+func setUser(u *User) error {
+	if err := syscall.Setgroups(u.Groups); err != nil {
+		return fmt.Errorf("setgroups(%v): %w", u.Groups, err)
+	}
+	if err := syscall.Setgid(u.GID); err != nil {
+		return fmt.Errorf("setgid(%d): %w", u.GID, err)
+	}
+	if err := syscall.Setuid(u.UID); err != nil {
+		return fmt.Errorf("setuid(%d): %w", u.UID, err)
+	}
+	return nil
+}
+
+// apply applies the policy to the calling thread. The thread must be
+// locked, and the only thing left for it to do is exec.
+func (p *Policy) apply() error {
+	nnp := p.NoNewPrivs
+	if p.Caps != nil {
+		err := boundCaps(p.Caps)
+		// A session that is no longer root can not change its
+		// bounding set; all it can do is make sure it can not gain
+		// capabilities on exec.
+		if errors.Is(err, unix.EPERM) && os.Geteuid() != 0 {
+			verbose("confine: %v; setting no_new_privs instead", err)
+			err, nnp = nil, true
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if p.User != nil {
+		if err := setUser(p.User); err != nil {
+			return err
+		}
+	}
+	// Without CAP_SYS_ADMIN, a seccomp filter requires no_new_privs.
+	seccomp := slices.DeleteFunc([]*Profile{p.Seccomp, p.ScopeSeccomp}, func(p *Profile) bool { return p == nil })
+	if nnp || (len(seccomp) > 0 && os.Geteuid() != 0) {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			return fmt.Errorf("no_new_privs: %w", err)
+		}
+	}
+	// Stacked filters all run; the most restrictive action wins.
+	for _, s := range seccomp {
+		f, err := s.filter()
+		if err != nil {
+			return err
+		}
+		prog := unix.SockFprog{Len: uint16(len(f)), Filter: &f[0]}
+		if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0); err != nil {
+			return fmt.Errorf("seccomp: %w", err)
+		}
+	}
+	return nil
+}
+
+// Confine is the confine helper. args are flags for the policy,
+// followed by "--", the path of the command, and its arguments,
+// including argv[0]. It only returns on error, with an exit status.
+func Confine(args []string) int {
+	// Capabilities, no_new_privs and seccomp filters are all per-thread,
+	// and inherited across exec.
+	runtime.LockOSThread()
+	f := flag.NewFlagSet("confine", flag.ContinueOnError)
+	caps := f.String("caps", "", "capabilities to keep in the bounding set")
+	ids := f.String("user", "", "uid:gid:groups to run as")
+	nnp := f.Bool("nnp", false, "set no_new_privs")
+	seccomp := f.String("seccomp", "", "seccomp profile")
+	scopeSeccomp := f.String("scopeseccomp", "", "second seccomp profile, of the listener")
+	if err := f.Parse(args); err != nil {
+		return 1
+	}
+	if f.NArg() < 2 {
+		log.Printf("CPUD(confine): usage: %s [flags] -- path argv0 [args]", ConfineArg)
+		return 1
+	}
+	p := &Policy{NoNewPrivs: *nnp}
+	var err error
+	if p.Caps, err = ParseCaps(*caps); err != nil {
+		log.Printf("CPUD(confine): %v", err)
+		return 1
+	}
+	if len(*ids) > 0 {
+		if p.User, err = parseIDs(*ids); err != nil {
+			log.Printf("CPUD(confine): %v", err)
+			return 1
+		}
+	}
+	if len(*seccomp) > 0 {
+		if p.Seccomp, err = ParseProfile(*seccomp); err != nil {
+			log.Printf("CPUD(confine): %v", err)
+			return 1
+		}
+	}
+	if len(*scopeSeccomp) > 0 {
+		if p.ScopeSeccomp, err = ParseProfile(*scopeSeccomp); err != nil {
+			log.Printf("CPUD(confine): %v", err)
+			return 1
+		}
+	}
+	if err := p.apply(); err != nil {
+		log.Printf("CPUD(confine): %v", err)
+		return 1
+	}
+	a := f.Args()
+	err = syscall.Exec(a[0], a[1:], os.Environ())
+	log.Printf("CPUD(confine): exec %q: %v", a[0], err)
+	return 1
+}
@@ -0,0 +1,79 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestConfine(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skipf("Skipping as we are not root")
+	}
+	for _, tt := range []struct {
+		name   string
+		policy string
+		cmd    string
+		out    string
+		ok     bool
+	}{
+		{name: "no caps", policy: "caps=none", cmd: "grep CapBnd /proc/self/status", out: "CapBnd:\t0000000000000000\n", ok: true},
+		{name: "kill", policy: "caps=kill", cmd: "grep CapBnd /proc/self/status", out: "CapBnd:\t0000000000000020\n", ok: true},
+		{name: "nnp", policy: "nnp", cmd: "grep NoNewPrivs /proc/self/status", out: "NoNewPrivs:\t1\n", ok: true},
+		{name: "deny", policy: "seccomp=deny uname", cmd: "uname", ok: false},
+		{name: "default", policy: "seccomp=" + DefaultProfile, cmd: "unshare -m true", ok: false},
+		{name: "default clone", policy: "seccomp=" + DefaultProfile, cmd: os.Args[0] + " " + cloneNSArg, ok: false},
+		{name: "clone", policy: "nnp", cmd: os.Args[0] + " " + cloneNSArg, ok: true},
+		{name: "default fork", policy: "seccomp=" + DefaultProfile, cmd: "/bin/echo hi | cat", out: "hi\n", ok: true},
+		{name: "allowed", policy: "seccomp=deny mount", cmd: "echo hi", out: "hi\n", ok: true},
+		{name: "user", policy: "user=65534:65534:65534,100", cmd: "id -u; id -G; grep CapEff /proc/self/status", out: "65534\n65534 100\nCapEff:\t0000000000000000\n", ok: true},
+		{name: "user seccomp", policy: "user=65534:65534:;seccomp=deny mount", cmd: "grep -E 'NoNewPrivs|Seccomp:' /proc/self/status", out: "NoNewPrivs:\t1\nSeccomp:\t2\n", ok: true},
+		{name: "scope", policy: "scopeseccomp=deny uname", cmd: "uname", ok: false},
+		{name: "scope stacked", policy: "seccomp=deny mount;scopeseccomp=deny uname", cmd: "uname", ok: false},
+		{name: "scope stacked allowed", policy: "seccomp=deny mount;scopeseccomp=deny uname", cmd: "echo hi", out: "hi\n", ok: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Policy{}
+			var err error
+			for _, kv := range strings.Split(tt.policy, ";") {
+				k, v, _ := strings.Cut(kv, "=")
+				switch k {
+				case "caps":
+					p.Caps, err = ParseCaps(v)
+				case "user":
+					p.User, err = parseIDs(v)
+				case "nnp":
+					p.NoNewPrivs = true
+				case "seccomp":
+					if p.Seccomp, err = LoadProfile("", v); err != nil {
+						p.Seccomp, err = ParseProfile(v)
+					}
+				case "scopeseccomp":
+					p.ScopeSeccomp, err = ParseProfile(v)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			var out bytes.Buffer
+			c := exec.Command("/bin/sh", "-c", tt.cmd)
+			c.Stdout, c.Dir = &out, "/"
+			if c, err = p.command(c); err != nil {
+				t.Fatalf("command: %v != nil", err)
+			}
+			err = c.Run()
+			if (err == nil) != tt.ok {
+				t.Fatalf("Run: %v, want ok %v; output %q", err, tt.ok, out.String())
+			}
+			if tt.ok && out.String() != tt.out {
+				t.Errorf("output: %q != %q", out.String(), tt.out)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package session
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// ConfineArg is the first argument to the session executable
+// when it is to apply a Policy and exec a command.
+const ConfineArg = "-confine"
+
+func (p *Policy) command(c *exec.Cmd) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("confining sessions is only supported on Linux:%w", os.ErrInvalid)
+}
+
+// Confine is the confine helper.
+// Policies are not supported on this kernel.
+func Confine(args []string) int {
+	return 1
+}
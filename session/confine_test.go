@@ -0,0 +1,102 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseCaps(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		caps []string
+		err  error
+	}{
+		{in: "", caps: nil},
+		{in: "all", caps: nil},
+		{in: "none", caps: []string{}},
+		{in: "chown", caps: []string{"chown"}},
+		{in: "CAP_NET_BIND_SERVICE, kill", caps: []string{"net_bind_service", "kill"}},
+		{in: "cap_sys_admin,checkpoint_restore", caps: []string{"sys_admin", "checkpoint_restore"}},
+		{in: "chown,", err: strconv.ErrSyntax},
+		{in: "root", err: strconv.ErrSyntax},
+	} {
+		caps, err := ParseCaps(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ParseCaps(%q): %v != %v", tt.in, err, tt.err)
+			continue
+		}
+		if (caps == nil) != (tt.caps == nil) || strings.Join(caps, ",") != strings.Join(tt.caps, ",") {
+			t.Errorf("ParseCaps(%q): %q != %q", tt.in, caps, tt.caps)
+		}
+	}
+}
+
+func TestParseProfile(t *testing.T) {
+	for _, tt := range []struct {
+		in  string
+		out string
+		err error
+	}{
+		{in: "deny", out: "deny"},
+		{in: "deny mount,umount2", out: "deny mount umount2"},
+		{in: "# comment\nallow\n\tread write # io\r\nexecve\n", out: "allow read write execve"},
+		{in: "", err: strconv.ErrSyntax},
+		{in: "# deny\n", err: strconv.ErrSyntax},
+		{in: "mount", err: strconv.ErrSyntax},
+		{in: "deny clone:ns io_uring_setup", out: "deny clone:ns io_uring_setup"},
+		{in: "allow clone:ns", err: strconv.ErrSyntax},
+	} {
+		p, err := ParseProfile(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ParseProfile(%q): %v != %v", tt.in, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if p.String() != tt.out {
+			t.Errorf("ParseProfile(%q): %q != %q", tt.in, p.String(), tt.out)
+		}
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	d := t.TempDir()
+	if err := os.WriteFile(filepath.Join(d, "nomount"), []byte("deny mount\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		dir  string
+		name string
+		out  string
+		err  error
+	}{
+		{dir: "", name: "", out: ""},
+		{dir: "", name: DefaultProfile, out: defaultProfile.String()},
+		{dir: d, name: "nomount", out: "deny mount"},
+		{dir: "", name: "nomount", err: os.ErrNotExist},
+		{dir: d, name: "missing", err: os.ErrNotExist},
+		{dir: d, name: "../nomount", err: os.ErrInvalid},
+		{dir: d, name: "..", err: os.ErrInvalid},
+	} {
+		p, err := LoadProfile(tt.dir, tt.name)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("LoadProfile(%q, %q): %v != %v", tt.dir, tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if p.String() != tt.out {
+			t.Errorf("LoadProfile(%q, %q): %q != %q", tt.dir, tt.name, p.String(), tt.out)
+		}
+	}
+}
@@ -16,7 +16,29 @@
 // if needed. If LC_GLENDA_CPU_FSTAB or CPU_FSTAB is set, it
 // is assumed to be a string in fstab(5) format and Run will mount the
 // specified file systems. *CPU_FSTAB is most often used for virtiofs
-// mounts from virtual machines.
+// mounts from virtual machines. The 9p mount uses extended attributes
+// only if the client says, in CPU_XATTRS, that it serves them, and
+// has the dfltuid and dfltgid the client gives in CPU_DFLTID. If the
+// client caches, it says, in CPU_CACHE, what cache= option the 9p
+// mount can have, e.g. loose. The client may serve several 9p
+// connections, as it says in CPU_CHANNELS: the first is mounted on
+// /tmp/cpu, the others on /tmp/cpu.1 and so on. Each is mounted with
+// the msize the client asks for in CPU_MSIZE, or 64K.
+//
+// If the client requests namespaces in CPU_NAMESPACES, and cpud allows
+// them, Run starts the command in new PID, network, UTS, IPC or user
+// namespaces, under a small init: the session executable re-executed
+// with NSInitArg. See Namespaces.
+//
+// cpud can also confine the command with a Policy: a reduced capability
+// bounding set, no_new_privs and a seccomp profile. The policy is applied
+// by the session executable re-executed with ConfineArg, which then execs
+// the command. If cpud sets CPUD_USER, the policy also runs the command
+// as that local account, with its groups, HOME, USER, LOGNAME and SHELL.
+//
+// If cpud sets CPUD_ROOTLESS, the session is in a user namespace, and
+// mounts the 9p namespace with FUSE, served by the session itself; see
+// package fuse9p. The command keeps no capabilities.
 //
 // For the moment, servers only call Run(), which
 // does all namespace, tty, and process startup. Run returns when the
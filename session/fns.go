@@ -5,6 +5,7 @@
 package session
 
 import (
+	"errors"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -16,7 +17,15 @@ func verbose(f string, a ...interface{}) {
 	v("session:"+f, a...)
 }
 
+// RunCmd runs a command, passing on SIGTERM and SIGINT.
 func RunCmd(c *exec.Cmd) error {
+	return runCmd(c, nil)
+}
+
+// runCmd runs a command, passing on SIGTERM and SIGINT.
+// If started is not nil, it is called once the command has
+// started; if it fails, the command is killed.
+func runCmd(c *exec.Cmd, started func() error) error {
 	sigChan := make(chan os.Signal, 1)
 	defer close(sigChan)
 	signal.Notify(sigChan, unix.SIGTERM, unix.SIGINT)
@@ -24,7 +33,18 @@ func RunCmd(c *exec.Cmd) error {
 	errChan := make(chan error, 1)
 	defer close(errChan)
 	go func() {
-		errChan <- c.Run()
+		if err := c.Start(); err != nil {
+			errChan <- err
+			return
+		}
+		if started != nil {
+			if err := started(); err != nil {
+				c.Process.Kill() //nolint
+				errChan <- errors.Join(err, c.Wait())
+				return
+			}
+		}
+		errChan <- c.Wait()
 	}()
 	var err error
 loop:
@@ -0,0 +1,208 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Namespaces are the namespaces, beyond the private mount namespace
+// every session has, in which a session runs its command.
+//
+// Namespaces are written as a comma-separated list, e.g.
+//
+//	pid,net,uts,ipc,user,veth,bridge=br0,usermap=0:100000:65536
+//
+// The command is started by a minimal init, which mounts /proc in a
+// pid namespace, brings up the interfaces in a network namespace,
+// and reaps orphans.
+type Namespaces struct {
+	PID  bool
+	Net  bool
+	UTS  bool
+	IPC  bool
+	User bool
+	// Veth requests a veth pair for the network namespace. Without it
+	// the network namespace only has a loopback interface.
+	// It requires a Bridge to attach the host end to.
+	Veth   bool
+	Bridge string
+	// UserMap is the uid and gid map for a user namespace, in the
+	// form inside:outside:count. If it is empty, root in the user
+	// namespace is mapped to the uid and gid of the session.
+	UserMap string
+}
+
+// ParseNamespaces parses a namespace string, as described in Namespaces.
+func ParseNamespaces(s string) (*Namespaces, error) {
+	n := &Namespaces{}
+	if len(s) == 0 {
+		return n, nil
+	}
+	for _, ns := range strings.Split(s, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(ns), "=")
+		switch k {
+		case "pid":
+			n.PID = true
+		case "net":
+			n.Net = true
+		case "uts":
+			n.UTS = true
+		case "ipc":
+			n.IPC = true
+		case "user":
+			n.User = true
+		case "veth":
+			n.Net, n.Veth = true, true
+		case "bridge":
+			n.Bridge = v
+		case "usermap":
+			if _, err := parseIDMap(v); err != nil {
+				return nil, err
+			}
+			n.UserMap = v
+		default:
+			return nil, fmt.Errorf("namespace %q: unknown:%w", ns, strconv.ErrSyntax)
+		}
+	}
+	return n, nil
+}
+
+// idMap is one id mapping for a user namespace.
+type idMap struct {
+	inside, outside, count int
+}
+
+func parseIDMap(s string) (*idMap, error) {
+	f := strings.Split(s, ":")
+	if len(f) != 3 {
+		return nil, fmt.Errorf("usermap %q: not inside:outside:count:%w", s, strconv.ErrSyntax)
+	}
+	var v [3]int
+	for i := range f {
+		n, err := strconv.ParseUint(f[i], 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("usermap %q: %w", s, err)
+		}
+		v[i] = int(n)
+	}
+	if v[2] == 0 {
+		return nil, fmt.Errorf("usermap %q: count is 0:%w", s, strconv.ErrRange)
+	}
+	return &idMap{inside: v[0], outside: v[1], count: v[2]}, nil
+}
+
+// Any returns true if any namespace is requested.
+func (n *Namespaces) Any() bool {
+	return n != nil && (n.PID || n.Net || n.UTS || n.IPC || n.User)
+}
+
+// run runs c in the namespaces, under an init which is the session
+// executable, re-executed, and returns the error of the init.
+func (n *Namespaces) run(c *exec.Cmd) error {
+	init, w, err := n.command(c)
+	if err != nil {
+		return err
+	}
+	return runCmd(init, func() error { return n.setup(init, w) })
+}
+
+// String implements fmt.Stringer. The output can be parsed by
+// ParseNamespaces.
+func (n *Namespaces) String() string {
+	if n == nil {
+		return ""
+	}
+	var s []string
+	for _, ns := range []struct {
+		name string
+		on   bool
+	}{
+		{"pid", n.PID},
+		{"net", n.Net && !n.Veth},
+		{"veth", n.Veth},
+		{"uts", n.UTS},
+		{"ipc", n.IPC},
+		{"user", n.User},
+	} {
+		if ns.on {
+			s = append(s, ns.name)
+		}
+	}
+	if len(n.Bridge) > 0 {
+		s = append(s, "bridge="+n.Bridge)
+	}
+	if len(n.UserMap) > 0 {
+		s = append(s, "usermap="+n.UserMap)
+	}
+	return strings.Join(s, ",")
+}
+
+// Allow returns the namespaces for a session, given a request and a
+// policy. The required namespaces are always included; a requested
+// namespace which is neither required nor allowed is an error.
+// The bridge and user map always come from the policy.
+func (n *Namespaces) Allow(required, allowed *Namespaces) (*Namespaces, error) {
+	var r, req, rq, al Namespaces
+	if n != nil {
+		req = *n
+	}
+	if required != nil {
+		rq = *required
+	}
+	if allowed != nil {
+		al = *allowed
+	}
+	for _, ns := range []struct {
+		name        string
+		out         *bool
+		req, rq, al bool
+	}{
+		{name: "pid", out: &r.PID, req: req.PID, rq: rq.PID, al: al.PID},
+		{name: "net", out: &r.Net, req: req.Net, rq: rq.Net, al: al.Net},
+		{name: "veth", out: &r.Veth, req: req.Veth, rq: rq.Veth, al: al.Veth},
+		{name: "uts", out: &r.UTS, req: req.UTS, rq: rq.UTS, al: al.UTS},
+		{name: "ipc", out: &r.IPC, req: req.IPC, rq: rq.IPC, al: al.IPC},
+		{name: "user", out: &r.User, req: req.User, rq: rq.User, al: al.User},
+	} {
+		if ns.req && !ns.rq && !ns.al {
+			return nil, fmt.Errorf("%s namespace is not allowed by cpud", ns.name)
+		}
+		*ns.out = ns.req || ns.rq
+	}
+	r.Net = r.Net || r.Veth
+	r.Bridge, r.UserMap = rq.Bridge, rq.UserMap
+	if len(r.Bridge) == 0 {
+		r.Bridge = al.Bridge
+	}
+	if len(r.UserMap) == 0 {
+		r.UserMap = al.UserMap
+	}
+	if r.Veth && len(r.Bridge) == 0 {
+		return nil, fmt.Errorf("veth requested, but cpud has no bridge for it")
+	}
+	return &r, nil
+}
+
+// sessionNamespaces returns the namespaces for the session command.
+// The request is in CPU_NAMESPACES, and the required and allowed
+// namespaces, set by cpud, in CPUD_NAMESPACES_REQUIRED and
+// CPUD_NAMESPACES_ALLOWED. None of them are passed on to the command.
+func sessionNamespaces() (*Namespaces, error) {
+	var ns [3]*Namespaces
+	for i, e := range []string{"CPU_NAMESPACES", "CPUD_NAMESPACES_REQUIRED", "CPUD_NAMESPACES_ALLOWED"} {
+		n, err := ParseNamespaces(os.Getenv(e))
+		os.Unsetenv(e)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e, err)
+		}
+		ns[i] = n
+	}
+	return ns[0].Allow(ns[1], ns[2])
+}
@@ -0,0 +1,216 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// NSInitArg is the first argument to the session executable, usually
+// cpud, when it is to run as the init of a set of namespaces.
+// Executables that use Run with Namespaces must call NSInit when
+// they see it, e.g.
+//
+//	if len(os.Args) > 1 && os.Args[1] == session.NSInitArg {
+//		os.Exit(session.NSInit(os.Args[2:]))
+//	}
+const NSInitArg = "-nsinit"
+
+func (n *Namespaces) cloneflags() uintptr {
+	// The init mounts /proc, which we do not want to leak into
+	// the session namespace, so it always gets a mount namespace too.
+	f := uintptr(syscall.CLONE_NEWNS)
+	for _, ns := range []struct {
+		on   bool
+		flag uintptr
+	}{
+		{n.PID, syscall.CLONE_NEWPID},
+		{n.Net, syscall.CLONE_NEWNET},
+		{n.UTS, syscall.CLONE_NEWUTS},
+		{n.IPC, syscall.CLONE_NEWIPC},
+		{n.User, syscall.CLONE_NEWUSER},
+	} {
+		if ns.on {
+			f |= ns.flag
+		}
+	}
+	return f
+}
+
+// self is the session executable. Its path may be hidden by the
+// mounts of the session, e.g. a tmpfs on /tmp; /proc/self/exe is not.
+const self = "/proc/self/exe"
+
+// command wraps c so it is started by an init in the namespaces.
+// The init waits to start c until the returned *os.File, the write
+// side of a pipe, is closed; this allows the session to finish setting
+// up the namespaces, e.g. to add a veth, once the init is started.
+func (n *Namespaces) command(c *exec.Cmd) (*exec.Cmd, *os.File, error) {
+	if c.Err != nil {
+		return nil, nil, c.Err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	init := exec.Command(self, append([]string{NSInitArg, n.String(), "--", c.Path}, c.Args[1:]...)...)
+	init.Stdin, init.Stdout, init.Stderr, init.Dir, init.Env = c.Stdin, c.Stdout, c.Stderr, c.Dir, c.Env
+	init.ExtraFiles = []*os.File{r}
+	init.SysProcAttr = &syscall.SysProcAttr{Cloneflags: n.cloneflags()}
+	if n.User {
+		m := &idMap{inside: 0, outside: os.Geteuid(), count: 1}
+		g := &idMap{inside: 0, outside: os.Getegid(), count: 1}
+		if len(n.UserMap) > 0 {
+			if m, err = parseIDMap(n.UserMap); err != nil {
+				return nil, nil, err
+			}
+			g = m
+		}
+		init.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: m.inside, HostID: m.outside, Size: m.count}}
+		init.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: g.inside, HostID: g.outside, Size: g.count}}
+	}
+	return init, w, nil
+}
+
+// veth adds a veth pair, with one end attached to the bridge, and
+// the other in the network namespace of pid.
+func (n *Namespaces) veth(pid int) error {
+	br, err := netlink.LinkByName(n.Bridge)
+	if err != nil {
+		return fmt.Errorf("bridge %q: %w", n.Bridge, err)
+	}
+	la := netlink.NewLinkAttrs()
+	la.Name = fmt.Sprintf("cpu%d", pid)
+	la.MasterIndex = br.Attrs().Index
+	v := &netlink.Veth{LinkAttrs: la, PeerName: fmt.Sprintf("cpuc%d", pid)}
+	if err := netlink.LinkAdd(v); err != nil {
+		return fmt.Errorf("adding veth %q: %w", la.Name, err)
+	}
+	peer, err := netlink.LinkByName(v.PeerName)
+	if err == nil {
+		err = netlink.LinkSetNsPid(peer, pid)
+	}
+	if err == nil {
+		err = netlink.LinkSetUp(v)
+	}
+	if err != nil {
+		// When the namespace goes away, so does the veth;
+		// until then, we need to remove it.
+		netlink.LinkDel(v) //nolint
+		return fmt.Errorf("veth %q: %w", la.Name, err)
+	}
+	verbose("veth %q on %q, peer %q in pid %d", la.Name, n.Bridge, v.PeerName, pid)
+	return nil
+}
+
+// setup completes setting up the namespaces of init, once it has started.
+func (n *Namespaces) setup(init *exec.Cmd, w *os.File) error {
+	defer w.Close()
+	if n.Veth {
+		if err := n.veth(init.Process.Pid); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte("ok"))
+	return err
+}
+
+// ifup brings up all the network interfaces.
+func ifup() error {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	var errs error
+	for _, i := range ifs {
+		ifr, err := unix.NewIfreq(i.Name)
+		if err == nil {
+			err = unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr)
+		}
+		if err == nil {
+			ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+			err = unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+		}
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("%s up: %w", i.Name, err))
+		}
+	}
+	return errs
+}
+
+// NSInit is the init of a session's namespaces. args are the
+// namespaces, as in ParseNamespaces, followed by "--" and the
+// command to run.
+// It returns the exit status of the command.
+func NSInit(args []string) int {
+	if len(args) < 3 || args[1] != "--" {
+		log.Printf("CPUD(nsinit): usage: %s namespaces -- command [args]", NSInitArg)
+		return 1
+	}
+	n, err := ParseNamespaces(args[0])
+	if err != nil {
+		log.Printf("CPUD(nsinit): %v", err)
+		return 1
+	}
+	// Wait for the session to finish its part of the setup.
+	sync := os.NewFile(3, "sync")
+	if b, err := io.ReadAll(sync); err != nil || string(b) != "ok" {
+		log.Printf("CPUD(nsinit): namespace setup failed (%q, %v)", b, err)
+		return 1
+	}
+	sync.Close()
+
+	if n.PID {
+		if err := unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+			log.Printf("CPUD(nsinit): mounting /proc: %v", err)
+		}
+	}
+	if n.Net {
+		if err := ifup(); err != nil {
+			log.Printf("CPUD(nsinit): %v", err)
+		}
+	}
+
+	c := exec.Command(args[2], args[3:]...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Start(); err != nil {
+		log.Printf("CPUD(nsinit): %v", err)
+		return 1
+	}
+
+	// As pid 1, we only get the signals we ask for; pass them on.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, unix.SIGTERM, unix.SIGINT, unix.SIGHUP)
+	go func() {
+		for sig := range sigs {
+			if err := c.Process.Signal(sig); err != nil {
+				verbose("nsinit: sending %v to %d: %v", sig, c.Process.Pid, err)
+			}
+		}
+	}()
+
+	s := Reap(c.Process.Pid)
+	verbose("nsinit: %q exits with %v", c.Args, s)
+	if s.Signaled() {
+		return 128 + int(s.Signal())
+	}
+	return s.ExitStatus()
+}
@@ -0,0 +1,89 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+// cloneNSArg is the first argument to the test binary when it is to
+// start a command in a new mount namespace.
+const cloneNSArg = "-clonens"
+
+// The namespace init and confine helper are the test binary, re-executed.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == NSInitArg {
+		os.Exit(NSInit(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == ConfineArg {
+		os.Exit(Confine(os.Args[2:]))
+	}
+	// The test binary can also start a command in a new mount
+	// namespace, with clone, to see if it is allowed.
+	if len(os.Args) > 1 && os.Args[1] == cloneNSArg {
+		c := exec.Command("/bin/true")
+		c.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS}
+		if err := c.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "clone(CLONE_NEWNS): %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestNSInit(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skipf("Skipping as we are not root")
+	}
+	n, err := ParseNamespaces("pid,uts,ipc,net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	c := exec.Command("/bin/sh", "-c", "echo $PPID; hostname cpu; hostname; exit 3")
+	c.Stdout, c.Stderr = &out, &out
+	init, w, err := n.command(c)
+	if err != nil {
+		t.Fatalf("command: %v != nil", err)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runCmd(init, func() error { return n.setup(init, w) })
+	if init.ProcessState == nil || init.ProcessState.ExitCode() != 3 {
+		t.Fatalf("runCmd: %v, %v, want exit status 3; output %q", err, init.ProcessState, out.String())
+	}
+	if out.String() != "1\ncpu\n" {
+		t.Errorf("output: %q != %q", out.String(), "1\ncpu\n")
+	}
+	if h, _ := os.Hostname(); h != host {
+		t.Errorf("hostname: %q != %q", h, host)
+	}
+}
+
+func TestNSRunFails(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skipf("Skipping as we are not root")
+	}
+	n, err := ParseNamespaces("uts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*exec.Cmd{
+		exec.Command("/bin/sh", "-c", "exit 3"),
+		exec.Command("/no/such/command"),
+	} {
+		if err := n.run(c); err == nil {
+			t.Errorf("run %v: nil != an error", c)
+		}
+	}
+}
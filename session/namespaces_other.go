@@ -0,0 +1,31 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package session
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// NSInitArg is the first argument to the session executable
+// when it is to run as the init of a set of namespaces.
+const NSInitArg = "-nsinit"
+
+func (n *Namespaces) command(c *exec.Cmd) (*exec.Cmd, *os.File, error) {
+	return nil, nil, fmt.Errorf("namespaces %q: only supported on Linux:%w", n, os.ErrInvalid)
+}
+
+func (n *Namespaces) setup(init *exec.Cmd, w *os.File) error {
+	return nil
+}
+
+// NSInit is the init of a session's namespaces.
+// Namespaces are not supported on this kernel.
+func NSInit(args []string) int {
+	return 1
+}
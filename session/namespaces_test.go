@@ -0,0 +1,83 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestParseNamespaces(t *testing.T) {
+	for _, tt := range []struct {
+		in  string
+		out string
+		err error
+	}{
+		{in: "", out: ""},
+		{in: "pid", out: "pid"},
+		{in: "ipc,uts,net,pid", out: "pid,net,uts,ipc"},
+		{in: "veth,bridge=br0", out: "veth,bridge=br0"},
+		{in: "net,veth", out: "veth"},
+		{in: "user,usermap=0:100000:65536", out: "user,usermap=0:100000:65536"},
+		{in: "mnt", err: strconv.ErrSyntax},
+		{in: "usermap=0:1", err: strconv.ErrSyntax},
+		{in: "usermap=0:1:0", err: strconv.ErrRange},
+		{in: "usermap=a:1:1", err: strconv.ErrSyntax},
+	} {
+		n, err := ParseNamespaces(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ParseNamespaces(%q): %v != %v", tt.in, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if n.String() != tt.out {
+			t.Errorf("ParseNamespaces(%q): %q != %q", tt.in, n.String(), tt.out)
+		}
+	}
+}
+
+func TestAllowNamespaces(t *testing.T) {
+	for _, tt := range []struct {
+		req      string
+		required string
+		allowed  string
+		out      string
+		ok       bool
+	}{
+		{req: "", required: "", allowed: "", out: "", ok: true},
+		{req: "pid", required: "", allowed: "", ok: false},
+		{req: "pid", required: "", allowed: "pid,net", out: "pid", ok: true},
+		{req: "pid", required: "pid", allowed: "", out: "pid", ok: true},
+		{req: "", required: "uts,ipc", allowed: "pid", out: "uts,ipc", ok: true},
+		{req: "pid,user", required: "", allowed: "pid,net", ok: false},
+		{req: "net", required: "", allowed: "veth,bridge=br0", out: "net,bridge=br0", ok: true},
+		{req: "veth", required: "", allowed: "veth", ok: false},
+		{req: "veth", required: "", allowed: "veth,bridge=br0", out: "veth,bridge=br0", ok: true},
+		{req: "user,usermap=0:0:1", required: "", allowed: "user,usermap=0:100000:65536", out: "user,usermap=0:100000:65536", ok: true},
+	} {
+		var ns [3]*Namespaces
+		for i, s := range []string{tt.req, tt.required, tt.allowed} {
+			n, err := ParseNamespaces(s)
+			if err != nil {
+				t.Fatalf("ParseNamespaces(%q): %v != nil", s, err)
+			}
+			ns[i] = n
+		}
+		n, err := ns[0].Allow(ns[1], ns[2])
+		if (err == nil) != tt.ok {
+			t.Errorf("%q.Allow(%q, %q): %v, want ok %v", tt.req, tt.required, tt.allowed, err, tt.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if n.String() != tt.out {
+			t.Errorf("%q.Allow(%q, %q): %q != %q", tt.req, tt.required, tt.allowed, n.String(), tt.out)
+		}
+	}
+}
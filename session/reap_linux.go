@@ -0,0 +1,54 @@
+// Copyright 2018-2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"errors"
+	"log"
+	"syscall"
+	"time"
+)
+
+// Reap waits for orphans. It must be run by a process that will
+// inherit orphans, i.e. pid 1 of a pid namespace.
+//
+// If pid is greater than 0, Reap returns the wait status of pid
+// once it exits. Otherwise, since there is no way of knowing when
+// we are done for good, our work here is never done, and Reap never
+// returns.
+//
+// A complication is that for long periods of time, there
+// may be no orphans. In that case, sleep for one second,
+// and try again. This background load is hardly enough
+// to matter. And, in general, it will happen by definition
+// when there is nothing to wait for, i.e. there is nothing
+// on the node to be upset about.
+// Were this ever to be a concern, an option is to kick off
+// a process that will never exit, such that wait4 will always
+// block and always return when any child process exits.
+func Reap(pid int) syscall.WaitStatus {
+	var numReaped int
+	for {
+		var (
+			s syscall.WaitStatus
+			r syscall.Rusage
+		)
+		p, err := syscall.Wait4(-1, &s, 0, &r)
+		if errors.Is(err, syscall.ECHILD) {
+			verbose("Nothing to wait for, %d waited for so far", numReaped)
+			time.Sleep(time.Second)
+			continue
+		}
+		verbose("orphan reaper: returns with %v", p)
+		if err != nil {
+			log.Printf("CPUD: a process exited with %v, status %v, rusage %v, err %v", p, s, r, err)
+			continue
+		}
+		numReaped++
+		if pid > 0 && p == pid {
+			return s
+		}
+	}
+}
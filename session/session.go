@@ -15,6 +15,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/u-root/cpu/mount"
 	"github.com/u-root/u-root/pkg/termios"
@@ -43,6 +45,20 @@ type Session struct {
 	port9p string
 	cmd    string
 	args   []string
+	// rootless is set when cpud runs the session in a user
+	// namespace, where mounts are restricted.
+	rootless bool
+	// xattrs is set when the client serves extended attributes,
+	// and the 9P mount can use them.
+	xattrs bool
+	// dfltid is the dfltuid and dfltgid options of the 9P mount,
+	// if the client gives them.
+	dfltid string
+	// cache is the cache option of the 9P mount, if the client
+	// caches.
+	cache string
+	// channels is how many 9P connections the client serves.
+	channels int
 }
 
 var (
@@ -122,7 +138,7 @@ func (s *Session) TmpMounts() error {
 		}
 	}
 
-	if err := osMounts(); err != nil {
+	if err := osMounts(s.rootless); err != nil {
 		log.Println(err)
 	}
 	return nil
@@ -139,6 +155,12 @@ func (s *Session) TmpMounts() error {
 func (s *Session) Run() error {
 	var errs error
 
+	s.rootless = sessionRootless()
+	s.xattrs = sessionXattrs()
+	s.dfltid = sessionDfltID()
+	s.cache = sessionCache()
+	s.channels = sessionChannels()
+	s.msize = sessionMsize(s.msize)
 	if err := runSetup(); err != nil {
 		return err
 	}
@@ -210,6 +232,35 @@ func (s *Session) Run() error {
 	os.Unsetenv("CPU_FSTAB")
 	os.Unsetenv("LC_GLENDA_CPU_FSTAB")
 
+	// The client requests namespaces in CPU_NAMESPACES; cpud sets
+	// the policy for them.
+	ns, err := sessionNamespaces()
+	if err != nil {
+		return errors.Join(errs, err)
+	}
+	// cpud also sets the capabilities, no_new_privs and seccomp
+	// profile for the command.
+	policy, err := sessionPolicy()
+	if err != nil {
+		return errors.Join(errs, err)
+	}
+	// A rootless session keeps the capabilities cpud gave it in
+	// its user namespace, to mount; the command must not.
+	if s.rootless && policy.Caps == nil {
+		policy.Caps = []string{}
+	}
+	// cpud can map the session to a local account, which the
+	// command runs as, with its environment.
+	if policy.User, err = sessionUser(); err != nil {
+		return errors.Join(errs, err)
+	}
+	if policy.User != nil {
+		for _, e := range policy.User.env() {
+			k, v, _ := strings.Cut(e, "=")
+			os.Setenv(k, v)
+		}
+	}
+
 	c := exec.Command(s.cmd, s.args...)
 	c.Stdin, c.Stdout, c.Stderr, c.Dir = s.Stdin, s.Stdout, s.Stderr, os.Getenv("PWD")
 	dirInfo, err := os.Stat(c.Dir)
@@ -217,7 +268,18 @@ func (s *Session) Run() error {
 		log.Printf("CPUD: your $PWD %q is not in the remote namespace", c.Dir)
 		return os.ErrNotExist
 	}
-	err = RunCmd(c)
+	if policy.Any() {
+		verbose("runRemote: policy caps %q, user %v, no_new_privs %v, seccomp %q, %q", policy.Caps, policy.User, policy.NoNewPrivs, policy.Seccomp, policy.ScopeSeccomp)
+		if c, err = policy.command(c); err != nil {
+			return errors.Join(errs, err)
+		}
+	}
+	if ns.Any() {
+		verbose("runRemote: namespaces %q", ns)
+		err = ns.run(c)
+	} else {
+		err = RunCmd(c)
+	}
 	verbose("Run %v returns %v", c, err)
 	if err != nil {
 		if s.fail && len(wtf) != 0 {
@@ -233,6 +295,109 @@ func (s *Session) Run() error {
 	return err
 }
 
+// sessionRootless returns true if cpud runs the session in a user
+// namespace, as set in CPUD_ROOTLESS, which is not passed on to the
+// command.
+func sessionRootless() bool {
+	r := os.Getenv("CPUD_ROOTLESS") == "1"
+	os.Unsetenv("CPUD_ROOTLESS")
+	return r
+}
+
+// sessionXattrs returns true if the client serves extended attributes,
+// as it says in CPU_XATTRS, which is not passed on to the command.
+func sessionXattrs() bool {
+	x := os.Getenv("CPU_XATTRS")
+	os.Unsetenv("CPU_XATTRS")
+	return len(x) > 0 && x != "none"
+}
+
+// sessionDfltID returns the dfltuid and dfltgid options of the 9P
+// mount, from the ids the client says, in CPU_DFLTID, the remote sees
+// its user and group as; CPU_DFLTID is not passed on to the command.
+func sessionDfltID() string {
+	x := os.Getenv("CPU_DFLTID")
+	os.Unsetenv("CPU_DFLTID")
+	u, g, ok := strings.Cut(x, ":")
+	if !ok {
+		return ""
+	}
+	uid, err := strconv.ParseUint(u, 10, 32)
+	if err != nil {
+		verbose("CPU_DFLTID %q:%v", x, err)
+		return ""
+	}
+	gid, err := strconv.ParseUint(g, 10, 32)
+	if err != nil {
+		verbose("CPU_DFLTID %q:%v", x, err)
+		return ""
+	}
+	return fmt.Sprintf("dfltuid=%d,dfltgid=%d", uid, gid)
+}
+
+// sessionCache returns the cache option of the 9P mount, from the
+// cache mode the client says, in CPU_CACHE, it can be mounted with;
+// CPU_CACHE is not passed on to the command.
+func sessionCache() string {
+	x := os.Getenv("CPU_CACHE")
+	os.Unsetenv("CPU_CACHE")
+	switch x {
+	case "":
+		return ""
+	case "loose", "fscache", "mmap":
+		return "cache=" + x
+	}
+	verbose("CPU_CACHE %q is not loose, fscache or mmap; not caching", x)
+	return ""
+}
+
+// maxChannels is the most 9P connections a session mounts.
+const maxChannels = 16
+
+// channelMount returns where 9P connection n is mounted: the first on
+// /tmp/cpu, as ever, and the others on /tmp/cpu.n, as the client
+// stripes the binds of the namespace over them.
+func channelMount(n int) string {
+	if n == 0 {
+		return filepath.Join(os.TempDir(), "cpu")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("cpu.%d", n))
+}
+
+// sessionChannels returns how many 9P connections the client serves,
+// as it says in CPU_CHANNELS, which is not passed on to the command;
+// by default, one.
+func sessionChannels() int {
+	x := os.Getenv("CPU_CHANNELS")
+	os.Unsetenv("CPU_CHANNELS")
+	if x == "" {
+		return 1
+	}
+	n, err := strconv.Atoi(x)
+	if err != nil || n < 1 || n > maxChannels {
+		verbose("CPU_CHANNELS %q is not 1 to %d; using 1", x, maxChannels)
+		return 1
+	}
+	return n
+}
+
+// sessionMsize returns the msize the client asks for, in CPU_MSIZE,
+// which is not passed on to the command, or, if it does not, def.
+// The kernel takes at most 1M for a 9P mount on a file descriptor.
+func sessionMsize(def int) int {
+	x := os.Getenv("CPU_MSIZE")
+	os.Unsetenv("CPU_MSIZE")
+	if x == "" {
+		return def
+	}
+	m, err := strconv.Atoi(x)
+	if err != nil || m < 4096 || m > 1024*1024 {
+		verbose("CPU_MSIZE %q is not 4096 to 1048576; using %d", x, def)
+		return def
+	}
+	return m
+}
+
 // New returns a New session with defaults set. It requires a port for
 // 9p (which can be the empty string, but is usually not) and a
 // command name.
@@ -258,6 +423,9 @@ func New(port9p, cmd string, args ...string) *Session {
 func (s *Session) NameSpace() error {
 	var errs error
 
+	s.rootless = sessionRootless()
+	s.channels = sessionChannels()
+	s.msize = sessionMsize(s.msize)
 	if err := runSetup(); err != nil {
 		return err
 	}
@@ -9,7 +9,7 @@ func (s *Session) Namespace() error {
 	return nil
 }
 
-func osMounts() error {
+func osMounts(bool) error {
 	return nil
 }
 
@@ -7,11 +7,15 @@ package session
 import (
 	"errors"
 	"fmt"
+	"log"
 	"net"
 	"os"
 	"path/filepath"
+	"runtime"
 	"syscall"
 
+	"github.com/hugelgupf/p9/p9"
+	"github.com/u-root/cpu/fuse9p"
 	"golang.org/x/sys/unix"
 )
 
@@ -56,6 +60,26 @@ func (s *Session) Namespace() error {
 	}
 	os.Unsetenv("CPUNONCE")
 
+	// Each channel is a connection of its own, mounted in its own
+	// place; the binds of the namespace are striped over them.
+	for i := 0; i < max(s.channels, 1); i++ {
+		t := channelMount(i)
+		if err := os.MkdirAll(t, 0o755); err != nil {
+			return err
+		}
+		if err := s.mount9P(nonce, t); err != nil {
+			return err
+		}
+	}
+	// Zero it. I realize I am not a crypto person.
+	// improvements welcome.
+	copy([]byte(nonce), make([]byte, len(nonce)))
+	return nil
+}
+
+// mount9P connects to the 9P server of the client, gives it the
+// nonce, and mounts it on mountTarget.
+func (s *Session) mount9P(nonce, mountTarget string) error {
 	// Connect to the socket, return the nonce.
 	var (
 		so net.Conn
@@ -88,9 +112,12 @@ func (s *Session) Namespace() error {
 		return fmt.Errorf("CPUD:Write nonce: %v", err)
 	}
 	verbose("Wrote the nonce")
-	// Zero it. I realize I am not a crypto person.
-	// improvements welcome.
-	copy([]byte(nonce), make([]byte, len(nonce)))
+
+	// A rootless session can not mount 9P, only FUSE; it serves
+	// the namespace itself, for as long as the session lasts.
+	if s.rootless {
+		return s.fuseMount(so, mountTarget)
+	}
 
 	// the kernel takes over the socket after the Mount.
 	defer so.Close()
@@ -111,11 +138,21 @@ func (s *Session) Namespace() error {
 	// The debug= option is here so you can see how to temporarily set it if needed.
 	// It generates copious output so use it sparingly.
 	// A useful compromise value is 5.
-	opts := fmt.Sprintf("version=9p2000.L,noxattr,trans=fd,rfdno=%d,wfdno=%d,uname=%v,debug=0,msize=%d", fd, fd, user, s.msize)
+	opts := fmt.Sprintf("version=9p2000.L,trans=fd,rfdno=%d,wfdno=%d,uname=%v,debug=0,msize=%d", fd, fd, user, s.msize)
+	// Unless the client serves extended attributes, the kernel
+	// need not ask for them.
+	if !s.xattrs {
+		opts += ",noxattr"
+	}
+	if len(s.dfltid) > 0 {
+		opts += "," + s.dfltid
+	}
+	if len(s.cache) > 0 {
+		opts += "," + s.cache
+	}
 	if len(s.mopts) > 0 {
 		opts += "," + s.mopts
 	}
-	mountTarget := filepath.Join(os.TempDir(), "cpu")
 	verbose("mount 127.0.0.1 on %s 9p %#x %s", mountTarget, flags, opts)
 	if err := unix.Mount("localhost", mountTarget, "9p", flags, opts); err != nil {
 		return fmt.Errorf("9p mount %v", err)
@@ -125,11 +162,52 @@ func (s *Session) Namespace() error {
 	return nil
 }
 
-func osMounts() error {
+// fuseMount mounts the 9P namespace served on so on mountTarget, with
+// FUSE.
+func (s *Session) fuseMount(so net.Conn, mountTarget string) error {
+	c, err := p9.NewClient(so, p9.WithMessageSize(uint32(s.msize)))
+	if err != nil {
+		so.Close()
+		return fmt.Errorf("CPUD:9p client: %w", err)
+	}
+	root, err := c.Attach("/")
+	if err != nil {
+		c.Close()
+		return fmt.Errorf("CPUD:9p attach: %w", err)
+	}
+	verbose("mount 9p on %s with FUSE", mountTarget)
+	srv, err := fuse9p.Mount(mountTarget, root)
+	if err != nil {
+		c.Close()
+		return err
+	}
+	// The command starts from the mount, and may add files on it
+	// to epoll; either blocks a thread, waiting for Serve, without
+	// letting go of its P. Serve must have a P of its own.
+	if runtime.GOMAXPROCS(0) < 2 {
+		runtime.GOMAXPROCS(2)
+	}
+	go func() {
+		if err := srv.Serve(); err != nil {
+			log.Printf("CPUD:%v", err)
+		}
+		c.Close()
+	}()
+	verbose("mount done")
+	return nil
+}
+
+// osMounts binds / onto /tmp/local. In a user namespace, the mounts
+// under / are locked to it, and must be bound with it.
+func osMounts(rootless bool) error {
 	var errs error
 	tmpMnt := os.TempDir()
+	flags := uintptr(syscall.MS_BIND)
+	if rootless {
+		flags |= syscall.MS_REC
+	}
 	// Further, bind / onto /tmp/local so a non-hacked-on version may be visible.
-	if err := unix.Mount("/", filepath.Join(tmpMnt, "local"), "", syscall.MS_BIND, ""); err != nil {
+	if err := unix.Mount("/", filepath.Join(tmpMnt, "local"), "", flags, ""); err != nil {
 		errs = errors.Join(errs, fmt.Errorf("CPUD:Warning: binding / over %s did not work: %v, continuing anyway", filepath.Join(tmpMnt, "local"), err))
 	}
 	return errs
@@ -19,7 +19,7 @@ func (s *Session) Namespace() error {
 	return fmt.Errorf("CPUD: 9p mounts are only valid on Linux:%w", os.ErrNotExist)
 }
 
-func osMounts() error {
+func osMounts(bool) error {
 	return nil
 }
 
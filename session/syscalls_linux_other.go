@@ -0,0 +1,15 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux && !amd64 && !arm64
+
+package session
+
+// There is no syscall table, and hence no seccomp, on this arch.
+const (
+	auditArch = 0
+	x32Bit    = 0
+)
+
+var syscalls map[string]uintptr
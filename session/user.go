@@ -0,0 +1,134 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// User is the local account a session command runs as.
+type User struct {
+	Name   string
+	UID    int
+	GID    int
+	Groups []int
+	Home   string
+	Shell  string
+}
+
+// passwd is the password file, in which login shells are found.
+var passwd = "/etc/passwd"
+
+// LookupUser looks up a local account by name. Its groups are those
+// initgroups(3) would set: the primary group, and every group the
+// account is a member of.
+func LookupUser(name string) (*User, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return nil, fmt.Errorf("user %q: uid %q: %w", name, u.Uid, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return nil, fmt.Errorf("user %q: gid %q: %w", name, u.Gid, err)
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("user %q: groups: %w", name, err)
+	}
+	groups := []int{gid}
+	for _, id := range ids {
+		g, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("user %q: group %q: %w", name, id, err)
+		}
+		if g != gid {
+			groups = append(groups, g)
+		}
+	}
+	return &User{Name: u.Username, UID: uid, GID: gid, Groups: groups, Home: u.HomeDir, Shell: loginShell(passwd, u.Username)}, nil
+}
+
+// loginShell returns the shell of name in the password file f, or
+// /bin/sh if it has none.
+func loginShell(f, name string) string {
+	if pf, err := os.Open(f); err == nil {
+		defer pf.Close()
+		s := bufio.NewScanner(pf)
+		for s.Scan() {
+			e := strings.Split(s.Text(), ":")
+			if len(e) == 7 && e[0] == name && len(e[6]) > 0 {
+				return e[6]
+			}
+		}
+	}
+	return "/bin/sh"
+}
+
+// env returns HOME, USER, LOGNAME and SHELL for the account.
+func (u *User) env() []string {
+	return []string{"HOME=" + u.Home, "USER=" + u.Name, "LOGNAME=" + u.Name, "SHELL=" + u.Shell}
+}
+
+// ids formats the uid, gid and groups of u as uid:gid:group,group...
+func (u *User) ids() string {
+	g := make([]string, len(u.Groups))
+	for i, id := range u.Groups {
+		g[i] = strconv.Itoa(id)
+	}
+	return fmt.Sprintf("%d:%d:%s", u.UID, u.GID, strings.Join(g, ","))
+}
+
+// parseIDs parses the output of ids.
+func parseIDs(s string) (*User, error) {
+	f := strings.Split(s, ":")
+	if len(f) != 3 {
+		return nil, fmt.Errorf("ids %q: not uid:gid:groups:%w", s, strconv.ErrSyntax)
+	}
+	u := &User{}
+	var err error
+	if u.UID, err = strconv.Atoi(f[0]); err != nil {
+		return nil, fmt.Errorf("ids %q: %w", s, err)
+	}
+	if u.GID, err = strconv.Atoi(f[1]); err != nil {
+		return nil, fmt.Errorf("ids %q: %w", s, err)
+	}
+	u.Groups = []int{}
+	for _, g := range strings.Split(f[2], ",") {
+		if len(g) == 0 {
+			continue
+		}
+		id, err := strconv.Atoi(g)
+		if err != nil {
+			return nil, fmt.Errorf("ids %q: %w", s, err)
+		}
+		u.Groups = append(u.Groups, id)
+	}
+	return u, nil
+}
+
+// sessionUser returns the account the session command runs as, as
+// set by cpud in CPUD_USER, or nil if it runs as the session. It is
+// not passed on to the command.
+func sessionUser() (*User, error) {
+	name := os.Getenv("CPUD_USER")
+	os.Unsetenv("CPUD_USER")
+	if len(name) == 0 {
+		return nil, nil
+	}
+	u, err := LookupUser(name)
+	if err != nil {
+		return nil, fmt.Errorf("CPUD_USER: %w", err)
+	}
+	return u, nil
+}
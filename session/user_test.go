@@ -0,0 +1,81 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestLoginShell(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "passwd")
+	data := "root:x:0:0:root:/root:/bin/bash\nglenda:x:1000:1000::/usr/glenda:/bin/rc\nnone:x:1001:1001::/:\nbad\n"
+	if err := os.WriteFile(f, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name  string
+		shell string
+	}{
+		{name: "root", shell: "/bin/bash"},
+		{name: "glenda", shell: "/bin/rc"},
+		{name: "none", shell: "/bin/sh"},
+		{name: "nobody", shell: "/bin/sh"},
+	} {
+		if s := loginShell(f, tt.name); s != tt.shell {
+			t.Errorf("loginShell(%q): %q != %q", tt.name, s, tt.shell)
+		}
+	}
+	if s := loginShell(filepath.Join(t.TempDir(), "nopasswd"), "root"); s != "/bin/sh" {
+		t.Errorf("loginShell(no file): %q != %q", s, "/bin/sh")
+	}
+}
+
+func TestParseIDs(t *testing.T) {
+	for _, tt := range []struct {
+		in  string
+		u   *User
+		err error
+	}{
+		{in: "0:0:0", u: &User{Groups: []int{0}}},
+		{in: "1000:100:100,27,44", u: &User{UID: 1000, GID: 100, Groups: []int{100, 27, 44}}},
+		{in: "65534:65534:", u: &User{UID: 65534, GID: 65534, Groups: []int{}}},
+		{in: "1000:100", err: strconv.ErrSyntax},
+		{in: "glenda:100:100", err: strconv.ErrSyntax},
+		{in: "1000:100:x", err: strconv.ErrSyntax},
+	} {
+		u, err := parseIDs(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("parseIDs(%q): %v != %v", tt.in, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if u.UID != tt.u.UID || u.GID != tt.u.GID || !slices.Equal(u.Groups, tt.u.Groups) {
+			t.Errorf("parseIDs(%q): %+v != %+v", tt.in, u, tt.u)
+		}
+		if s := u.ids(); s != tt.in {
+			t.Errorf("parseIDs(%q).ids(): %q != %q", tt.in, s, tt.in)
+		}
+	}
+}
+
+func TestLookupUser(t *testing.T) {
+	u, err := LookupUser("root")
+	if err != nil {
+		t.Skipf("Skipping: no root account: %v", err)
+	}
+	if u.UID != 0 || u.GID != 0 || len(u.Groups) == 0 || u.Groups[0] != 0 {
+		t.Errorf("LookupUser(root): %+v, want uid 0, gid 0, and group 0 first", u)
+	}
+	if _, err := LookupUser("no such user, surely"); err == nil {
+		t.Errorf("LookupUser(no such user): nil != an error")
+	}
+}
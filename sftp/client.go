@@ -0,0 +1,555 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// chunk is the size of the reads and writes a client makes; servers
+// commonly allow no more than this, or return less.
+const chunk = 32 * 1024
+
+// window is the most reads or writes a client has outstanding at
+// once, in copying a file.
+const window = 16
+
+// errClosed is returned for requests made once a client is closed.
+var errClosed = fmt.Errorf("sftp client closed:%w", os.ErrClosed)
+
+// StatusError is an error from a server.
+type StatusError struct {
+	Code uint32
+	Msg  string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("sftp: %s (status %d)", e.Msg, e.Code)
+}
+
+// Is makes StatusError match the os errors for its code, e.g.
+// errors.Is(err, os.ErrNotExist).
+func (e *StatusError) Is(target error) bool {
+	switch e.Code {
+	case fxNoSuchFile:
+		return target == os.ErrNotExist
+	case fxPermissionDenied:
+		return target == os.ErrPermission
+	case fxOpUnsupported:
+		return target == errors.ErrUnsupported
+	}
+	return false
+}
+
+// reply is the reply to a request.
+type reply struct {
+	t   byte
+	b   *buffer
+	err error
+}
+
+// Client is an SFTP client, version 3. Its methods can be called
+// concurrently; requests are sent as they are made, and the replies
+// matched to them as they come.
+type Client struct {
+	w io.WriteCloser
+	// wmu is held to write a request. It is not mu, which is
+	// needed to pass on replies, so that a server can reply while a
+	// request is written.
+	wmu        sync.Mutex
+	mu         sync.Mutex
+	next       uint32
+	pending    map[uint32]chan reply
+	err        error
+	extensions map[string]string
+}
+
+// NewClient starts an SFTP client on r and w, e.g. the stdout and stdin
+// of an sftp subsystem session.
+func NewClient(r io.Reader, w io.WriteCloser) (*Client, error) {
+	if err := newPacket(fxpInit).uint32(Version).send(w); err != nil {
+		return nil, err
+	}
+	t, p, err := readPacket(r)
+	if err != nil {
+		return nil, fmt.Errorf("sftp version: %w", err)
+	}
+	b := &buffer{b: p}
+	if v := b.uint32(); t != fxpVersion || b.err != nil || v < Version {
+		return nil, fmt.Errorf("sftp version: type %d, version %d, want %d, %d:%w", t, v, fxpVersion, Version, errBadMessage)
+	}
+	c := &Client{w: w, pending: map[uint32]chan reply{}, extensions: map[string]string{}}
+	for len(b.b) > 0 && b.err == nil {
+		n, v := b.string(), b.string()
+		c.extensions[n] = v
+	}
+	go c.receive(r)
+	return c, nil
+}
+
+// receive passes replies to the requests that wait for them, until r
+// fails, when all requests fail.
+func (c *Client) receive(r io.Reader) {
+	var err error
+	for {
+		var t byte
+		var p []byte
+		if t, p, err = readPacket(r); err != nil {
+			break
+		}
+		b := &buffer{b: p}
+		id := b.uint32()
+		if b.err != nil {
+			err = b.err
+			break
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ok {
+			ch <- reply{t: t, b: b}
+		}
+	}
+	if err == io.EOF {
+		err = errClosed
+	}
+	c.mu.Lock()
+	c.err = err
+	for id, ch := range c.pending {
+		ch <- reply{err: err}
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+}
+
+// request sends a request, made by f from its ID, and returns a channel
+// on which its reply is sent.
+func (c *Client) request(t byte, f func(packet) packet) <-chan reply {
+	ch := make(chan reply, 1)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		ch <- reply{err: c.err}
+		return ch
+	}
+	c.next++
+	id := c.next
+	c.pending[id] = ch
+	c.mu.Unlock()
+	if err := f(newPacket(t).uint32(id)).send(c.w); err != nil {
+		c.mu.Lock()
+		if _, ok := c.pending[id]; ok {
+			delete(c.pending, id)
+			ch <- reply{err: err}
+		}
+		c.mu.Unlock()
+	}
+	return ch
+}
+
+// call sends a request, and waits for its reply.
+func (c *Client) call(t byte, f func(packet) packet) (byte, *buffer, error) {
+	r := <-c.request(t, f)
+	return r.t, r.b, r.err
+}
+
+// check returns the error of a status reply, or of a reply that is not
+// of type want.
+func check(t byte, b *buffer, err error, want byte) error {
+	if err != nil {
+		return err
+	}
+	if t == fxpStatus {
+		code, msg := b.uint32(), b.string()
+		if b.err != nil {
+			return b.err
+		}
+		if code == fxOK && want == fxpStatus {
+			return nil
+		}
+		if code == fxEOF {
+			return io.EOF
+		}
+		return &StatusError{Code: code, Msg: msg}
+	}
+	if t != want {
+		return fmt.Errorf("reply type %d, want %d:%w", t, want, errBadMessage)
+	}
+	return nil
+}
+
+// status sends a request whose reply is a status.
+func (c *Client) status(t byte, f func(packet) packet) error {
+	rt, b, err := c.call(t, f)
+	return check(rt, b, err, fxpStatus)
+}
+
+// Close closes the client.
+func (c *Client) Close() error {
+	return c.w.Close()
+}
+
+// HasExtension returns true if the server supports an extension, e.g.
+// posix-rename@openssh.com.
+func (c *Client) HasExtension(name string) bool {
+	_, ok := c.extensions[name]
+	return ok
+}
+
+func (c *Client) stat(t byte, name string) (os.FileInfo, error) {
+	rt, b, err := c.call(t, func(p packet) packet { return p.string(name) })
+	if err := check(rt, b, err, fxpAttrs); err != nil {
+		return nil, err
+	}
+	a := b.attrs()
+	if b.err != nil {
+		return nil, b.err
+	}
+	return &fileInfo{name: path.Base(name), a: a}, nil
+}
+
+// Stat returns the attributes of a file, following symbolic links. The
+// Sys method of the os.FileInfo returns its *Attrs.
+func (c *Client) Stat(name string) (os.FileInfo, error) {
+	return c.stat(fxpStat, name)
+}
+
+// Lstat is Stat, but does not follow a symbolic link.
+func (c *Client) Lstat(name string) (os.FileInfo, error) {
+	return c.stat(fxpLstat, name)
+}
+
+// Setstat sets the attributes of a file that are in a.Flags.
+func (c *Client) Setstat(name string, a *Attrs) error {
+	return c.status(fxpSetstat, func(p packet) packet { return p.string(name).attrs(a) })
+}
+
+// Chmod sets the mode of a file.
+func (c *Client) Chmod(name string, mode os.FileMode) error {
+	return c.Setstat(name, &Attrs{Flags: attrPermissions, Mode: modeBits(mode)})
+}
+
+// Chtimes sets the access and modification times of a file, in seconds.
+func (c *Client) Chtimes(name string, atime, mtime time.Time) error {
+	return c.Setstat(name, &Attrs{Flags: attrACModTime, Atime: uint32(atime.Unix()), Mtime: uint32(mtime.Unix())})
+}
+
+// Mkdir makes a directory.
+func (c *Client) Mkdir(name string, mode os.FileMode) error {
+	return c.status(fxpMkdir, func(p packet) packet {
+		return p.string(name).attrs(&Attrs{Flags: attrPermissions, Mode: uint32(mode.Perm())})
+	})
+}
+
+// Remove removes a file.
+func (c *Client) Remove(name string) error {
+	return c.status(fxpRemove, func(p packet) packet { return p.string(name) })
+}
+
+// RemoveDir removes an empty directory.
+func (c *Client) RemoveDir(name string) error {
+	return c.status(fxpRmdir, func(p packet) packet { return p.string(name) })
+}
+
+// Rename renames a file, replacing to, if the server supports
+// posix-rename@openssh.com; otherwise, to must not exist.
+func (c *Client) Rename(from, to string) error {
+	if c.HasExtension("posix-rename@openssh.com") {
+		return c.status(fxpExtended, func(p packet) packet {
+			return p.string("posix-rename@openssh.com").string(from).string(to)
+		})
+	}
+	return c.status(fxpRename, func(p packet) packet { return p.string(from).string(to) })
+}
+
+// Symlink makes a symbolic link, link, to target.
+func (c *Client) Symlink(target, link string) error {
+	// As in the server, the target is first.
+	return c.status(fxpSymlink, func(p packet) packet { return p.string(target).string(link) })
+}
+
+// name sends a request whose reply is one name.
+func (c *Client) name(t byte, name string) (string, error) {
+	rt, b, err := c.call(t, func(p packet) packet { return p.string(name) })
+	if err := check(rt, b, err, fxpName); err != nil {
+		return "", err
+	}
+	if n := b.uint32(); n != 1 {
+		return "", fmt.Errorf("%d names, want 1:%w", n, errBadMessage)
+	}
+	s := b.string()
+	return s, b.err
+}
+
+// Readlink returns the target of a symbolic link.
+func (c *Client) Readlink(name string) (string, error) {
+	return c.name(fxpReadlink, name)
+}
+
+// RealPath returns the absolute path of name; "." is the working
+// directory of the server.
+func (c *Client) RealPath(name string) (string, error) {
+	return c.name(fxpRealpath, name)
+}
+
+// ReadDir returns the files in a directory, sorted by name.
+func (c *Client) ReadDir(name string) ([]os.FileInfo, error) {
+	rt, b, err := c.call(fxpOpendir, func(p packet) packet { return p.string(name) })
+	if err := check(rt, b, err, fxpHandle); err != nil {
+		return nil, err
+	}
+	h := b.string()
+	defer c.status(fxpClose, func(p packet) packet { return p.string(h) }) //nolint
+	var fis []os.FileInfo
+	for {
+		rt, b, err := c.call(fxpReaddir, func(p packet) packet { return p.string(h) })
+		if err := check(rt, b, err, fxpName); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		for n := b.uint32(); n > 0 && b.err == nil; n-- {
+			fn, _, a := b.string(), b.string(), b.attrs()
+			if fn != "." && fn != ".." {
+				fis = append(fis, &fileInfo{name: fn, a: a})
+			}
+		}
+		if b.err != nil {
+			return nil, b.err
+		}
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	return fis, nil
+}
+
+// Open opens a file to read.
+func (c *Client) Open(name string) (*File, error) {
+	return c.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Create creates or truncates a file, to write.
+func (c *Client) Create(name string, mode os.FileMode) (*File, error) {
+	return c.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+}
+
+// OpenFile opens a file with os.OpenFile flags.
+func (c *Client) OpenFile(name string, flag int, mode os.FileMode) (*File, error) {
+	var f uint32
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY:
+		f = fxfRead
+	case os.O_WRONLY:
+		f = fxfWrite
+	case os.O_RDWR:
+		f = fxfRead | fxfWrite
+	}
+	for _, fl := range []struct {
+		os   int
+		sftp uint32
+	}{
+		{os.O_APPEND, fxfAppend},
+		{os.O_CREATE, fxfCreat},
+		{os.O_TRUNC, fxfTrunc},
+		{os.O_EXCL, fxfExcl},
+	} {
+		if flag&fl.os != 0 {
+			f |= fl.sftp
+		}
+	}
+	rt, b, err := c.call(fxpOpen, func(p packet) packet {
+		return p.string(name).uint32(f).attrs(&Attrs{Flags: attrPermissions, Mode: uint32(mode.Perm())})
+	})
+	if err := check(rt, b, err, fxpHandle); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	h := b.string()
+	return &File{c: c, name: name, h: h}, b.err
+}
+
+// File is an open file on the server.
+type File struct {
+	c    *Client
+	name string
+	h    string
+	off  int64
+}
+
+// Name returns the name of the file, as it was opened.
+func (f *File) Name() string {
+	return f.name
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	return f.c.status(fxpClose, func(p packet) packet { return p.string(f.h) })
+}
+
+// Stat returns the attributes of the file.
+func (f *File) Stat() (os.FileInfo, error) {
+	rt, b, err := f.c.call(fxpFstat, func(p packet) packet { return p.string(f.h) })
+	if err := check(rt, b, err, fxpAttrs); err != nil {
+		return nil, err
+	}
+	a := b.attrs()
+	return &fileInfo{name: path.Base(f.name), a: a}, b.err
+}
+
+// readAt asks for the data at off.
+func (f *File) readAt(off int64, n int) <-chan reply {
+	return f.c.request(fxpRead, func(p packet) packet { return p.string(f.h).uint64(uint64(off)).uint32(uint32(n)) })
+}
+
+// data returns the data of the reply to a read.
+func data(r reply) ([]byte, error) {
+	if err := check(r.t, r.b, r.err, fxpData); err != nil {
+		return nil, err
+	}
+	d := r.b.bytes()
+	return d, r.b.err
+}
+
+// Read reads from the file.
+func (f *File) Read(b []byte) (int, error) {
+	if len(b) > maxData {
+		b = b[:maxData]
+	}
+	d, err := data(<-f.readAt(f.off, len(b)))
+	if err != nil {
+		return 0, err
+	}
+	n := copy(b, d)
+	f.off += int64(n)
+	return n, nil
+}
+
+// WriteTo writes the rest of the file to w, with several reads
+// outstanding at once; io.Copy uses it.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	var (
+		done  int64
+		reads []<-chan reply
+		next  = f.off
+		eof   bool
+	)
+	for {
+		for !eof && len(reads) < window {
+			reads = append(reads, f.readAt(next, chunk))
+			next += chunk
+		}
+		if len(reads) == 0 {
+			return done, nil
+		}
+		r := <-reads[0]
+		reads = reads[1:]
+		d, err := data(r)
+		if err == io.EOF {
+			eof = true
+			continue
+		}
+		if err == nil {
+			_, err = w.Write(d)
+		}
+		if err != nil {
+			for _, r := range reads {
+				<-r
+			}
+			return done, err
+		}
+		done += int64(len(d))
+		f.off += int64(len(d))
+		if len(d) < chunk {
+			// A short read: the rest is read from where it
+			// ended, not where the next read started.
+			for _, r := range reads {
+				<-r
+			}
+			reads, next = nil, f.off
+		}
+	}
+}
+
+// writeAt writes b at off.
+func (f *File) writeAt(b []byte, off int64) <-chan reply {
+	return f.c.request(fxpWrite, func(p packet) packet { return p.string(f.h).uint64(uint64(off)).bytes(b) })
+}
+
+// Write writes to the file.
+func (f *File) Write(b []byte) (int, error) {
+	var n int
+	for len(b) > 0 {
+		d := b
+		if len(d) > chunk {
+			d = d[:chunk]
+		}
+		r := <-f.writeAt(d, f.off)
+		if err := check(r.t, r.b, r.err, fxpStatus); err != nil {
+			return n, err
+		}
+		n += len(d)
+		f.off += int64(len(d))
+		b = b[len(d):]
+	}
+	return n, nil
+}
+
+// ReadFrom writes what is read from r to the file, with several writes
+// outstanding at once; io.Copy uses it.
+func (f *File) ReadFrom(r io.Reader) (int64, error) {
+	var (
+		done   int64
+		writes []<-chan reply
+		err    error
+	)
+	wait := func() error {
+		w := <-writes[0]
+		writes = writes[1:]
+		return check(w.t, w.b, w.err, fxpStatus)
+	}
+	for err == nil {
+		b := make([]byte, chunk)
+		n, rerr := io.ReadFull(r, b)
+		if n > 0 {
+			writes = append(writes, f.writeAt(b[:n], f.off))
+			f.off += int64(n)
+			done += int64(n)
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		err = rerr
+		if len(writes) == window && err == nil {
+			err = wait()
+		}
+	}
+	for len(writes) > 0 {
+		if werr := wait(); err == nil {
+			err = werr
+		}
+	}
+	return done, err
+}
+
+// fileInfo is the os.FileInfo of a file on a server.
+type fileInfo struct {
+	name string
+	a    *Attrs
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return int64(fi.a.Size) }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.a.FileMode() }
+func (fi *fileInfo) ModTime() time.Time { return time.Unix(int64(fi.a.Mtime), 0) }
+func (fi *fileInfo) IsDir() bool        { return fi.Mode().IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return fi.a }
@@ -0,0 +1,124 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sftp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newPipeClient returns a client of a server on pipes.
+func newPipeClient(t *testing.T) *Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	go func() {
+		Serve(sr, sw) //nolint
+		sw.Close()
+	}()
+	c, err := NewClient(cr, cw)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClient(t *testing.T) {
+	d := t.TempDir()
+	c := newPipeClient(t)
+	if !c.HasExtension("posix-rename@openssh.com") {
+		t.Errorf("posix-rename@openssh.com: not supported, want supported")
+	}
+
+	// More than a window of chunks, and not a multiple of them.
+	data := make([]byte, window*chunk*2+100)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	f, err := c.Create(filepath.Join(d, "f"), 0o600)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if n, err := io.Copy(f, bytes.NewReader(data)); err != nil || n != int64(len(data)) {
+		t.Fatalf("copying to the file: %d, %v, want %d, nil", n, err, len(data))
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(d, "f")); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("file written: %d bytes, %v, want %d bytes, nil", len(b), err, len(data))
+	}
+
+	f, err = c.Open(filepath.Join(d, "f"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	var b bytes.Buffer
+	if n, err := io.Copy(&b, f); err != nil || !bytes.Equal(b.Bytes(), data) {
+		t.Errorf("copying from the file: %d bytes, %v, want %d bytes, nil", n, err, len(data))
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.Size() != int64(len(data)) || fi.Mode() != 0o600 {
+		t.Errorf("Stat: %v, %v, want size %d, mode %v", fi, err, len(data), os.FileMode(0o600))
+	}
+	f.Close()
+
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := c.Chmod(filepath.Join(d, "f"), 0o751); err != nil {
+		t.Errorf("Chmod: %v", err)
+	}
+	if err := c.Chtimes(filepath.Join(d, "f"), when, when); err != nil {
+		t.Errorf("Chtimes: %v", err)
+	}
+	if fi, err := c.Stat(filepath.Join(d, "f")); err != nil || fi.Mode() != 0o751 || !fi.ModTime().Equal(when) {
+		t.Errorf("Stat: %v, %v, want mode %v, time %v", fi, err, os.FileMode(0o751), when)
+	}
+
+	if err := c.Mkdir(filepath.Join(d, "dir"), 0o755); err != nil {
+		t.Errorf("Mkdir: %v", err)
+	}
+	if err := c.Symlink("f", filepath.Join(d, "l")); err != nil {
+		t.Errorf("Symlink: %v", err)
+	}
+	if s, err := c.Readlink(filepath.Join(d, "l")); err != nil || s != "f" {
+		t.Errorf("Readlink: %q, %v, want %q, nil", s, err, "f")
+	}
+	fis, err := c.ReadDir(d)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if len(fis) != 3 || names[0] != "dir" || !fis[0].IsDir() || names[1] != "f" || names[2] != "l" || fis[2].Mode()&os.ModeSymlink == 0 {
+		t.Errorf("ReadDir: %q, want dir, f and l", names)
+	}
+
+	if err := c.Rename(filepath.Join(d, "l"), filepath.Join(d, "f")); err != nil {
+		t.Errorf("Rename onto a file: %v", err)
+	}
+	if _, err := c.Stat(filepath.Join(d, "none")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat of a file that does not exist: %v, want %v", err, os.ErrNotExist)
+	}
+	if err := c.RemoveDir(filepath.Join(d, "dir")); err != nil {
+		t.Errorf("RemoveDir: %v", err)
+	}
+	if err := c.Remove(filepath.Join(d, "f")); err != nil {
+		t.Errorf("Remove: %v", err)
+	}
+
+	// Once the server is gone, requests fail.
+	c.Close()
+	if _, err := c.Stat(d); err == nil {
+		t.Errorf("Stat once closed: nil, want an error")
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix
+
+package sftp
+
+import "os"
+
+// owner is not known.
+func owner(os.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
+
+// nlink is not known.
+func nlink(os.FileInfo) uint64 {
+	return 1
+}
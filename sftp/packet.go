@@ -0,0 +1,323 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sftp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Packet types, from draft-ietf-secsh-filexfer-02.
+const (
+	fxpInit          = 1
+	fxpVersion       = 2
+	fxpOpen          = 3
+	fxpClose         = 4
+	fxpRead          = 5
+	fxpWrite         = 6
+	fxpLstat         = 7
+	fxpFstat         = 8
+	fxpSetstat       = 9
+	fxpFsetstat      = 10
+	fxpOpendir       = 11
+	fxpReaddir       = 12
+	fxpRemove        = 13
+	fxpMkdir         = 14
+	fxpRmdir         = 15
+	fxpRealpath      = 16
+	fxpStat          = 17
+	fxpRename        = 18
+	fxpReadlink      = 19
+	fxpSymlink       = 20
+	fxpStatus        = 101
+	fxpHandle        = 102
+	fxpData          = 103
+	fxpName          = 104
+	fxpAttrs         = 105
+	fxpExtended      = 200
+	fxpExtendedReply = 201
+)
+
+// Status codes.
+const (
+	fxOK               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+	fxFailure          = 4
+	fxBadMessage       = 5
+	fxOpUnsupported    = 8
+)
+
+// Open flags.
+const (
+	fxfRead   = 0x01
+	fxfWrite  = 0x02
+	fxfAppend = 0x04
+	fxfCreat  = 0x08
+	fxfTrunc  = 0x10
+	fxfExcl   = 0x20
+)
+
+// Attribute flags.
+const (
+	attrSize        = 0x01
+	attrUIDGID      = 0x02
+	attrPermissions = 0x04
+	attrACModTime   = 0x08
+	attrExtended    = 0x80000000
+)
+
+// Version is the version of the protocol.
+const Version = 3
+
+// maxPacket is the largest packet that is read. OpenSSH allows 256K.
+const maxPacket = 256 * 1024
+
+// maxData is the most data in a read or write.
+const maxData = maxPacket - 1024
+
+// errBadMessage is returned for a packet that can not be decoded.
+var errBadMessage = fmt.Errorf("bad message:%w", strconv.ErrSyntax)
+
+// buffer decodes the fields of a packet. Once a field is short, all
+// fields are zero, and err is set.
+type buffer struct {
+	b   []byte
+	err error
+}
+
+func (b *buffer) take(n int) []byte {
+	if b.err != nil || n < 0 || len(b.b) < n {
+		b.err = errBadMessage
+		return nil
+	}
+	v := b.b[:n]
+	b.b = b.b[n:]
+	return v
+}
+
+func (b *buffer) uint32() uint32 {
+	if v := b.take(4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (b *buffer) uint64() uint64 {
+	if v := b.take(8); v != nil {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (b *buffer) bytes() []byte {
+	n := b.uint32()
+	if n > maxPacket {
+		b.err = errBadMessage
+		return nil
+	}
+	return b.take(int(n))
+}
+
+func (b *buffer) string() string {
+	return string(b.bytes())
+}
+
+// attrs decodes file attributes.
+func (b *buffer) attrs() *Attrs {
+	a := &Attrs{Flags: b.uint32()}
+	if a.Flags&attrSize != 0 {
+		a.Size = b.uint64()
+	}
+	if a.Flags&attrUIDGID != 0 {
+		a.UID, a.GID = b.uint32(), b.uint32()
+	}
+	if a.Flags&attrPermissions != 0 {
+		a.Mode = b.uint32()
+	}
+	if a.Flags&attrACModTime != 0 {
+		a.Atime, a.Mtime = b.uint32(), b.uint32()
+	}
+	if a.Flags&attrExtended != 0 {
+		for n := b.uint32(); n > 0 && b.err == nil; n-- {
+			b.string()
+			b.string()
+		}
+	}
+	return a
+}
+
+// packet encodes a packet.
+type packet []byte
+
+func newPacket(t byte) packet {
+	return packet{0, 0, 0, 0, t}
+}
+
+func (p packet) uint32(v uint32) packet {
+	return binary.BigEndian.AppendUint32(p, v)
+}
+
+func (p packet) uint64(v uint64) packet {
+	return binary.BigEndian.AppendUint64(p, v)
+}
+
+func (p packet) bytes(v []byte) packet {
+	return append(p.uint32(uint32(len(v))), v...)
+}
+
+func (p packet) string(v string) packet {
+	return append(p.uint32(uint32(len(v))), v...)
+}
+
+func (p packet) attrs(a *Attrs) packet {
+	f := a.Flags &^ attrExtended
+	p = p.uint32(f)
+	if f&attrSize != 0 {
+		p = p.uint64(a.Size)
+	}
+	if f&attrUIDGID != 0 {
+		p = p.uint32(a.UID).uint32(a.GID)
+	}
+	if f&attrPermissions != 0 {
+		p = p.uint32(a.Mode)
+	}
+	if f&attrACModTime != 0 {
+		p = p.uint32(a.Atime).uint32(a.Mtime)
+	}
+	return p
+}
+
+// send writes the packet, with its length.
+func (p packet) send(w io.Writer) error {
+	binary.BigEndian.PutUint32(p, uint32(len(p)-4))
+	_, err := w.Write(p)
+	return err
+}
+
+// readPacket reads a packet, and returns its type and the rest of it.
+func readPacket(r io.Reader) (byte, []byte, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(l[:])
+	if n < 1 || n > maxPacket {
+		return 0, nil, fmt.Errorf("packet of %d bytes: %w", n, errBadMessage)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return buf[0], buf[1:], nil
+}
+
+// Attrs are the attributes of a file, in the form of the protocol.
+// Flags says which are set.
+type Attrs struct {
+	Flags        uint32
+	Size         uint64
+	UID, GID     uint32
+	Mode         uint32
+	Atime, Mtime uint32
+}
+
+// Unix mode bits for file types.
+const (
+	sIFMT   = 0o170000
+	sIFSOCK = 0o140000
+	sIFLNK  = 0o120000
+	sIFREG  = 0o100000
+	sIFBLK  = 0o060000
+	sIFDIR  = 0o040000
+	sIFCHR  = 0o020000
+	sIFIFO  = 0o010000
+	sISUID  = 0o4000
+	sISGID  = 0o2000
+	sISVTX  = 0o1000
+)
+
+// modeBits returns the Unix mode bits of an os.FileMode.
+func modeBits(m os.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	switch {
+	case m.IsDir():
+		mode |= sIFDIR
+	case m&os.ModeSymlink != 0:
+		mode |= sIFLNK
+	case m&os.ModeNamedPipe != 0:
+		mode |= sIFIFO
+	case m&os.ModeSocket != 0:
+		mode |= sIFSOCK
+	case m&os.ModeCharDevice != 0:
+		mode |= sIFCHR
+	case m&os.ModeDevice != 0:
+		mode |= sIFBLK
+	default:
+		mode |= sIFREG
+	}
+	if m&os.ModeSetuid != 0 {
+		mode |= sISUID
+	}
+	if m&os.ModeSetgid != 0 {
+		mode |= sISGID
+	}
+	if m&os.ModeSticky != 0 {
+		mode |= sISVTX
+	}
+	return mode
+}
+
+// fileInfoAttrs returns the attributes of a file.
+func fileInfoAttrs(fi os.FileInfo) *Attrs {
+	a := &Attrs{
+		Flags: attrSize | attrPermissions | attrACModTime,
+		Size:  uint64(fi.Size()),
+		Mode:  modeBits(fi.Mode()),
+		Atime: uint32(fi.ModTime().Unix()),
+		Mtime: uint32(fi.ModTime().Unix()),
+	}
+	if uid, gid, ok := owner(fi); ok {
+		a.Flags |= attrUIDGID
+		a.UID, a.GID = uid, gid
+	}
+	return a
+}
+
+// FileMode returns the mode of the attributes as an os.FileMode.
+func (a *Attrs) FileMode() os.FileMode {
+	m := os.FileMode(a.Mode & 0o777)
+	switch a.Mode & sIFMT {
+	case sIFDIR:
+		m |= os.ModeDir
+	case sIFLNK:
+		m |= os.ModeSymlink
+	case sIFIFO:
+		m |= os.ModeNamedPipe
+	case sIFSOCK:
+		m |= os.ModeSocket
+	case sIFCHR:
+		m |= os.ModeDevice | os.ModeCharDevice
+	case sIFBLK:
+		m |= os.ModeDevice
+	}
+	if a.Mode&sISUID != 0 {
+		m |= os.ModeSetuid
+	}
+	if a.Mode&sISGID != 0 {
+		m |= os.ModeSetgid
+	}
+	if a.Mode&sISVTX != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
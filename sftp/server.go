@@ -0,0 +1,492 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sftp is an SFTP server, version 3, as in
+// draft-ietf-secsh-filexfer-02, with the extensions of OpenSSH that
+// clients use, which cpud runs for the sftp subsystem.
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SubsystemArg is the argument with which cpud runs as an SFTP server,
+// as the command of a session, e.g.
+//
+//	if len(os.Args) > 1 && os.Args[1] == sftp.SubsystemArg {
+//		os.Exit(sftp.Main())
+//	}
+const SubsystemArg = "-sftp"
+
+// extensions are the extensions the server supports, as in OpenSSH's
+// PROTOCOL.
+var extensions = []string{"posix-rename@openssh.com", "hardlink@openssh.com", "fsync@openssh.com"}
+
+// readdirBatch is the most names returned by one READDIR.
+const readdirBatch = 128
+
+// maxHandles is the most files and directories a client can have open.
+const maxHandles = 1024
+
+// handle is an open file or directory.
+type handle struct {
+	f *os.File
+	// dir is true for a directory, whose names are read by READDIR.
+	dir bool
+	// appends is true for a file opened to append, whose writes
+	// ignore their offset.
+	appends bool
+}
+
+// server serves one client.
+type server struct {
+	w       io.Writer
+	handles map[string]*handle
+	next    uint64
+}
+
+// Serve serves SFTP, version 3, on r and w, until r is closed. Paths
+// are those of the process, and relative paths relative to its working
+// directory.
+func Serve(r io.Reader, w io.Writer) error {
+	s := &server{w: w, handles: map[string]*handle{}}
+	defer func() {
+		for _, h := range s.handles {
+			h.f.Close()
+		}
+	}()
+	for {
+		t, p, err := readPacket(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.dispatch(t, &buffer{b: p}); err != nil {
+			return err
+		}
+	}
+}
+
+// Main serves SFTP on stdin and stdout, in the home directory, if there
+// is one, and returns the exit status.
+func Main() int {
+	if home := os.Getenv("HOME"); len(home) > 0 {
+		os.Chdir(home) //nolint
+	}
+	if err := Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "CPUD:sftp: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// status returns the status code for an error.
+func status(err error) uint32 {
+	switch {
+	case err == nil:
+		return fxOK
+	case err == io.EOF:
+		return fxEOF
+	case errors.Is(err, os.ErrNotExist):
+		return fxNoSuchFile
+	case errors.Is(err, os.ErrPermission):
+		return fxPermissionDenied
+	case errors.Is(err, errBadMessage):
+		return fxBadMessage
+	case errors.Is(err, errors.ErrUnsupported):
+		return fxOpUnsupported
+	}
+	return fxFailure
+}
+
+func (s *server) sendStatus(id uint32, err error) error {
+	msg := "ok"
+	if err != nil {
+		msg = err.Error()
+	}
+	return newPacket(fxpStatus).uint32(id).uint32(status(err)).string(msg).string("").send(s.w)
+}
+
+func (s *server) sendAttrs(id uint32, fi os.FileInfo, err error) error {
+	if err != nil {
+		return s.sendStatus(id, err)
+	}
+	return newPacket(fxpAttrs).uint32(id).attrs(fileInfoAttrs(fi)).send(s.w)
+}
+
+func (s *server) sendName(id uint32, name string) error {
+	return newPacket(fxpName).uint32(id).uint32(1).string(name).string(name).attrs(&Attrs{}).send(s.w)
+}
+
+// open returns a new handle for f.
+func (s *server) open(id uint32, h *handle) error {
+	if len(s.handles) >= maxHandles {
+		h.f.Close()
+		return s.sendStatus(id, fmt.Errorf("more than %d files open", maxHandles))
+	}
+	s.next++
+	n := strconv.FormatUint(s.next, 10)
+	s.handles[n] = h
+	return newPacket(fxpHandle).uint32(id).string(n).send(s.w)
+}
+
+// handle returns the handle named by the next field of b.
+func (s *server) handle(b *buffer, dir bool) (*handle, error) {
+	h, ok := s.handles[b.string()]
+	if b.err != nil {
+		return nil, b.err
+	}
+	if !ok || h.dir != dir {
+		return nil, fmt.Errorf("not a handle of an open file:%w", os.ErrInvalid)
+	}
+	return h, nil
+}
+
+// dispatch handles one request. Errors in requests are sent to the
+// client; only errors in writing to it are returned.
+func (s *server) dispatch(t byte, b *buffer) error {
+	if t == fxpInit {
+		p := newPacket(fxpVersion).uint32(Version)
+		for _, e := range extensions {
+			p = p.string(e).string("1")
+		}
+		return p.send(s.w)
+	}
+	id := b.uint32()
+	if b.err != nil {
+		return b.err
+	}
+	switch t {
+	case fxpOpen:
+		name, flags, attrs := b.string(), b.uint32(), b.attrs()
+		if b.err != nil {
+			return s.sendStatus(id, b.err)
+		}
+		mode := os.FileMode(0o666)
+		if attrs.Flags&attrPermissions != 0 {
+			mode = attrs.FileMode().Perm()
+		}
+		f, err := os.OpenFile(name, openFlags(flags), mode)
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		return s.open(id, &handle{f: f, appends: flags&fxfAppend != 0})
+
+	case fxpOpendir:
+		name := b.string()
+		if b.err != nil {
+			return s.sendStatus(id, b.err)
+		}
+		f, err := os.Open(name)
+		if err == nil {
+			var fi os.FileInfo
+			if fi, err = f.Stat(); err == nil && !fi.IsDir() {
+				err = fmt.Errorf("%q is not a directory:%w", name, os.ErrInvalid)
+			}
+			if err != nil {
+				f.Close()
+			}
+		}
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		return s.open(id, &handle{f: f, dir: true})
+
+	case fxpClose:
+		hn := b.string()
+		h, ok := s.handles[hn]
+		if b.err != nil || !ok {
+			return s.sendStatus(id, fmt.Errorf("not a handle of an open file:%w", os.ErrInvalid))
+		}
+		delete(s.handles, hn)
+		return s.sendStatus(id, h.f.Close())
+
+	case fxpRead:
+		h, err := s.handle(b, false)
+		off, n := b.uint64(), b.uint32()
+		if err == nil {
+			err = b.err
+		}
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		if n > maxData {
+			n = maxData
+		}
+		data := make([]byte, n)
+		got, err := h.f.ReadAt(data, int64(off))
+		if got == 0 && err != nil {
+			return s.sendStatus(id, err)
+		}
+		return newPacket(fxpData).uint32(id).bytes(data[:got]).send(s.w)
+
+	case fxpWrite:
+		h, err := s.handle(b, false)
+		off, data := b.uint64(), b.bytes()
+		if err == nil {
+			err = b.err
+		}
+		if err == nil && h.appends {
+			_, err = h.f.Write(data)
+		} else if err == nil {
+			_, err = h.f.WriteAt(data, int64(off))
+		}
+		return s.sendStatus(id, err)
+
+	case fxpFstat:
+		h, err := s.handle(b, false)
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		fi, err := h.f.Stat()
+		return s.sendAttrs(id, fi, err)
+
+	case fxpStat, fxpLstat:
+		name := b.string()
+		if b.err != nil {
+			return s.sendStatus(id, b.err)
+		}
+		stat := os.Stat
+		if t == fxpLstat {
+			stat = os.Lstat
+		}
+		fi, err := stat(name)
+		return s.sendAttrs(id, fi, err)
+
+	case fxpSetstat:
+		name, attrs := b.string(), b.attrs()
+		if b.err != nil {
+			return s.sendStatus(id, b.err)
+		}
+		return s.sendStatus(id, setstat(name, attrs))
+
+	case fxpFsetstat:
+		h, err := s.handle(b, false)
+		attrs := b.attrs()
+		if err == nil {
+			err = b.err
+		}
+		if err == nil {
+			err = setstat(h.f.Name(), attrs)
+		}
+		return s.sendStatus(id, err)
+
+	case fxpReaddir:
+		h, err := s.handle(b, true)
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		return s.readdir(id, h)
+
+	case fxpRemove:
+		name := b.string()
+		if b.err != nil {
+			return s.sendStatus(id, b.err)
+		}
+		fi, err := os.Lstat(name)
+		if err == nil && fi.IsDir() {
+			err = fmt.Errorf("%q is a directory:%w", name, os.ErrInvalid)
+		}
+		if err == nil {
+			err = os.Remove(name)
+		}
+		return s.sendStatus(id, err)
+
+	case fxpMkdir:
+		name, attrs := b.string(), b.attrs()
+		if b.err != nil {
+			return s.sendStatus(id, b.err)
+		}
+		mode := os.FileMode(0o777)
+		if attrs.Flags&attrPermissions != 0 {
+			mode = attrs.FileMode().Perm()
+		}
+		return s.sendStatus(id, os.Mkdir(name, mode))
+
+	case fxpRmdir:
+		name := b.string()
+		if b.err != nil {
+			return s.sendStatus(id, b.err)
+		}
+		fi, err := os.Lstat(name)
+		if err == nil && !fi.IsDir() {
+			err = fmt.Errorf("%q is not a directory:%w", name, os.ErrInvalid)
+		}
+		if err == nil {
+			err = os.Remove(name)
+		}
+		return s.sendStatus(id, err)
+
+	case fxpRealpath:
+		name := b.string()
+		if b.err != nil {
+			return s.sendStatus(id, b.err)
+		}
+		p, err := filepath.Abs(name)
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		return s.sendName(id, p)
+
+	case fxpRename:
+		from, to := b.string(), b.string()
+		if b.err != nil {
+			return s.sendStatus(id, b.err)
+		}
+		// SFTP version 3 does not replace an existing file.
+		_, err := os.Lstat(to)
+		if err == nil {
+			err = fmt.Errorf("%q exists:%w", to, os.ErrExist)
+		} else if errors.Is(err, os.ErrNotExist) {
+			err = os.Rename(from, to)
+		}
+		return s.sendStatus(id, err)
+
+	case fxpReadlink:
+		name := b.string()
+		if b.err != nil {
+			return s.sendStatus(id, b.err)
+		}
+		target, err := os.Readlink(name)
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		return s.sendName(id, target)
+
+	case fxpSymlink:
+		// OpenSSH sends the target first, the reverse of the
+		// draft, and other clients follow it.
+		target, link := b.string(), b.string()
+		if b.err != nil {
+			return s.sendStatus(id, b.err)
+		}
+		return s.sendStatus(id, os.Symlink(target, link))
+
+	case fxpExtended:
+		return s.extended(id, b)
+	}
+	return s.sendStatus(id, fmt.Errorf("request %d:%w", t, errors.ErrUnsupported))
+}
+
+// extended handles the extensions.
+func (s *server) extended(id uint32, b *buffer) error {
+	var err error
+	switch ext := b.string(); ext {
+	case "posix-rename@openssh.com":
+		from, to := b.string(), b.string()
+		if err = b.err; err == nil {
+			err = os.Rename(from, to)
+		}
+	case "hardlink@openssh.com":
+		from, to := b.string(), b.string()
+		if err = b.err; err == nil {
+			err = os.Link(from, to)
+		}
+	case "fsync@openssh.com":
+		var h *handle
+		if h, err = s.handle(b, false); err == nil {
+			err = h.f.Sync()
+		}
+	default:
+		err = fmt.Errorf("extension %q:%w", ext, errors.ErrUnsupported)
+	}
+	return s.sendStatus(id, err)
+}
+
+// readdir returns the next names in a directory.
+func (s *server) readdir(id uint32, h *handle) error {
+	ents, err := h.f.ReadDir(readdirBatch)
+	if len(ents) == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return s.sendStatus(id, err)
+	}
+	sort.Slice(ents, func(i, j int) bool { return ents[i].Name() < ents[j].Name() })
+	p := newPacket(fxpName).uint32(id)
+	var n uint32
+	var names packet
+	for _, e := range ents {
+		fi, err := e.Info()
+		if err != nil {
+			// It was removed since it was read.
+			continue
+		}
+		names = names.string(e.Name()).string(longName(fi)).attrs(fileInfoAttrs(fi))
+		n++
+	}
+	return append(p.uint32(n), names...).send(s.w)
+}
+
+// longName is the name of a file as ls -l shows it, which clients
+// show for a listing.
+func longName(fi os.FileInfo) string {
+	a := fileInfoAttrs(fi)
+	t := fi.ModTime()
+	when := t.Format("Jan _2 15:04")
+	if time.Since(t) > 180*24*time.Hour || time.Until(t) > 24*time.Hour {
+		when = t.Format("Jan _2  2006")
+	}
+	return fmt.Sprintf("%s %4d %-8d %-8d %8d %s %s", fi.Mode(), nlink(fi), a.UID, a.GID, fi.Size(), when, fi.Name())
+}
+
+// openFlags returns the os.OpenFile flags for SFTP open flags.
+func openFlags(f uint32) int {
+	var flags int
+	switch {
+	case f&fxfRead != 0 && f&fxfWrite != 0:
+		flags = os.O_RDWR
+	case f&fxfWrite != 0:
+		flags = os.O_WRONLY
+	default:
+		flags = os.O_RDONLY
+	}
+	if f&fxfAppend != 0 {
+		flags |= os.O_APPEND
+	}
+	if f&fxfCreat != 0 {
+		flags |= os.O_CREATE
+	}
+	if f&fxfTrunc != 0 {
+		flags |= os.O_TRUNC
+	}
+	if f&fxfExcl != 0 {
+		flags |= os.O_EXCL
+	}
+	return flags
+}
+
+// setstat sets the attributes of a file.
+func setstat(name string, a *Attrs) error {
+	if a.Flags&attrSize != 0 {
+		if err := os.Truncate(name, int64(a.Size)); err != nil {
+			return err
+		}
+	}
+	if a.Flags&attrUIDGID != 0 {
+		if err := os.Lchown(name, int(a.UID), int(a.GID)); err != nil {
+			return err
+		}
+	}
+	if a.Flags&attrPermissions != 0 {
+		if err := os.Chmod(name, a.FileMode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+			return err
+		}
+	}
+	if a.Flags&attrACModTime != 0 {
+		if err := os.Chtimes(name, time.Unix(int64(a.Atime), 0), time.Unix(int64(a.Mtime), 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+// Copyright 2026 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sftp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testClient sends requests to a server, and reads its replies.
+type testClient struct {
+	t  *testing.T
+	w  io.Writer
+	r  io.Reader
+	id uint32
+}
+
+func newTestClient(t *testing.T) *testClient {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(sr, sw)
+		sw.Close()
+	}()
+	t.Cleanup(func() {
+		cw.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	c := &testClient{t: t, w: cw, r: cr}
+	if err := newPacket(fxpInit).uint32(Version).send(c.w); err != nil {
+		t.Fatal(err)
+	}
+	typ, p, err := readPacket(c.r)
+	if err != nil || typ != fxpVersion {
+		t.Fatalf("init: type %d, %v, want %d, nil", typ, err, fxpVersion)
+	}
+	b := &buffer{b: p}
+	if v := b.uint32(); v != Version {
+		t.Fatalf("version %d, want %d", v, Version)
+	}
+	var exts []string
+	for len(b.b) > 0 && b.err == nil {
+		exts = append(exts, b.string())
+		b.string()
+	}
+	if !reflect.DeepEqual(exts, extensions) {
+		t.Errorf("extensions %q, want %q", exts, extensions)
+	}
+	return c
+}
+
+// call sends a request, and returns the type of the reply and the rest
+// of it, after the ID.
+func (c *testClient) call(p packet) (byte, *buffer) {
+	c.t.Helper()
+	if err := p.send(c.w); err != nil {
+		c.t.Fatal(err)
+	}
+	typ, r, err := readPacket(c.r)
+	if err != nil {
+		c.t.Fatalf("reply: %v", err)
+	}
+	b := &buffer{b: r}
+	if id := b.uint32(); id != c.id {
+		c.t.Fatalf("reply to %d, want %d", id, c.id)
+	}
+	return typ, b
+}
+
+func (c *testClient) request(t byte) packet {
+	c.id++
+	return newPacket(t).uint32(c.id)
+}
+
+// status sends a request, and returns its status, which is fxOK for a
+// reply other than a status.
+func (c *testClient) status(p packet) uint32 {
+	c.t.Helper()
+	typ, b := c.call(p)
+	if typ != fxpStatus {
+		return fxOK
+	}
+	return b.uint32()
+}
+
+// handle sends a request, and returns the handle in its reply.
+func (c *testClient) handle(p packet) string {
+	c.t.Helper()
+	typ, b := c.call(p)
+	if typ != fxpHandle {
+		c.t.Fatalf("reply type %d (status %d), want a handle", typ, b.uint32())
+	}
+	return b.string()
+}
+
+func TestServe(t *testing.T) {
+	d := t.TempDir()
+	c := newTestClient(t)
+	f := filepath.Join(d, "f")
+
+	h := c.handle(c.request(fxpOpen).string(f).uint32(fxfWrite | fxfCreat | fxfExcl).attrs(&Attrs{Flags: attrPermissions, Mode: 0o640}))
+	if s := c.status(c.request(fxpWrite).string(h).uint64(0).string("hello, ")); s != fxOK {
+		t.Errorf("write: status %d, want %d", s, fxOK)
+	}
+	if s := c.status(c.request(fxpWrite).string(h).uint64(7).string("world")); s != fxOK {
+		t.Errorf("write at 7: status %d, want %d", s, fxOK)
+	}
+	if s := c.status(c.request(fxpClose).string(h)); s != fxOK {
+		t.Errorf("close: status %d, want %d", s, fxOK)
+	}
+	if s := c.status(c.request(fxpClose).string(h)); s != fxFailure {
+		t.Errorf("close of a closed handle: status %d, want %d", s, fxFailure)
+	}
+	if b, err := os.ReadFile(f); err != nil || string(b) != "hello, world" {
+		t.Errorf("file: %q, %v, want %q, nil", b, err, "hello, world")
+	}
+	if fi, err := os.Stat(f); err != nil || fi.Mode().Perm() != 0o640 {
+		t.Errorf("file mode: %v, %v, want %v", fi.Mode(), err, os.FileMode(0o640))
+	}
+
+	h = c.handle(c.request(fxpOpen).string(f).uint32(fxfWrite | fxfAppend).attrs(&Attrs{}))
+	if s := c.status(c.request(fxpWrite).string(h).uint64(0).string("!")); s != fxOK {
+		t.Errorf("append: status %d, want %d", s, fxOK)
+	}
+	c.status(c.request(fxpClose).string(h))
+
+	h = c.handle(c.request(fxpOpen).string(f).uint32(fxfRead).attrs(&Attrs{}))
+	typ, b := c.call(c.request(fxpRead).string(h).uint64(7).uint32(100))
+	if got := string(b.bytes()); typ != fxpData || got != "world!" {
+		t.Errorf("read at 7: type %d, %q, want %d, %q", typ, got, fxpData, "world!")
+	}
+	if s := c.status(c.request(fxpRead).string(h).uint64(100).uint32(100)); s != fxEOF {
+		t.Errorf("read past the end: status %d, want %d", s, fxEOF)
+	}
+	typ, b = c.call(c.request(fxpFstat).string(h))
+	if a := b.attrs(); typ != fxpAttrs || a.Size != 13 || a.FileMode() != 0o640 {
+		t.Errorf("fstat: type %d, %+v, want size 13, mode %v", typ, a, os.FileMode(0o640))
+	}
+	c.status(c.request(fxpClose).string(h))
+
+	for _, tt := range []struct {
+		name string
+		req  packet
+		want uint32
+	}{
+		{name: "open exclusive of a file that exists", req: c.request(fxpOpen).string(f).uint32(fxfWrite | fxfCreat | fxfExcl).attrs(&Attrs{}), want: fxFailure},
+		{name: "open of a file that does not exist", req: c.request(fxpOpen).string(filepath.Join(d, "none")).uint32(fxfRead).attrs(&Attrs{}), want: fxNoSuchFile},
+		{name: "opendir of a file", req: c.request(fxpOpendir).string(f), want: fxFailure},
+		{name: "mkdir", req: c.request(fxpMkdir).string(filepath.Join(d, "dir")).attrs(&Attrs{}), want: fxOK},
+		{name: "mkdir that exists", req: c.request(fxpMkdir).string(filepath.Join(d, "dir")).attrs(&Attrs{}), want: fxFailure},
+		{name: "rename onto a file that exists", req: c.request(fxpRename).string(f).string(f), want: fxFailure},
+		{name: "rename", req: c.request(fxpRename).string(f).string(filepath.Join(d, "dir", "g")), want: fxOK},
+		{name: "posix-rename", req: c.request(fxpExtended).string("posix-rename@openssh.com").string(filepath.Join(d, "dir", "g")).string(f), want: fxOK},
+		{name: "hardlink", req: c.request(fxpExtended).string("hardlink@openssh.com").string(f).string(filepath.Join(d, "dir", "g")), want: fxOK},
+		{name: "symlink", req: c.request(fxpSymlink).string("f").string(filepath.Join(d, "l")), want: fxOK},
+		{name: "setstat", req: c.request(fxpSetstat).string(f).attrs(&Attrs{Flags: attrSize | attrPermissions, Size: 5, Mode: 0o600}), want: fxOK},
+		{name: "rmdir of a file", req: c.request(fxpRmdir).string(f), want: fxFailure},
+		{name: "remove of a directory", req: c.request(fxpRemove).string(filepath.Join(d, "dir")), want: fxFailure},
+		{name: "remove", req: c.request(fxpRemove).string(filepath.Join(d, "dir", "g")), want: fxOK},
+		{name: "rmdir", req: c.request(fxpRmdir).string(filepath.Join(d, "dir")), want: fxOK},
+		{name: "unknown extension", req: c.request(fxpExtended).string("statvfs@openssh.com").string(d), want: fxOpUnsupported},
+		{name: "unknown request", req: c.request(99).string(d), want: fxOpUnsupported},
+		{name: "short request", req: c.request(fxpStat), want: fxBadMessage},
+	} {
+		// The ID of the request is the one made last.
+		c.id = (&buffer{b: tt.req[5:]}).uint32()
+		if s := c.status(tt.req); s != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, s, tt.want)
+		}
+	}
+	if b, err := os.ReadFile(f); err != nil || string(b) != "hello" {
+		t.Errorf("file after setstat: %q, %v, want %q, nil", b, err, "hello")
+	}
+	if fi, err := os.Stat(f); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("file mode after setstat: %v, %v, want %v", fi.Mode(), err, os.FileMode(0o600))
+	}
+
+	typ, b = c.call(c.request(fxpReadlink).string(filepath.Join(d, "l")))
+	if n, name := b.uint32(), b.string(); typ != fxpName || n != 1 || name != "f" {
+		t.Errorf("readlink: type %d, %d names, %q, want %d, 1, %q", typ, n, name, fxpName, "f")
+	}
+	typ, b = c.call(c.request(fxpLstat).string(filepath.Join(d, "l")))
+	if a := b.attrs(); typ != fxpAttrs || a.FileMode()&os.ModeSymlink == 0 {
+		t.Errorf("lstat of a symlink: type %d, mode %v, want a symlink", typ, a.FileMode())
+	}
+	typ, b = c.call(c.request(fxpStat).string(filepath.Join(d, "l")))
+	if a := b.attrs(); typ != fxpAttrs || !a.FileMode().IsRegular() {
+		t.Errorf("stat of a symlink: type %d, mode %v, want a file", typ, a.FileMode())
+	}
+	typ, b = c.call(c.request(fxpRealpath).string(filepath.Join(d, "x", "..", "f")))
+	if n, name := b.uint32(), b.string(); typ != fxpName || n != 1 || name != f {
+		t.Errorf("realpath: type %d, %d names, %q, want %d, 1, %q", typ, n, name, fxpName, f)
+	}
+
+	h = c.handle(c.request(fxpOpendir).string(d))
+	var names []string
+	for {
+		typ, b := c.call(c.request(fxpReaddir).string(h))
+		if typ == fxpStatus {
+			if s := b.uint32(); s != fxEOF {
+				t.Errorf("readdir: status %d, want %d", s, fxEOF)
+			}
+			break
+		}
+		for n := b.uint32(); n > 0; n-- {
+			names = append(names, b.string())
+			b.string()
+			b.attrs()
+		}
+		if b.err != nil {
+			t.Fatalf("readdir: %v", b.err)
+		}
+	}
+	sort.Strings(names)
+	if want := []string{"f", "l"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("readdir: %q, want %q", names, want)
+	}
+	if s := c.status(c.request(fxpRead).string(h).uint64(0).uint32(10)); s != fxFailure {
+		t.Errorf("read of a directory handle: status %d, want %d", s, fxFailure)
+	}
+	c.status(c.request(fxpClose).string(h))
+}
+
+func TestOpenFlags(t *testing.T) {
+	for _, tt := range []struct {
+		in   uint32
+		want int
+	}{
+		{in: fxfRead, want: os.O_RDONLY},
+		{in: fxfWrite, want: os.O_WRONLY},
+		{in: fxfRead | fxfWrite, want: os.O_RDWR},
+		{in: fxfWrite | fxfCreat | fxfTrunc, want: os.O_WRONLY | os.O_CREATE | os.O_TRUNC},
+		{in: fxfWrite | fxfAppend | fxfCreat | fxfExcl, want: os.O_WRONLY | os.O_APPEND | os.O_CREATE | os.O_EXCL},
+	} {
+		if got := openFlags(tt.in); got != tt.want {
+			t.Errorf("openFlags(%#x): %#x, want %#x", tt.in, got, tt.want)
+		}
+	}
+}